@@ -0,0 +1,184 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+	"ip-api/geoip"
+	"ip-api/updater"
+)
+
+// AdminAuthMiddleware 使用 config.App.AdminToken 验证管理接口的 Bearer 令牌。
+// 未配置令牌时管理接口被禁用。
+func AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.App.AdminToken == "" {
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.App.AdminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON 以 JSON 格式写入响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("JSON encode error: %v", err)
+	}
+}
+
+// writeError 以 JSON 格式写入错误响应
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// VersionsHandler 列出数据库保存的历史版本
+// GET /admin/versions?db=GeoLite2-City
+func VersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, err := updater.ListVersions(r.URL.Query().Get("db"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// RollbackHandler 将数据库回滚到指定版本并热加载
+// POST /admin/rollback?db=GeoLite2-City&version=1700000000
+func RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	epoch, err := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid version")
+		return
+	}
+
+	db, err := updater.Rollback(r.URL.Query().Get("db"), uint(epoch))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := geoip.ReloadDB(db); err != nil {
+		writeError(w, http.StatusInternalServerError, "rolled back but reload failed: "+err.Error())
+		return
+	}
+	ipCache.Flush()
+
+	versions, _ := updater.ListVersions(db.Name)
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// UnpinHandler 取消数据库的版本固定
+// POST /admin/unpin?db=GeoLite2-City
+func UnpinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := updater.Unpin(r.URL.Query().Get("db")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "unpinned"})
+}
+
+// DiffHandler 返回数据库最近一次更新的差异报告，或比较两个保存的版本
+// GET /admin/diff?db=GeoLite2-City
+// GET /admin/diff?db=GeoLite2-City&from=1700000000&to=1700600000
+func DiffHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("db")
+
+	if q.Get("from") == "" && q.Get("to") == "" {
+		report, err := updater.LatestDiff(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	from, err1 := strconv.ParseUint(q.Get("from"), 10, 64)
+	to, err2 := strconv.ParseUint(q.Get("to"), 10, 64)
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusBadRequest, "invalid from or to version")
+		return
+	}
+	report, err := updater.DiffVersions(name, uint(from), uint(to))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// BundleImportHandler 导入上传的离线数据库包并热加载其中的数据库
+// POST /admin/bundle（请求体为数据库包 tar.gz）
+func BundleImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// 数据库包可能很大，上传时间会超过服务器的读写超时
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear read deadline for bundle upload: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for bundle upload: %v", err)
+	}
+
+	body := http.MaxBytesReader(w, r.Body, config.App.Bundle.MaxBundleSize())
+	manifest, installed, err := updater.ImportBundle(body)
+	if manifest == nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var names []string
+	for _, db := range installed {
+		if reloadErr := geoip.ReloadDB(db); reloadErr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: reload failed: %w", db.Name, reloadErr))
+			continue
+		}
+		names = append(names, db.Name)
+	}
+	if len(installed) > 0 {
+		ipCache.Flush()
+	}
+
+	status := http.StatusOK
+	result := map[string]interface{}{
+		"manifest":  manifest,
+		"installed": names,
+	}
+	if err != nil {
+		status = http.StatusUnprocessableEntity
+		result["error"] = err.Error()
+	}
+	writeJSON(w, status, result)
+}
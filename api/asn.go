@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ip-api/geoip"
+)
+
+const (
+	// defaultASNSearchLimit 和 maxASNSearchLimit 限制 ASN 搜索返回的结果数量
+	defaultASNSearchLimit = 50
+	maxASNSearchLimit     = 500
+)
+
+// ASNHandler 返回自治系统的组织、前缀列表、地址总数和按国家的分布，或按组织名称搜索
+// GET /asn/4134
+// GET /asn?search=telecom&limit=20
+func ASNHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	param := strings.Trim(strings.TrimPrefix(r.URL.Path, "/asn"), "/")
+	if param == "" {
+		searchASN(w, r)
+		return
+	}
+
+	number, err := parseASN(param)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid ASN")
+		return
+	}
+
+	details, found, err := geoip.LookupASN(number)
+	if err != nil {
+		writeASNError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "ASN not in database")
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// searchASN 处理 GET /asn?search=
+func searchASN(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("search"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "missing search parameter")
+		return
+	}
+
+	limit := defaultASNSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxASNSearchLimit)
+	}
+
+	results, err := geoip.SearchASN(query, limit)
+	if err != nil {
+		writeASNError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// parseASN 解析 "4134" 或 "AS4134" 形式的自治系统编号
+func parseASN(s string) (uint, error) {
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, errors.New("invalid ASN")
+	}
+	return uint(n), nil
+}
+
+// writeASNError 写入 ASN 查询的错误，索引尚未构建时返回 503
+func writeASNError(w http.ResponseWriter, err error) {
+	if errors.Is(err, geoip.ErrASNIndexUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	log.Printf("ASN lookup failed: %v", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
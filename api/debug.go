@@ -0,0 +1,36 @@
+package api
+
+import "ip-api/geoip"
+
+// debugSources 是 ?debug=sources 调试模式的名称
+const debugSources = "sources"
+
+// SourcesDebug 是 ?debug=sources 返回的数据源明细
+type SourcesDebug struct {
+	// Answers 是每个找到该地址的数据源给出的原始记录
+	Answers []SourceAnswer `json:"answers"`
+	// Fields 是合并后的每个字段由哪个数据库提供
+	Fields geoip.Attribution `json:"fields"`
+}
+
+// SourceAnswer 是一个数据源的原始记录
+type SourceAnswer struct {
+	Source  string       `json:"source"`
+	Role    string       `json:"role"`
+	Network string       `json:"network"`
+	Record  geoip.Record `json:"record"`
+}
+
+// sourcesDebug 根据查询结果构建数据源明细
+func sourcesDebug(result *geoip.Result) *SourcesDebug {
+	debug := &SourcesDebug{Answers: []SourceAnswer{}, Fields: result.Attribution}
+	for _, a := range result.Answers {
+		debug.Answers = append(debug.Answers, SourceAnswer{
+			Source:  a.Source,
+			Role:    a.Role,
+			Network: a.Network.String(),
+			Record:  a.Record,
+		})
+	}
+	return debug
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log"
+	"net"
+	"net/netip"
+
+	"ip-api/config"
+	"ip-api/geoip"
+	"ip-api/iputil"
+)
+
+// EmbeddedInfo 描述 IPv6 地址中嵌入的 IPv4 地址以及两者各自的查询结果
+type EmbeddedInfo struct {
+	Type         string    `json:"type"` // 6to4、teredo、nat64 或 ipv4-mapped
+	IPv4         string    `json:"ipv4"`
+	TeredoServer string    `json:"teredo_server,omitempty"`
+	TeredoPort   uint16    `json:"teredo_port,omitempty"`
+	Preferred    string    `json:"preferred"` // 填充顶层字段的一方：inner 或 outer
+	Inner        *Response `json:"inner,omitempty"`
+	Outer        *Response `json:"outer,omitempty"`
+}
+
+// resolveEmbedded 分别查询 IPv6 地址本身和嵌入的 IPv4 地址，返回用于填充顶层字段的地址。
+// 配置偏好的一方没有数据而另一方有时，使用另一方。
+func resolveEmbedded(addr netip.Addr, e iputil.Embedded) (net.IP, *EmbeddedInfo) {
+	info := &EmbeddedInfo{
+		Type:      e.Kind,
+		IPv4:      e.IPv4.String(),
+		Preferred: config.App.EmbeddedIPv4,
+	}
+	if e.Kind == iputil.EmbeddedTeredo {
+		info.TeredoServer = e.Server.String()
+		info.TeredoPort = e.Port
+	}
+
+	outer := net.IP(addr.AsSlice())
+	inner := net.IP(e.IPv4.AsSlice())
+	info.Outer = lookupEmbeddedSide(outer)
+	info.Inner = lookupEmbeddedSide(inner)
+
+	switch {
+	case info.Preferred == config.EmbeddedPreferOuter && info.Outer == nil && info.Inner != nil:
+		info.Preferred = config.EmbeddedPreferInner
+	case info.Preferred == config.EmbeddedPreferInner && info.Inner == nil && info.Outer != nil:
+		info.Preferred = config.EmbeddedPreferOuter
+	}
+
+	log.Printf("IP %s embeds %s address %s, using %s result", addr, e.Kind, e.IPv4, info.Preferred)
+	if info.Preferred == config.EmbeddedPreferOuter {
+		return outer, info
+	}
+	return inner, info
+}
+
+// lookupEmbeddedSide 查询一个地址，所有数据库都不包含该地址时返回 nil
+func lookupEmbeddedSide(ip net.IP) *Response {
+	result, err := geoip.Lookup(ip)
+	if err != nil {
+		return nil
+	}
+	resp := buildSuccessResponse(ip, result)
+	return &resp
+}
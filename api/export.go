@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ip-api/firewall"
+)
+
+// ExportHandler 导出符合条件的 IP 范围，格式适用于防火墙和路由软件
+// GET /export?format=nftables&country=CN&family=4
+// GET /export?format=ipset&province=广东&isp=电信&name=gd_telecom
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := firewall.ParseOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 首次导出需要遍历整个数据库，可能超过服务器的写入超时
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for export: %v", err)
+	}
+
+	result, err := firewall.Export(opts)
+	if err != nil {
+		if errors.Is(err, firewall.ErrNoDatabase) {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		log.Printf("Export failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("ETag", result.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == result.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Body)))
+	w.Header().Set("X-Prefix-Count-IPv4", strconv.Itoa(result.IPv4))
+	w.Header().Set("X-Prefix-Count-IPv6", strconv.Itoa(result.IPv6))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(result.Body)
+	}
+}
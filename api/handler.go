@@ -2,19 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"ip-api/config"
 	"ip-api/geoip"
+	"ip-api/ipclass"
+	"ip-api/iputil"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"reflect"
 	"strings"
 	"time"
 
-	"github.com/oschwald/geoip2-golang"
 	"github.com/patrickmn/go-cache"
 )
 
@@ -22,12 +25,34 @@ import (
 // 每 10 分钟清除过期项目
 var ipCache = cache.New(5*time.Minute, 10*time.Minute)
 
+// FlushCache 清空缓存的查询结果，用于覆盖记录等影响结果的数据在接口之外被修改之后
+func FlushCache() {
+	ipCache.Flush()
+}
+
 // IPHandler 处理 IP 查找请求
 func IPHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
 	ipStr := getIPFromRequest(r)
 
+	// CIDR 或起止地址范围返回整个网络的拆分结果
+	if strings.ContainsAny(ipStr, "/-") {
+		networkLookup(w, r, ipStr)
+		return
+	}
+
+	// ?debug=sources 返回每个数据源的原始记录和字段来源
+	debug := r.URL.Query().Get("debug")
+	if debug != "" && debug != debugSources {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			IP:      ipStr,
+			Message: "invalid debug mode",
+		})
+		return
+	}
+
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		log.Printf("Invalid IP address provided: %s", ipStr)
@@ -39,9 +64,32 @@ func IPHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// IPv6 中嵌入的 IPv4 地址同时查询，按配置决定哪一方填充顶层字段
+	// net.IP 不区分 IPv4 映射地址，缓存键使用 netip 的规范形式
+	var embedded *EmbeddedInfo
+	var classification *ipclass.Classification
+	var queryAddr netip.Addr
+	var embeddedIPv4 iputil.Embedded
+	hasEmbedded := false
+	queryKey := ip.String()
+	if addr, err := netip.ParseAddr(ipStr); err == nil {
+		queryAddr, queryKey = addr, addr.String()
+
+		// 特殊用途地址不在公网上使用，不查询数据库，除非覆盖记录或 custom 数据库包含该地址
+		if class, ok := ipclass.Classify(addr); ok {
+			if !class.GloballyReachable && !geoip.HasLocalData(ip) {
+				writeSpecialPurpose(w, ipStr, class)
+				return
+			}
+			classification = &class
+		}
+
+		embeddedIPv4, hasEmbedded = iputil.EmbeddedIPv4(addr)
+	}
+
 	// 首先检查缓存
 	fieldsStr := r.URL.Query().Get("fields")
-	cacheKey := ip.String() + "?fields=" + fieldsStr
+	cacheKey := queryKey + "?fields=" + fieldsStr + "&debug=" + debug
 
 	if cachedResponse, found := ipCache.Get(cacheKey); found {
 		log.Printf("Serving IP %s from cache", ip.String())
@@ -52,11 +100,16 @@ func IPHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 嵌入地址的两侧在缓存未命中时才查询
+	if hasEmbedded {
+		ip, embedded = resolveEmbedded(queryAddr, embeddedIPv4)
+	}
+
 	log.Printf("Looking up IP: %s", ip.String())
-	city, asn, cnResult, err := geoip.Lookup(ip)
+	result, err := geoip.Lookup(ip)
 	if err != nil {
 		// 首先检查内部错误（例如，数据库未打开、文件损坏）
-		if !strings.Contains(err.Error(), "is not in the database") {
+		if !errors.Is(err, geoip.ErrNotFound) {
 			log.Printf("Internal server error during lookup for IP %s: %v", ip.String(), err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(Response{
@@ -73,7 +126,7 @@ func IPHandler(w http.ResponseWriter, r *http.Request) {
 			message = "private range"
 		} else if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
 			message = "reserved range"
-		} else if strings.Contains(err.Error(), "is not in the database") {
+		} else if errors.Is(err, geoip.ErrNotFound) {
 			message = "not in database"
 		}
 
@@ -86,11 +139,23 @@ func IPHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 构建完整的响应结构
-	fullResp := buildSuccessResponse(ip, city, asn, cnResult)
+	fullResp := buildSuccessResponse(ip, result)
+	if embedded != nil {
+		fullResp.IP = queryKey
+		fullResp.Embedded = embedded
+	}
+	fullResp.Classification = classification
+	if debug == debugSources {
+		fullResp.Debug = sourcesDebug(result)
+	}
 
 	var finalResp interface{}
 	if fieldsStr != "" {
-		finalResp = filterResponse(fullResp, fieldsStr)
+		filtered := filterResponse(fullResp, fieldsStr)
+		if fullResp.Debug != nil {
+			filtered["debug"] = fullResp.Debug
+		}
+		finalResp = filtered
 	} else {
 		finalResp = fullResp
 	}
@@ -101,12 +166,27 @@ func IPHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Successfully served lookup for IP: %s", ip.String())
 }
 
+// writeSpecialPurpose 返回特殊用途地址的分类，私有网络地址的消息为 "private range"，其他为 "reserved range"
+func writeSpecialPurpose(w http.ResponseWriter, ipStr string, class ipclass.Classification) {
+	message := "reserved range"
+	if class.Private {
+		message = "private range"
+	}
+	log.Printf("IP %s is in special-purpose block %s (%s)", ipStr, class.Network, class.Name)
+	writeJSON(w, http.StatusOK, Response{
+		IP:             ipStr,
+		Message:        message,
+		Classification: &class,
+	})
+}
+
 // getIPFromRequest extracts the IP address string from the HTTP request.
 func getIPFromRequest(r *http.Request) string {
 	// 1. 从 URL 路径获取
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) > 1 && parts[0] == "json" {
-		return parts[1]
+		// CIDR 的前缀长度也是路径的一部分，例如 /json/1.0.0.0/16
+		return strings.Join(parts[1:], "/")
 	}
 
 	// 2. 从 "query" URL 参数获取
@@ -132,14 +212,14 @@ func getIPFromRequest(r *http.Request) string {
 
 // buildSuccessResponse 从查找结果创建 Response 结构
 // 扩展以包含 GeoCN 数据，提供全面的中国 IP 信息
-func buildSuccessResponse(ip net.IP, city *geoip2.City, asn *geoip2.ASN, cnResult *geoip.GeoCNResult) Response {
+func buildSuccessResponse(ip net.IP, result *geoip.Result) Response {
+	rec := &result.Record
 	resp := Response{
 		IP: ip.String(),
 	}
 
 	// 网络和版本信息
-	// 注意：geoip2-golang v1 中的 ASN 结构没有 Network 字段
-	// 我们将基于 IP 类别生成基本的网络范围
+	// 网络优先使用数据库中包含该地址的网络，没有时基于 IP 类别生成基本的网络范围
 	if ip.To4() != nil {
 		resp.Version = "IPv4"
 		// 为 IPv4 生成基本的 /24 网络
@@ -148,69 +228,75 @@ func buildSuccessResponse(ip net.IP, city *geoip2.City, asn *geoip2.ASN, cnResul
 	} else {
 		resp.Version = "IPv6"
 		// 为 IPv6 生成基本的 /64 网络
-		resp.Network = fmt.Sprintf("%s/64", ip.String()[:19])
+		// 压缩形式的地址可能短于 19 个字符，按掩码计算而不是截取字符串
+		mask := net.CIDRMask(64, 128)
+		resp.Network = (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+	}
+	if result.Network != nil {
+		resp.Network = result.Network.String()
+	}
+	resp.Sources = result.Sources
+	if !rec.HasLocation() && result.LocationMissing() {
+		// 只有 ASN 等数据时仍然返回结果，但明确说明位置不在数据库中
+		resp.Message = "location not in database"
 	}
 
-	if city != nil {
+	if rec.HasLocation() {
 		// 国家信息
-		resp.Country = city.Country.IsoCode
-		resp.CountryCode = city.Country.IsoCode
-		if len(city.Country.Names) > 0 {
+		resp.Country = rec.CountryCode
+		resp.CountryCode = rec.CountryCode
+		if len(rec.CountryNames) > 0 {
 			// 对于 CN 查找，优先使用中文名称
-			if resp.CountryCode == "CN" && city.Country.Names["zh-CN"] != "" {
-				resp.CountryName = city.Country.Names["zh-CN"]
+			if resp.CountryCode == "CN" && rec.CountryNames["zh-CN"] != "" {
+				resp.CountryName = rec.CountryNames["zh-CN"]
 			} else {
-				resp.CountryName = city.Country.Names["en"]
+				resp.CountryName = rec.CountryNames["en"]
 			}
 		}
-		resp.CountryCodeISO3 = getISO3Code(city.Country.IsoCode)
-		resp.CountryTLD = getCountryTLD(city.Country.IsoCode)
-		resp.CountryCallingCode = getCountryCallingCode(city.Country.IsoCode)
-		resp.Currency = getCurrency(city.Country.IsoCode)
-		resp.CurrencyName = getCurrencyName(city.Country.IsoCode)
-		resp.Languages = getLanguages(city.Country.IsoCode)
-		resp.CountryArea = getCountryArea(city.Country.IsoCode)
-		resp.CountryPopulation = getCountryPopulation(city.Country.IsoCode)
+		resp.CountryCodeISO3 = getISO3Code(rec.CountryCode)
+		resp.CountryTLD = getCountryTLD(rec.CountryCode)
+		resp.CountryCallingCode = getCountryCallingCode(rec.CountryCode)
+		resp.Currency = getCurrency(rec.CountryCode)
+		resp.CurrencyName = getCurrencyName(rec.CountryCode)
+		resp.Languages = getLanguages(rec.CountryCode)
+		resp.CountryArea = getCountryArea(rec.CountryCode)
+		resp.CountryPopulation = getCountryPopulation(rec.CountryCode)
 
 		// 香港和台湾的特殊处理 - 更改国家名称、首都、地区和城市
-		if city.Country.IsoCode == "HK" || city.Country.IsoCode == "TW" {
+		if rec.CountryCode == "HK" || rec.CountryCode == "TW" {
 			resp.Country = "CN"
 			resp.CountryCode = "CN"
 			resp.CountryName = "中国"
 			resp.CountryCapital = "北京"
-			if city.Country.IsoCode == "HK" {
+			if rec.CountryCode == "HK" {
 				resp.Region = "香港"
 				resp.City = "香港"
-			} else if city.Country.IsoCode == "TW" {
+			} else if rec.CountryCode == "TW" {
 				resp.Region = "台湾"
 				resp.City = "台湾"
 			}
 		} else {
-			resp.CountryCapital = getCountryCapital(city.Country.IsoCode)
+			resp.CountryCapital = getCountryCapital(rec.CountryCode)
 		}
 
 		// 大洲信息
-		resp.ContinentCode = city.Continent.Code
-		resp.InEU = isEUCountry(city.Country.IsoCode)
-
-		// 城市和地区信息
-		log.Printf("GeoLite2 city data for %s: %v", ip.String(), city.City.Names)
-		if city.City.Names != nil {
-			if name, ok := city.City.Names["zh-CN"]; ok && name != "" {
-				resp.City = name
-			} else if name, ok := city.City.Names["en"]; ok && name != "" {
-				resp.City = name
-			}
+		resp.ContinentCode = rec.ContinentCode
+		resp.InEU = isEUCountry(rec.CountryCode)
+
+		// 城市和地区信息，数据源合并后的名称优先使用中文
+		log.Printf("City data for %s: %v", ip.String(), rec.CityNames)
+		if name, ok := rec.CityNames["zh-CN"]; ok && name != "" {
+			resp.City = name
+		} else if name, ok := rec.CityNames["en"]; ok && name != "" {
+			resp.City = name
 		}
 
-		log.Printf("GeoLite2 subdivision data for %s: %d subdivisions", ip.String(), len(city.Subdivisions))
-		if len(city.Subdivisions) > 0 && city.Subdivisions[0].Names != nil {
-			subdivision := city.Subdivisions[0]
-			log.Printf("First subdivision names: %v, IsoCode: %s", subdivision.Names, subdivision.IsoCode)
-			resp.RegionCode = subdivision.IsoCode
-			if name, ok := subdivision.Names["zh-CN"]; ok && name != "" {
+		log.Printf("Region data for %s: %v, IsoCode: %s", ip.String(), rec.RegionNames, rec.RegionCode)
+		if len(rec.RegionNames) > 0 {
+			resp.RegionCode = rec.RegionCode
+			if name, ok := rec.RegionNames["zh-CN"]; ok && name != "" {
 				resp.Region = name
-			} else if name, ok := subdivision.Names["en"]; ok && name != "" {
+			} else if name, ok := rec.RegionNames["en"]; ok && name != "" {
 				resp.Region = name
 			}
 		}
@@ -228,7 +314,7 @@ func buildSuccessResponse(ip net.IP, city *geoip2.City, asn *geoip2.ASN, cnResul
 		}
 
 		// 位置信息
-		resp.Postal = city.Postal.Code
+		resp.Postal = rec.Postal
 		// 对于中国 IP，如果缺少邮政编码，尝试基于地区提供
 		if resp.Postal == "" && resp.CountryCode == "CN" {
 			resp.Postal = getChinesePostalCode(resp.RegionCode, resp.Region)
@@ -236,41 +322,58 @@ func buildSuccessResponse(ip net.IP, city *geoip2.City, asn *geoip2.ASN, cnResul
 				log.Printf("Inferred postal code for CN IP %s: %s", ip.String(), resp.Postal)
 			}
 		}
-		resp.Latitude = city.Location.Latitude
-		resp.Longitude = city.Location.Longitude
-		resp.Timezone = city.Location.TimeZone
-		resp.UTCOffset = getUTCOffset(city.Location.TimeZone)
+		if rec.Location != nil {
+			resp.Latitude = rec.Location.Latitude
+			resp.Longitude = rec.Location.Longitude
+		}
+		resp.Timezone = rec.TimeZone
+		resp.UTCOffset = getUTCOffset(rec.TimeZone)
+		if resp.UTCOffset == "" {
+			// IP2Location 只提供 +08:00 形式的 UTC 偏移，没有时区名称
+			resp.UTCOffset = strings.Replace(rec.UTCOffset, ":", "", 1)
+		}
+		resp.Elevation = rec.Elevation
+		resp.IDDCode = rec.IDDCode
+		resp.AreaCode = rec.AreaCode
+		resp.WeatherStationCode = rec.WeatherStationCode
+		resp.WeatherStationName = rec.WeatherStationName
 	}
 
 	// ASN 信息
-	if asn != nil && asn.AutonomousSystemNumber > 0 {
-		resp.ASN = fmt.Sprintf("AS%d", asn.AutonomousSystemNumber)
-		resp.Org = asn.AutonomousSystemOrganization
+	if rec.ASN > 0 {
+		resp.ASN = fmt.Sprintf("AS%d", rec.ASN)
+		resp.Org = rec.ASOrganization
 	}
 
-	// GeoCN 信息 - 对于中国 IP 优先使用 GeoCN 数据
-	log.Printf("Processing GeoCN data: cnResult=%v", cnResult != nil)
-	if cnResult != nil {
-		log.Printf("GeoCN data available: %+v", cnResult)
-		// 将 GeoCN 字段直接映射到响应
-		if cnResult.City != "" {
-			resp.City = cnResult.City
-			log.Printf("Using GeoCN city: %s", cnResult.City)
-		}
-		if cnResult.Province != "" {
-			resp.Region = cnResult.Province
-			log.Printf("Using GeoCN province as region: %s", cnResult.Province)
-		}
-		// 添加 GeoCN 特定字段
-		resp.CityCode = cnResult.CityCode
-		resp.ProvinceCode = cnResult.ProvinceCode
-		resp.Districts = cnResult.Districts
-		resp.DistrictsCode = cnResult.DistrictsCode
-		resp.ISP = cnResult.ISP
-
-		log.Printf("GeoCN data mapped: City=%s, Province=%s, Districts=%s, ISP=%s",
-			cnResult.City, cnResult.Province, cnResult.Districts, cnResult.ISP)
+	// GeoCN 的行政区划代码和区县，城市和省份已由合并策略写入记录
+	resp.CityCode = rec.CityCode
+	resp.ProvinceCode = rec.ProvinceCode
+	resp.Districts = rec.District
+	resp.DistrictsCode = rec.DistrictCode
+
+	// 网络特征，合并策略中 GeoCN 的 ISP 优先
+	resp.ISP = rec.ISP
+	resp.Organization = rec.Organization
+	resp.ConnectionType = rec.ConnectionType
+	resp.UserType = rec.UserType
+	resp.Domain = rec.Domain
+	resp.MobileCountryCode = rec.MobileCountryCode
+	resp.MobileNetworkCode = rec.MobileNetworkCode
+	resp.MobileBrand = rec.MobileBrand
+	resp.NetSpeed = rec.NetSpeed
+	resp.UsageType = rec.UsageType
+	resp.AddressType = rec.AddressType
+	resp.Category = rec.Category
+	if a := rec.Anonymizer; a != nil {
+		resp.IsAnonymous = &a.IsAnonymous
+		resp.IsAnonymousVPN = &a.IsAnonymousVPN
+		resp.IsHostingProvider = &a.IsHostingProvider
+		resp.IsPublicProxy = &a.IsPublicProxy
+		resp.IsResidentialProxy = &a.IsResidentialProxy
+		resp.IsTorExitNode = &a.IsTorExitNode
 	}
+	resp.Tags = result.Tags
+	resp.Custom = result.Custom
 
 	return resp
 }
@@ -301,6 +404,22 @@ func filterResponse(resp Response, fieldsStr string) map[string]interface{} {
 		}
 	}
 
+	// custom.<name> 只选择一个 custom 数据库的记录
+	for f := range requestFields {
+		name, ok := strings.CutPrefix(f, "custom.")
+		if !ok {
+			continue
+		}
+		if v, found := resp.Custom[name]; found {
+			custom, _ := filteredMap["custom"].(map[string]interface{})
+			if custom == nil {
+				custom = make(map[string]interface{})
+				filteredMap["custom"] = custom
+			}
+			custom[name] = v
+		}
+	}
+
 	return filteredMap
 }
 
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/geoip"
+	"ip-api/mmdbwriter"
+)
+
+// countingProvider 记录查询次数
+type countingProvider struct {
+	geoip.FakeProvider
+	lookups atomic.Int32
+}
+
+func (p *countingProvider) Lookup(ip net.IP) (geoip.Record, *net.IPNet, bool, error) {
+	p.lookups.Add(1)
+	return p.FakeProvider.Lookup(ip)
+}
+
+func TestEmbeddedLookupUsesCache(t *testing.T) {
+	old := config.App
+	defer func() {
+		geoip.CloseDBs()
+		ipCache.Flush()
+		config.App = old
+	}()
+	config.App.EmbeddedIPv4 = config.EmbeddedPreferInner
+
+	provider := &countingProvider{FakeProvider: geoip.FakeProvider{Entries: []geoip.FakeEntry{
+		{Network: "192.0.2.0/24", Record: geoip.Record{CountryCode: "JP"}},
+	}}}
+	if err := geoip.AddProvider(config.Database{Name: "Test-City", Role: config.RoleCity}, provider); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2002:c000:201:: 是 192.0.2.1 的 6to4 地址
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		IPHandler(w, httptest.NewRequest("GET", "/json/2002:c000:201::", nil))
+		return w
+	}
+	if w := get(); w.Code != 200 || w.Header().Get("X-Cache") != "" {
+		t.Fatalf("first request: status %d, X-Cache %q, body %s", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	first := provider.lookups.Load()
+	if first == 0 {
+		t.Fatal("first request did not query the provider")
+	}
+
+	if w := get(); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("second request was not served from cache: %s", w.Body)
+	}
+	if n := provider.lookups.Load(); n != first {
+		t.Errorf("cached request made %d more lookups", n-first)
+	}
+}
+
+// openCustomDB 在数据目录中写入一个 custom 数据库并打开
+func openCustomDB(t *testing.T, name string, records map[string]interface{}) {
+	t.Helper()
+	db := config.Database{Name: name, Role: config.RoleCustom}
+	w := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "Internal-Custom"})
+	for network, rec := range records {
+		if err := w.Insert(netip.MustParsePrefix(network), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := geoip.OpenDBs([]config.Database{db}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	old := config.App
+	defer func() {
+		geoip.CloseDBs()
+		ipCache.Flush()
+		config.App = old
+	}()
+	config.App.DataDir = t.TempDir()
+	openCustomDB(t, "Customers", map[string]interface{}{"10.1.0.0/16": map[string]interface{}{"customer": "acme"}})
+	openCustomDB(t, "Sites", map[string]interface{}{"10.1.2.0/24": map[string]interface{}{"site": "fra1"}})
+
+	get := func(path string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		IPHandler(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d, body %s", path, w.Code, w.Body)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return body
+	}
+	customers := map[string]interface{}{"customer": "acme"}
+	sites := map[string]interface{}{"site": "fra1"}
+
+	// 私有地址只因为 custom 数据库包含它才返回数据
+	body := get("/json/10.1.2.3")
+	if want := map[string]interface{}{"Customers": customers, "Sites": sites}; !reflect.DeepEqual(body["custom"], want) {
+		t.Errorf("custom = %v, want %v", body["custom"], want)
+	}
+	if body := get("/json/10.9.9.9"); body["message"] != "private range" || body["custom"] != nil {
+		t.Errorf("uncovered private address = %v, want private range", body)
+	}
+
+	tests := []struct {
+		fields string
+		want   map[string]interface{}
+	}{
+		{"custom.Sites", map[string]interface{}{"ip": "10.1.2.3", "custom": map[string]interface{}{"Sites": sites}}},
+		{"custom.Customers,custom.Unknown", map[string]interface{}{"ip": "10.1.2.3", "custom": map[string]interface{}{"Customers": customers}}},
+		{"custom", map[string]interface{}{"ip": "10.1.2.3", "custom": map[string]interface{}{"Customers": customers, "Sites": sites}}},
+		{"custom.Unknown", map[string]interface{}{"ip": "10.1.2.3"}},
+	}
+	for _, tt := range tests {
+		if body := get("/json/10.1.2.3?fields=" + tt.fields); !reflect.DeepEqual(body, tt.want) {
+			t.Errorf("fields=%s: body = %v, want %v", tt.fields, body, tt.want)
+		}
+	}
+}
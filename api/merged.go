@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net"
+	"reflect"
+	"strings"
+
+	"ip-api/geoip"
+)
+
+// mergedSkip 是只与单次请求有关的响应字段，不写入合并数据库
+var mergedSkip = map[string]bool{
+	"ip": true, "network": true, "version": true, "embedded": true,
+	"classification": true, "sources": true, "debug": true, "message": true,
+}
+
+// MergedRecord 返回合并数据库中的一条记录。键与 /json 响应的字段名相同，值也相同，
+// 省略空字段和只与单次请求有关的字段。
+func MergedRecord(ip net.IP, result *geoip.Result) map[string]interface{} {
+	resp := buildSuccessResponse(ip, result)
+	record := make(map[string]interface{})
+	val := reflect.ValueOf(resp)
+	for i := 0; i < val.NumField(); i++ {
+		name := strings.Split(val.Type().Field(i).Tag.Get("json"), ",")[0]
+		if mergedSkip[name] {
+			continue
+		}
+		if v, ok := mmdbValue(val.Field(i)); ok {
+			record[name] = v
+		}
+	}
+	return record
+}
+
+// mmdbValue 把响应字段转换为 MMDB 支持的类型，零值返回 false。
+// 匿名标记的 false 有意义，只要字段存在就写入。
+func mmdbValue(v reflect.Value) (interface{}, bool) {
+	if v.IsZero() {
+		return nil, false
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return true, true
+	case reflect.Uint:
+		return uint32(v.Uint()), true
+	case reflect.Int64:
+		return uint64(v.Int()), true
+	case reflect.Float64:
+		return v.Float(), true
+	case reflect.Ptr:
+		if v.Elem().Kind() == reflect.Bool {
+			return v.Elem().Bool(), true
+		}
+	case reflect.Map:
+		return v.Interface(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+	"ip-api/updater"
+)
+
+// MirrorAuthMiddleware 使用 config.App.Mirror.Token 验证 follower 的 Bearer 令牌
+func MirrorAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if config.App.Mirror.Token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.App.Mirror.Token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MirrorManifestHandler 列出 leader 当前发布的数据库文件及其 SHA-256 和构建时间
+// GET /mirror/manifest
+func MirrorManifestHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := updater.MirrorManifest()
+	if err != nil {
+		log.Printf("Failed to build mirror manifest: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build manifest")
+		return
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// MirrorFileHandler 提供数据库文件下载，支持 ETag 条件请求和 Range 续传
+// GET /mirror/files/GeoLite2-City
+func MirrorFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/mirror/files/")
+	f, info, err := updater.OpenMirrorFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, "database file not found")
+		} else {
+			writeError(w, http.StatusNotFound, err.Error())
+		}
+		return
+	}
+	defer f.Close()
+
+	// 数据库文件可能比服务器的写入超时大得多
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for mirror download: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", info.ETag())
+	w.Header().Set("X-Content-SHA256", info.SHA256)
+	w.Header().Set("X-Build-Epoch", strconv.FormatUint(uint64(info.BuildEpoch), 10))
+	http.ServeContent(w, r, filepath.Base(f.Name()), info.ModTime, f)
+}
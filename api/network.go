@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ip-api/geoip"
+	"ip-api/iputil"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	// defaultNetworkLimit 和 maxNetworkLimit 限制范围查询每页返回的子网络数量
+	defaultNetworkLimit = 100
+	maxNetworkLimit     = 1000
+)
+
+// networkLookup 处理 CIDR 或起止地址范围的查询，返回数据库拆分出的子网络和按国家、ASN 的统计
+// GET /json/1.0.0.0/16?offset=0&limit=100
+// GET /json/1.0.0.0-1.0.3.255
+func networkLookup(w http.ResponseWriter, r *http.Request, query string) {
+	rng, err := iputil.ParseRange(query)
+	if err != nil {
+		log.Printf("Invalid network provided: %s", query)
+		writeJSON(w, http.StatusBadRequest, Response{IP: query, Message: "invalid query"})
+		return
+	}
+
+	offset, limit := 0, defaultNetworkLimit
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeJSON(w, http.StatusBadRequest, Response{IP: query, Message: "invalid offset"})
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeJSON(w, http.StatusBadRequest, Response{IP: query, Message: "invalid limit"})
+			return
+		}
+		limit = min(limit, maxNetworkLimit)
+	}
+
+	cacheKey := "network:" + rng.From.String() + "-" + rng.To.String() + "?offset=" + strconv.Itoa(offset) + "&limit=" + strconv.Itoa(limit)
+	if cached, found := ipCache.Get(cacheKey); found {
+		w.Header().Set("X-Cache", "HIT")
+		writeJSON(w, http.StatusOK, cached)
+		return
+	}
+
+	breakdown, err := geoip.LookupRange(rng, offset, limit)
+	if err != nil {
+		if errors.Is(err, geoip.ErrRangeTooLarge) {
+			writeJSON(w, http.StatusBadRequest, Response{IP: query, Message: "network too large"})
+			return
+		}
+		if errors.Is(err, geoip.ErrIPv4CompatibleRange) {
+			writeJSON(w, http.StatusBadRequest, Response{IP: query, Message: "use IPv4 addresses instead of ::/96"})
+			return
+		}
+		log.Printf("Internal server error during network lookup for %s: %v", query, err)
+		writeJSON(w, http.StatusInternalServerError, Response{IP: query, Message: "internal error"})
+		return
+	}
+
+	ipCache.Set(cacheKey, breakdown, cache.DefaultExpiration)
+	writeJSON(w, http.StatusOK, breakdown)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ip-api/overrides"
+)
+
+// OverridesHandler 列出、添加和删除内部地址段的覆盖记录，修改会写回覆盖文件
+// GET /admin/overrides
+// POST /admin/overrides（请求体为 JSON 格式的覆盖记录，已存在的网络会被替换）
+// DELETE /admin/overrides?network=10.0.0.0/8
+func OverridesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, overrides.List())
+
+	case http.MethodPost:
+		var e overrides.Entry
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&e); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		e, err := overrides.Add(e)
+		if err != nil {
+			writeError(w, overrideErrorStatus(err), err.Error())
+			return
+		}
+		ipCache.Flush()
+		writeJSON(w, http.StatusOK, e)
+
+	case http.MethodDelete:
+		if err := overrides.Delete(r.URL.Query().Get("network")); err != nil {
+			writeError(w, overrideErrorStatus(err), err.Error())
+			return
+		}
+		ipCache.Flush()
+		writeJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// overrideErrorStatus 返回覆盖记录错误对应的 HTTP 状态码
+func overrideErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, overrides.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, overrides.ErrNotFound), errors.Is(err, overrides.ErrDisabled):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,5 +1,10 @@
 package api
 
+import (
+	"ip-api/geoip"
+	"ip-api/ipclass"
+)
+
 // Response 是 API 响应的结构，兼容 ip-api.com
 // 扩展以包含 GeoCN 数据库字段
 type Response struct {
@@ -35,5 +40,34 @@ type Response struct {
 	ASN                string  `json:"asn,omitempty"`
 	Org                string  `json:"org,omitempty"`
 	ISP                string  `json:"isp,omitempty"`     // GeoCN ISP
+	Organization       string  `json:"organization,omitempty"`
+	ConnectionType     string  `json:"connection_type,omitempty"`
+	UserType           string  `json:"user_type,omitempty"`
+	Domain             string  `json:"domain,omitempty"`
+	MobileCountryCode  string  `json:"mobile_country_code,omitempty"`
+	MobileNetworkCode  string  `json:"mobile_network_code,omitempty"`
+	MobileBrand        string  `json:"mobile_brand,omitempty"`
+	UsageType          string  `json:"usage_type,omitempty"`   // IP2Location 使用类型，例如 ISP、DCH
+	AddressType        string  `json:"address_type,omitempty"`
+	Category           string  `json:"category,omitempty"`     // IAB 分类
+	NetSpeed           string  `json:"net_speed,omitempty"`
+	IDDCode            string  `json:"idd_code,omitempty"`
+	AreaCode           string  `json:"area_code,omitempty"`
+	WeatherStationCode string  `json:"weather_station_code,omitempty"`
+	WeatherStationName string  `json:"weather_station_name,omitempty"`
+	Elevation          float64 `json:"elevation,omitempty"`    // 海拔，单位米
+	// 匿名标记仅在加载了 Anonymous-IP 数据库时出现
+	IsAnonymous        *bool   `json:"is_anonymous,omitempty"`
+	IsAnonymousVPN     *bool   `json:"is_anonymous_vpn,omitempty"`
+	IsHostingProvider  *bool   `json:"is_hosting_provider,omitempty"`
+	IsPublicProxy      *bool   `json:"is_public_proxy,omitempty"`
+	IsResidentialProxy *bool   `json:"is_residential_proxy,omitempty"`
+	IsTorExitNode      *bool   `json:"is_tor_exit_node,omitempty"`
+	Embedded           *EmbeddedInfo `json:"embedded,omitempty"` // IPv6 中嵌入的 IPv4 地址
+	Classification     *ipclass.Classification `json:"classification,omitempty"` // IANA 特殊用途地址分类
+	Tags               map[string]string `json:"tags,omitempty"` // 覆盖记录的自定义标签
+	Custom             map[string]interface{} `json:"custom,omitempty"` // custom 数据库的记录，键是数据库名称
+	Sources            []geoip.SourceStatus `json:"sources,omitempty"` // 每个数据库是否包含该地址
+	Debug              *SourcesDebug `json:"debug,omitempty"` // ?debug=sources 的数据源明细
 	Message            string  `json:"message,omitempty"`      // 用于错误信息
 }
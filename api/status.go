@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"ip-api/geoip"
+	"ip-api/updater"
+)
+
+// ReadyHandler 报告注册表中的数据库是否已加载，
+// 所有必需的数据库都可用时返回 200，否则返回 503
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	status, code := "ok", http.StatusOK
+	if err := geoip.Ready(); err != nil {
+		status, code = err.Error(), http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, code, map[string]interface{}{
+		"status":    status,
+		"databases": geoip.Status(),
+	})
+}
+
+// UpdaterStatusHandler 返回每个数据库的更新状态，包括最近一次金丝雀校验的结果
+// GET /admin/updater/status
+func UpdaterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, updater.Status())
+}
+
+// MetricsHandler 以 Prometheus 文本格式输出更新器指标
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	updater.WriteMetrics(w)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ip-api/api"
+	"ip-api/config"
+	"ip-api/dbbuild"
+	"ip-api/dbdiff"
+	"ip-api/firewall"
+	"ip-api/geoip"
+	"ip-api/overrides"
+	"ip-api/updater"
+)
+
+// commands 是可用的命令行子命令
+var commands = map[string]struct {
+	usage string
+	run   func(args []string) error
+}{
+	"versions": {"versions <db>", cmdVersions},
+	"rollback": {"rollback <db> <build_epoch>", cmdRollback},
+	"unpin":    {"unpin <db>", cmdUnpin},
+	"diff":     {"diff <old.mmdb> <new.mmdb> [city|asn|cn]", cmdDiff},
+	"export":   {"export <format> country=CN [asn=4134] [province=..] [city=..] [isp=..] [family=4|6] [name=..]", cmdExport},
+
+	"bundle-keygen": {"bundle-keygen <private-key-file>", cmdBundleKeygen},
+	"bundle-export": {"bundle-export <bundle.tar.gz> [private-key-file]", cmdBundleExport},
+	"bundle-import": {"bundle-import <bundle.tar.gz>", cmdBundleImport},
+
+	"build-merged": {"build-merged <out.mmdb> [countries=CN,HK] [publish]", cmdBuildMerged},
+	"build-mmdb":   {"build-mmdb <in.csv|in.jsonl> <out.mmdb> [schema=city|custom] [type=<database_type>]", cmdBuildMMDB},
+}
+
+// runCommand 执行命令行子命令并返回进程退出码
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nUsage:\n", args[0])
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", os.Args[0], c.usage)
+		}
+		return 2
+	}
+
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// printJSON 将 v 以缩进的 JSON 格式输出到标准输出
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func cmdVersions(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: versions <db>")
+	}
+	versions, err := updater.ListVersions(args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(versions)
+}
+
+func cmdRollback(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: rollback <db> <build_epoch>")
+	}
+	epoch, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid build epoch %q", args[1])
+	}
+	if _, err := updater.Rollback(args[0], uint(epoch)); err != nil {
+		return err
+	}
+	fmt.Println("Rolled back. Send SIGHUP to the running server to reload its databases.")
+	return nil
+}
+
+func cmdUnpin(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unpin <db>")
+	}
+	return updater.Unpin(args[0])
+}
+
+func cmdDiff(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("usage: diff <old.mmdb> <new.mmdb> [city|asn|cn]")
+	}
+	role := ""
+	if len(args) == 3 {
+		role = args[2]
+	}
+	report, err := dbdiff.Compare(args[0], args[1], role)
+	if err != nil {
+		return err
+	}
+	return printJSON(report)
+}
+
+func cmdExport(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: export <%s> key=value...", strings.Join(firewall.Formats(), "|"))
+	}
+	query := url.Values{"format": {args[0]}}
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid argument %q, expected key=value", arg)
+		}
+		query.Add(key, value)
+	}
+	opts, err := firewall.ParseOptions(query)
+	if err != nil {
+		return err
+	}
+	result, err := firewall.Export(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d IPv4 and %d IPv6 prefixes\n", result.IPv4, result.IPv6)
+	_, err = os.Stdout.Write(result.Body)
+	return err
+}
+
+func cmdBundleKeygen(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: bundle-keygen <private-key-file>")
+	}
+	private, public, err := updater.GenerateBundleKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(args[0], os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, private); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Private key written to %s. Add the public key to bundle.trusted_keys:\n%s\n", args[0], public)
+	return nil
+}
+
+func cmdBundleExport(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("usage: bundle-export <bundle.tar.gz> [private-key-file]")
+	}
+	keyFile := config.App.Bundle.SigningKeyFile
+	if len(args) == 2 {
+		keyFile = args[1]
+	}
+	if keyFile == "" {
+		return fmt.Errorf("no signing key: pass a key file or set bundle.signing_key_file")
+	}
+	key, err := updater.LoadSigningKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	tmp := args[0] + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	manifest, err := updater.ExportBundle(f, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, args[0]); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return printJSON(manifest)
+}
+
+func cmdBundleImport(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: bundle-import <bundle.tar.gz>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, installed, err := updater.ImportBundle(f)
+	if manifest != nil {
+		printJSON(manifest)
+	}
+	for _, db := range installed {
+		fmt.Printf("Imported %s\n", db.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(installed) > 0 {
+		fmt.Println("Send SIGHUP to the running server to reload its databases, or upload the bundle to /admin/bundle instead.")
+	}
+	return nil
+}
+
+func cmdBuildMerged(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: build-merged <out.mmdb> [countries=CN,HK] [publish]")
+	}
+	var opts dbbuild.MergedOptions
+	publish := false
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		switch {
+		case ok && key == "countries":
+			opts.Countries = strings.Split(value, ",")
+		case arg == "publish":
+			publish = true
+		default:
+			return fmt.Errorf("invalid argument %q", arg)
+		}
+	}
+
+	if err := geoip.OpenDBs(config.App.Databases); err != nil {
+		return err
+	}
+	defer geoip.CloseDBs()
+	if config.App.Overrides != "" {
+		if err := overrides.Load(config.App.Overrides); err != nil {
+			return err
+		}
+	}
+
+	// 每个网络都会经过查询接口的响应构建，构建期间关闭它们的日志
+	log.SetOutput(io.Discard)
+	manifest, err := dbbuild.BuildMerged(args[0], opts, api.MergedRecord)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d networks to %s\n", manifest.Networks, args[0])
+
+	if publish {
+		for _, path := range []string{args[0], args[0] + ".sha256", args[0] + ".json"} {
+			location, err := updater.PublishFile("merged/"+filepath.Base(path), path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Published %s\n", location)
+		}
+	}
+	return printJSON(manifest)
+}
+
+func cmdBuildMMDB(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: build-mmdb <in.csv|in.jsonl> <out.mmdb> [schema=city|custom] [type=<database_type>]")
+	}
+	var opts dbbuild.CompileOptions
+	for _, arg := range args[2:] {
+		key, value, _ := strings.Cut(arg, "=")
+		switch key {
+		case "schema":
+			opts.Schema = value
+		case "type":
+			opts.DatabaseType = value
+		default:
+			return fmt.Errorf("invalid argument %q", arg)
+		}
+	}
+	report, err := dbbuild.Compile(args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d rows as %d networks to %s and verified %d rows\n", report.Rows, report.Networks, args[1], report.Verified)
+	return printJSON(report)
+}
@@ -0,0 +1,22 @@
+package config
+
+// Bundle 配置离线部署使用的数据库包。
+// 数据库包是包含清单、签名和数据库文件的 tar.gz，清单使用 ed25519 签名。
+type Bundle struct {
+	// TrustedKeys 是允许导入的签名公钥（base64），为空时拒绝导入任何数据库包。
+	TrustedKeys []string `json:"trusted_keys"`
+
+	// SigningKeyFile 是导出时使用的私钥文件（base64），由 bundle-keygen 生成。
+	SigningKeyFile string `json:"signing_key_file,omitempty"`
+
+	// MaxSize 是导入的数据库包解压后的最大字节数，默认 2 GiB。
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+// MaxBundleSize 返回导入数据库包允许的最大字节数
+func (b Bundle) MaxBundleSize() int64 {
+	if b.MaxSize > 0 {
+		return b.MaxSize
+	}
+	return 2 << 30
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// Canary 配置新数据库上线前的金丝雀校验。
+// 每个新版本都会先在暂存读取器中查询黄金 IP 集合，
+// 失败比例超过 MaxFailureRatio 时拒绝该版本。
+type Canary struct {
+	// Golden 是黄金 IP 断言集合，为空时跳过金丝雀校验。
+	Golden []GoldenIP `json:"golden"`
+
+	// MaxFailureRatio 是允许失败的断言比例（0-1）。
+	MaxFailureRatio float64 `json:"max_failure_ratio"`
+}
+
+// GoldenIP 是一个 IP 的期望查询结果。
+// 每个字段只用于校验相应角色的数据库：Country、Province 和坐标用于 city，
+// ASN 用于 asn，Province 同时用于 cn。
+type GoldenIP struct {
+	// IP 是要查询的地址。
+	IP string `json:"ip"`
+
+	// Country 是可接受的 ISO 国家代码，任意一个匹配即通过。
+	Country []string `json:"country,omitempty"`
+
+	// ASN 是可接受的自治系统号。
+	ASN []uint `json:"asn,omitempty"`
+
+	// Province 是可接受的省份/地区名称，允许省略“省”“市”等后缀。
+	Province []string `json:"province,omitempty"`
+
+	// Latitude 和 Longitude 是期望的坐标，与 RadiusKm 一起使用。
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+
+	// RadiusKm 是坐标允许的偏差（公里），默认 100。
+	RadiusKm float64 `json:"radius_km,omitempty"`
+}
+
+// validateCanary 检查黄金 IP 的地址和坐标，写错的地址会让该断言被悄悄跳过
+func validateCanary(c Canary) error {
+	if c.MaxFailureRatio < 0 || c.MaxFailureRatio > 1 {
+		return fmt.Errorf("canary max_failure_ratio %v must be between 0 and 1", c.MaxFailureRatio)
+	}
+	for i, g := range c.Golden {
+		if net.ParseIP(g.IP) == nil {
+			return fmt.Errorf("canary golden %d: invalid ip %q", i, g.IP)
+		}
+		if (g.Latitude == nil) != (g.Longitude == nil) {
+			return fmt.Errorf("canary golden %s: latitude and longitude must be set together", g.IP)
+		}
+	}
+	return nil
+}
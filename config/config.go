@@ -2,51 +2,116 @@ package config
 
 import "time"
 
+// 数据库更新方式
+const (
+	UpdateModeDownload = "download"
+	UpdateModeWatch    = "watch"
+	UpdateModeOffline  = "offline"
+)
+
+// IPv6 地址中嵌入的 IPv4 地址（6to4、Teredo、NAT64、IPv4 映射）由哪一方填充响应的顶层字段
+const (
+	EmbeddedPreferInner = "inner" // 嵌入的 IPv4 客户端地址
+	EmbeddedPreferOuter = "outer" // IPv6 地址本身
+)
+
 // App 保存应用程序配置。
-// All values are hardcoded.
+// 默认值在此处硬编码，可以通过 JSON 配置文件覆盖（参见 Load）。
 var App = struct {
 	// DataDir 是存储数据库文件的目录。
-	DataDir string
+	DataDir string `json:"data_dir"`
+
+	// UpdateInterval 是检查数据库更新的默认间隔（小时）。
+	UpdateInterval int `json:"update_interval"`
 
-	// UpdateInterval 是检查数据库更新的间隔（小时）。
-	UpdateInterval int
+	// UpdateMode 是数据库的更新方式：download 由内置更新器下载，
+	// watch 禁用下载并监视 DataDir 中由外部工具（例如 geoipupdate）替换的文件，
+	// offline 禁用所有网络访问，只通过导入数据库包更新。
+	UpdateMode string `json:"update_mode"`
+
+	// EmbeddedIPv4 决定带有嵌入 IPv4 地址的 IPv6 查询使用哪一方的结果填充顶层字段：
+	// inner 使用嵌入的 IPv4 地址，outer 使用 IPv6 地址本身。两者的结果都会在 embedded 中返回。
+	EmbeddedIPv4 string `json:"embedded_ipv4"`
 
 	// ListenAddr 是服务器监听地址。
-	ListenAddr string
+	ListenAddr string `json:"listen_addr"`
 
-	// ReadTimeout 是 HTTP 读取超时时间。
-	ReadTimeout time.Duration
+	// ReadTimeout 是 HTTP 读取超时时间，例如 "5s"。
+	ReadTimeout Duration `json:"read_timeout"`
 
 	// WriteTimeout 是 HTTP 写入超时时间。
-	WriteTimeout time.Duration
+	WriteTimeout Duration `json:"write_timeout"`
 
 	// IdleTimeout 是 HTTP 空闲超时时间。
-	IdleTimeout time.Duration
+	IdleTimeout Duration `json:"idle_timeout"`
+
+	// Databases 是数据库注册表，声明了所有需要下载、校验和加载的数据库。
+	Databases []Database `json:"databases"`
+
+	// Merge 是合并多个数据源结果时按字段和国家指定的优先级规则，排在内置规则之前。
+	Merge []MergeRule `json:"merge"`
+
+	// Overrides 是内部地址段覆盖文件的路径（.csv、.yaml 或 .yml），为空时不启用覆盖。
+	// 文件修改后自动重新加载，管理接口添加和删除的覆盖也写入该文件。
+	Overrides string `json:"overrides"`
+
+	// Canary 是新数据库上线前的黄金 IP 校验配置。
+	Canary Canary `json:"canary"`
+
+	// Mirror 是集群实例之间同步数据库的配置。
+	Mirror Mirror `json:"mirror"`
+
+	// Snapshot 是新数据库校验通过后上传快照的对象存储配置。
+	Snapshot Snapshot `json:"snapshot"`
 
-	// CityDBName 是 GeoLite2 城市数据库的文件名。
-	CityDBName string
+	// Bundle 是离线数据库包的签名配置。
+	Bundle Bundle `json:"bundle"`
 
-	// AsnDBName 是 GeoLite2 ASN 数据库的文件名。
-	AsnDBName string
+	// KeepVersions 是每个数据库保留的历史版本数量，用于回滚。
+	KeepVersions int `json:"keep_versions"`
 
-	// CnDBName 是中国 IP 数据库的文件名。
-	CnDBName string
+	// AdminToken 是管理接口的 Bearer 令牌，为空时禁用管理接口。
+	AdminToken string `json:"admin_token"`
 
 	// MaxMindLicenseKey 是您的 MaxMind 许可证密钥（硬编码配置）。
-	MaxMindLicenseKey string
+	MaxMindLicenseKey string `json:"maxmind_license_key"`
 
 	// GeoapifyAPIKey 是您的 Geoapify API 密钥（用于静态地图服务）。
-	GeoapifyAPIKey string
+	GeoapifyAPIKey string `json:"geoapify_api_key"`
 }{
 	DataDir:        "data",
 	UpdateInterval: 24, // hours
+	UpdateMode:     UpdateModeDownload,
+	EmbeddedIPv4:   EmbeddedPreferInner,
 	ListenAddr:     "0.0.0.0:8180",
-	ReadTimeout:    5 * time.Second,
-	WriteTimeout:   10 * time.Second,
-	IdleTimeout:    120 * time.Second,
-	CityDBName:     "GeoLite2-City.mmdb",
-	AsnDBName:      "GeoLite2-ASN.mmdb",
-	CnDBName:       "GeoCN.mmdb",
-	MaxMindLicenseKey: "", // 请在此处直接设置您的 MaxMind 许可证密钥
+	ReadTimeout:    Duration(5 * time.Second),
+	WriteTimeout:   Duration(10 * time.Second),
+	IdleTimeout:    Duration(120 * time.Second),
+	Databases: []Database{
+		{
+			Name:       "GeoLite2-City",
+			Role:       RoleCity,
+			Source:     Source{Type: SourceMaxMind, EditionID: "GeoLite2-City"},
+			Validation: Validation{MinSize: 2 * 1024 * 1024, DatabaseType: "City"},
+			Required:   true,
+		},
+		{
+			Name:       "GeoLite2-ASN",
+			Role:       RoleASN,
+			Source:     Source{Type: SourceMaxMind, EditionID: "GeoLite2-ASN"},
+			Validation: Validation{MinSize: 2 * 1024 * 1024, DatabaseType: "ASN"},
+			Required:   true,
+		},
+		{
+			Name:       "GeoCN",
+			Role:       RoleCN,
+			Source:     Source{Type: SourceURL, URL: "https://github.com/ljxi/GeoCN/releases/download/Latest/GeoCN.mmdb"},
+			Validation: Validation{MinSize: 2 * 1024 * 1024},
+		},
+	},
+	Canary:            Canary{MaxFailureRatio: 0.1},
+	KeepVersions:      3,
+	AdminToken:        "",
+	MaxMindLicenseKey: "",                       // 请在此处直接设置您的 MaxMind 许可证密钥
 	GeoapifyAPIKey:    "test_key_for_debugging", // 临时测试密钥，请替换为真实密钥
 }
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// 数据库角色决定了数据库在查询路径中的用途
+const (
+	RoleCity   = "city"   // GeoLite2/GeoIP2 City 格式的位置数据库
+	RoleASN    = "asn"    // GeoLite2/GeoIP2 ASN 格式的自治系统数据库
+	RoleCN     = "cn"     // GeoCN 格式的中国地区数据库
+	RoleCustom = "custom" // 任意结构的 MMDB，记录原样出现在响应的 custom 中，不参与合并
+
+	// 付费的 GeoIP2 版本，均为可选
+	RoleEnterprise     = "enterprise"      // GeoIP2 Enterprise，存在时优先于 city
+	RoleISP            = "isp"             // GeoIP2 ISP
+	RoleConnectionType = "connection_type" // GeoIP2 Connection-Type
+	RoleAnonymousIP    = "anonymous_ip"    // GeoIP2 Anonymous-IP
+	RoleDomain         = "domain"          // GeoIP2 Domain
+)
+
+// 数据源类型决定如何读取数据库文件并归一化查询结果
+const (
+	ProviderMaxMind = "maxmind" // MaxMind GeoIP2/GeoLite2 格式，按角色解码
+	ProviderGeoCN   = "geocn"   // GeoCN 格式的 MMDB
+	ProviderFake    = "fake"    // JSON 文件中的固定记录，用于测试
+
+	ProviderIP2Location = "ip2location" // IP2Location BIN 格式（DB1-DB26）
+	ProviderIP2Region   = "ip2region"   // ip2region xdb 格式的中国地区数据库
+	ProviderMMDB        = "mmdb"        // 按字段映射读取任意结构的 MMDB，例如 DB-IP 和 IPinfo
+)
+
+// ip2region 数据源的缓存模式
+const (
+	CacheVectorIndex = "vector_index" // 只缓存向量索引，数据从磁盘读取
+	CacheContent     = "content"      // 将整个文件读入内存
+)
+
+// 数据库来源类型
+const (
+	SourceMaxMind = "maxmind" // 使用许可证密钥从 MaxMind 下载指定版本
+	SourceURL     = "url"     // 从直接 URL 下载（.mmdb 或 .tar.gz）
+	SourceFile    = "file"    // 从本地文件复制
+	SourceS3      = "s3"      // 从 S3 兼容对象存储下载
+)
+
+// Database 描述注册表中的一个数据库
+type Database struct {
+	// Name 是数据库的唯一名称，例如 GeoLite2-City。
+	Name string `json:"name"`
+
+	// File 是 DataDir 中的文件名，默认为 Name 加上数据源类型的扩展名（参见 Extension）。
+	File string `json:"file,omitempty"`
+
+	// Role 是数据库的角色（city/asn/cn/custom 或付费版本的角色）。
+	Role string `json:"role"`
+
+	// Provider 是读取数据库的数据源类型，默认根据角色选择。
+	Provider string `json:"provider,omitempty"`
+
+	// Cache 是 ip2region 数据源的缓存模式（vector_index/content），默认 vector_index。
+	Cache string `json:"cache,omitempty"`
+
+	// Schema 是 mmdb 数据源的内置字段映射名称（参见 BuiltinMappings）。
+	Schema string `json:"schema,omitempty"`
+
+	// Mapping 是 mmdb 数据源的字段映射，键是用 . 分隔的 MMDB 路径（数组用下标），值是记录字段。
+	Mapping map[string]string `json:"mapping,omitempty"`
+
+	// Source 描述从哪里获取数据库。
+	Source Source `json:"source"`
+
+	// UpdateInterval 是该数据库的更新间隔（小时），0 表示使用 App.UpdateInterval。
+	UpdateInterval int `json:"update_interval,omitempty"`
+
+	// Validation 是新版本上线前必须满足的校验规则。
+	Validation Validation `json:"validation"`
+
+	// Required 表示缺少该数据库时服务无法启动，也无法通过就绪检查。
+	Required bool `json:"required"`
+
+	// KeepVersions 覆盖 App.KeepVersions，0 表示使用全局配置。
+	KeepVersions int `json:"keep_versions,omitempty"`
+}
+
+// Source 描述数据库的来源
+type Source struct {
+	// Type 是来源类型（maxmind/url/file/s3）。
+	Type string `json:"type"`
+
+	// EditionID 是 MaxMind 的数据库版本 ID，用于 maxmind 类型。
+	EditionID string `json:"edition_id,omitempty"`
+
+	// URL 是下载地址，用于 url 类型。
+	URL string `json:"url,omitempty"`
+
+	// Path 是本地文件路径，用于 file 类型。
+	Path string `json:"path,omitempty"`
+
+	// S3 是对象存储中的位置，用于 s3 类型。
+	S3 S3 `json:"s3"`
+}
+
+// Validation 描述数据库文件的校验规则
+type Validation struct {
+	// MinSize 是下载文件的最小字节数，用于识别错误页面。
+	MinSize int64 `json:"min_size,omitempty"`
+
+	// DatabaseType 如果非空，MMDB 元数据中的 database_type 必须包含该字符串。
+	DatabaseType string `json:"database_type,omitempty"`
+}
+
+// FileName 返回数据库在 DataDir 中的文件名
+func (d Database) FileName() string {
+	if d.File != "" {
+		return d.File
+	}
+	return d.Name + d.Extension()
+}
+
+// Extension 返回数据源类型的文件扩展名，也用于从下载的压缩包中找到数据库文件
+func (d Database) Extension() string {
+	switch d.ProviderKind() {
+	case ProviderIP2Location:
+		return ".BIN"
+	case ProviderIP2Region:
+		return ".xdb"
+	case ProviderFake:
+		return ".json"
+	default:
+		return ".mmdb"
+	}
+}
+
+// IsMMDB 检查数据库文件是否是 MMDB 格式，只有 MMDB 支持按网络遍历和差异比较
+func (d Database) IsMMDB() bool {
+	return d.Extension() == ".mmdb"
+}
+
+// ProviderKind 返回数据库的数据源类型，cn 角色默认使用 GeoCN，其他角色默认使用 MaxMind
+func (d Database) ProviderKind() string {
+	if d.Provider != "" {
+		return d.Provider
+	}
+	if d.Role == RoleCN {
+		return ProviderGeoCN
+	}
+	return ProviderMaxMind
+}
+
+// Path 返回数据库文件的完整路径
+func (d Database) Path() string {
+	return filepath.Join(App.DataDir, d.FileName())
+}
+
+// Interval 返回数据库的更新间隔
+func (d Database) Interval() time.Duration {
+	hours := d.UpdateInterval
+	if hours <= 0 {
+		hours = App.UpdateInterval
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// VersionsToKeep 返回该数据库保留的历史版本数量
+func (d Database) VersionsToKeep() int {
+	if d.KeepVersions > 0 {
+		return d.KeepVersions
+	}
+	return App.KeepVersions
+}
+
+// DatabaseByName 返回指定名称的数据库
+func DatabaseByName(name string) (Database, bool) {
+	for _, db := range App.Databases {
+		if db.Name == name {
+			return db, true
+		}
+	}
+	return Database{}, false
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Load 从 JSON 文件加载配置并覆盖硬编码的默认值。
+// 文件不存在时保留默认值并返回 nil。
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// 结构体字段在默认值上逐个覆盖，但切片会复用已有的元素，
+	// 文件中的数据库会继承同一位置的默认数据库中未设置的字段，因此先清空切片
+	defaults := App.Databases
+	App.Databases, App.Merge = nil, nil
+	if err := json.Unmarshal(data, &App); err != nil {
+		App.Databases = defaults
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if App.Databases == nil {
+		App.Databases = defaults
+	}
+	return validate()
+}
+
+// Duration 是配置文件中的时间长度，可以写成 "5s"、"2m" 这样的字符串，也可以写成秒数
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// validate 检查更新方式、嵌入地址偏好、合并规则、金丝雀断言、覆盖文件、镜像和快照配置以及数据库注册表是否有效
+func validate() error {
+	switch App.UpdateMode {
+	case UpdateModeDownload, UpdateModeWatch, UpdateModeOffline:
+	default:
+		return fmt.Errorf("unknown update_mode %q", App.UpdateMode)
+	}
+
+	switch App.EmbeddedIPv4 {
+	case EmbeddedPreferInner, EmbeddedPreferOuter:
+	default:
+		return fmt.Errorf("unknown embedded_ipv4 preference %q", App.EmbeddedIPv4)
+	}
+
+	if err := validateMerge(App.Merge); err != nil {
+		return err
+	}
+	if err := validateCanary(App.Canary); err != nil {
+		return err
+	}
+
+	if App.Overrides != "" {
+		switch strings.ToLower(filepath.Ext(App.Overrides)) {
+		case ".csv", ".yaml", ".yml":
+		default:
+			return fmt.Errorf("overrides file %s must be .csv, .yaml or .yml", App.Overrides)
+		}
+	}
+
+	switch App.Mirror.Mode {
+	case "":
+	case MirrorLeader, MirrorFollower:
+		if App.Mirror.Token == "" {
+			return fmt.Errorf("mirror %s mode requires a token", App.Mirror.Mode)
+		}
+		if App.Mirror.Mode == MirrorFollower && App.Mirror.LeaderURL == "" {
+			return fmt.Errorf("mirror follower mode requires leader_url")
+		}
+	default:
+		return fmt.Errorf("unknown mirror mode %q", App.Mirror.Mode)
+	}
+
+	if App.Snapshot.S3.Bucket != "" && App.Snapshot.S3.Endpoint == "" {
+		return fmt.Errorf("snapshot s3 target requires endpoint")
+	}
+
+	seen := make(map[string]bool)
+	for _, db := range App.Databases {
+		if db.Name == "" {
+			return fmt.Errorf("database entry without name")
+		}
+		if seen[db.FileName()] {
+			return fmt.Errorf("duplicate database %s", db.FileName())
+		}
+		seen[db.FileName()] = true
+
+		switch db.Role {
+		case RoleCity, RoleASN, RoleCN, RoleCustom,
+			RoleEnterprise, RoleISP, RoleConnectionType, RoleAnonymousIP, RoleDomain:
+		default:
+			return fmt.Errorf("database %s: unknown role %q", db.Name, db.Role)
+		}
+
+		switch db.ProviderKind() {
+		case ProviderMaxMind, ProviderGeoCN, ProviderFake, ProviderIP2Location, ProviderIP2Region, ProviderMMDB:
+		default:
+			return fmt.Errorf("database %s: unknown provider %q", db.Name, db.Provider)
+		}
+		if db.Role == RoleCustom && db.ProviderKind() != ProviderMaxMind {
+			return fmt.Errorf("database %s: custom role requires the %s provider", db.Name, ProviderMaxMind)
+		}
+		if err := validateMapping(db); err != nil {
+			return err
+		}
+
+		switch db.Cache {
+		case "", CacheVectorIndex, CacheContent:
+		default:
+			return fmt.Errorf("database %s: unknown cache mode %q", db.Name, db.Cache)
+		}
+
+		switch db.Source.Type {
+		case SourceMaxMind:
+			if db.Source.EditionID == "" {
+				return fmt.Errorf("database %s: maxmind source requires edition_id", db.Name)
+			}
+		case SourceURL:
+			if db.Source.URL == "" {
+				return fmt.Errorf("database %s: url source requires url", db.Name)
+			}
+		case SourceFile:
+			if db.Source.Path == "" {
+				return fmt.Errorf("database %s: file source requires path", db.Name)
+			}
+		case SourceS3:
+			s3 := db.Source.S3
+			if s3.Endpoint == "" || s3.Bucket == "" || s3.Key == "" {
+				return fmt.Errorf("database %s: s3 source requires endpoint, bucket and key", db.Name)
+			}
+		default:
+			return fmt.Errorf("database %s: unknown source type %q", db.Name, db.Source.Type)
+		}
+	}
+	return nil
+}
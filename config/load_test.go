@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withDefaults 在测试结束后恢复全局配置
+func withDefaults(t *testing.T) {
+	saved := App
+	saved.Databases = append([]Database(nil), App.Databases...)
+	t.Cleanup(func() { App = saved })
+}
+
+func writeConfig(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadReplacesDefaultDatabases(t *testing.T) {
+	withDefaults(t)
+	path := writeConfig(t, `{"databases": [
+		{"name": "GeoCN", "role": "cn", "source": {"type": "url", "url": "https://example.com/GeoCN.mmdb"}}
+	]}`)
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(App.Databases) != 1 {
+		t.Fatalf("got %d databases, want 1", len(App.Databases))
+	}
+	db := App.Databases[0]
+	if db.Required || db.Validation.DatabaseType != "" || db.Validation.MinSize != 0 || db.Source.EditionID != "" {
+		t.Errorf("database inherited default fields: %+v", db)
+	}
+}
+
+func TestLoadKeepsDefaultsForMissingKeys(t *testing.T) {
+	withDefaults(t)
+	want := len(App.Databases)
+	path := writeConfig(t, `{"listen_addr": "127.0.0.1:9000"}`)
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(App.Databases) != want || App.Databases[0].Validation.DatabaseType != "City" {
+		t.Errorf("default databases were not kept: %+v", App.Databases)
+	}
+	if App.ListenAddr != "127.0.0.1:9000" || App.UpdateInterval != 24 {
+		t.Errorf("unexpected scalar fields: %q %d", App.ListenAddr, App.UpdateInterval)
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{`"5s"`, 5 * time.Second, true},
+		{`"1m30s"`, 90 * time.Second, true},
+		{`5`, 5 * time.Second, true},
+		{`0.5`, 500 * time.Millisecond, true},
+		{`"five"`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		var d Duration
+		err := json.Unmarshal([]byte(tt.in), &d)
+		if (err == nil) != tt.ok || time.Duration(d) != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, %v; want %v, ok=%v", tt.in, time.Duration(d), err, tt.want, tt.ok)
+		}
+	}
+	data, _ := json.Marshal(Duration(10 * time.Second))
+	if string(data) != `"10s"` {
+		t.Errorf("Marshal = %s", data)
+	}
+}
+
+func TestLoadRejectsInvalidCanary(t *testing.T) {
+	tests := []struct {
+		body, err string
+	}{
+		{`{"canary": {"golden": [{"ip": "8.8.8.8", "country": ["US"]}, {"ip": "1.1.1.300", "country": ["AU"]}]}}`, `canary golden 1: invalid ip "1.1.1.300"`},
+		{`{"canary": {"golden": [{"ip": "", "asn": [15169]}]}}`, `canary golden 0: invalid ip ""`},
+		{`{"canary": {"golden": [{"ip": "8.8.8.8", "latitude": 37.4}]}}`, "latitude and longitude must be set together"},
+		{`{"canary": {"max_failure_ratio": 1.5}}`, "max_failure_ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			withDefaults(t)
+			err := Load(writeConfig(t, tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Load(%s) error = %v, want %q", tt.body, err, tt.err)
+			}
+		})
+	}
+
+	withDefaults(t)
+	if err := Load(writeConfig(t, `{"canary": {"golden": [{"ip": "2001:4860:4860::8888", "asn": [15169]}]}}`)); err != nil {
+		t.Errorf("valid canary rejected: %v", err)
+	}
+}
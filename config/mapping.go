@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MappingTargets 是 mmdb 数据源的字段映射可以写入的记录字段。
+// 名称字段接受语言映射，也可以写作 city_names.zh-CN 只写入一种语言；字符串值视为英文名称。
+var MappingTargets = []string{
+	"country_code", "country_names", "continent_code", "region_code", "region_names", "city_names",
+	"district", "postal", "latitude", "longitude", "accuracy_radius", "time_zone", "utc_offset",
+	"asn", "as_organization", "isp", "organization", "connection_type", "user_type", "domain",
+	"mobile_country_code", "mobile_network_code", "mobile_brand", "usage_type",
+}
+
+// BuiltinMappings 是内置的字段映射，键是 Database.Schema 的值，值是 MMDB 路径到记录字段的映射
+var BuiltinMappings = map[string]map[string]string{
+	// DB-IP IP to City Lite，结构与 GeoLite2 City 相同，但没有地区代码、邮政编码和时区
+	"dbip-city-lite": {
+		"continent.code":       "continent_code",
+		"country.iso_code":     "country_code",
+		"country.names":        "country_names",
+		"subdivisions.0.names": "region_names",
+		"city.names":           "city_names",
+		"location.latitude":    "latitude",
+		"location.longitude":   "longitude",
+	},
+	// DB-IP IP to Country Lite
+	"dbip-country-lite": {
+		"continent.code":   "continent_code",
+		"country.iso_code": "country_code",
+		"country.names":    "country_names",
+	},
+	// DB-IP IP to ASN Lite，结构与 GeoLite2 ASN 相同
+	"dbip-asn-lite": {
+		"autonomous_system_number":       "asn",
+		"autonomous_system_organization": "as_organization",
+	},
+	// IPinfo Lite，一个文件同时包含国家和 ASN，asn 是 AS13335 形式的字符串
+	"ipinfo-lite": {
+		"continent_code": "continent_code",
+		"country_code":   "country_code",
+		"country":        "country_names",
+		"asn":            "asn",
+		"as_name":        "as_organization",
+	},
+}
+
+// FieldMapping 返回 mmdb 数据源从记录字段到 MMDB 路径的映射。
+// Mapping 中的字段覆盖 Schema 内置映射中写入同一字段的路径。
+func (d Database) FieldMapping() map[string]string {
+	fields := make(map[string]string)
+	for path, field := range BuiltinMappings[d.Schema] {
+		fields[field] = path
+	}
+	for path, field := range d.Mapping {
+		fields[field] = path
+	}
+	return fields
+}
+
+// validateMapping 检查 mmdb 数据源的内置映射名称和映射的目标字段
+func validateMapping(db Database) error {
+	if db.ProviderKind() != ProviderMMDB {
+		if db.Schema != "" || len(db.Mapping) > 0 {
+			return fmt.Errorf("database %s: schema and mapping require the %s provider", db.Name, ProviderMMDB)
+		}
+		return nil
+	}
+	if db.Schema == "" && len(db.Mapping) == 0 {
+		return fmt.Errorf("database %s: %s provider requires schema or mapping", db.Name, ProviderMMDB)
+	}
+	if _, ok := BuiltinMappings[db.Schema]; db.Schema != "" && !ok {
+		return fmt.Errorf("database %s: unknown schema %q", db.Name, db.Schema)
+	}
+
+	known := make(map[string]bool, len(MappingTargets))
+	for _, f := range MappingTargets {
+		known[f] = true
+	}
+	mapped := make(map[string]bool, len(db.Mapping))
+	for path, field := range db.Mapping {
+		if path == "" {
+			return fmt.Errorf("database %s: empty MMDB path in mapping", db.Name)
+		}
+		base, lang, hasLang := strings.Cut(field, ".")
+		if !known[base] || (hasLang && (lang == "" || !strings.HasSuffix(base, "_names"))) {
+			return fmt.Errorf("database %s: unknown mapping field %q", db.Name, field)
+		}
+		if mapped[field] {
+			return fmt.Errorf("database %s: mapping field %q has more than one path", db.Name, field)
+		}
+		mapped[field] = true
+	}
+	return nil
+}
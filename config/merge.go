@@ -0,0 +1,69 @@
+package config
+
+import "fmt"
+
+// 可以单独指定数据源优先级的字段。名称、代码和 GeoCN 的行政区划代码随所属字段一起合并。
+const (
+	FieldCountry        = "country"         // 国家代码和名称
+	FieldContinent      = "continent"       // 大洲代码
+	FieldRegion         = "region"          // 省份/地区名称、代码和 GeoCN 省份代码
+	FieldCity           = "city"            // 城市名称和 GeoCN 城市代码
+	FieldDistrict       = "district"        // 区县名称和代码
+	FieldPostal         = "postal"          // 邮政编码
+	FieldLocation       = "location"        // 坐标
+	FieldTimeZone       = "time_zone"       // 时区名称或 UTC 偏移
+	FieldElevation      = "elevation"       // 海拔
+	FieldAreaCode       = "area_code"       // 国际和地区电话区号
+	FieldWeather        = "weather_station" // 气象站代码和名称
+	FieldASN            = "asn"             // 自治系统号和组织
+	FieldISP            = "isp"             // ISP
+	FieldOrganization   = "organization"    // 组织
+	FieldConnectionType = "connection_type" // 连接类型
+	FieldUserType       = "user_type"       // 用户类型
+	FieldDomain         = "domain"          // 域名
+	FieldMobile         = "mobile"          // 移动国家代码、网络代码和运营商品牌
+	FieldNetSpeed       = "net_speed"       // 网络速度
+	FieldUsageType      = "usage_type"      // 使用类型、地址类型和分类
+	FieldAnonymizer     = "anonymizer"      // 匿名网络标记
+)
+
+// MergeFields 是所有可合并的字段，按合并顺序排列。国家最先合并，它决定其他字段使用的规则。
+var MergeFields = []string{
+	FieldCountry, FieldContinent, FieldRegion, FieldCity, FieldDistrict, FieldPostal, FieldLocation, FieldTimeZone,
+	FieldElevation, FieldAreaCode, FieldWeather,
+	FieldASN, FieldISP, FieldOrganization, FieldConnectionType, FieldUserType, FieldDomain, FieldMobile,
+	FieldNetSpeed, FieldUsageType, FieldAnonymizer,
+}
+
+// MergeRule 指定一组字段的数据源优先级。
+// 规则按顺序匹配，第一条包含该字段且国家匹配的规则生效；未列出的数据源排在规则之后。
+type MergeRule struct {
+	// Fields 是规则适用的字段。
+	Fields []string `json:"fields"`
+
+	// Countries 是规则适用的 ISO 国家代码（合并后的国家），为空时适用于所有国家。
+	// 国家字段本身只使用不限国家的规则。
+	Countries []string `json:"countries,omitempty"`
+
+	// Sources 是按优先级排列的数据库名称或角色。
+	Sources []string `json:"sources"`
+}
+
+// validateMerge 检查合并规则中的字段名称和数据源是否有效
+func validateMerge(rules []MergeRule) error {
+	known := make(map[string]bool, len(MergeFields))
+	for _, f := range MergeFields {
+		known[f] = true
+	}
+	for i, rule := range rules {
+		if len(rule.Fields) == 0 || len(rule.Sources) == 0 {
+			return fmt.Errorf("merge rule %d requires fields and sources", i)
+		}
+		for _, f := range rule.Fields {
+			if !known[f] {
+				return fmt.Errorf("merge rule %d: unknown field %q", i, f)
+			}
+		}
+	}
+	return nil
+}
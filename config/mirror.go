@@ -0,0 +1,21 @@
+package config
+
+// 集群镜像角色
+const (
+	MirrorLeader   = "leader"
+	MirrorFollower = "follower"
+)
+
+// Mirror 配置实例之间的数据库同步。
+// leader 通过 /mirror/ 接口发布当前已校验的数据库文件，
+// follower 从 leader 拉取数据库，leader 不可用时退回到上游来源。
+type Mirror struct {
+	// Mode 是 leader、follower 或为空（不参与同步）。
+	Mode string `json:"mode"`
+
+	// Token 是 /mirror/ 接口的 Bearer 令牌，leader 和 follower 必须一致。
+	Token string `json:"token"`
+
+	// LeaderURL 是 follower 访问的 leader 地址，例如 http://10.0.0.1:8180。
+	LeaderURL string `json:"leader_url"`
+}
@@ -0,0 +1,36 @@
+package config
+
+// S3 描述 S3 兼容对象存储（例如 MinIO）中的一个位置，使用路径风格的 URL 访问
+type S3 struct {
+	// Endpoint 是服务地址，例如 https://minio.internal:9000 或 https://s3.us-east-1.amazonaws.com。
+	Endpoint string `json:"endpoint"`
+
+	// Region 是签名使用的区域，默认 us-east-1。
+	Region string `json:"region,omitempty"`
+
+	// Bucket 是存储桶名称。
+	Bucket string `json:"bucket"`
+
+	// Key 是对象键；用于快照时是对象键的前缀。
+	Key string `json:"key,omitempty"`
+
+	// AccessKeyID 和 SecretAccessKey 是访问凭据，
+	// 为空时使用环境变量 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY。
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+}
+
+// SigningRegion 返回签名使用的区域
+func (s S3) SigningRegion() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	return "us-east-1"
+}
+
+// Snapshot 配置将每个校验通过的新数据库上传到对象存储作为快照
+type Snapshot struct {
+	// S3 是快照的存储位置，Bucket 为空时不上传。
+	// 对象键为 <key>/<数据库名称>/<构建时间>.mmdb。
+	S3 S3 `json:"s3"`
+}
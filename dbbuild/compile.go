@@ -0,0 +1,361 @@
+package dbbuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+
+	"ip-api/mmdbwriter"
+)
+
+// 编译数据库的记录结构
+const (
+	SchemaCity   = "city"   // 与 GeoLite2-City 兼容，可以作为 city 角色的数据库加载
+	SchemaCustom = "custom" // 输入的字段原样写入记录，适合作为 custom 角色的数据库加载
+)
+
+// cityColumns 是 city 结构可以使用的字段
+var cityColumns = map[string]bool{
+	"country": true, "country_name": true, "continent": true, "region": true, "region_code": true,
+	"city": true, "postal": true, "latitude": true, "longitude": true, "accuracy_radius": true, "time_zone": true,
+}
+
+// CompileOptions 是编译数据库的选项
+type CompileOptions struct {
+	// Schema 是记录结构（city/custom），默认 city
+	Schema string
+	// DatabaseType 是元数据中的 database_type，city 结构默认 GeoLite2-City，custom 结构默认 Internal-Custom。
+	// geoip2-golang 只按已知的类型名称读取城市数据，使用其他名称时 geoip2 的 City 查询会拒绝该文件。
+	DatabaseType string
+}
+
+// CompileReport 描述一次编译的结果
+type CompileReport struct {
+	File         string `json:"file"`
+	SHA256       string `json:"sha256"`
+	Size         int64  `json:"size"`
+	DatabaseType string `json:"database_type"`
+	Schema       string `json:"schema"`
+	BuildEpoch   uint   `json:"build_epoch"`
+	Rows         int    `json:"rows"`
+	Networks     int    `json:"networks"`
+	Verified     int    `json:"verified"`
+}
+
+// Compile 把 CSV 或 JSONL 文件编译为 path 处的 MMDB 文件，写入后重新打开并逐行校验。
+// 文件是 IPv6 数据库，IPv4 数据也可以通过 ::ffff:0:0/96 和 2002::/16 查询。
+func Compile(input, path string, opts CompileOptions) (*CompileReport, error) {
+	if opts.Schema == "" {
+		opts.Schema = SchemaCity
+	}
+	if opts.DatabaseType == "" {
+		switch opts.Schema {
+		case SchemaCity:
+			opts.DatabaseType = "GeoLite2-City"
+		case SchemaCustom:
+			opts.DatabaseType = "Internal-Custom"
+		}
+	}
+	if opts.Schema != SchemaCity && opts.Schema != SchemaCustom {
+		return nil, fmt.Errorf("unknown schema %q", opts.Schema)
+	}
+
+	rows, err := ReadRows(input)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]interface{}, len(rows))
+	var errs []error
+	for i, row := range rows {
+		if opts.Schema == SchemaCity {
+			records[i], err = cityRecord(row.Fields)
+		} else {
+			records[i], err = customRecord(row.Fields)
+		}
+		if err != nil && len(errs) < maxRowErrors {
+			errs = append(errs, fmt.Errorf("line %d: %w", row.Line, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	report := &CompileReport{
+		File:         filepath.Base(path),
+		DatabaseType: opts.DatabaseType,
+		Schema:       opts.Schema,
+		BuildEpoch:   uint(time.Now().Unix()),
+		Rows:         len(rows),
+	}
+	w := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: opts.DatabaseType,
+		Description:  map[string]string{"en": "Compiled from " + filepath.Base(input)},
+		Languages:    []string{"en"},
+		BuildEpoch:   time.Unix(int64(report.BuildEpoch), 0),
+	})
+	for i, row := range rows {
+		for _, p := range row.Range.Prefixes() {
+			if err := w.Insert(p, records[i]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			report.Networks++
+		}
+	}
+
+	if report.SHA256, report.Size, err = writeAtomic(path, w); err != nil {
+		return nil, err
+	}
+	if report.Verified, err = Verify(path, rows, records, opts.Schema); err != nil {
+		return nil, fmt.Errorf("verify %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Verify 重新打开数据库，检查每行范围的第一个和最后一个地址都能查到与输入相同的记录，
+// IPv4 行还通过 2002::/16 别名查询一次。city 结构的数据库在 geoip2 支持其类型时还用 geoip2 读取国家代码。
+// 返回校验通过的行数。
+func Verify(path string, rows []Row, records []map[string]interface{}, schema string) (int, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var city *geoip2.Reader
+	if schema == SchemaCity {
+		reader, err := geoip2.Open(path)
+		var unknown geoip2.UnknownDatabaseTypeError
+		switch {
+		case errors.As(err, &unknown):
+			reader.Close()
+		case err != nil:
+			return 0, err
+		default:
+			city = reader
+			defer city.Close()
+		}
+	}
+
+	for i, row := range rows {
+		want, err := json.Marshal(records[i])
+		if err != nil {
+			return i, err
+		}
+		addrs := []netip.Addr{row.Range.From, row.Range.To}
+		if row.Range.From.Is4() {
+			addrs = append(addrs, sixToFour(row.Range.From))
+		}
+		for _, addr := range addrs {
+			var got interface{}
+			_, found, err := db.LookupNetwork(net.IP(addr.AsSlice()), &got)
+			if err != nil {
+				return i, fmt.Errorf("line %d: %s: %w", row.Line, addr, err)
+			}
+			if !found {
+				return i, fmt.Errorf("line %d: %s is not in the database", row.Line, addr)
+			}
+			if data, _ := json.Marshal(got); !bytes.Equal(data, want) {
+				return i, fmt.Errorf("line %d: record for %s is %s, want %s", row.Line, addr, data, want)
+			}
+		}
+		if city != nil {
+			rec, err := city.City(net.IP(row.Range.From.AsSlice()))
+			if err != nil {
+				return i, fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			if want, _ := row.Fields["country"].(string); rec.Country.IsoCode != strings.ToUpper(want) {
+				return i, fmt.Errorf("line %d: geoip2 returned country %q, want %q", row.Line, rec.Country.IsoCode, want)
+			}
+		}
+	}
+	return len(rows), nil
+}
+
+// sixToFour 返回 IPv4 地址对应的 6to4 地址（2002:AABB:CCDD::）
+func sixToFour(a netip.Addr) netip.Addr {
+	v4 := a.As4()
+	var b [16]byte
+	b[0], b[1] = 0x20, 0x02
+	copy(b[2:6], v4[:])
+	return netip.AddrFrom16(b)
+}
+
+// cityRecord 把一行转换为 GeoLite2-City 结构的记录
+func cityRecord(fields map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]string, len(fields))
+	for key, v := range fields {
+		if !cityColumns[key] {
+			return nil, fmt.Errorf("unknown field %q for the city schema", key)
+		}
+		switch v := v.(type) {
+		case string:
+			values[key] = strings.TrimSpace(v)
+		case json.Number:
+			values[key] = v.String()
+		case nil:
+		default:
+			return nil, fmt.Errorf("field %q must be a string or a number", key)
+		}
+	}
+
+	rec := make(map[string]interface{})
+	names := func(name string) map[string]interface{} {
+		return map[string]interface{}{"en": name}
+	}
+	if code, name := strings.ToUpper(values["country"]), values["country_name"]; code != "" || name != "" {
+		if code != "" && (len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+			return nil, fmt.Errorf("invalid country code %q", values["country"])
+		}
+		country := make(map[string]interface{})
+		if code != "" {
+			country["iso_code"] = code
+		}
+		if name != "" {
+			country["names"] = names(name)
+		}
+		rec["country"] = country
+	}
+	if code := strings.ToUpper(values["continent"]); code != "" {
+		rec["continent"] = map[string]interface{}{"code": code}
+	}
+	if code, name := values["region_code"], values["region"]; code != "" || name != "" {
+		region := make(map[string]interface{})
+		if code != "" {
+			region["iso_code"] = code
+		}
+		if name != "" {
+			region["names"] = names(name)
+		}
+		rec["subdivisions"] = []interface{}{region}
+	}
+	if name := values["city"]; name != "" {
+		rec["city"] = map[string]interface{}{"names": names(name)}
+	}
+	if code := values["postal"]; code != "" {
+		rec["postal"] = map[string]interface{}{"code": code}
+	}
+
+	location := make(map[string]interface{})
+	if lat, lon := values["latitude"], values["longitude"]; lat != "" || lon != "" {
+		latitude, err1 := strconv.ParseFloat(lat, 64)
+		longitude, err2 := strconv.ParseFloat(lon, 64)
+		if err1 != nil || err2 != nil || math.Abs(latitude) > 90 || math.Abs(longitude) > 180 {
+			return nil, fmt.Errorf("invalid coordinates %q, %q", lat, lon)
+		}
+		location["latitude"], location["longitude"] = latitude, longitude
+	}
+	if radius := values["accuracy_radius"]; radius != "" {
+		n, err := strconv.ParseUint(radius, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accuracy_radius %q", radius)
+		}
+		location["accuracy_radius"] = uint16(n)
+	}
+	if tz := values["time_zone"]; tz != "" {
+		location["time_zone"] = tz
+	}
+	if len(location) > 0 {
+		rec["location"] = location
+	}
+
+	if len(rec) == 0 {
+		return nil, errors.New("row has no data")
+	}
+	return rec, nil
+}
+
+// customRecord 把一行的字段原样转换为记录，键中的 . 表示嵌套的映射（例如 risk.tier）
+func customRecord(fields map[string]interface{}) (map[string]interface{}, error) {
+	rec := make(map[string]interface{})
+	for key, v := range fields {
+		value, ok, err := customValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+		parts := strings.Split(key, ".")
+		m := rec
+		for _, part := range parts[:len(parts)-1] {
+			child, exists := m[part]
+			if !exists {
+				child = make(map[string]interface{})
+				m[part] = child
+			}
+			next, isMap := child.(map[string]interface{})
+			if !isMap {
+				return nil, fmt.Errorf("field %q conflicts with %q", key, part)
+			}
+			m = next
+		}
+		last := parts[len(parts)-1]
+		if _, exists := m[last]; exists {
+			return nil, fmt.Errorf("field %q is set more than once", key)
+		}
+		m[last] = value
+	}
+	return rec, nil
+}
+
+// customValue 把 JSON 值转换为 MMDB 的类型：非负整数写为 uint32 或 uint64，负整数写为 int32，
+// 其他数字写为 double。null 被忽略，返回 false。
+func customValue(v interface{}) (interface{}, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false, nil
+	case string, bool:
+		return v, true, nil
+	case json.Number:
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			if n <= math.MaxUint32 {
+				return uint32(n), true, nil
+			}
+			return n, true, nil
+		}
+		if n, err := strconv.ParseInt(v.String(), 10, 32); err == nil {
+			return int32(n), true, nil
+		}
+		f, err := v.Float64()
+		if err != nil || strings.IndexAny(v.String(), ".eE") < 0 {
+			return nil, false, fmt.Errorf("number %s is out of range", v)
+		}
+		return f, true, nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			value, ok, err := customValue(item)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				list = append(list, value)
+			}
+		}
+		return list, true, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, ok, err := customValue(item)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", key, err)
+			}
+			if ok {
+				m[key] = value
+			}
+		}
+		return m, true, nil
+	}
+	return nil, false, fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,190 @@
+package dbbuild
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestCompileCity(t *testing.T) {
+	input := writeInput(t, "offices.csv", `network,country,country_name,region,region_code,city,latitude,longitude,accuracy_radius,time_zone
+10.0.0.0/8,de,Germany,Berlin,BE,Berlin,52.52,13.405,10,Europe/Berlin
+192.0.2.0-192.0.2.9,US,,,,,,,,
+2001:db8::/32,NL,Netherlands,,,Amsterdam,,,,
+`)
+	out := filepath.Join(t.TempDir(), "offices.mmdb")
+	report, err := Compile(input, out, CompileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Schema != SchemaCity || report.DatabaseType != "GeoLite2-City" || report.Rows != 3 || report.Verified != 3 {
+		t.Errorf("report = %+v", report)
+	}
+	// 192.0.2.0-192.0.2.9 拆分为 /29 和 /31
+	if report.Networks != 4 {
+		t.Errorf("networks = %d, want 4", report.Networks)
+	}
+
+	db, err := maxminddb.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	reader, err := geoip2.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	tests := []struct {
+		ip, country, name, region, city string
+	}{
+		{"10.1.2.3", "DE", "Germany", "Berlin", "Berlin"},
+		{"::ffff:10.1.2.3", "DE", "Germany", "Berlin", "Berlin"},
+		{"2002:a01:203::", "DE", "Germany", "Berlin", "Berlin"},
+		{"192.0.2.9", "US", "", "", ""},
+		{"2001:db8::1", "NL", "Netherlands", "", "Amsterdam"},
+		{"192.0.2.10", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		rec, err := reader.City(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatalf("City(%s): %v", tt.ip, err)
+		}
+		var region string
+		if len(rec.Subdivisions) > 0 {
+			region = rec.Subdivisions[0].Names["en"]
+		}
+		if rec.Country.IsoCode != tt.country || rec.Country.Names["en"] != tt.name || region != tt.region || rec.City.Names["en"] != tt.city {
+			t.Errorf("City(%s) = %s %q %q %q, want %s %q %q %q", tt.ip, rec.Country.IsoCode, rec.Country.Names["en"], region,
+				rec.City.Names["en"], tt.country, tt.name, tt.region, tt.city)
+		}
+	}
+	rec, _ := reader.City(net.ParseIP("10.0.0.1"))
+	if rec.Location.Latitude != 52.52 || rec.Location.AccuracyRadius != 10 || rec.Location.TimeZone != "Europe/Berlin" {
+		t.Errorf("location = %+v", rec.Location)
+	}
+}
+
+func TestCompileCustom(t *testing.T) {
+	input := writeInput(t, "custom.jsonl", `{"network": "10.0.0.0/8", "customer": "acme", "risk.tier": 2, "risk.score": 0.25, "tags": ["a", "b"], "offset": -3, "big": 5000000000, "none": null}
+{"start": "2001:db8::", "end": "2001:db8::ff", "customer": "globex", "active": true}
+`)
+	out := filepath.Join(t.TempDir(), "custom.mmdb")
+	report, err := Compile(input, out, CompileOptions{Schema: SchemaCustom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DatabaseType != "Internal-Custom" || report.Verified != 2 {
+		t.Errorf("report = %+v", report)
+	}
+
+	db, err := maxminddb.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var got map[string]interface{}
+	if err := db.Lookup(net.ParseIP("10.0.0.1"), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"customer": "acme",
+		"risk":     map[string]interface{}{"tier": uint64(2), "score": 0.25},
+		"tags":     []interface{}{"a", "b"},
+		"offset":   -3,
+		"big":      uint64(5000000000),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record = %#v, want %#v", got, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		opts                CompileOptions
+		err                 string
+	}{
+		{"unknown schema", "in.csv", "network,country\n10.0.0.0/8,DE\n", CompileOptions{Schema: "asn"}, `unknown schema "asn"`},
+		{"unknown city field", "in.csv", "network,asn\n10.0.0.0/8,64496\n", CompileOptions{}, `line 2: unknown field "asn"`},
+		{"invalid country", "in.csv", "network,country\n10.0.0.0/8,DEU\n", CompileOptions{}, `line 2: invalid country code "DEU"`},
+		{"coordinates", "in.csv", "network,latitude,longitude\n10.0.0.0/8,91,0\n", CompileOptions{}, "line 2: invalid coordinates"},
+		{"half coordinates", "in.csv", "network,latitude\n10.0.0.0/8,1\n", CompileOptions{}, "line 2: invalid coordinates"},
+		{"no data", "in.csv", "network,country\n10.0.0.0/8,\n", CompileOptions{}, "line 2: row has no data"},
+		{"custom conflict", "in.jsonl", `{"network": "10.0.0.0/8", "risk": 1, "risk.tier": 2}`, CompileOptions{Schema: SchemaCustom}, "line 1: field"},
+		{"custom range", "in.jsonl", `{"network": "10.0.0.0/8", "n": -3000000000}`, CompileOptions{Schema: SchemaCustom}, "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.mmdb")
+			_, err := Compile(writeInput(t, tt.file, tt.content), out, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Compile error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	input := writeInput(t, "in.csv", "network,country\n10.0.0.0/8,DE\n192.0.2.0/24,US\n")
+	out := filepath.Join(t.TempDir(), "out.mmdb")
+	if _, err := Compile(input, out, CompileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadRows(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		if records[i], err = cityRecord(row.Fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Verify(out, rows, records, SchemaCity); err != nil || n != 2 {
+		t.Fatalf("Verify = %d, %v", n, err)
+	}
+
+	// 记录与文件不一致
+	changed := append([]map[string]interface{}{}, records...)
+	changed[1] = map[string]interface{}{"country": map[string]interface{}{"iso_code": "FR"}}
+	if n, err := Verify(out, rows, changed, SchemaCity); err == nil || n != 1 || !strings.Contains(err.Error(), "line 3: record for 192.0.2.0 is") {
+		t.Errorf("Verify with a changed record = %d, %v", n, err)
+	}
+
+	// 范围不在文件中
+	missing := append([]Row{}, rows...)
+	missing[1].Range.To = missing[1].Range.To.Next()
+	if _, err := Verify(out, missing, records, SchemaCity); err == nil || !strings.Contains(err.Error(), "192.0.3.0 is not in the database") {
+		t.Errorf("Verify with a wider range = %v", err)
+	}
+
+	// geoip2 读到的国家代码与输入不一致
+	wrong := append([]Row{}, rows...)
+	wrong[0].Fields = map[string]interface{}{"country": "FR"}
+	if _, err := Verify(out, wrong, records, SchemaCity); err == nil || !strings.Contains(err.Error(), `geoip2 returned country "DE", want "FR"`) {
+		t.Errorf("Verify with a different country = %v", err)
+	}
+}
+
+func TestCompileCityCustomType(t *testing.T) {
+	// geoip2 不认识的类型名称只用 maxminddb 校验
+	input := writeInput(t, "in.csv", "network,country\n10.0.0.0/8,DE\n")
+	out := filepath.Join(t.TempDir(), "out.mmdb")
+	report, err := Compile(input, out, CompileOptions{DatabaseType: "Internal-Offices"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DatabaseType != "Internal-Offices" || report.Verified != 1 {
+		t.Errorf("report = %+v", report)
+	}
+}
@@ -0,0 +1,166 @@
+// Package dbbuild 生成 MMDB 格式的数据库文件，用于分发给不运行本服务的团队。
+package dbbuild
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ip-api/geoip"
+	"ip-api/iputil"
+	"ip-api/mmdbwriter"
+	"ip-api/overrides"
+)
+
+// MergedDatabaseType 是合并数据库元数据中的 database_type
+const MergedDatabaseType = "ip-api-Merged"
+
+// mergedRanges 是合并数据库遍历的地址范围。IPv6 数据库中的 ::/96、::ffff:0:0/96 和 2002::/16
+// 由写入器指向 IPv4 数据，不单独遍历，否则会覆盖 IPv4 的记录。
+var mergedRanges = []iputil.Range{
+	{From: netip.MustParseAddr("0.0.0.0"), To: netip.MustParseAddr("255.255.255.255")},
+	{From: netip.MustParseAddr("::1:0:0"), To: netip.MustParseAddr("::fffe:ffff:ffff")},
+	{From: netip.MustParseAddr("::1:0:0:0"), To: netip.MustParseAddr("2001:ffff:ffff:ffff:ffff:ffff:ffff:ffff")},
+	{From: netip.MustParseAddr("2003::"), To: netip.MustParseAddr("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")},
+}
+
+// RecordFunc 把合并后的查询结果转换为写入数据库的记录，返回空记录时跳过该网络
+type RecordFunc func(ip net.IP, res *geoip.Result) map[string]interface{}
+
+// MergedOptions 是构建合并数据库的选项
+type MergedOptions struct {
+	// Countries 只包含合并后国家代码在列表中的网络，为空时包含所有网络
+	Countries []string
+}
+
+// Manifest 描述一次构建的结果，与数据库和校验和文件一起发布
+type Manifest struct {
+	File         string           `json:"file"`
+	SHA256       string           `json:"sha256"`
+	Size         int64            `json:"size"`
+	DatabaseType string           `json:"database_type"`
+	BuildEpoch   uint             `json:"build_epoch"`
+	Countries    []string         `json:"countries,omitempty"`
+	Networks     int              `json:"networks"`
+	Overrides    int              `json:"overrides"`
+	Sources      []geoip.DBStatus `json:"sources"`
+}
+
+// BuildMerged 遍历所有已加载数据源和覆盖记录的合并结果，写入 path 处的 MMDB 文件，
+// 同时写入 <path>.sha256（sha256sum 格式）和 <path>.json（Manifest）。
+// 每个网络的记录由 record 生成，与查询接口使用相同的合并规则。
+func BuildMerged(path string, opts MergedOptions, record RecordFunc) (*Manifest, error) {
+	var sources []geoip.DBStatus
+	var names []string
+	for _, status := range geoip.Status() {
+		if status.Loaded {
+			sources = append(sources, status)
+			names = append(names, status.Name)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no GeoIP databases are available")
+	}
+	manifest := &Manifest{
+		File:         filepath.Base(path),
+		DatabaseType: MergedDatabaseType,
+		BuildEpoch:   uint(time.Now().Unix()),
+		Overrides:    len(overrides.List()),
+		Sources:      sources,
+	}
+	if manifest.Overrides > 0 {
+		names = append(names, "overrides")
+	}
+
+	countries := make(map[string]bool)
+	for _, c := range opts.Countries {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" && !countries[c] {
+			countries[c] = true
+			manifest.Countries = append(manifest.Countries, c)
+		}
+	}
+
+	w := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: MergedDatabaseType,
+		Description:  map[string]string{"en": "Merged from " + strings.Join(names, ", ")},
+		Languages:    []string{"en"},
+		BuildEpoch:   time.Unix(int64(manifest.BuildEpoch), 0),
+	})
+	for _, r := range mergedRanges {
+		// 一个结果可能拆分为多个前缀，只生成一次记录
+		var last *geoip.Result
+		var rec map[string]interface{}
+		err := geoip.WalkMerged(r, func(p netip.Prefix, res *geoip.Result) error {
+			if len(countries) > 0 && !countries[res.Record.CountryCode] {
+				return nil
+			}
+			if res != last {
+				last, rec = res, record(net.IP(p.Addr().AsSlice()), res)
+			}
+			if len(rec) == 0 {
+				return nil
+			}
+			manifest.Networks++
+			if err := w.Insert(p, rec); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sum, size, err := writeAtomic(path, w)
+	if err != nil {
+		return nil, err
+	}
+	manifest.SHA256, manifest.Size = sum, size
+	if err := writeSidecars(path, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeAtomic 先写入临时文件再重命名，返回文件的 SHA-256 和大小
+func writeAtomic(path string, w io.WriterTo) (string, int64, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	hasher := sha256.New()
+	size, err := w.WriteTo(io.MultiWriter(f, hasher))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// writeSidecars 写入校验和文件和 Manifest，校验和文件可以用 sha256sum -c 检查
+func writeSidecars(path string, manifest *Manifest) error {
+	line := manifest.SHA256 + "  " + manifest.File + "\n"
+	if err := os.WriteFile(path+".sha256", []byte(line), 0644); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", append(data, '\n'), 0644)
+}
@@ -0,0 +1,224 @@
+package dbbuild
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ip-api/iputil"
+)
+
+// maxRowErrors 是校验输入时最多报告的错误数量
+const maxRowErrors = 20
+
+// aliasedPrefixes 是 IPv6 数据库中指向 IPv4 数据的网络，输入中不能包含这些网络的 IPv6 形式
+var aliasedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("::/96"),
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// Row 是输入文件中的一行
+type Row struct {
+	// Line 是行号，用于错误信息
+	Line int
+	// Range 是该行的地址范围，IPv4 映射的 IPv6 地址按 IPv4 处理
+	Range iputil.Range
+	// Fields 是地址列以外的字段。CSV 中的值都是字符串，JSONL 中的数字是 json.Number
+	Fields map[string]interface{}
+}
+
+// ReadRows 读取 CSV 或 JSONL（按扩展名区分）文件并校验地址范围。
+// 地址由 network 列给出（CIDR、起止地址或单个地址），也可以使用 start 和 end 两列。
+// 无效的地址、重叠的范围和 IPv4 别名网络中的 IPv6 地址都是错误，最多报告 maxRowErrors 个。
+func ReadRows(path string) ([]Row, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []Row
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readCSVRows(f)
+	case ".jsonl", ".ndjson":
+		rows, err = readJSONLRows(f)
+	default:
+		return nil, fmt.Errorf("input file %s must be .csv, .jsonl or .ndjson", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s contains no rows", path)
+	}
+	return rows, checkRows(rows)
+}
+
+// readCSVRows 读取 CSV 文件，第一行是列名，# 开头的行是注释
+func readCSVRows(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	var rows []Row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		fields := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if value := strings.TrimSpace(record[i]); value != "" {
+				fields[name] = value
+			}
+		}
+		row, err := newRow(line, fields)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readJSONLRows 读取每行一个 JSON 对象的文件，空行被忽略
+func readJSONLRows(r io.Reader) ([]Row, error) {
+	var rows []Row
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		var fields map[string]interface{}
+		if err := dec.Decode(&fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		row, err := newRow(line, fields)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// newRow 从字段中取出地址列并解析地址范围
+func newRow(line int, fields map[string]interface{}) (Row, error) {
+	network, _ := fields["network"].(string)
+	start, _ := fields["start"].(string)
+	end, _ := fields["end"].(string)
+	delete(fields, "network")
+	delete(fields, "start")
+	delete(fields, "end")
+
+	var r iputil.Range
+	var err error
+	switch {
+	case network != "" && start == "" && end == "":
+		r, err = parseNetwork(network)
+	case network == "" && start != "" && end != "":
+		r, err = iputil.ParseRange(start + "-" + end)
+	default:
+		err = errors.New("each row needs either network or start and end")
+	}
+	if err != nil {
+		return Row{}, fmt.Errorf("line %d: %w", line, err)
+	}
+	return Row{Line: line, Range: r, Fields: fields}, nil
+}
+
+// parseNetwork 解析 CIDR、起止地址或单个地址
+func parseNetwork(s string) (iputil.Range, error) {
+	if strings.ContainsAny(s, "/-") {
+		return iputil.ParseRange(s)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil || addr.Zone() != "" {
+		return iputil.Range{}, fmt.Errorf("invalid network %q", s)
+	}
+	addr = addr.Unmap()
+	return iputil.Range{From: addr, To: addr}, nil
+}
+
+// checkRows 检查范围是否重叠，以及 IPv6 范围是否落在 IPv4 别名网络中
+func checkRows(rows []Row) error {
+	var errs []error
+	report := func(err error) {
+		if len(errs) < maxRowErrors {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, row := range rows {
+		if !row.Range.From.Is6() {
+			continue
+		}
+		for _, alias := range aliasedPrefixes {
+			a := iputil.PrefixRange(alias)
+			if row.Range.From.Compare(a.To) <= 0 && a.From.Compare(row.Range.To) <= 0 {
+				report(fmt.Errorf("line %d: %s overlaps %s, which is reserved for IPv4 data; use IPv4 addresses instead",
+					row.Line, formatRange(row.Range), alias))
+			}
+		}
+	}
+
+	sorted := make([]Row, len(rows))
+	copy(sorted, rows)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Range.From.Less(sorted[j].Range.From)
+	})
+	// widest 是同一地址族中结束地址最大的前一行，被较大范围包含的行不会遮住后面的重叠
+	widest := 0
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[widest], sorted[i]
+		if prev.Range.From.BitLen() != cur.Range.From.BitLen() {
+			widest = i
+			continue
+		}
+		if cur.Range.From.Compare(prev.Range.To) <= 0 {
+			report(fmt.Errorf("line %d: %s overlaps line %d: %s",
+				cur.Line, formatRange(cur.Range), prev.Line, formatRange(prev.Range)))
+		}
+		if prev.Range.To.Less(cur.Range.To) {
+			widest = i
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// formatRange 返回范围的字符串形式，正好是一个前缀时使用 CIDR
+func formatRange(r iputil.Range) string {
+	if prefixes := r.Prefixes(); len(prefixes) == 1 {
+		return prefixes[0].String()
+	}
+	return r.From.String() + "-" + r.To.String()
+}
@@ -0,0 +1,158 @@
+package dbbuild
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeInput 在临时目录中写入输入文件
+func writeInput(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadRowsCSV(t *testing.T) {
+	p := writeInput(t, "in.csv", `network,start,end,country,city
+# 注释行
+10.0.0.0/8,,,DE,Berlin
+,192.0.2.10,192.0.2.20,US,
+,::ffff:192.0.2.30,::ffff:192.0.2.31,CA,
+198.51.100.7,,,FR,Paris
+::ffff:203.0.113.0/120,,,JP,
+2001:db8::/32,,,NL,Amsterdam
+`)
+	rows, err := ReadRows(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		line     int
+		from, to string
+		fields   map[string]interface{}
+	}{
+		{3, "10.0.0.0", "10.255.255.255", map[string]interface{}{"country": "DE", "city": "Berlin"}},
+		{4, "192.0.2.10", "192.0.2.20", map[string]interface{}{"country": "US"}},
+		{5, "192.0.2.30", "192.0.2.31", map[string]interface{}{"country": "CA"}},
+		{6, "198.51.100.7", "198.51.100.7", map[string]interface{}{"country": "FR", "city": "Paris"}},
+		{7, "203.0.113.0", "203.0.113.255", map[string]interface{}{"country": "JP"}},
+		{8, "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", map[string]interface{}{"country": "NL", "city": "Amsterdam"}},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		row := rows[i]
+		if row.Line != w.line || row.Range.From.String() != w.from || row.Range.To.String() != w.to {
+			t.Errorf("row %d = line %d %s-%s, want line %d %s-%s", i, row.Line, row.Range.From, row.Range.To, w.line, w.from, w.to)
+		}
+		if !reflect.DeepEqual(row.Fields, w.fields) {
+			t.Errorf("row %d fields = %v, want %v", i, row.Fields, w.fields)
+		}
+	}
+}
+
+func TestReadRowsJSONL(t *testing.T) {
+	p := writeInput(t, "in.jsonl", `{"network": "10.0.0.0/8", "tier": 2, "risk": {"score": 0.5}}
+
+{"start": "2001:db8::", "end": "2001:db8::ff", "tags": ["a", "b"], "note": null}
+`)
+	rows, err := ReadRows(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Line != 1 || rows[1].Line != 3 {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if tier, ok := rows[0].Fields["tier"].(json.Number); !ok || tier != "2" {
+		t.Errorf("tier = %#v, want json.Number 2", rows[0].Fields["tier"])
+	}
+	if rows[1].Range.To.String() != "2001:db8::ff" {
+		t.Errorf("range = %s-%s", rows[1].Range.From, rows[1].Range.To)
+	}
+	if _, ok := rows[1].Fields["start"]; ok {
+		t.Error("address columns kept in fields")
+	}
+}
+
+func TestReadRowsErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		errs                []string
+	}{
+		{"extension", "in.txt", "network\n10.0.0.0/8\n", []string{"must be .csv"}},
+		{"empty", "in.csv", "network,country\n", []string{"contains no rows"}},
+		{"no address", "in.csv", "network,country\n,DE\n", []string{"line 2: each row needs either network or start and end"}},
+		{"network and start", "in.csv", "network,start,end\n10.0.0.0/8,10.0.0.0,10.0.0.1\n", []string{"line 2:"}},
+		{"invalid network", "in.csv", "network\n10.0.0.300\n", []string{`line 2: invalid network "10.0.0.300"`}},
+		{"mixed families", "in.csv", "start,end\n10.0.0.0,2001:db8::\n", []string{"line 2: invalid range"}},
+		{"invalid JSON", "in.jsonl", "{\"network\": \"10.0.0.0/8\"}\n{\n", []string{"line 2:"}},
+		{"contained", "in.csv", "network\n10.0.0.0/8\n10.1.0.0/16\n", []string{"line 3: 10.1.0.0/16 overlaps line 2: 10.0.0.0/8"}},
+		{"partial", "in.csv", "start,end\n10.0.0.0,10.0.0.10\n10.0.0.10,10.0.0.20\n",
+			[]string{"line 3: 10.0.0.10-10.0.0.20 overlaps line 2: 10.0.0.0-10.0.0.10"}},
+		// 10.1.0.0/16 被 10.0.0.0/8 包含，10.2.0.0/16 仍然要与更宽的 10.0.0.0/8 比较
+		{"nested widest", "in.csv", "network\n10.0.0.0/8\n10.1.0.0/16\n10.2.0.0/16\n",
+			[]string{"line 3: 10.1.0.0/16 overlaps line 2", "line 4: 10.2.0.0/16 overlaps line 2"}},
+		{"duplicate IPv6", "in.csv", "network\n2001:db8::/32\n2001:db8::1\n", []string{"line 3: 2001:db8::1/128 overlaps line 2"}},
+		{"IPv4-compatible", "in.csv", "network\n::a00:0/104\n", []string{"line 2: ::a00:0/104 overlaps ::/96"}},
+		{"IPv4-mapped wider", "in.csv", "network\n::fffe:0:0/95\n", []string{"line 2: ::fffe:0:0/95 overlaps ::ffff:0.0.0.0/96"}},
+		{"6to4", "in.csv", "network\n2002:a00::/24\n", []string{"line 2: 2002:a00::/24 overlaps 2002::/16"}},
+		{"covers aliases", "in.csv", "network\n::/0\n",
+			[]string{"overlaps ::/96", "overlaps ::ffff:0.0.0.0/96", "overlaps 2002::/16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadRows(writeInput(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("ReadRows succeeded")
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRowsNoOverlap(t *testing.T) {
+	// 相邻的范围、被包含的行之后的行以及不同地址族的范围都不重叠
+	p := writeInput(t, "in.csv", `network
+10.0.0.0/9
+10.128.0.0/9
+11.0.0.0-11.0.0.255
+11.0.1.0
+0.0.0.0/32
+2001:db8::/32
+2001:db9::/32
+`)
+	rows, err := ReadRows(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 {
+		t.Errorf("got %d rows, want 7", len(rows))
+	}
+}
+
+func TestReadRowsErrorLimit(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("network\n10.0.0.0/8\n")
+	for i := 0; i < maxRowErrors+5; i++ {
+		sb.WriteString("10.0.0.1\n")
+	}
+	_, err := ReadRows(writeInput(t, "in.csv", sb.String()))
+	if err == nil {
+		t.Fatal("ReadRows succeeded")
+	}
+	if n := strings.Count(err.Error(), "overlaps"); n != maxRowErrors {
+		t.Errorf("reported %d errors, want %d", n, maxRowErrors)
+	}
+}
@@ -0,0 +1,436 @@
+// Package dbdiff 比较同一数据库的两个 MMDB 版本，
+// 汇总按国家、ASN 和省份统计的地址空间变化以及新增、删除和移动的网络。
+package dbdiff
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+	"ip-api/iputil"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// 报告中各列表的最大长度
+const (
+	maxMoves     = 20
+	maxNetworks  = 1000
+	maxDimension = 50
+)
+
+// Report 是两个数据库版本之间的差异报告
+type Report struct {
+	Role          string    `json:"role"`
+	DatabaseType  string    `json:"database_type"`
+	OldFile       string    `json:"old_file"`
+	NewFile       string    `json:"new_file"`
+	OldBuildEpoch uint      `json:"old_build_epoch"`
+	NewBuildEpoch uint      `json:"new_build_epoch"`
+	GeneratedAt   time.Time `json:"generated_at"`
+
+	Summary Summary `json:"summary"`
+
+	// ByCountry、ByASN 和 ByProvince 按变化的地址数量降序排列
+	ByCountry  []DimensionChange `json:"by_country,omitempty"`
+	ByASN      []DimensionChange `json:"by_asn,omitempty"`
+	ByProvince []DimensionChange `json:"by_province,omitempty"`
+
+	// LargestMoves 是记录发生变化的最大前缀
+	LargestMoves []Move `json:"largest_moves"`
+
+	// Added 和 Removed 是只存在于新版本或旧版本中的网络（截断到 1000 条）
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Summary 是地址空间变化的总计
+type Summary struct {
+	AddedAddresses   iputil.Count `json:"added_addresses"`
+	RemovedAddresses iputil.Count `json:"removed_addresses"`
+	ChangedAddresses iputil.Count `json:"changed_addresses"`
+	AddedNetworks    int          `json:"added_networks"`
+	RemovedNetworks  int          `json:"removed_networks"`
+	ChangedNetworks  int          `json:"changed_networks"`
+}
+
+// DimensionChange 是某个国家、ASN 或省份获得和失去的地址数量
+type DimensionChange struct {
+	Key    string       `json:"key"`
+	Gained iputil.Count `json:"gained"`
+	Lost   iputil.Count `json:"lost"`
+}
+
+// Move 是一个记录发生变化的网络
+type Move struct {
+	Network string       `json:"network"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Size    iputil.Count `json:"size"`
+}
+
+// record 是用于比较的规范化记录
+type record struct {
+	Country  string
+	Province string
+	City     string
+	ASN      string
+	Label    string
+}
+
+// key 返回用于判断记录是否变化的键
+func (r record) key() string {
+	return r.Country + "|" + r.Province + "|" + r.City + "|" + r.ASN
+}
+
+// Compare 比较两个 MMDB 文件。role 为空或不支持比较时根据元数据中的 database_type 推断。
+func Compare(oldPath, newPath, role string) (*Report, error) {
+	oldDB, err := maxminddb.Open(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", oldPath, err)
+	}
+	defer oldDB.Close()
+
+	newDB, err := maxminddb.Open(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", newPath, err)
+	}
+	defer newDB.Close()
+
+	if _, ok := decoders[role]; !ok {
+		role = DetectRole(newDB.Metadata.DatabaseType)
+	}
+	decode := decoders[role]
+
+	report := &Report{
+		Role:          role,
+		DatabaseType:  newDB.Metadata.DatabaseType,
+		OldFile:       oldPath,
+		NewFile:       newPath,
+		OldBuildEpoch: oldDB.Metadata.BuildEpoch,
+		NewBuildEpoch: newDB.Metadata.BuildEpoch,
+		GeneratedAt:   time.Now().UTC(),
+	}
+
+	d := &differ{
+		report:    report,
+		countries: make(map[string]*DimensionChange),
+		asns:      make(map[string]*DimensionChange),
+		provinces: make(map[string]*DimensionChange),
+	}
+	if err := d.run(newCursor(oldDB, decode), newCursor(newDB, decode)); err != nil {
+		return nil, err
+	}
+	d.finish()
+	return report, nil
+}
+
+// DetectRole 根据 MMDB 的 database_type 推断数据库角色
+func DetectRole(databaseType string) string {
+	switch {
+	case strings.Contains(databaseType, "ASN"), strings.Contains(databaseType, "ISP"):
+		return config.RoleASN
+	case strings.Contains(databaseType, "City"), strings.Contains(databaseType, "Country"),
+		strings.Contains(databaseType, "Enterprise"):
+		return config.RoleCity
+	default:
+		return config.RoleCN
+	}
+}
+
+// decoders 按角色将网络记录解码为规范化记录
+var decoders = map[string]func(n *maxminddb.Networks) (*net.IPNet, record, error){
+	config.RoleCity: func(n *maxminddb.Networks) (*net.IPNet, record, error) {
+		var v struct {
+			Country struct {
+				IsoCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+			Subdivisions []struct {
+				IsoCode string `maxminddb:"iso_code"`
+			} `maxminddb:"subdivisions"`
+			City struct {
+				GeoNameID uint              `maxminddb:"geoname_id"`
+				Names     map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
+		}
+		network, err := n.Network(&v)
+		if err != nil {
+			return nil, record{}, err
+		}
+		r := record{Country: v.Country.IsoCode}
+		if len(v.Subdivisions) > 0 && v.Subdivisions[0].IsoCode != "" {
+			r.Province = v.Country.IsoCode + "-" + v.Subdivisions[0].IsoCode
+		}
+		if v.City.GeoNameID != 0 {
+			r.City = strconv.FormatUint(uint64(v.City.GeoNameID), 10)
+		}
+		r.Label = strings.Trim(strings.Join([]string{r.Country, r.Province, v.City.Names["en"]}, "/"), "/")
+		return network, r, nil
+	},
+	config.RoleASN: func(n *maxminddb.Networks) (*net.IPNet, record, error) {
+		var v struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		network, err := n.Network(&v)
+		if err != nil {
+			return nil, record{}, err
+		}
+		r := record{}
+		if v.Number != 0 {
+			r.ASN = "AS" + strconv.FormatUint(uint64(v.Number), 10)
+			r.Label = r.ASN + " " + v.Org
+		}
+		return network, r, nil
+	},
+	config.RoleCN: func(n *maxminddb.Networks) (*net.IPNet, record, error) {
+		var v struct {
+			Province string `maxminddb:"province"`
+			City     string `maxminddb:"city"`
+			ISP      string `maxminddb:"isp"`
+		}
+		network, err := n.Network(&v)
+		if err != nil {
+			return nil, record{}, err
+		}
+		return network, record{
+			Province: v.Province,
+			City:     v.City + "|" + v.ISP,
+			Label:    strings.Trim(strings.Join([]string{v.Province, v.City, v.ISP}, "/"), "/"),
+		}, nil
+	},
+}
+
+// piece 是一个网络（或其剩余部分）及其记录
+type piece struct {
+	r   iputil.Range
+	rec record
+}
+
+// cursor 按地址升序遍历数据库中的网络
+type cursor struct {
+	networks *maxminddb.Networks
+	decode   func(n *maxminddb.Networks) (*net.IPNet, record, error)
+	err      error
+}
+
+func newCursor(db *maxminddb.Reader, decode func(n *maxminddb.Networks) (*net.IPNet, record, error)) *cursor {
+	return &cursor{networks: db.Networks(maxminddb.SkipAliasedNetworks), decode: decode}
+}
+
+// next 返回下一个网络，遍历结束时返回 nil
+func (c *cursor) next() *piece {
+	if c.err != nil || !c.networks.Next() {
+		if c.err == nil {
+			c.err = c.networks.Err()
+		}
+		return nil
+	}
+	network, rec, err := c.decode(c.networks)
+	if err != nil {
+		c.err = err
+		return nil
+	}
+	return &piece{r: iputil.PrefixRange(iputil.PrefixFromIPNet(network)), rec: rec}
+}
+
+// differ 累积比较的结果
+type differ struct {
+	report    *Report
+	countries map[string]*DimensionChange
+	asns      map[string]*DimensionChange
+	provinces map[string]*DimensionChange
+	added     []iputil.Range
+	removed   []iputil.Range
+}
+
+// run 以扫描线方式同时遍历两个版本，把地址空间划分为新增、删除和变化的片段
+func (d *differ) run(oldCur, newCur *cursor) error {
+	a, b := oldCur.next(), newCur.next()
+	for a != nil || b != nil {
+		switch {
+		case b == nil || (a != nil && a.r.To.Less(b.r.From)):
+			d.onRemoved(a.r, a.rec)
+			a = oldCur.next()
+			continue
+		case a == nil || b.r.To.Less(a.r.From):
+			d.onAdded(b.r, b.rec)
+			b = newCur.next()
+			continue
+		}
+
+		// 两个片段重叠：先处理只在一侧的前导部分
+		if a.r.From.Less(b.r.From) {
+			d.onRemoved(iputil.Range{From: a.r.From, To: b.r.From.Prev()}, a.rec)
+			a.r.From = b.r.From
+		} else if b.r.From.Less(a.r.From) {
+			d.onAdded(iputil.Range{From: b.r.From, To: a.r.From.Prev()}, b.rec)
+			b.r.From = a.r.From
+		}
+
+		end := a.r.To
+		if b.r.To.Less(end) {
+			end = b.r.To
+		}
+		if a.rec.key() != b.rec.key() {
+			d.onChanged(iputil.Range{From: a.r.From, To: end}, a.rec, b.rec)
+		}
+
+		if a.r.To == end {
+			a = oldCur.next()
+		} else {
+			a.r.From = end.Next()
+		}
+		if b.r.To == end {
+			b = newCur.next()
+		} else {
+			b.r.From = end.Next()
+		}
+	}
+
+	if oldCur.err != nil {
+		return fmt.Errorf("failed to read old database: %w", oldCur.err)
+	}
+	if newCur.err != nil {
+		return fmt.Errorf("failed to read new database: %w", newCur.err)
+	}
+	return nil
+}
+
+func (d *differ) onAdded(r iputil.Range, rec record) {
+	size := r.Size()
+	d.report.Summary.AddedAddresses = d.report.Summary.AddedAddresses.Add(size)
+	d.added = append(d.added, r)
+	d.account(rec, size, true)
+}
+
+func (d *differ) onRemoved(r iputil.Range, rec record) {
+	size := r.Size()
+	d.report.Summary.RemovedAddresses = d.report.Summary.RemovedAddresses.Add(size)
+	d.removed = append(d.removed, r)
+	d.account(rec, size, false)
+}
+
+func (d *differ) onChanged(r iputil.Range, from, to record) {
+	size := r.Size()
+	d.report.Summary.ChangedAddresses = d.report.Summary.ChangedAddresses.Add(size)
+	d.report.Summary.ChangedNetworks++
+
+	// 只统计真正变化的维度，例如同一国家内城市的变化不计入国家
+	lost, gained := from, to
+	if from.Country == to.Country {
+		lost.Country, gained.Country = "", ""
+	}
+	if from.Province == to.Province {
+		lost.Province, gained.Province = "", ""
+	}
+	if from.ASN == to.ASN {
+		lost.ASN, gained.ASN = "", ""
+	}
+	d.account(lost, size, false)
+	d.account(gained, size, true)
+
+	d.report.LargestMoves = insertMove(d.report.LargestMoves, Move{
+		Network: joinPrefixes(r.Prefixes()),
+		From:    from.Label,
+		To:      to.Label,
+		Size:    size,
+	})
+}
+
+// account 将地址数量计入记录所属的各个维度
+func (d *differ) account(rec record, size iputil.Count, gained bool) {
+	add := func(m map[string]*DimensionChange, key string) {
+		if key == "" {
+			return
+		}
+		c, ok := m[key]
+		if !ok {
+			c = &DimensionChange{Key: key}
+			m[key] = c
+		}
+		if gained {
+			c.Gained = c.Gained.Add(size)
+		} else {
+			c.Lost = c.Lost.Add(size)
+		}
+	}
+	add(d.countries, rec.Country)
+	add(d.asns, rec.ASN)
+	add(d.provinces, rec.Province)
+}
+
+// finish 汇总新增和删除的网络，并对各维度排序
+func (d *differ) finish() {
+	for _, r := range iputil.Merge(d.added) {
+		for _, p := range r.Prefixes() {
+			d.report.Summary.AddedNetworks++
+			if len(d.report.Added) < maxNetworks {
+				d.report.Added = append(d.report.Added, p.String())
+			}
+		}
+	}
+	for _, r := range iputil.Merge(d.removed) {
+		for _, p := range r.Prefixes() {
+			d.report.Summary.RemovedNetworks++
+			if len(d.report.Removed) < maxNetworks {
+				d.report.Removed = append(d.report.Removed, p.String())
+			}
+		}
+	}
+
+	d.report.ByCountry = sortDimension(d.countries)
+	d.report.ByASN = sortDimension(d.asns)
+	d.report.ByProvince = sortDimension(d.provinces)
+}
+
+// sortDimension 按变化的地址总数降序排列并截断
+func sortDimension(m map[string]*DimensionChange) []DimensionChange {
+	list := make([]DimensionChange, 0, len(m))
+	for _, c := range m {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ti := list[i].Gained.Add(list[i].Lost)
+		tj := list[j].Gained.Add(list[j].Lost)
+		if c := ti.Cmp(tj); c != 0 {
+			return c > 0
+		}
+		return list[i].Key < list[j].Key
+	})
+	if len(list) > maxDimension {
+		list = list[:maxDimension]
+	}
+	return list
+}
+
+// insertMove 将 m 插入按大小降序排列的列表，并保持最多 maxMoves 项
+func insertMove(moves []Move, m Move) []Move {
+	i := sort.Search(len(moves), func(i int) bool {
+		return moves[i].Size.Cmp(m.Size) < 0
+	})
+	if i >= maxMoves {
+		return moves
+	}
+	moves = append(moves, Move{})
+	copy(moves[i+1:], moves[i:])
+	moves[i] = m
+	if len(moves) > maxMoves {
+		moves = moves[:maxMoves]
+	}
+	return moves
+}
+
+// joinPrefixes 将前缀列表格式化为逗号分隔的字符串
+func joinPrefixes(prefixes []netip.Prefix) string {
+	parts := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		parts[i] = p.String()
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,254 @@
+package dbdiff
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/mmdbwriter"
+)
+
+// entry 是写入测试数据库的一个网络
+type entry struct {
+	network string
+	value   interface{}
+}
+
+// writeDB 用 mmdbwriter 在临时目录中生成数据库
+func writeDB(t *testing.T, name, dbType string, entries []entry) string {
+	t.Helper()
+	w := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType})
+	for _, e := range entries {
+		if err := w.Insert(netip.MustParsePrefix(e.network), e.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func cityValue(country, subdivision string, geonameID uint32, city string) map[string]interface{} {
+	v := map[string]interface{}{"country": map[string]interface{}{"iso_code": country}}
+	if subdivision != "" {
+		v["subdivisions"] = []interface{}{map[string]interface{}{"iso_code": subdivision}}
+	}
+	if geonameID != 0 {
+		v["city"] = map[string]interface{}{"geoname_id": geonameID, "names": map[string]string{"en": city}}
+	}
+	return v
+}
+
+// dimension 把维度统计格式化为便于比较的字符串
+func dimension(changes []DimensionChange) []string {
+	var out []string
+	for _, c := range changes {
+		out = append(out, c.Key+" +"+c.Gained.String()+" -"+c.Lost.String())
+	}
+	return out
+}
+
+func TestCompareCity(t *testing.T) {
+	oldPath := writeDB(t, "old.mmdb", "GeoLite2-City", []entry{
+		{"10.0.0.0/24", cityValue("US", "CA", 5368361, "Los Angeles")},
+		{"10.0.1.0/24", cityValue("DE", "", 0, "")},
+		{"10.0.2.0/24", cityValue("FR", "", 0, "")},
+		{"2001:db8::/120", cityValue("GB", "ENG", 2643743, "London")},
+	})
+	newPath := writeDB(t, "new.mmdb", "GeoLite2-City", []entry{
+		{"10.0.0.0/24", cityValue("US", "NY", 5128581, "New York")},
+		{"10.0.1.0/24", cityValue("NL", "", 0, "")},
+		{"10.0.3.0/24", cityValue("JP", "", 0, "")},
+		// 只有名称变化的记录不算变化
+		{"2001:db8::/120", cityValue("GB", "ENG", 2643743, "Greater London")},
+	})
+
+	report, err := Compare(oldPath, newPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Role != config.RoleCity || report.DatabaseType != "GeoLite2-City" {
+		t.Errorf("role %q, database type %q", report.Role, report.DatabaseType)
+	}
+
+	s := report.Summary
+	if s.AddedAddresses.String() != "256" || s.RemovedAddresses.String() != "256" || s.ChangedAddresses.String() != "512" ||
+		s.AddedNetworks != 1 || s.RemovedNetworks != 1 || s.ChangedNetworks != 2 {
+		t.Errorf("summary = %+v", s)
+	}
+	if !reflect.DeepEqual(report.Added, []string{"10.0.3.0/24"}) || !reflect.DeepEqual(report.Removed, []string{"10.0.2.0/24"}) {
+		t.Errorf("added %v, removed %v", report.Added, report.Removed)
+	}
+
+	// 同一国家内的省份变化不计入国家
+	if got, want := dimension(report.ByCountry), []string{"DE +0 -256", "FR +0 -256", "JP +256 -0", "NL +256 -0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by country = %v, want %v", got, want)
+	}
+	if got, want := dimension(report.ByProvince), []string{"US-CA +0 -256", "US-NY +256 -0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by province = %v, want %v", got, want)
+	}
+	if len(report.ByASN) != 0 {
+		t.Errorf("by ASN = %v", dimension(report.ByASN))
+	}
+
+	want := []Move{
+		{Network: "10.0.0.0/24", From: "US/US-CA/Los Angeles", To: "US/US-NY/New York"},
+		{Network: "10.0.1.0/24", From: "DE", To: "NL"},
+	}
+	if len(report.LargestMoves) != len(want) {
+		t.Fatalf("largest moves = %+v", report.LargestMoves)
+	}
+	for i, m := range report.LargestMoves {
+		if m.Network != want[i].Network || m.From != want[i].From || m.To != want[i].To || m.Size.String() != "256" {
+			t.Errorf("move %d = %+v, want %+v", i, m, want[i])
+		}
+	}
+}
+
+func TestComparePartialOverlap(t *testing.T) {
+	// 新版本把一个网络拆开：前半部分变化，后半部分删除，并向后扩展了新的地址
+	oldPath := writeDB(t, "old.mmdb", "GeoLite2-Country", []entry{
+		{"192.0.2.0/24", cityValue("US", "", 0, "")},
+	})
+	newPath := writeDB(t, "new.mmdb", "GeoLite2-Country", []entry{
+		{"192.0.2.0/25", cityValue("CA", "", 0, "")},
+		{"192.0.3.0/24", cityValue("US", "", 0, "")},
+	})
+	report, err := Compare(oldPath, newPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := report.Summary
+	if s.ChangedAddresses.String() != "128" || s.RemovedAddresses.String() != "128" || s.AddedAddresses.String() != "256" {
+		t.Errorf("summary = %+v", s)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"192.0.2.128/25"}) || !reflect.DeepEqual(report.Added, []string{"192.0.3.0/24"}) {
+		t.Errorf("added %v, removed %v", report.Added, report.Removed)
+	}
+	if got, want := dimension(report.ByCountry), []string{"US +256 -256", "CA +128 -0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by country = %v, want %v", got, want)
+	}
+}
+
+func TestCompareASN(t *testing.T) {
+	asn := func(number uint32, org string) map[string]interface{} {
+		return map[string]interface{}{"autonomous_system_number": number, "autonomous_system_organization": org}
+	}
+	oldPath := writeDB(t, "old.mmdb", "GeoLite2-ASN", []entry{
+		{"198.51.100.0/24", asn(64496, "Example")},
+		{"203.0.113.0/24", asn(64497, "Other")},
+	})
+	newPath := writeDB(t, "new.mmdb", "GeoLite2-ASN", []entry{
+		{"198.51.100.0/24", asn(64496, "Example Renamed")},
+		{"203.0.113.0/24", asn(64498, "New")},
+	})
+	report, err := Compare(oldPath, newPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Role != config.RoleASN {
+		t.Errorf("role = %q, want %q", report.Role, config.RoleASN)
+	}
+	// 只有组织名称变化的网络不算变化
+	if report.Summary.ChangedNetworks != 1 || report.Summary.ChangedAddresses.String() != "256" {
+		t.Errorf("summary = %+v", report.Summary)
+	}
+	if got, want := dimension(report.ByASN), []string{"AS64497 +0 -256", "AS64498 +256 -0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by ASN = %v, want %v", got, want)
+	}
+	if len(report.LargestMoves) != 1 || report.LargestMoves[0].From != "AS64497 Other" || report.LargestMoves[0].To != "AS64498 New" {
+		t.Errorf("largest moves = %+v", report.LargestMoves)
+	}
+}
+
+func TestCompareCN(t *testing.T) {
+	cn := func(province, city, isp string) map[string]interface{} {
+		return map[string]interface{}{"province": province, "city": city, "isp": isp}
+	}
+	oldPath := writeDB(t, "old.mmdb", "GeoCN", []entry{
+		{"1.2.4.0/24", cn("北京市", "北京市", "电信")},
+		{"1.2.5.0/24", cn("上海市", "上海市", "联通")},
+	})
+	newPath := writeDB(t, "new.mmdb", "GeoCN", []entry{
+		{"1.2.4.0/24", cn("北京市", "北京市", "联通")},
+		{"1.2.5.0/24", cn("江苏省", "南京市", "联通")},
+	})
+	report, err := Compare(oldPath, newPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Role != config.RoleCN {
+		t.Errorf("role = %q, want %q", report.Role, config.RoleCN)
+	}
+	// 运营商变化算作记录变化，但只有第二个网络的省份变化
+	if report.Summary.ChangedNetworks != 2 {
+		t.Errorf("summary = %+v", report.Summary)
+	}
+	if got, want := dimension(report.ByProvince), []string{"上海市 +0 -256", "江苏省 +256 -0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by province = %v, want %v", got, want)
+	}
+	if len(report.LargestMoves) != 2 || report.LargestMoves[0].From != "北京市/北京市/电信" || report.LargestMoves[0].To != "北京市/北京市/联通" {
+		t.Errorf("largest moves = %+v", report.LargestMoves)
+	}
+}
+
+func TestCompareRole(t *testing.T) {
+	value := map[string]interface{}{
+		"country":                  map[string]interface{}{"iso_code": "US"},
+		"autonomous_system_number": uint32(64496),
+	}
+	oldPath := writeDB(t, "old.mmdb", "Internal-Offices", []entry{{"192.0.2.0/24", value}})
+	newPath := writeDB(t, "new.mmdb", "Internal-Offices", []entry{{"198.51.100.0/24", value}})
+
+	tests := []struct {
+		role, want string
+		country    bool
+		asn        bool
+	}{
+		// 未知类型按 cn 解码，不统计国家和 ASN
+		{"", config.RoleCN, false, false},
+		{config.RoleCity, config.RoleCity, true, false},
+		{config.RoleASN, config.RoleASN, false, true},
+		// 不支持比较的角色按类型推断
+		{config.RoleCustom, config.RoleCN, false, false},
+	}
+	for _, tt := range tests {
+		report, err := Compare(oldPath, newPath, tt.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Role != tt.want || (len(report.ByCountry) > 0) != tt.country || (len(report.ByASN) > 0) != tt.asn {
+			t.Errorf("Compare with role %q: role %q, by country %v, by ASN %v", tt.role, report.Role,
+				dimension(report.ByCountry), dimension(report.ByASN))
+		}
+	}
+
+	for dbType, want := range map[string]string{
+		"GeoLite2-City": config.RoleCity, "GeoIP2-Country": config.RoleCity, "GeoIP2-Enterprise": config.RoleCity,
+		"GeoLite2-ASN": config.RoleASN, "GeoIP2-ISP": config.RoleASN, "GeoCN": config.RoleCN,
+	} {
+		if got := DetectRole(dbType); got != want {
+			t.Errorf("DetectRole(%q) = %q, want %q", dbType, got, want)
+		}
+	}
+}
+
+func TestCompareMissingFile(t *testing.T) {
+	path := writeDB(t, "db.mmdb", "GeoLite2-City", []entry{{"192.0.2.0/24", cityValue("US", "", 0, "")}})
+	if _, err := Compare(filepath.Join(t.TempDir(), "missing.mmdb"), path, ""); err == nil {
+		t.Error("Compare with a missing old file succeeded")
+	}
+	if _, err := Compare(path, filepath.Join(t.TempDir(), "missing.mmdb"), ""); err == nil {
+		t.Error("Compare with a missing new file succeeded")
+	}
+}
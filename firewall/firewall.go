@@ -0,0 +1,393 @@
+// Package firewall 从 MMDB 数据库导出按国家、ASN 或 GeoCN 地区筛选的 IP 范围列表，
+// 并渲染为 nftables、ipset、iptables、MikroTik、BIRD 和 nginx geo 等格式。
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+	"ip-api/iputil"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/patrickmn/go-cache"
+)
+
+// DefaultName 是未指定时使用的集合名称
+const DefaultName = "geoip"
+
+// ErrNoDatabase 表示导出条件需要的数据库不存在
+var ErrNoDatabase = errors.New("database is not available")
+
+// validName 限制集合名称，保证它在所有输出格式中都是合法的标识符
+var validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,30}$`)
+
+// validTarget 限制 iptables 的跳转目标
+var validTarget = regexp.MustCompile(`^[A-Z][A-Z0-9_-]{0,28}$`)
+
+// Options 描述一次导出。同一类条件之间是“或”，不同类条件之间是“且”，
+// 例如 country=CN&isp=电信 导出中国境内的电信地址。
+type Options struct {
+	Format     string
+	Name       string
+	Target     string // iptables 的跳转目标，默认 ACCEPT
+	Family     int    // 4 或 6，0 表示两者
+	Countries  []string
+	Continents []string
+	ASNs       []uint
+	Provinces  []string // GeoCN 省份，子串匹配
+	Cities     []string // GeoCN 城市，子串匹配
+	ISPs       []string // GeoCN 运营商，子串匹配
+}
+
+// ParseOptions 从查询参数解析导出选项，多个值可以用逗号分隔或重复参数
+func ParseOptions(q url.Values) (Options, error) {
+	o := Options{
+		Format:     strings.ToLower(q.Get("format")),
+		Name:       q.Get("name"),
+		Target:     q.Get("target"),
+		Countries:  upperList(q["country"]),
+		Continents: upperList(q["continent"]),
+		Provinces:  list(q["province"]),
+		Cities:     list(q["city"]),
+		ISPs:       list(q["isp"]),
+	}
+	if o.Name == "" {
+		o.Name = DefaultName
+	}
+	if o.Target == "" {
+		o.Target = "ACCEPT"
+	}
+
+	switch q.Get("family") {
+	case "", "all":
+	case "4", "ipv4":
+		o.Family = 4
+	case "6", "ipv6":
+		o.Family = 6
+	default:
+		return Options{}, fmt.Errorf("invalid family %q", q.Get("family"))
+	}
+
+	for _, v := range list(q["asn"]) {
+		s := v
+		if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+			s = s[2:]
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 {
+			return Options{}, fmt.Errorf("invalid ASN %q", v)
+		}
+		o.ASNs = append(o.ASNs, uint(n))
+	}
+
+	if _, ok := renderers[o.Format]; !ok {
+		return Options{}, fmt.Errorf("unsupported format %q (supported: %s)", o.Format, strings.Join(Formats(), ", "))
+	}
+	if !validName.MatchString(o.Name) {
+		return Options{}, fmt.Errorf("invalid name %q", o.Name)
+	}
+	if !validTarget.MatchString(o.Target) {
+		return Options{}, fmt.Errorf("invalid target %q", o.Target)
+	}
+	if len(o.Countries) == 0 && len(o.Continents) == 0 && len(o.ASNs) == 0 &&
+		len(o.Provinces) == 0 && len(o.Cities) == 0 && len(o.ISPs) == 0 {
+		return Options{}, fmt.Errorf("at least one of country, continent, asn, province, city or isp is required")
+	}
+	return o, nil
+}
+
+// list 展开逗号分隔的参数值并去掉空白
+func list(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func upperList(values []string) []string {
+	out := list(values)
+	for i := range out {
+		out[i] = strings.ToUpper(out[i])
+	}
+	return out
+}
+
+// key 返回选项的规范化表示，用于生成 ETag
+func (o Options) key() string {
+	asns := make([]string, len(o.ASNs))
+	for i, n := range o.ASNs {
+		asns[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	parts := []string{
+		o.Format, o.Name, o.Target, strconv.Itoa(o.Family),
+		sortedJoin(o.Countries), sortedJoin(o.Continents), sortedJoin(asns),
+		sortedJoin(o.Provinces), sortedJoin(o.Cities), sortedJoin(o.ISPs),
+	}
+	return strings.Join(parts, "|")
+}
+
+func sortedJoin(values []string) string {
+	s := append([]string(nil), values...)
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// Result 是渲染好的导出结果
+type Result struct {
+	ETag        string
+	ContentType string
+	Body        []byte
+	IPv4        int // IPv4 前缀数量
+	IPv6        int // IPv6 前缀数量
+}
+
+// results 按 ETag 缓存渲染结果，ETag 由选项和所用数据库的版本决定
+var results = cache.New(time.Hour, 10*time.Minute)
+
+// source 是一次导出使用的数据库
+type source struct {
+	db     config.Database
+	reader *maxminddb.Reader
+	stamp  string
+}
+
+// openSource 打开注册表中第一个具有给定角色且文件存在的数据库。
+// 导出使用单独打开的读取器，长时间的遍历不会阻塞查询和热加载。
+func openSource(roles ...string) (*source, error) {
+	for _, role := range roles {
+		for _, db := range config.App.Databases {
+			if db.Role != role || !db.IsMMDB() {
+				continue
+			}
+			info, err := os.Stat(db.Path())
+			if err != nil {
+				continue
+			}
+			reader, err := maxminddb.Open(db.Path())
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s: %w", db.Name, err)
+			}
+			stamp := fmt.Sprintf("%s:%d:%d:%d", db.Name, reader.Metadata.BuildEpoch, info.Size(), info.ModTime().UnixNano())
+			return &source{db: db, reader: reader, stamp: stamp}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %w", strings.Join(roles, " or "), ErrNoDatabase)
+}
+
+// Export 收集符合条件的网络，合并为最少的 CIDR 集合并渲染为指定格式。
+// 相同选项和数据库版本的结果会被缓存。
+func Export(o Options) (*Result, error) {
+	type filter struct {
+		roles []string
+		match func(n *maxminddb.Networks) (*netip.Prefix, error)
+	}
+	var filters []filter
+
+	if len(o.Countries) > 0 || len(o.Continents) > 0 {
+		filters = append(filters, filter{
+			roles: []string{config.RoleEnterprise, config.RoleCity},
+			match: matchCountry(o.Countries, o.Continents),
+		})
+	}
+	if len(o.ASNs) > 0 {
+		filters = append(filters, filter{
+			roles: []string{config.RoleASN, config.RoleISP},
+			match: matchASN(o.ASNs),
+		})
+	}
+	if len(o.Provinces) > 0 || len(o.Cities) > 0 || len(o.ISPs) > 0 {
+		filters = append(filters, filter{
+			roles: []string{config.RoleCN},
+			match: matchGeoCN(o.Provinces, o.Cities, o.ISPs),
+		})
+	}
+
+	sources := make([]*source, 0, len(filters))
+	defer func() {
+		for _, s := range sources {
+			s.reader.Close()
+		}
+	}()
+	stamps := []string{o.key()}
+	for _, f := range filters {
+		s, err := openSource(f.roles...)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+		stamps = append(stamps, s.stamp)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(stamps, "\n")))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if cached, ok := results.Get(etag); ok {
+		return cached.(*Result), nil
+	}
+
+	// 每个条件得到一组范围，多个条件取交集
+	var ranges []iputil.Range
+	for i, f := range filters {
+		matched, err := collect(sources[i].reader, o.Family, f.match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sources[i].db.Name, err)
+		}
+		if i == 0 {
+			ranges = matched
+		} else {
+			ranges = iputil.Intersect(ranges, matched)
+		}
+	}
+
+	var v4, v6 []netip.Prefix
+	for _, r := range ranges {
+		for _, p := range r.Prefixes() {
+			if p.Addr().Is4() {
+				v4 = append(v4, p)
+			} else {
+				v6 = append(v6, p)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	renderers[o.Format](&sb, o, v4, v6)
+	result := &Result{
+		ETag:        etag,
+		ContentType: "text/plain; charset=utf-8",
+		Body:        []byte(sb.String()),
+		IPv4:        len(v4),
+		IPv6:        len(v6),
+	}
+	results.Set(etag, result, cache.DefaultExpiration)
+	return result, nil
+}
+
+// collect 遍历数据库的所有网络，返回匹配的网络合并后的范围
+func collect(reader *maxminddb.Reader, family int, match func(n *maxminddb.Networks) (*netip.Prefix, error)) ([]iputil.Range, error) {
+	var ranges []iputil.Range
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		p, err := match(networks)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if (family == 4 && !p.Addr().Is4()) || (family == 6 && p.Addr().Is4()) {
+			continue
+		}
+		ranges = append(ranges, iputil.PrefixRange(*p))
+	}
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+	return iputil.Merge(ranges), nil
+}
+
+// matchCountry 匹配国家代码或大洲代码
+func matchCountry(countries, continents []string) func(n *maxminddb.Networks) (*netip.Prefix, error) {
+	return func(n *maxminddb.Networks) (*netip.Prefix, error) {
+		var record struct {
+			Continent struct {
+				Code string `maxminddb:"code"`
+			} `maxminddb:"continent"`
+			Country struct {
+				IsoCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		network, err := n.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		if !contains(countries, record.Country.IsoCode) && !contains(continents, record.Continent.Code) {
+			return nil, nil
+		}
+		p := iputil.PrefixFromIPNet(network)
+		return &p, nil
+	}
+}
+
+// matchASN 匹配自治系统编号
+func matchASN(asns []uint) func(n *maxminddb.Networks) (*netip.Prefix, error) {
+	return func(n *maxminddb.Networks) (*netip.Prefix, error) {
+		var record struct {
+			Number uint `maxminddb:"autonomous_system_number"`
+		}
+		network, err := n.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		for _, asn := range asns {
+			if record.Number == asn {
+				p := iputil.PrefixFromIPNet(network)
+				return &p, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// matchGeoCN 匹配 GeoCN 的省份、城市和运营商，每个给定的字段都必须匹配
+func matchGeoCN(provinces, cities, isps []string) func(n *maxminddb.Networks) (*netip.Prefix, error) {
+	return func(n *maxminddb.Networks) (*netip.Prefix, error) {
+		var record struct {
+			Province string `maxminddb:"province"`
+			City     string `maxminddb:"city"`
+			ISP      string `maxminddb:"isp"`
+		}
+		network, err := n.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		if !containsSubstring(provinces, record.Province) ||
+			!containsSubstring(cities, record.City) ||
+			!containsSubstring(isps, record.ISP) {
+			return nil, nil
+		}
+		p := iputil.PrefixFromIPNet(network)
+		return &p, nil
+	}
+}
+
+func contains(values []string, v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// containsSubstring 在 values 为空时总是匹配，否则 v 必须包含其中一个值（不区分大小写）
+func containsSubstring(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	v = strings.ToLower(v)
+	for _, s := range values {
+		if v != "" && strings.Contains(v, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
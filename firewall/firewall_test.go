@@ -0,0 +1,324 @@
+package firewall
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ip-api/config"
+	"ip-api/mmdbwriter"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// 测试使用的数据库
+var (
+	cityDB = config.Database{Name: "Test-City", Role: config.RoleCity}
+	asnDB  = config.Database{Name: "Test-ASN", Role: config.RoleASN}
+	cnDB   = config.Database{Name: "Test-CN", Role: config.RoleCN}
+)
+
+// writeMMDB 写入包含给定网络和记录的 MMDB 文件
+func writeMMDB(t *testing.T, path, databaseType string, epoch int64, records map[string]interface{}) {
+	t.Helper()
+	w := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, BuildEpoch: time.Unix(epoch, 0)})
+	for network, rec := range records {
+		if err := w.Insert(netip.MustParsePrefix(network), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func place(continent, country string) map[string]interface{} {
+	return map[string]interface{}{
+		"continent": map[string]interface{}{"code": continent},
+		"country":   map[string]interface{}{"iso_code": country},
+	}
+}
+
+func asn(number uint32) map[string]interface{} {
+	return map[string]interface{}{"autonomous_system_number": number}
+}
+
+func geocn(province, city, isp string) map[string]interface{} {
+	return map[string]interface{}{"province": province, "city": city, "isp": isp}
+}
+
+// useDatabases 在临时数据目录中写入城市、ASN 和 GeoCN 数据库，测试结束后恢复配置
+func useDatabases(t *testing.T) {
+	t.Helper()
+	old := config.App
+	t.Cleanup(func() { config.App = old })
+	config.App.DataDir = t.TempDir()
+	config.App.Databases = []config.Database{cityDB, asnDB, cnDB}
+
+	writeMMDB(t, cityDB.Path(), "GeoLite2-City", 100, map[string]interface{}{
+		// 两个相邻的 /25 合并为一个 /24
+		"192.0.2.0/25":    place("AS", "CN"),
+		"192.0.2.128/25":  place("AS", "CN"),
+		"198.51.100.0/24": place("NA", "US"),
+		"203.0.113.0/24":  place("AS", "JP"),
+		// 嵌套的 /48 把 /33 拆成多个网络，导出时重新合并
+		"2001:db8::/33": place("AS", "CN"),
+		"2001:db8::/48": place("AS", "CN"),
+	})
+	writeMMDB(t, asnDB.Path(), "GeoLite2-ASN", 100, map[string]interface{}{
+		"192.0.2.0/24":     asn(4134),
+		"198.51.100.0/24":  asn(4134),
+		"203.0.113.0/25":   asn(4134),
+		"203.0.113.128/25": asn(2516),
+		"2001:db8::/32":    asn(4134),
+	})
+	writeMMDB(t, cnDB.Path(), "GeoCN", 100, map[string]interface{}{
+		"192.0.2.0/25":   geocn("广东省", "深圳市", "电信"),
+		"192.0.2.128/25": geocn("北京市", "北京市", "联通"),
+	})
+}
+
+// export 解析查询参数并导出，返回结果中的前缀列表
+func export(t *testing.T, query string) (*Result, []string) {
+	t.Helper()
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := ParseOptions(q)
+	if err != nil {
+		t.Fatalf("ParseOptions(%s): %v", query, err)
+	}
+	res, err := Export(o)
+	if err != nil {
+		t.Fatalf("Export(%s): %v", query, err)
+	}
+	var prefixes []string
+	for _, line := range strings.Split(string(res.Body), "\n") {
+		if line != "" {
+			prefixes = append(prefixes, line)
+		}
+	}
+	return res, prefixes
+}
+
+func TestExport(t *testing.T) {
+	useDatabases(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"country=CN", []string{"192.0.2.0/24", "2001:db8::/33"}},
+		{"country=cn&family=4", []string{"192.0.2.0/24"}},
+		{"country=CN&family=ipv6", []string{"2001:db8::/33"}},
+		{"continent=AS", []string{"192.0.2.0/24", "203.0.113.0/24", "2001:db8::/33"}},
+		{"country=US&continent=EU", []string{"198.51.100.0/24"}},
+		{"asn=AS2516", []string{"203.0.113.128/25"}},
+		// 不同类条件取交集
+		{"country=CN,JP&asn=4134", []string{"192.0.2.0/24", "203.0.113.0/25", "2001:db8::/33"}},
+		{"asn=4134&isp=电信", []string{"192.0.2.0/25"}},
+		{"country=CN&province=北京", []string{"192.0.2.128/25"}},
+		{"province=广东&isp=联通", nil},
+		{"country=US&asn=2516", nil},
+	}
+	for _, tt := range tests {
+		res, got := export(t, "format=plain&"+tt.query)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Export(%s) = %v, want %v", tt.query, got, tt.want)
+		}
+		v4 := 0
+		for _, p := range tt.want {
+			if !strings.Contains(p, ":") {
+				v4++
+			}
+		}
+		if res.IPv4 != v4 || res.IPv6 != len(tt.want)-v4 {
+			t.Errorf("Export(%s) counts %d IPv4 and %d IPv6 prefixes", tt.query, res.IPv4, res.IPv6)
+		}
+	}
+}
+
+func TestExportETag(t *testing.T) {
+	useDatabases(t)
+
+	first, _ := export(t, "format=plain&country=CN,JP&asn=4134")
+	again, _ := export(t, "format=plain&asn=AS4134&country=JP&country=CN")
+	if first.ETag != again.ETag {
+		t.Errorf("equivalent options have different ETags %s and %s", first.ETag, again.ETag)
+	}
+	for _, query := range []string{"format=nftables&country=CN,JP&asn=4134", "format=plain&country=CN&asn=4134", "format=plain&country=CN,JP&asn=4134&family=4"} {
+		if res, _ := export(t, query); res.ETag == first.ETag {
+			t.Errorf("Export(%s) has the same ETag as different options", query)
+		}
+	}
+
+	// 数据库更新后 ETag 和内容都随之变化
+	writeMMDB(t, asnDB.Path(), "GeoLite2-ASN", 200, map[string]interface{}{"203.0.113.0/24": asn(4134)})
+	updated, got := export(t, "format=plain&country=CN,JP&asn=4134")
+	if updated.ETag == first.ETag {
+		t.Error("ETag did not change after the database was replaced")
+	}
+	if !reflect.DeepEqual(got, []string{"203.0.113.0/24"}) {
+		t.Errorf("Export after the update = %v", got)
+	}
+}
+
+func TestExportMissingDatabase(t *testing.T) {
+	useDatabases(t)
+	config.App.Databases = []config.Database{cityDB, asnDB}
+
+	o, err := ParseOptions(url.Values{"format": {"plain"}, "country": {"CN"}, "isp": {"电信"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Export(o); !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("Export without a GeoCN database = %v, want ErrNoDatabase", err)
+	}
+
+	// 注册表中的数据库文件不存在时同样不可用
+	os.Remove(cityDB.Path())
+	o.ISPs = nil
+	if _, err := Export(o); !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("Export without a city database file = %v, want ErrNoDatabase", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	useDatabases(t)
+	reader, err := maxminddb.Open(cityDB.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		family int
+		match  func(n *maxminddb.Networks) (*netip.Prefix, error)
+		want   []string
+	}{
+		{0, matchCountry([]string{"CN"}, nil), []string{"192.0.2.0-192.0.2.255", "2001:db8::-2001:db8:7fff:ffff:ffff:ffff:ffff:ffff"}},
+		{4, matchCountry(nil, []string{"AS"}), []string{"192.0.2.0-192.0.2.255", "203.0.113.0-203.0.113.255"}},
+		{6, matchCountry([]string{"US"}, nil), nil},
+		// 没有国家信息的记录不匹配空代码
+		{0, matchCountry([]string{""}, nil), nil},
+	}
+	for i, tt := range tests {
+		ranges, err := collect(reader, tt.family, tt.match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range ranges {
+			got = append(got, r.From.String()+"-"+r.To.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("case %d: ranges = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	useDatabases(t)
+
+	tests := []struct {
+		db    config.Database
+		match func(n *maxminddb.Networks) (*netip.Prefix, error)
+		want  []string
+	}{
+		{asnDB, matchASN([]uint{2516, 64496}), []string{"203.0.113.128/25"}},
+		{asnDB, matchASN(nil), nil},
+		{cnDB, matchGeoCN([]string{"广东"}, nil, nil), []string{"192.0.2.0/25"}},
+		// 同一字段的多个值之间是“或”，不同字段之间是“且”
+		{cnDB, matchGeoCN([]string{"广东", "北京"}, []string{"北京市"}, nil), []string{"192.0.2.128/25"}},
+		{cnDB, matchGeoCN(nil, nil, []string{"电信", "移动"}), []string{"192.0.2.0/25"}},
+		{cnDB, matchGeoCN(nil, []string{"上海"}, nil), nil},
+	}
+	for i, tt := range tests {
+		reader, err := maxminddb.Open(tt.db.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+		for networks.Next() {
+			p, err := tt.match(networks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				got = append(got, p.String())
+			}
+		}
+		reader.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("case %d: matched %v, want %v", i, got, tt.want)
+		}
+	}
+
+	if !containsSubstring([]string{"telecom"}, "China TELECOM") || containsSubstring([]string{"telecom"}, "") || !containsSubstring(nil, "") {
+		t.Error("containsSubstring does not match case-insensitively or treats empty values incorrectly")
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	q := url.Values{
+		"format":    {"NFTables"},
+		"name":      {"cn_block"},
+		"country":   {"cn, jp", ""},
+		"continent": {"eu"},
+		"asn":       {"AS4134,as2516", "9808"},
+		"isp":       {" 电信 "},
+		"family":    {"ipv6"},
+	}
+	o, err := ParseOptions(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Options{
+		Format: "nftables", Name: "cn_block", Target: "ACCEPT", Family: 6,
+		Countries: []string{"CN", "JP"}, Continents: []string{"EU"}, ASNs: []uint{4134, 2516, 9808}, ISPs: []string{"电信"},
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("ParseOptions = %+v, want %+v", o, want)
+	}
+
+	if o, err := ParseOptions(url.Values{"format": {"plain"}, "country": {"CN"}}); err != nil || o.Name != DefaultName || o.Family != 0 {
+		t.Errorf("ParseOptions defaults = %+v, %v", o, err)
+	}
+
+	tests := []struct {
+		query, err string
+	}{
+		{"format=pf&country=CN", "unsupported format"},
+		{"country=CN", "unsupported format"},
+		{"format=plain&country=CN&name=1set", "invalid name"},
+		{"format=plain&country=CN&name=geo-ip", "invalid name"},
+		{"format=plain&country=CN&name=" + strings.Repeat("a", 32), "invalid name"},
+		{"format=iptables&country=CN&target=accept", "invalid target"},
+		{"format=iptables&country=CN&target=DROP+ALL", "invalid target"},
+		{"format=plain&asn=ASX", "invalid ASN"},
+		{"format=plain&asn=0", "invalid ASN"},
+		{"format=plain&asn=4294967296", "invalid ASN"},
+		{"format=plain&asn=-1", "invalid ASN"},
+		{"format=plain&country=CN&family=5", "invalid family"},
+		{"format=plain", "at least one of"},
+		{"format=plain&country=,", "at least one of"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		if _, err := ParseOptions(q); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseOptions(%s) error = %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// renderers 按格式名称注册的渲染器，每个渲染器将 IPv4 和 IPv6 前缀写成一种输出格式
+var renderers = map[string]func(sb *strings.Builder, o Options, v4, v6 []netip.Prefix){
+	"plain":    renderPlain,    // 每行一个 CIDR
+	"nftables": renderNftables, // 命名集合，在 table 块中 include
+	"ipset":    renderIpset,    // ipset restore 文件
+	"iptables": renderIptables, // 填充一个链的 iptables/ip6tables 命令
+	"mikrotik": renderMikrotik, // RouterOS address-list 脚本
+	"bird":     renderBird,     // BIRD 前缀集合定义
+	"nginx":    renderNginx,    // nginx geo 块
+}
+
+// Formats 返回支持的输出格式名称
+func Formats() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// header 写入描述导出内容的注释
+func header(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	fmt.Fprintf(sb, "# %s: %d IPv4 and %d IPv6 prefixes\n", o.Name, len(v4), len(v6))
+}
+
+// family 是一个地址族的前缀，suffix 用于区分集合名称
+type family struct {
+	suffix   string
+	prefixes []netip.Prefix
+}
+
+// families 返回需要输出的地址族，family 选项排除的地址族不输出
+func families(o Options, v4, v6 []netip.Prefix) []family {
+	var out []family
+	if o.Family != 6 {
+		out = append(out, family{"v4", v4})
+	}
+	if o.Family != 4 {
+		out = append(out, family{"v6", v6})
+	}
+	return out
+}
+
+func renderPlain(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	for _, p := range v4 {
+		sb.WriteString(p.String() + "\n")
+	}
+	for _, p := range v6 {
+		sb.WriteString(p.String() + "\n")
+	}
+}
+
+func renderNftables(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	for _, f := range families(o, v4, v6) {
+		addrType := "ipv4_addr"
+		if f.suffix == "v6" {
+			addrType = "ipv6_addr"
+		}
+		fmt.Fprintf(sb, "set %s_%s {\n\ttype %s\n\tflags interval\n", o.Name, f.suffix, addrType)
+		if len(f.prefixes) > 0 {
+			sb.WriteString("\telements = {\n")
+			for i, p := range f.prefixes {
+				sep := ","
+				if i == len(f.prefixes)-1 {
+					sep = ""
+				}
+				fmt.Fprintf(sb, "\t\t%s%s\n", p, sep)
+			}
+			sb.WriteString("\t}\n")
+		}
+		sb.WriteString("}\n")
+	}
+}
+
+func renderIpset(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	for _, f := range families(o, v4, v6) {
+		family := "inet"
+		if f.suffix == "v6" {
+			family = "inet6"
+		}
+		set := o.Name + "_" + f.suffix
+		fmt.Fprintf(sb, "create %s hash:net family %s maxelem %d -exist\n", set, family, max(65536, len(f.prefixes)))
+		fmt.Fprintf(sb, "flush %s\n", set)
+		for _, p := range f.prefixes {
+			fmt.Fprintf(sb, "add %s %s\n", set, p)
+		}
+	}
+}
+
+func renderIptables(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	for _, f := range families(o, v4, v6) {
+		cmd := "iptables"
+		if f.suffix == "v6" {
+			cmd = "ip6tables"
+		}
+		fmt.Fprintf(sb, "%s -N %s 2>/dev/null || %s -F %s\n", cmd, o.Name, cmd, o.Name)
+		for _, p := range f.prefixes {
+			fmt.Fprintf(sb, "%s -A %s -s %s -j %s\n", cmd, o.Name, p, o.Target)
+		}
+	}
+}
+
+func renderMikrotik(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	for _, f := range families(o, v4, v6) {
+		menu := "/ip firewall address-list"
+		if f.suffix == "v6" {
+			menu = "/ipv6 firewall address-list"
+		}
+		sb.WriteString(menu + "\n")
+		fmt.Fprintf(sb, "remove [find list=%s]\n", o.Name)
+		for _, p := range f.prefixes {
+			fmt.Fprintf(sb, "add list=%s address=%s\n", o.Name, p)
+		}
+	}
+}
+
+// renderBird 写入 BIRD 前缀集合。BIRD 不接受空集合，没有前缀的地址族只写一行注释。
+func renderBird(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	for _, f := range families(o, v4, v6) {
+		if len(f.prefixes) == 0 {
+			fmt.Fprintf(sb, "# %s_%s is not defined: no prefixes\n", o.Name, f.suffix)
+			continue
+		}
+		fmt.Fprintf(sb, "define %s_%s = [", o.Name, f.suffix)
+		for i, p := range f.prefixes {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(sb, "\n\t%s", p)
+		}
+		sb.WriteString("\n];\n")
+	}
+}
+
+func renderNginx(sb *strings.Builder, o Options, v4, v6 []netip.Prefix) {
+	header(sb, o, v4, v6)
+	fmt.Fprintf(sb, "geo $%s {\n\tdefault 0;\n", o.Name)
+	for _, f := range families(o, v4, v6) {
+		for _, p := range f.prefixes {
+			fmt.Fprintf(sb, "\t%s 1;\n", p)
+		}
+	}
+	sb.WriteString("}\n")
+}
@@ -0,0 +1,54 @@
+package firewall
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestRenderers(t *testing.T) {
+	v4 := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("198.51.100.0/24")}
+	v6 := []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")}
+	o := Options{Name: "allow", Target: "ACCEPT"}
+	const head = "# allow: 2 IPv4 and 1 IPv6 prefixes\n"
+
+	tests := []struct {
+		format string
+		o      Options
+		v4, v6 []netip.Prefix
+		want   string
+	}{
+		{"plain", o, v4, v6, "192.0.2.0/24\n198.51.100.0/24\n2001:db8::/32\n"},
+		{"nftables", o, v4, v6, head +
+			"set allow_v4 {\n\ttype ipv4_addr\n\tflags interval\n\telements = {\n\t\t192.0.2.0/24,\n\t\t198.51.100.0/24\n\t}\n}\n" +
+			"set allow_v6 {\n\ttype ipv6_addr\n\tflags interval\n\telements = {\n\t\t2001:db8::/32\n\t}\n}\n"},
+		{"nftables", o, nil, nil, "# allow: 0 IPv4 and 0 IPv6 prefixes\n" +
+			"set allow_v4 {\n\ttype ipv4_addr\n\tflags interval\n}\n" +
+			"set allow_v6 {\n\ttype ipv6_addr\n\tflags interval\n}\n"},
+		{"ipset", Options{Name: "allow", Family: 4}, v4, v6, head +
+			"create allow_v4 hash:net family inet maxelem 65536 -exist\nflush allow_v4\n" +
+			"add allow_v4 192.0.2.0/24\nadd allow_v4 198.51.100.0/24\n"},
+		{"iptables", Options{Name: "allow", Target: "DROP", Family: 6}, v4, v6, head +
+			"ip6tables -N allow 2>/dev/null || ip6tables -F allow\n" +
+			"ip6tables -A allow -s 2001:db8::/32 -j DROP\n"},
+		{"mikrotik", o, v4, v6, head +
+			"/ip firewall address-list\nremove [find list=allow]\n" +
+			"add list=allow address=192.0.2.0/24\nadd list=allow address=198.51.100.0/24\n" +
+			"/ipv6 firewall address-list\nremove [find list=allow]\nadd list=allow address=2001:db8::/32\n"},
+		{"bird", o, v4, v6, head +
+			"define allow_v4 = [\n\t192.0.2.0/24,\n\t198.51.100.0/24\n];\n" +
+			"define allow_v6 = [\n\t2001:db8::/32\n];\n"},
+		{"bird", o, v4, nil, "# allow: 2 IPv4 and 0 IPv6 prefixes\n" +
+			"define allow_v4 = [\n\t192.0.2.0/24,\n\t198.51.100.0/24\n];\n" +
+			"# allow_v6 is not defined: no prefixes\n"},
+		{"nginx", o, v4, v6, head +
+			"geo $allow {\n\tdefault 0;\n\t192.0.2.0/24 1;\n\t198.51.100.0/24 1;\n\t2001:db8::/32 1;\n}\n"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		renderers[tt.format](&sb, tt.o, tt.v4, tt.v6)
+		if got := sb.String(); got != tt.want {
+			t.Errorf("%s with %d/%d prefixes:\n%s\nwant:\n%s", tt.format, len(tt.v4), len(tt.v6), got, tt.want)
+		}
+	}
+}
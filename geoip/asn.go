@@ -0,0 +1,349 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ip-api/config"
+	"ip-api/iputil"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// unknownCountry 是城市数据库中没有国家信息的地址使用的代码
+const unknownCountry = "ZZ"
+
+// ErrASNIndexUnavailable 表示 ASN 索引尚未构建（ASN 数据库未加载或仍在构建中）
+var ErrASNIndexUnavailable = errors.New("ASN index is not available")
+
+// asnEntry 是 ASN 索引中的一个自治系统
+type asnEntry struct {
+	number       uint
+	organization string
+	prefixes     []netip.Prefix
+}
+
+// asnIndex 是从 ASN 数据库的网络树构建的自治系统索引
+type asnIndex struct {
+	entries    map[uint]*asnEntry
+	numbers    []uint // 按编号排序，用于稳定的搜索结果
+	buildEpoch uint
+}
+
+var (
+	asnMu  sync.RWMutex
+	asnIdx *asnIndex
+	// asnGen 标识最近一次请求的索引构建，较早的构建完成后被丢弃
+	asnGen uint64
+	// asnBuilds 跟踪正在进行的后台构建
+	asnBuilds sync.WaitGroup
+)
+
+// scheduleASNIndex 在后台重新构建 ASN 索引，调用者必须持有写锁。
+// 构建使用单独打开的读取器，不会因为 dbs 中的读取器被替换而失效。
+// 文件路径在启动构建前确定，后台构建不读取可能随后变化的配置。
+func scheduleASNIndex(db config.Database) {
+	path := db.Path()
+	asnMu.Lock()
+	asnGen++
+	gen := asnGen
+	asnMu.Unlock()
+
+	asnBuilds.Add(1)
+	go func() {
+		defer asnBuilds.Done()
+		start := time.Now()
+		idx, err := buildASNIndex(db, path)
+		if err != nil {
+			log.Printf("Failed to build ASN index from %s: %v", db.Name, err)
+			return
+		}
+
+		asnMu.Lock()
+		defer asnMu.Unlock()
+		if gen != asnGen {
+			return
+		}
+		asnIdx = idx
+		log.Printf("ASN index built from %s: %d autonomous systems in %s", db.Name, len(idx.entries), time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// waitASNIndex 等待所有已启动的后台构建结束
+func waitASNIndex() {
+	asnBuilds.Wait()
+}
+
+// asnDecoder 解码网络遍历中的一条记录，返回网络、自治系统号和组织
+type asnDecoder func(n *maxminddb.Networks) (*net.IPNet, uint, string, error)
+
+// newASNDecoder 返回数据库的记录解码方式：mmdb 数据源按字段映射读取 asn 和 as_organization，
+// 其他数据源按 GeoLite2 ASN 的结构解码
+func newASNDecoder(db config.Database) (asnDecoder, error) {
+	if db.ProviderKind() != config.ProviderMMDB {
+		return func(n *maxminddb.Networks) (*net.IPNet, uint, string, error) {
+			var record struct {
+				Number       uint   `maxminddb:"autonomous_system_number"`
+				Organization string `maxminddb:"autonomous_system_organization"`
+			}
+			network, err := n.Network(&record)
+			return network, record.Number, record.Organization, err
+		}, nil
+	}
+
+	mapping := db.FieldMapping()
+	if mapping["asn"] == "" {
+		return nil, fmt.Errorf("the mapping of %s has no asn field", db.Name)
+	}
+	numberPath := strings.Split(mapping["asn"], ".")
+	var orgPath []string
+	if p := mapping["as_organization"]; p != "" {
+		orgPath = strings.Split(p, ".")
+	}
+	return func(n *maxminddb.Networks) (*net.IPNet, uint, string, error) {
+		var record interface{}
+		network, err := n.Network(&record)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		var number uint
+		var org string
+		if v, ok := valueAt(record, numberPath); ok {
+			number = toASN(v)
+		}
+		if orgPath != nil {
+			if v, ok := valueAt(record, orgPath); ok {
+				org = toString(v)
+			}
+		}
+		return network, number, org, nil
+	}, nil
+}
+
+// buildASNIndex 遍历 path 处 ASN 数据库的所有网络，按自治系统编号归类前缀
+func buildASNIndex(db config.Database, path string) (*asnIndex, error) {
+	decode, err := newASNDecoder(db)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	idx := &asnIndex{
+		entries:    make(map[uint]*asnEntry),
+		buildEpoch: reader.Metadata.BuildEpoch,
+	}
+
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		network, number, org, err := decode(networks)
+		if err != nil {
+			return nil, err
+		}
+		if number == 0 {
+			continue
+		}
+
+		entry, ok := idx.entries[number]
+		if !ok {
+			entry = &asnEntry{number: number, organization: org}
+			idx.entries[number] = entry
+			idx.numbers = append(idx.numbers, number)
+		}
+		entry.prefixes = append(entry.prefixes, iputil.PrefixFromIPNet(network))
+	}
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+
+	// 数据库中相邻的网络合并为最少的前缀
+	for _, entry := range idx.entries {
+		entry.prefixes = iputil.Aggregate(entry.prefixes)
+	}
+	sort.Slice(idx.numbers, func(i, j int) bool { return idx.numbers[i] < idx.numbers[j] })
+	return idx, nil
+}
+
+// ASNDetails 是一个自治系统的前缀和地址分布
+type ASNDetails struct {
+	ASN           string       `json:"asn"`
+	Number        uint         `json:"number"`
+	Organization  string       `json:"organization"`
+	IPv4Prefixes  []string     `json:"ipv4_prefixes"`
+	IPv6Prefixes  []string     `json:"ipv6_prefixes"`
+	IPv4Addresses iputil.Count `json:"ipv4_addresses"`
+	IPv6Addresses iputil.Count `json:"ipv6_addresses"`
+	Countries     []ASNCountry `json:"countries,omitempty"`
+	BuildEpoch    uint         `json:"build_epoch"`
+}
+
+// ASNCountry 是自治系统在一个国家的地址数量，国家来自城市数据库
+type ASNCountry struct {
+	CountryCode   string       `json:"country_code"`
+	IPv4Addresses iputil.Count `json:"ipv4_addresses"`
+	IPv6Addresses iputil.Count `json:"ipv6_addresses"`
+}
+
+// ASNSummary 是 ASN 搜索结果中的一项
+type ASNSummary struct {
+	ASN          string `json:"asn"`
+	Number       uint   `json:"number"`
+	Organization string `json:"organization"`
+	IPv4Prefixes int    `json:"ipv4_prefixes"`
+	IPv6Prefixes int    `json:"ipv6_prefixes"`
+}
+
+// LookupASN 返回自治系统的前缀列表、地址总数和按国家的分布，未找到时第二个返回值为 false
+func LookupASN(number uint) (*ASNDetails, bool, error) {
+	asnMu.RLock()
+	idx := asnIdx
+	asnMu.RUnlock()
+	if idx == nil {
+		return nil, false, ErrASNIndexUnavailable
+	}
+
+	entry, ok := idx.entries[number]
+	if !ok {
+		return nil, false, nil
+	}
+
+	details := &ASNDetails{
+		ASN:          "AS" + strconv.FormatUint(uint64(number), 10),
+		Number:       number,
+		Organization: entry.organization,
+		IPv4Prefixes: []string{},
+		IPv6Prefixes: []string{},
+		BuildEpoch:   idx.buildEpoch,
+	}
+	for _, p := range entry.prefixes {
+		if p.Addr().Is4() {
+			details.IPv4Prefixes = append(details.IPv4Prefixes, p.String())
+			details.IPv4Addresses = details.IPv4Addresses.Add(iputil.PrefixSize(p))
+		} else {
+			details.IPv6Prefixes = append(details.IPv6Prefixes, p.String())
+			details.IPv6Addresses = details.IPv6Addresses.Add(iputil.PrefixSize(p))
+		}
+	}
+
+	countries, err := countryBreakdown(entry.prefixes)
+	if err != nil {
+		return nil, false, err
+	}
+	details.Countries = countries
+	return details, true, nil
+}
+
+// countryBreakdown 用城市数据库统计前缀中每个国家的地址数量，按 IPv4 地址数降序排列。
+// 没有加载城市数据库时返回 nil。遍历使用单独打开的读取器，大型自治系统的统计不会阻塞热加载。
+func countryBreakdown(prefixes []netip.Prefix) ([]ASNCountry, error) {
+	cityDB, err := openReaderFor(config.RoleEnterprise, config.RoleCity)
+	if err != nil || cityDB == nil {
+		return nil, err
+	}
+	defer cityDB.Close()
+
+	byCountry := make(map[string]*ASNCountry)
+	for _, p := range prefixes {
+		if p.Addr().Is6() && cityDB.Metadata.IPVersion == 4 {
+			continue
+		}
+		networks := cityDB.NetworksWithin(iputil.IPNetFromPrefix(p), maxminddb.SkipAliasedNetworks)
+		for networks.Next() {
+			var record struct {
+				Country struct {
+					IsoCode string `maxminddb:"iso_code"`
+				} `maxminddb:"country"`
+			}
+			network, err := networks.Network(&record)
+			if err != nil {
+				return nil, err
+			}
+
+			// 前缀可能位于城市数据库中更大的网络内，只统计两者重叠的部分
+			size := iputil.PrefixSize(p)
+			if n := iputil.PrefixFromIPNet(network); n.Bits() > p.Bits() {
+				size = iputil.PrefixSize(n)
+			}
+
+			code := record.Country.IsoCode
+			if code == "" {
+				code = unknownCountry
+			}
+			c, ok := byCountry[code]
+			if !ok {
+				c = &ASNCountry{CountryCode: code}
+				byCountry[code] = c
+			}
+			if p.Addr().Is4() {
+				c.IPv4Addresses = c.IPv4Addresses.Add(size)
+			} else {
+				c.IPv6Addresses = c.IPv6Addresses.Add(size)
+			}
+		}
+		if err := networks.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	countries := make([]ASNCountry, 0, len(byCountry))
+	for _, c := range byCountry {
+		countries = append(countries, *c)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		if cmp := countries[i].IPv4Addresses.Cmp(countries[j].IPv4Addresses); cmp != 0 {
+			return cmp > 0
+		}
+		if cmp := countries[i].IPv6Addresses.Cmp(countries[j].IPv6Addresses); cmp != 0 {
+			return cmp > 0
+		}
+		return countries[i].CountryCode < countries[j].CountryCode
+	})
+	return countries, nil
+}
+
+// SearchASN 按组织名称（不区分大小写的子串）搜索自治系统，最多返回 limit 个结果
+func SearchASN(query string, limit int) ([]ASNSummary, error) {
+	asnMu.RLock()
+	idx := asnIdx
+	asnMu.RUnlock()
+	if idx == nil {
+		return nil, ErrASNIndexUnavailable
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	results := []ASNSummary{}
+	for _, number := range idx.numbers {
+		entry := idx.entries[number]
+		if !strings.Contains(strings.ToLower(entry.organization), query) {
+			continue
+		}
+		summary := ASNSummary{
+			ASN:          "AS" + strconv.FormatUint(uint64(number), 10),
+			Number:       number,
+			Organization: entry.organization,
+		}
+		for _, p := range entry.prefixes {
+			if p.Addr().Is4() {
+				summary.IPv4Prefixes++
+			} else {
+				summary.IPv6Prefixes++
+			}
+		}
+		results = append(results, summary)
+		if len(results) >= limit {
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package geoip
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/iputil"
+)
+
+func TestBuildASNIndex(t *testing.T) {
+	useDataDir(t)
+	db := config.Database{Name: "Test-ASN", Role: config.RoleASN}
+	writeMMDB(t, db.Path(), "GeoLite2-ASN", map[string]interface{}{
+		"1.0.0.0/24": map[string]interface{}{"autonomous_system_number": uint32(13335), "autonomous_system_organization": "CLOUDFLARENET"},
+		"1.0.1.0/24": map[string]interface{}{"autonomous_system_number": uint32(13335), "autonomous_system_organization": "CLOUDFLARENET"},
+		"2.0.0.0/8":  map[string]interface{}{"autonomous_system_number": uint32(3215), "autonomous_system_organization": "Orange"},
+	})
+
+	idx, err := buildASNIndex(db, db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx.numbers, []uint{3215, 13335}) {
+		t.Errorf("numbers = %v", idx.numbers)
+	}
+	entry := idx.entries[13335]
+	if entry == nil || entry.organization != "CLOUDFLARENET" ||
+		!reflect.DeepEqual(entry.prefixes, []netip.Prefix{netip.MustParsePrefix("1.0.0.0/23")}) {
+		t.Errorf("AS13335 = %+v, want 1.0.0.0/23", entry)
+	}
+}
+
+func TestBuildASNIndexMapped(t *testing.T) {
+	useDataDir(t)
+	db := config.Database{Name: "Test-IPinfo", Role: config.RoleASN, Provider: config.ProviderMMDB, Schema: "ipinfo-lite"}
+	writeMMDB(t, db.Path(), "ipinfo_lite.mmdb", map[string]interface{}{
+		"1.0.0.0/24":    map[string]interface{}{"country_code": "AU", "asn": "AS13335", "as_name": "Cloudflare, Inc."},
+		"2001:db8::/32": map[string]interface{}{"country_code": "DE", "asn": "AS64496", "as_name": "Example"},
+		"2.0.0.0/8":     map[string]interface{}{"country_code": "FR"},
+	})
+
+	idx, err := buildASNIndex(db, db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx.numbers, []uint{13335, 64496}) {
+		t.Errorf("numbers = %v", idx.numbers)
+	}
+	if entry := idx.entries[13335]; entry == nil || entry.organization != "Cloudflare, Inc." {
+		t.Errorf("AS13335 = %+v", entry)
+	}
+
+	db.Schema = "dbip-country-lite"
+	if _, err := buildASNIndex(db, db.Path()); err == nil {
+		t.Error("a mapping without asn should not build an index")
+	}
+}
+
+func TestCountryBreakdown(t *testing.T) {
+	useDataDir(t)
+	if countries, err := countryBreakdown([]netip.Prefix{netip.MustParsePrefix("1.0.0.0/24")}); countries != nil || err != nil {
+		t.Fatalf("without a city database got %v, %v", countries, err)
+	}
+
+	openTestDB(t, config.Database{Name: "Test-City", Role: config.RoleCity}, "GeoLite2-City", map[string]interface{}{
+		"1.0.0.0/16": country("US"),
+		"1.1.0.0/17": country("CN"),
+	})
+	countries, err := countryBreakdown([]netip.Prefix{
+		netip.MustParsePrefix("1.0.0.0/24"), // 城市数据库中更大网络的一部分
+		netip.MustParsePrefix("1.1.0.0/16"), // 一半没有数据
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ASNCountry{
+		{CountryCode: "CN", IPv4Addresses: iputil.PrefixSize(netip.MustParsePrefix("1.1.0.0/17"))},
+		{CountryCode: "US", IPv4Addresses: iputil.PrefixSize(netip.MustParsePrefix("1.0.0.0/24"))},
+	}
+	if !reflect.DeepEqual(countries, want) {
+		t.Errorf("countryBreakdown = %+v, want %+v", countries, want)
+	}
+}
@@ -0,0 +1,61 @@
+package geoip
+
+import (
+	"log"
+	"net"
+
+	"ip-api/config"
+	"ip-api/overrides"
+)
+
+// Annotator 由 custom 角色的数据源实现，返回地址的原始记录，
+// 例如客户 ID、数据中心名称和风险等级等自定义的元数据
+type Annotator interface {
+	Annotate(ip net.IP) (interface{}, *net.IPNet, bool, error)
+}
+
+// annotate 查询一个 custom 角色的数据库，记录以数据库名称为键写入 Result.Custom，返回包含该地址的网络
+func annotate(res *Result, db *database, ip net.IP) *net.IPNet {
+	a, ok := db.provider.(Annotator)
+	if !ok {
+		return nil
+	}
+	data, network, found, err := a.Annotate(ip)
+	if err != nil {
+		log.Printf("%s lookup for %s failed: %v", db.cfg.Name, ip, err)
+		return nil
+	}
+
+	status := SourceStatus{Name: db.cfg.Name, Role: db.cfg.Role, Found: found}
+	if found {
+		status.Network = network.String()
+		if res.Custom == nil {
+			res.Custom = make(map[string]interface{})
+		}
+		res.Custom[db.cfg.Name] = data
+	}
+	res.Sources = append(res.Sources, status)
+	return network
+}
+
+// HasLocalData 检查覆盖记录或 custom 角色的数据库是否包含该地址。
+// 私有网络等特殊用途地址不在公共数据库中，只有本地数据包含时才需要查询。
+func HasLocalData(ip net.IP) bool {
+	if _, _, ok := overrides.Lookup(ip); ok {
+		return true
+	}
+
+	dbMux.RLock()
+	defer dbMux.RUnlock()
+	for _, db := range dbs {
+		if db.cfg.Role != config.RoleCustom || ipv4Only(db.provider) && ip.To4() == nil {
+			continue
+		}
+		if a, ok := db.provider.(Annotator); ok {
+			if _, _, found, err := a.Annotate(ip); err == nil && found {
+				return true
+			}
+		}
+	}
+	return false
+}
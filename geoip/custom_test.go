@@ -0,0 +1,102 @@
+package geoip
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+)
+
+func TestAnnotateCustomDatabases(t *testing.T) {
+	useDataDir(t)
+	customers := config.Database{Name: "Customers", Role: config.RoleCustom}
+	sites := config.Database{Name: "Sites", Role: config.RoleCustom}
+	openTestDB(t, customers, "Internal-Custom", map[string]interface{}{
+		"10.1.0.0/16":  map[string]interface{}{"customer": "acme", "tier": uint32(2)},
+		"192.0.2.0/24": map[string]interface{}{"customer": "globex"},
+	})
+	openTestDB(t, sites, "Internal-Custom", map[string]interface{}{
+		"10.1.2.0/24": map[string]interface{}{"site": "fra1"},
+	})
+	addFake(t, "Test-City", config.RoleCity, map[string]Record{"192.0.2.0/24": {CountryCode: "US"}})
+
+	tests := []struct {
+		ip      string
+		custom  map[string]interface{}
+		sources []SourceStatus
+	}{
+		{
+			// 每个数据库的记录以各自的名称为键，不会互相覆盖
+			"10.1.2.3",
+			map[string]interface{}{
+				"Customers": map[string]interface{}{"customer": "acme", "tier": uint64(2)},
+				"Sites":     map[string]interface{}{"site": "fra1"},
+			},
+			[]SourceStatus{
+				{Name: "Customers", Role: config.RoleCustom, Found: true, Network: "10.1.0.0/16"},
+				{Name: "Sites", Role: config.RoleCustom, Found: true, Network: "10.1.2.0/24"},
+				{Name: "Test-City", Role: config.RoleCity},
+			},
+		},
+		{
+			"10.1.3.1",
+			map[string]interface{}{"Customers": map[string]interface{}{"customer": "acme", "tier": uint64(2)}},
+			[]SourceStatus{
+				{Name: "Customers", Role: config.RoleCustom, Found: true, Network: "10.1.0.0/16"},
+				{Name: "Sites", Role: config.RoleCustom, Found: false},
+				{Name: "Test-City", Role: config.RoleCity},
+			},
+		},
+	}
+	for _, tt := range tests {
+		res, err := Lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", tt.ip, err)
+		}
+		if !reflect.DeepEqual(res.Custom, tt.custom) {
+			t.Errorf("Lookup(%s) custom = %#v, want %#v", tt.ip, res.Custom, tt.custom)
+		}
+		if !reflect.DeepEqual(res.Sources, tt.sources) {
+			t.Errorf("Lookup(%s) sources = %+v, want %+v", tt.ip, res.Sources, tt.sources)
+		}
+		if len(res.Answers) != 0 {
+			t.Errorf("Lookup(%s) merged custom records into answers: %+v", tt.ip, res.Answers)
+		}
+	}
+
+	// custom 记录与其他数据源的结果并存，不参与合并
+	res, err := Lookup(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Record.CountryCode != "US" || !reflect.DeepEqual(res.Custom, map[string]interface{}{"Customers": map[string]interface{}{"customer": "globex"}}) {
+		t.Errorf("Lookup(192.0.2.1) = %+v, custom %v", res.Record, res.Custom)
+	}
+
+	if _, err := Lookup(net.ParseIP("10.2.0.1")); err != ErrNotFound {
+		t.Errorf("Lookup(10.2.0.1) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestHasLocalData(t *testing.T) {
+	useDataDir(t)
+	openTestDB(t, config.Database{Name: "Customers", Role: config.RoleCustom}, "Internal-Custom", map[string]interface{}{
+		"10.1.0.0/16": map[string]interface{}{"customer": "acme"},
+		"fd00::/64":   map[string]interface{}{"customer": "initech"},
+	})
+	// 只有 custom 数据库算作本地数据
+	addFake(t, "Test-City", config.RoleCity, map[string]Record{"10.2.0.0/16": {CountryCode: "US"}})
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"::ffff:10.1.2.3": true,
+		"fd00::1":         true,
+		"10.2.0.1":        false,
+		"192.168.0.1":     false,
+	} {
+		if got := HasLocalData(net.ParseIP(ip)); got != want {
+			t.Errorf("HasLocalData(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
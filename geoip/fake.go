@@ -0,0 +1,83 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"ip-api/config"
+	"ip-api/iputil"
+)
+
+func init() {
+	RegisterProvider(config.ProviderFake, func(cfg config.Database, path string) (Provider, error) {
+		return &FakeProvider{Path: path}, nil
+	})
+}
+
+// FakeEntry 是 FakeProvider 中一个网络的固定记录
+type FakeEntry struct {
+	Network string `json:"network"`
+	Record  Record `json:"record"`
+}
+
+// FakeProvider 返回固定记录的数据源，用于测试。记录可以直接设置，
+// 也可以在 Open 时从 JSON 文件加载：[{"network": "192.0.2.0/24", "record": {"country_code": "JP"}}]
+type FakeProvider struct {
+	Path    string
+	Entries []FakeEntry
+
+	prefixes []netip.Prefix
+}
+
+func (p *FakeProvider) Open() error {
+	if p.Path != "" {
+		data, err := os.ReadFile(p.Path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &p.Entries); err != nil {
+			return fmt.Errorf("decode %s: %w", p.Path, err)
+		}
+	}
+
+	p.prefixes = make([]netip.Prefix, len(p.Entries))
+	for i, e := range p.Entries {
+		prefix, err := netip.ParsePrefix(e.Network)
+		if err != nil {
+			return err
+		}
+		p.prefixes[i] = prefix.Masked()
+	}
+	return nil
+}
+
+func (p *FakeProvider) Close() error {
+	return nil
+}
+
+// Lookup 返回包含地址的最具体网络的记录
+func (p *FakeProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Record{}, nil, false, fmt.Errorf("invalid IP address %v", ip)
+	}
+	addr = addr.Unmap()
+
+	best := -1
+	for i, prefix := range p.prefixes {
+		if prefix.Contains(addr) && (best < 0 || prefix.Bits() > p.prefixes[best].Bits()) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Record{}, nil, false, nil
+	}
+	return p.Entries[best].Record, iputil.IPNetFromPrefix(p.prefixes[best]), true, nil
+}
+
+func (p *FakeProvider) Metadata() Metadata {
+	return Metadata{Kind: config.ProviderFake, DatabaseType: "fake"}
+}
@@ -0,0 +1,65 @@
+package geoip
+
+import (
+	"log"
+	"net"
+
+	"ip-api/config"
+)
+
+func init() {
+	RegisterProvider(config.ProviderGeoCN, newGeoCNProvider)
+}
+
+// GeoCNResult 表示 GeoCN 数据库响应的结构
+type GeoCNResult struct {
+	City          string `maxminddb:"city"`
+	CityCode      uint   `maxminddb:"cityCode"`
+	Districts     string `maxminddb:"districts"`
+	DistrictsCode uint   `maxminddb:"districtsCode"`
+	ISP           string `maxminddb:"isp"`
+	Net           string `maxminddb:"net"`
+	Province      string `maxminddb:"province"`
+	ProvinceCode  uint   `maxminddb:"provinceCode"`
+}
+
+// geocnProvider 读取 GeoCN 格式的中国地区数据库
+type geocnProvider struct{ mmdbProvider }
+
+func newGeoCNProvider(cfg config.Database, path string) (Provider, error) {
+	return &geocnProvider{mmdbProvider{cfg: cfg, path: path, kind: config.ProviderGeoCN}}, nil
+}
+
+func (p *geocnProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	var result GeoCNResult
+	network, found, err := p.reader.LookupNetwork(ip, &result)
+	if err != nil || !found {
+		return Record{}, network, found, err
+	}
+
+	log.Printf("GeoCN raw query result for %s: City=%s, Province=%s, Districts=%s, ISP=%s",
+		ip.String(), result.City, result.Province, result.Districts, result.ISP)
+	return convertGeoCN(result), network, true, nil
+}
+
+// convertGeoCN 将 GeoCN 结果转换为归一化记录，GeoCN 只包含中国地址，名称均为中文
+func convertGeoCN(cn GeoCNResult) Record {
+	rec := Record{
+		CountryCode:  "CN",
+		CountryNames: Names{"en": "China", "zh-CN": "中国"},
+		District:     cn.Districts,
+		ProvinceCode: cn.ProvinceCode,
+		CityCode:     cn.CityCode,
+		DistrictCode: cn.DistrictsCode,
+		ISP:          cn.ISP,
+		// 中国默认时区
+		TimeZone: "Asia/Shanghai",
+	}
+	if cn.Province != "" {
+		rec.RegionNames = Names{"zh-CN": cn.Province}
+	}
+	if cn.City != "" {
+		rec.CityNames = Names{"zh-CN": cn.City}
+	}
+	return rec
+}
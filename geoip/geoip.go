@@ -2,51 +2,90 @@ package geoip
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/netip"
+	"strings"
 	"sync"
 
+	"ip-api/config"
+	"ip-api/iputil"
+	"ip-api/overrides"
+
 	"github.com/oschwald/geoip2-golang"
 	"github.com/oschwald/maxminddb-golang"
 )
 
+// database 是注册表中一个已打开的数据库
+type database struct {
+	cfg      config.Database
+	provider Provider
+}
+
 var (
-	cityDB  *geoip2.Reader
-	asnDB   *geoip2.Reader
-	cnRawDB *maxminddb.Reader // Raw maxminddb reader for GeoCN
-	dbMux   sync.RWMutex
+	dbs   []*database // 按注册表顺序排列的已打开数据库
+	dbMux sync.RWMutex
 )
 
-// OpenDBs 打开 GeoLite2 City、ASN 和 CN 数据库
-func OpenDBs(cityDBPath, asnDBPath, cnDBPath string) error {
+// OpenDBs 打开注册表中声明的所有数据库。
+// 一个数据库失败不影响其余数据库的打开；必需的数据库打开失败时返回所有这些错误，可选的数据库只记录日志。
+func OpenDBs(list []config.Database) error {
 	dbMux.Lock()
 	defer dbMux.Unlock()
-	return openDBs(cityDBPath, asnDBPath, cnDBPath)
+	return openDBs(list)
 }
 
-func openDBs(cityDBPath, asnDBPath, cnDBPath string) error {
-	var err error
-	cityDB, err = geoip2.Open(cityDBPath)
-	if err != nil {
-		log.Printf("Error opening GeoLite2-City database: %v", err)
-		return err
+// openDBs 依次打开 list 中的每个数据库，合并必需数据库的错误，调用者必须持有写锁
+func openDBs(list []config.Database) error {
+	var errs []error
+	for _, db := range list {
+		if err := openDB(db); err != nil && db.Required {
+			errs = append(errs, fmt.Errorf("%s: %w", db.Name, err))
+		}
 	}
-	log.Println("GeoLite2-City database opened successfully.")
+	return errors.Join(errs...)
+}
 
-	asnDB, err = geoip2.Open(asnDBPath)
+// openDB 打开单个数据库并替换同名的旧数据源，调用者必须持有写锁
+func openDB(db config.Database) error {
+	provider, err := newProvider(db, db.Path())
 	if err != nil {
-		log.Printf("Error opening GeoLite2-ASN database: %v", err)
+		log.Printf("Error opening %s database: %v", db.Name, err)
 		return err
 	}
-	log.Println("GeoLite2-ASN database opened successfully.")
+	return addProvider(db, provider)
+}
 
-	cnRawDB, err = maxminddb.Open(cnDBPath)
-	if err != nil {
-		log.Printf("Error opening GeoCN raw database: %v", err)
-	} else {
-		log.Println("GeoCN raw database opened successfully.")
+// AddProvider 打开一个已创建的数据源并以 db 的名称和角色加入注册表，
+// 用于测试或嵌入时使用注册表之外的数据源
+func AddProvider(db config.Database, provider Provider) error {
+	dbMux.Lock()
+	defer dbMux.Unlock()
+	return addProvider(db, provider)
+}
+
+func addProvider(db config.Database, provider Provider) error {
+	if err := provider.Open(); err != nil {
+		log.Printf("Error opening %s database: %v", db.Name, err)
+		return err
+	}
+	if db.Role == config.RoleASN {
+		if _, ok := provider.(mmdbSource); ok {
+			scheduleASNIndex(db)
+		}
 	}
 
+	for i, existing := range dbs {
+		if existing.cfg.Name == db.Name {
+			existing.provider.Close()
+			dbs[i] = &database{cfg: db, provider: provider}
+			log.Printf("%s database reopened successfully.", db.Name)
+			return nil
+		}
+	}
+	dbs = append(dbs, &database{cfg: db, provider: provider})
+	log.Printf("%s database opened successfully.", db.Name)
 	return nil
 }
 
@@ -58,39 +97,31 @@ func CloseDBs() {
 }
 
 func closeDBs() {
-	if cityDB != nil {
-		cityDB.Close()
-		log.Println("GeoLite2-City database closed.")
-	}
-	if asnDB != nil {
-		asnDB.Close()
-		log.Println("GeoLite2-ASN database closed.")
+	for _, db := range dbs {
+		db.provider.Close()
+		log.Printf("%s database closed.", db.cfg.Name)
 	}
+	dbs = nil
+}
 
-	if cnRawDB != nil {
-		cnRawDB.Close()
-		log.Println("GeoCN raw database closed.")
-	}
+// ReloadDB 重新打开单个数据库以获取文件更改。
+// 新文件打开失败时保留旧的读取器继续服务。
+func ReloadDB(db config.Database) error {
+	dbMux.Lock()
+	defer dbMux.Unlock()
+
+	log.Printf("Reloading %s database...", db.Name)
+	return openDB(db)
 }
 
-// ReloadDBs 关闭并重新打开数据库以原子性地获取文件更改
-func ReloadDBs(cityDBPath, asnDBPath, cnDBPath string) error {
+// ReloadDBs 重新打开注册表中的所有数据库，打开失败的数据库继续使用旧的读取器
+func ReloadDBs(list []config.Database) error {
 	dbMux.Lock()
 	defer dbMux.Unlock()
 
 	log.Println("Reloading databases...")
 
-	// 首先关闭现有数据库
-	if cityDB != nil {
-		cityDB.Close()
-	}
-	if asnDB != nil {
-		asnDB.Close()
-	}
-
-	// 重新打开数据库
-	err := openDBs(cityDBPath, asnDBPath, cnDBPath)
-	if err != nil {
+	if err := openDBs(list); err != nil {
 		return err
 	}
 
@@ -98,210 +129,201 @@ func ReloadDBs(cityDBPath, asnDBPath, cnDBPath string) error {
 	return nil
 }
 
-// Lookup 对给定的 IP 地址执行查找
-// 返回城市数据、ASN 数据、GeoCN 数据（如果可用）和错误
-func Lookup(ip net.IP) (*geoip2.City, *geoip2.ASN, *GeoCNResult, error) {
+// openReaderFor 单独打开第一个已加载且具有给定角色（按参数顺序）的 MMDB 数据库，调用者负责关闭。
+// 其他格式的数据源（例如 IP2Location）不能按网络遍历，会被跳过。
+// 长时间的遍历使用单独的读取器，不在持有读锁时进行，不会阻塞热加载。没有这样的数据库时返回 nil。
+func openReaderFor(roles ...string) (*maxminddb.Reader, error) {
+	var path string
 	dbMux.RLock()
-	defer dbMux.RUnlock()
-
-	var city *geoip2.City
-	var cnResult *GeoCNResult
-	var err error
-
-	// 默认使用 GeoLite2-City
-	if cityDB != nil {
-		city, err = cityDB.City(ip)
-		if err != nil {
-			// 记录错误但不立即返回，ASN 查找可能仍然有效
-			log.Printf("GeoLite2-City lookup for %s failed: %v", ip, err)
+search:
+	for _, role := range roles {
+		for _, db := range dbs {
+			if _, ok := db.provider.(mmdbSource); ok && db.cfg.Role == role {
+				path = db.cfg.Path()
+				break search
+			}
 		}
 	}
+	dbMux.RUnlock()
 
-	// 对于中国 IP，如果国家是 CN 或城市数据为空，则检查 GeoCN 数据库
-	log.Printf("Checking GeoCN for IP %s: cnRawDB=%v, city=%v, country=%s", ip, cnRawDB != nil, city != nil, func() string {
-		if city != nil {
-			return city.Country.IsoCode
-		}
-		return "unknown"
-	}())
-
-	if cnRawDB != nil && (city == nil || city.Country.IsoCode == "CN") {
-		log.Printf("Attempting GeoCN lookup for IP: %s", ip)
-		cnResult, cnErr := queryGeoCNDatabase(ip)
-		if cnErr == nil && cnResult != nil {
-			log.Printf("GeoCN lookup successful for IP: %s, data: %+v", ip, cnResult)
-			if city == nil {
-				city = convertGeoCNToGeoLite2(cnResult)
-				log.Printf("Using GeoCN as primary data source for %s", ip)
-			} else {
-				mergeGeoCNData(city, cnResult)
-				log.Printf("Merged GeoCN data with GeoLite2 for %s", ip)
-			}
-		} else {
-			log.Printf("GeoCN lookup failed for %s: %v", ip, cnErr)
-			cnResult = nil // Ensure cnResult is nil on failure
-		}
-	} else {
-		log.Printf("Skipping GeoCN lookup for IP %s: cnRawDB=%v", ip, cnRawDB != nil)
-		cnResult = nil
+	if path == "" {
+		return nil, nil
 	}
+	return maxminddb.Open(path)
+}
 
-	// 如果 GeoCN 没有提供数据，则回退到 GeoLite2
-	if city != nil && city.Country.IsoCode == "CN" {
-		log.Printf("Chinese IP %s processed, checking data completeness", ip)
-		if len(city.City.Names) == 0 {
-			log.Printf("No city data available for Chinese IP %s", ip)
-		}
-		if len(city.Subdivisions) == 0 {
-			log.Printf("No subdivision data available for Chinese IP %s", ip)
+// DBStatus 描述注册表中一个数据库的加载状态
+type DBStatus struct {
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Required     bool   `json:"required"`
+	Loaded       bool   `json:"loaded"`
+	Provider     string `json:"provider,omitempty"`
+	DatabaseType string `json:"database_type,omitempty"`
+	BuildEpoch   uint   `json:"build_epoch,omitempty"`
+}
+
+// Status 返回注册表中每个数据库的加载状态
+func Status() []DBStatus {
+	dbMux.RLock()
+	defer dbMux.RUnlock()
+
+	var statuses []DBStatus
+	for _, cfg := range config.App.Databases {
+		status := DBStatus{Name: cfg.Name, Role: cfg.Role, Required: cfg.Required}
+		for _, db := range dbs {
+			if db.cfg.Name == cfg.Name {
+				meta := db.provider.Metadata()
+				status.Loaded = true
+				status.Provider = meta.Kind
+				status.DatabaseType = meta.DatabaseType
+				status.BuildEpoch = meta.BuildEpoch
+			}
 		}
+		statuses = append(statuses, status)
 	}
+	return statuses
+}
 
-	var asn *geoip2.ASN
-	if asnDB != nil {
-		asn, _ = asnDB.ASN(ip) // Ignore error for ASN, as it's less critical
+// Ready 在所有必需的数据库都已加载时返回 nil
+func Ready() error {
+	var missing []string
+	for _, status := range Status() {
+		if status.Required && !status.Loaded {
+			missing = append(missing, status.Name)
+		}
 	}
-
-	if cityDB == nil && asnDB == nil {
-		return nil, nil, nil, errors.New("no GeoIP databases are available")
+	if len(missing) > 0 {
+		return fmt.Errorf("required databases not loaded: %s", strings.Join(missing, ", "))
 	}
-
-	return city, asn, cnResult, nil // Return city, ASN, GeoCN data, and error
+	return nil
 }
 
-// GeoCNResult 表示 GeoCN 数据库响应的结构
-type GeoCNResult struct {
-	City          string `maxminddb:"city"`
-	CityCode      uint   `maxminddb:"cityCode"`
-	Districts     string `maxminddb:"districts"`
-	DistrictsCode uint   `maxminddb:"districtsCode"`
-	ISP           string `maxminddb:"isp"`
-	Net           string `maxminddb:"net"`
-	Province      string `maxminddb:"province"`
-	ProvinceCode  uint   `maxminddb:"provinceCode"`
+// ErrNotFound 表示没有任何已加载的数据库包含该地址
+var ErrNotFound = errors.New("address is not in the database")
+
+// Result 是一次查询从所有已加载数据源得到的结果
+type Result struct {
+	// Record 是按合并策略合并后的记录
+	Record Record
+	// Attribution 记录合并后的每个字段来自哪个数据库
+	Attribution Attribution
+	// Network 是包含该地址的网络，优先取自城市数据库，其次是 ASN 数据库
+	Network *net.IPNet
+	// Sources 记录每个被查询的数据库是否包含该地址
+	Sources []SourceStatus
+	// Answers 是每个找到该地址的数据源给出的原始记录
+	Answers []Answer
+	// Tags 是覆盖记录的自定义标签
+	Tags map[string]string
+	// Custom 是 custom 角色的数据库中的原始记录，键是数据库名称
+	Custom map[string]interface{}
 }
 
-// queryGeoCNDatabase 使用 maxminddb 直接查询 GeoCN 数据库
-func queryGeoCNDatabase(ip net.IP) (*GeoCNResult, error) {
-	if cnRawDB == nil {
-		return nil, errors.New("GeoCN raw database not available")
-	}
-
-	var result GeoCNResult
-	err := cnRawDB.Lookup(ip, &result)
-	if err != nil {
-		return nil, err
-	}
+// SourceStatus 是一个数据库的查询结果
+type SourceStatus struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Found   bool   `json:"found"`
+	Network string `json:"network,omitempty"`
+}
 
-	// 检查是否获得有效数据（对于中国 IP，至少应该有省份信息）
-	if result.Province == "" {
-		return nil, errors.New("no GeoCN data found for IP")
-	}
+// Lookup 在所有已加载的数据源中查询地址，并按合并策略合并结果，然后应用覆盖记录。
+// custom 角色的数据库不参与合并，记录写入 Result.Custom。
+// 每个数据库是否包含该地址记录在 Result.Sources 中，所有数据库和覆盖记录都不包含时返回 ErrNotFound。
+func Lookup(ip net.IP) (*Result, error) {
+	dbMux.RLock()
+	defer dbMux.RUnlock()
 
-	log.Printf("GeoCN raw query result for %s: City=%s, Province=%s, Districts=%s, ISP=%s",
-		ip.String(), result.City, result.Province, result.Districts, result.ISP)
-	return &result, nil
+	res, _, err := lookup(ip)
+	return res, err
 }
 
-// convertGeoCNToGeoLite2 将 GeoCN 结果转换为 GeoLite2 City 格式
-func convertGeoCNToGeoLite2(cnResult *GeoCNResult) *geoip2.City {
-	city := &geoip2.City{}
-
-	// 设置国家信息
-	city.Country.IsoCode = "CN"
-	city.Country.Names = map[string]string{
-		"en":    "China",
-		"zh-CN": "中国",
+// lookup 是 Lookup 的实现，调用者必须持有读锁。span 是包含该地址的一个网络，
+// 网络中所有地址在每个数据源和覆盖记录中的结果都相同，用于按网络遍历地址空间。
+func lookup(ip net.IP) (*Result, netip.Prefix, error) {
+	if len(dbs) == 0 {
+		return nil, netip.Prefix{}, errors.New("no GeoIP databases are available")
 	}
-
-	// 设置城市信息 - 直接使用 GeoCN 城市字段
-	if cnResult.City != "" {
-		city.City.Names = map[string]string{
-			"zh-CN": cnResult.City,
-			"en":    cnResult.City, // Use Chinese name as fallback
-		}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil, netip.Prefix{}, ErrNotFound
 	}
+	addr = addr.Unmap()
 
-	// 设置行政区划（省份）信息 - 使用匿名结构体类型
-	if cnResult.Province != "" {
-		city.Subdivisions = []struct {
-			Names     map[string]string `maxminddb:"names"`
-			IsoCode   string            `maxminddb:"iso_code"`
-			GeoNameID uint              `maxminddb:"geoname_id"`
-		}{
-			{
-				Names: map[string]string{
-					"zh-CN": cnResult.Province,
-				},
-				IsoCode:   "",
-				GeoNameID: 0,
-			},
+	span := netip.PrefixFrom(addr, 0)
+	narrow := func(network *net.IPNet) {
+		if network == nil {
+			return
 		}
-	}
-
-	// 设置位置时区（中国默认时区）
-	city.Location.TimeZone = "Asia/Shanghai"
-
-	log.Printf("Converted GeoCN data: City=%s, Province=%s, Districts=%s, ISP=%s",
-		cnResult.City, cnResult.Province, cnResult.Districts, cnResult.ISP)
-	return city
-}
-
-// mergeGeoCNData 将 GeoCN 数据合并到现有的 GeoLite2 城市数据中
-func mergeGeoCNData(city *geoip2.City, cnResult *GeoCNResult) {
-	// 如果可用，优先使用 GeoCN 数据 - 直接映射城市字段
-	if cnResult.City != "" {
-		if city.City.Names == nil {
-			city.City.Names = make(map[string]string)
+		if p := iputil.PrefixFromIPNet(network); p.Contains(addr) && p.Bits() > span.Bits() {
+			span = p
 		}
-		city.City.Names["zh-CN"] = cnResult.City
-		// 为保持一致性，也将英文名称设置为中文
-		city.City.Names["en"] = cnResult.City
-		log.Printf("Updated city from GeoCN: %s", cnResult.City)
 	}
 
-	// 将 GeoCN 省份映射到 GeoLite2 行政区划（地区）- 使用匿名结构体类型
-	if cnResult.Province != "" {
-		if len(city.Subdivisions) == 0 {
-			city.Subdivisions = []struct {
-				Names     map[string]string `maxminddb:"names"`
-				IsoCode   string            `maxminddb:"iso_code"`
-				GeoNameID uint              `maxminddb:"geoname_id"`
-			}{
-				{
-					Names:     make(map[string]string),
-					IsoCode:   "",
-					GeoNameID: 0,
-				},
-			}
+	res := &Result{}
+	for _, db := range dbs {
+		if addr.Is6() && ipv4Only(db.provider) {
+			continue
 		}
-		if city.Subdivisions[0].Names == nil {
-			city.Subdivisions[0].Names = make(map[string]string)
+		if db.cfg.Role == config.RoleCustom {
+			narrow(annotate(res, db, ip))
+			continue
 		}
-		// 将省份映射到地区
-		city.Subdivisions[0].Names["zh-CN"] = cnResult.Province
-		city.Subdivisions[0].Names["en"] = cnResult.Province
-		log.Printf("Updated province/region from GeoCN: %s", cnResult.Province)
-	}
+		rec, network, found, err := db.provider.Lookup(ip)
+		if err != nil {
+			// 记录错误但不立即返回，其他数据源可能仍然有效
+			log.Printf("%s lookup for %s failed: %v", db.cfg.Name, ip, err)
+			continue
+		}
+		narrow(network)
 
-	// 确保国家设置为中国
-	if city.Country.IsoCode != "CN" {
-		city.Country.IsoCode = "CN"
-		city.Country.Names = map[string]string{"en": "China", "zh-CN": "中国"}
+		status := SourceStatus{Name: db.cfg.Name, Role: db.cfg.Role, Found: found}
+		if found {
+			status.Network = network.String()
+			res.Answers = append(res.Answers, Answer{Source: db.cfg.Name, Role: db.cfg.Role, Network: network, Record: rec})
+		}
+		res.Sources = append(res.Sources, status)
 	}
 
-	// 确保为中国 IP 设置时区
-	if city.Location.TimeZone == "" {
-		city.Location.TimeZone = "Asia/Shanghai"
+	entry, overrideSpan, overridden := overrides.Lookup(ip)
+	if overrideSpan.Bits() > span.Bits() {
+		span = overrideSpan
 	}
-
-	// 记录额外的 GeoCN 数据用于调试
-	if cnResult.Districts != "" {
-		log.Printf("GeoCN Districts: %s", cnResult.Districts)
+	if len(res.Answers) == 0 && !overridden && len(res.Custom) == 0 {
+		return nil, span, ErrNotFound
+	}
+	res.Record, res.Attribution = policy.Merge(res.Answers)
+	if a := firstAnswer(res.Answers, config.RoleEnterprise, config.RoleCity, config.RoleASN); a != nil {
+		res.Network = a.Network
+	} else if len(res.Answers) > 0 {
+		res.Network = res.Answers[0].Network
+	}
+	if overridden {
+		applyOverride(res, entry, overrideSpan)
 	}
-	if cnResult.ISP != "" {
-		log.Printf("GeoCN ISP: %s", cnResult.ISP)
+	return res, span, nil
+}
+
+// ipv4Only 检查数据源是否是只包含 IPv4 数据的 MMDB，查询 IPv6 地址会返回错误
+func ipv4Only(p Provider) bool {
+	source, ok := p.(mmdbSource)
+	return ok && source.Reader().Metadata.IPVersion == 4
+}
+
+// LocationMissing 检查是否查询了位置数据库（City、Enterprise 或 GeoCN）但都不包含该地址
+func (res *Result) LocationMissing() bool {
+	queried := false
+	for _, s := range res.Sources {
+		switch s.Role {
+		case config.RoleCity, config.RoleEnterprise, config.RoleCN:
+			if s.Found {
+				return false
+			}
+			queried = true
+		}
 	}
+	return queried
 }
 
 // OpenTestDB 用于测试MMDB文件是否有效，返回一个可以关闭的数据库连接
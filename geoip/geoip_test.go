@@ -0,0 +1,89 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ip-api/config"
+	"ip-api/mmdbwriter"
+)
+
+// useDataDir 把数据目录切换到临时目录，测试结束后等待后台的 ASN 索引构建、关闭打开的数据库并恢复配置
+func useDataDir(t *testing.T) {
+	t.Helper()
+	old := config.App
+	config.App.DataDir = t.TempDir()
+	t.Cleanup(func() {
+		waitASNIndex()
+		CloseDBs()
+		config.App = old
+	})
+}
+
+// writeMMDB 写入包含给定网络和记录的 MMDB 文件
+func writeMMDB(t *testing.T, path, databaseType string, records map[string]interface{}) {
+	t.Helper()
+	w := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, BuildEpoch: time.Unix(1700000000, 0)})
+	for network, rec := range records {
+		if err := w.Insert(netip.MustParsePrefix(network), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openTestDB 在数据目录中写入 MMDB 文件并打开为 db
+func openTestDB(t *testing.T, db config.Database, databaseType string, records map[string]interface{}) {
+	t.Helper()
+	writeMMDB(t, db.Path(), databaseType, records)
+	if err := OpenDBs([]config.Database{db}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// country 返回 GeoLite2-City 结构中只有国家代码的记录
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+func TestOpenDBsOpensEveryDatabase(t *testing.T) {
+	useDataDir(t)
+	city := config.Database{Name: "Test-City", Role: config.RoleCity, Required: true}
+	asn := config.Database{Name: "Test-ASN", Role: config.RoleASN, Required: true}
+	cn := config.Database{Name: "Test-CN", Role: config.RoleCN, Required: true}
+	optional := config.Database{Name: "Test-Optional", Role: config.RoleCity}
+	list := []config.Database{asn, city, optional, cn}
+	config.App.Databases = list
+	writeMMDB(t, city.Path(), "GeoLite2-City", map[string]interface{}{"192.0.2.0/24": country("US")})
+
+	// 前面的必需数据库失败不影响后面的数据库打开，错误包含每个失败的必需数据库
+	for name, open := range map[string]func([]config.Database) error{"OpenDBs": OpenDBs, "ReloadDBs": ReloadDBs} {
+		err := open(list)
+		if err == nil {
+			t.Fatalf("%s succeeded with missing required databases", name)
+		}
+		for _, want := range []string{"Test-ASN", "Test-CN"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s error %q does not mention %s", name, err, want)
+			}
+		}
+		if strings.Contains(err.Error(), "Test-Optional") {
+			t.Errorf("%s error %q mentions the optional database", name, err)
+		}
+		for _, status := range Status() {
+			if status.Loaded != (status.Name == "Test-City") {
+				t.Errorf("%s: %s loaded = %v", name, status.Name, status.Loaded)
+			}
+		}
+	}
+}
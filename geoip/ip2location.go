@@ -0,0 +1,114 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"ip-api/config"
+	"ip-api/ip2location"
+)
+
+func init() {
+	RegisterProvider(config.ProviderIP2Location, func(cfg config.Database, path string) (Provider, error) {
+		return &ip2locationProvider{path: path}, nil
+	})
+}
+
+// ip2locationProvider 读取 IP2Location BIN 文件
+type ip2locationProvider struct {
+	path string
+	db   *ip2location.DB
+}
+
+func (p *ip2locationProvider) Open() error {
+	db, err := ip2location.Open(p.path)
+	if err != nil {
+		return err
+	}
+	p.db = db
+	return nil
+}
+
+func (p *ip2locationProvider) Close() error {
+	return p.db.Close()
+}
+
+// Metadata 返回数据库类型（例如 IP2Location-DB11）和发布日期作为构建时间
+func (p *ip2locationProvider) Metadata() Metadata {
+	meta := p.db.Meta()
+	return Metadata{
+		Kind:         config.ProviderIP2Location,
+		DatabaseType: fmt.Sprintf("IP2Location-DB%d", meta.Type),
+		BuildEpoch:   uint(meta.Date.Unix()),
+	}
+}
+
+func (p *ip2locationProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Record{}, nil, false, fmt.Errorf("invalid IP address %v", ip)
+	}
+	row, rng, found, err := p.db.Lookup(addr)
+	if err != nil || !found {
+		return Record{}, nil, false, err
+	}
+
+	// 未分配和保留的地址段国家为 "-"，视为不在数据库中，与 MMDB 一样返回该地址段的网络
+	if value(row.CountryShort) == "" {
+		return Record{}, rangeNetwork(rng, addr), false, nil
+	}
+	return p.convert(row), rangeNetwork(rng, addr), true, nil
+}
+
+// convert 将 BIN 文件的一行转换为归一化记录，IP2Location 的名称都是英文
+func (p *ip2locationProvider) convert(row ip2location.Record) Record {
+	rec := Record{
+		CountryCode:        value(row.CountryShort),
+		District:           value(row.District),
+		Postal:             value(row.ZipCode),
+		UTCOffset:          value(row.TimeZone),
+		Elevation:          float64(row.Elevation),
+		IDDCode:            value(row.IDDCode),
+		AreaCode:           value(row.AreaCode),
+		WeatherStationCode: value(row.WeatherStationCode),
+		WeatherStationName: value(row.WeatherStationName),
+		ISP:                value(row.ISP),
+		Domain:             value(row.Domain),
+		MobileCountryCode:  value(row.MCC),
+		MobileNetworkCode:  value(row.MNC),
+		MobileBrand:        value(row.MobileBrand),
+		NetSpeed:           value(row.NetSpeed),
+		UsageType:          value(row.UsageType),
+		AddressType:        value(row.AddressType),
+		Category:           value(row.Category),
+		ASOrganization:     value(row.AS),
+	}
+	if name := value(row.CountryLong); name != "" {
+		rec.CountryNames = Names{"en": name}
+	}
+	if name := value(row.Region); name != "" {
+		rec.RegionNames = Names{"en": name}
+	}
+	if name := value(row.City); name != "" {
+		rec.CityNames = Names{"en": name}
+	}
+	if p.db.Has(ip2location.Latitude) && (row.Latitude != 0 || row.Longitude != 0) {
+		rec.Location = &Location{Latitude: float64(row.Latitude), Longitude: float64(row.Longitude)}
+	}
+	if n, err := strconv.ParseUint(value(row.ASN), 10, 32); err == nil {
+		rec.ASN = uint(n)
+	}
+	return rec
+}
+
+// value 将 IP2Location 表示未知的 "-" 转换为空字符串
+func value(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"ip-api/config"
+	"ip-api/ip2region"
+)
+
+func init() {
+	RegisterProvider(config.ProviderIP2Region, func(cfg config.Database, path string) (Provider, error) {
+		return &ip2regionProvider{cfg: cfg, path: path}, nil
+	})
+}
+
+// ip2regionProvider 读取 ip2region xdb 文件，与 GeoCN 一样只提供中国地址的省份、城市和 ISP。
+// 作为 cn 角色和 GeoCN 一起加载时，GeoCN 缺少的字段由它补充。
+type ip2regionProvider struct {
+	cfg      config.Database
+	path     string
+	searcher *ip2region.Searcher
+}
+
+func (p *ip2regionProvider) Open() error {
+	policy := ip2region.VectorIndex
+	if p.cfg.Cache == config.CacheContent {
+		policy = ip2region.Content
+	}
+	searcher, err := ip2region.Open(p.path, policy)
+	if err != nil {
+		return err
+	}
+	p.searcher = searcher
+	return nil
+}
+
+func (p *ip2regionProvider) Close() error {
+	return p.searcher.Close()
+}
+
+// Metadata 返回结构版本作为数据库类型，生成时间作为构建时间
+func (p *ip2regionProvider) Metadata() Metadata {
+	h := p.searcher.Header()
+	return Metadata{
+		Kind:         config.ProviderIP2Region,
+		DatabaseType: fmt.Sprintf("ip2region-xdb-v%d", h.Version),
+		BuildEpoch:   uint(h.CreatedAt.Unix()),
+	}
+}
+
+func (p *ip2regionProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Record{}, nil, false, fmt.Errorf("invalid IP address %v", ip)
+	}
+	raw, rng, found, err := p.searcher.Lookup(addr)
+	if err != nil || !found {
+		return Record{}, nil, false, err
+	}
+
+	rec, ok := convertIP2Region(ip2region.ParseRegion(raw))
+	if !ok {
+		// 与 MMDB 一样返回不在数据库中的地址所在段的网络
+		return Record{}, rangeNetwork(rng, addr), false, nil
+	}
+	return rec, rangeNetwork(rng, addr), true, nil
+}
+
+// ip2regionAreas 是 ip2region 中作为省份记录的港澳台地区
+var ip2regionAreas = map[string]string{
+	"香港": "HK",
+	"澳门": "MO",
+	"台湾": "TW",
+}
+
+// convertIP2Region 将 ip2region 的区域转换为归一化记录。ip2region 的国家名称是中文，
+// 无法可靠地映射为国家代码，因此国外地址视为不在数据库中。
+func convertIP2Region(r ip2region.Region) (Record, bool) {
+	if r.Country != "中国" {
+		return Record{}, false
+	}
+
+	// 港澳台地址的国家代码与 GeoLite2 一致，名称由响应构建统一处理
+	if code, ok := ip2regionAreas[r.Province]; ok {
+		return Record{CountryCode: code, ISP: r.ISP}, true
+	}
+
+	rec := Record{
+		CountryCode:  "CN",
+		CountryNames: Names{"en": "China", "zh-CN": "中国"},
+		ISP:          r.ISP,
+		// 中国默认时区
+		TimeZone: "Asia/Shanghai",
+	}
+	if r.Province != "" {
+		rec.RegionNames = Names{"zh-CN": r.Province}
+	}
+	if r.City != "" {
+		rec.CityNames = Names{"zh-CN": r.City}
+	}
+	return rec, true
+}
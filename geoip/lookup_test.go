@@ -0,0 +1,280 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+)
+
+// addFake 用 FakeProvider 注册一个数据源，entries 是网络到记录的映射
+func addFake(t *testing.T, name, role string, entries map[string]Record) {
+	t.Helper()
+	p := &FakeProvider{}
+	for network, rec := range entries {
+		p.Entries = append(p.Entries, FakeEntry{Network: network, Record: rec})
+	}
+	if err := AddProvider(config.Database{Name: name, Role: role}, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	useDataDir(t)
+	addFake(t, "Test-City", config.RoleCity, map[string]Record{
+		"198.51.100.0/24": {CountryCode: "US", CityNames: Names{"en": "Chicago"}},
+		"198.51.100.0/26": {CountryCode: "US", CityNames: Names{"en": "Denver"}},
+	})
+	addFake(t, "Test-ASN", config.RoleASN, map[string]Record{
+		"198.51.0.0/16":  {ASN: 64496, ASOrganization: "Example"},
+		"203.0.113.0/24": {ASN: 64497, ASOrganization: "Other"},
+	})
+
+	tests := []struct {
+		ip              string
+		err             error
+		country, city   string
+		asn             uint
+		network, span   string
+		locationMissing bool
+	}{
+		{"198.51.100.1", nil, "US", "Denver", 64496, "198.51.100.0/26", "198.51.100.0/26", false},
+		{"198.51.100.200", nil, "US", "Chicago", 64496, "198.51.100.0/24", "198.51.100.0/24", false},
+		{"::ffff:198.51.100.200", nil, "US", "Chicago", 64496, "198.51.100.0/24", "198.51.100.0/24", false},
+		{"198.51.1.1", nil, "", "", 64496, "198.51.0.0/16", "198.51.0.0/16", true},
+		{"192.0.2.1", ErrNotFound, "", "", 0, "", "", false},
+	}
+	for _, tt := range tests {
+		dbMux.RLock()
+		res, span, err := lookup(net.ParseIP(tt.ip))
+		dbMux.RUnlock()
+		if !errors.Is(err, tt.err) {
+			t.Errorf("lookup(%s) error = %v, want %v", tt.ip, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		rec := res.Record
+		if rec.CountryCode != tt.country || rec.CityNames["en"] != tt.city || rec.ASN != tt.asn {
+			t.Errorf("lookup(%s) record = %+v", tt.ip, rec)
+		}
+		if res.Network.String() != tt.network || span.String() != tt.span {
+			t.Errorf("lookup(%s) network = %s, span = %s; want %s, %s", tt.ip, res.Network, span, tt.network, tt.span)
+		}
+		if got := res.LocationMissing(); got != tt.locationMissing {
+			t.Errorf("lookup(%s).LocationMissing() = %v, want %v", tt.ip, got, tt.locationMissing)
+		}
+		if len(res.Sources) != 2 || res.Sources[0].Name != "Test-City" || res.Sources[1].Name != "Test-ASN" {
+			t.Errorf("lookup(%s) sources = %+v", tt.ip, res.Sources)
+		}
+	}
+
+	res, err := Lookup(net.ParseIP("198.51.100.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Attribution{config.FieldCountry: "Test-City", config.FieldCity: "Test-City", config.FieldASN: "Test-ASN"}
+	if !reflect.DeepEqual(res.Attribution, want) {
+		t.Errorf("attribution = %v, want %v", res.Attribution, want)
+	}
+}
+
+func TestLocationMissingWithoutLocationSources(t *testing.T) {
+	res := &Result{Sources: []SourceStatus{{Name: "Test-ASN", Role: config.RoleASN, Found: true}}}
+	if res.LocationMissing() {
+		t.Error("LocationMissing is true although no location database was queried")
+	}
+}
+
+func TestProviderConversion(t *testing.T) {
+	useDataDir(t)
+	ip := net.ParseIP("192.0.2.1")
+
+	tests := []struct {
+		db           config.Database
+		databaseType string
+		record       map[string]interface{}
+		want         Record
+	}{
+		{
+			config.Database{Name: "Test-City", Role: config.RoleCity},
+			"GeoLite2-City",
+			map[string]interface{}{
+				"continent":    map[string]interface{}{"code": "NA"},
+				"country":      map[string]interface{}{"iso_code": "US", "names": map[string]interface{}{"en": "United States"}},
+				"subdivisions": []interface{}{map[string]interface{}{"iso_code": "IL", "names": map[string]interface{}{"en": "Illinois"}}},
+				"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Chicago"}},
+				"postal":       map[string]interface{}{"code": "60601"},
+				"location": map[string]interface{}{"latitude": 41.88, "longitude": -87.62,
+					"accuracy_radius": uint16(20), "time_zone": "America/Chicago"},
+			},
+			Record{
+				CountryCode: "US", CountryNames: Names{"en": "United States"}, ContinentCode: "NA",
+				RegionCode: "IL", RegionNames: Names{"en": "Illinois"}, CityNames: Names{"en": "Chicago"},
+				Postal: "60601", TimeZone: "America/Chicago",
+				Location: &Location{Latitude: 41.88, Longitude: -87.62, AccuracyRadius: 20},
+			},
+		},
+		{
+			config.Database{Name: "Test-ASN", Role: config.RoleASN},
+			"GeoLite2-ASN",
+			map[string]interface{}{"autonomous_system_number": uint32(64496), "autonomous_system_organization": "Example"},
+			Record{ASN: 64496, ASOrganization: "Example"},
+		},
+		{
+			config.Database{Name: "Test-ISP", Role: config.RoleISP},
+			"GeoIP2-ISP",
+			map[string]interface{}{"autonomous_system_number": uint32(64496), "autonomous_system_organization": "Example",
+				"isp": "Example ISP", "organization": "Example Org", "mobile_country_code": "310", "mobile_network_code": "004"},
+			Record{ASN: 64496, ASOrganization: "Example", ISP: "Example ISP", Organization: "Example Org",
+				MobileCountryCode: "310", MobileNetworkCode: "004"},
+		},
+		{
+			config.Database{Name: "Test-Connection-Type", Role: config.RoleConnectionType},
+			"GeoIP2-Connection-Type",
+			map[string]interface{}{"connection_type": "Cable/DSL"},
+			Record{ConnectionType: "Cable/DSL"},
+		},
+		{
+			config.Database{Name: "Test-Domain", Role: config.RoleDomain},
+			"GeoIP2-Domain",
+			map[string]interface{}{"domain": "example.com"},
+			Record{Domain: "example.com"},
+		},
+		{
+			config.Database{Name: "Test-Anonymous-IP", Role: config.RoleAnonymousIP},
+			"GeoIP2-Anonymous-IP",
+			map[string]interface{}{"is_anonymous": true, "is_anonymous_vpn": true, "is_tor_exit_node": true},
+			Record{Anonymizer: &Anonymizer{IsAnonymous: true, IsAnonymousVPN: true, IsTorExitNode: true}},
+		},
+		{
+			config.Database{Name: "Test-Enterprise", Role: config.RoleEnterprise},
+			"GeoIP2-Enterprise",
+			map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "DE"},
+				"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Berlin"}},
+				"traits": map[string]interface{}{"autonomous_system_number": uint32(64497), "isp": "Enterprise ISP",
+					"organization": "Enterprise Org", "connection_type": "Corporate", "user_type": "business",
+					"domain": "example.de", "mobile_country_code": "262", "mobile_network_code": "01"},
+			},
+			Record{
+				CountryCode: "DE", CityNames: Names{"en": "Berlin"}, ASN: 64497, ISP: "Enterprise ISP",
+				Organization: "Enterprise Org", ConnectionType: "Corporate", UserType: "business", Domain: "example.de",
+				MobileCountryCode: "262", MobileNetworkCode: "01",
+			},
+		},
+		{
+			config.Database{Name: "Test-CN", Role: config.RoleCN, Provider: config.ProviderGeoCN},
+			"GeoCN",
+			map[string]interface{}{"province": "广东省", "provinceCode": uint32(440000), "city": "深圳市",
+				"cityCode": uint32(440300), "districts": "南山区", "districtsCode": uint32(440305), "isp": "电信"},
+			Record{
+				CountryCode: "CN", CountryNames: Names{"en": "China", "zh-CN": "中国"},
+				RegionNames: Names{"zh-CN": "广东省"}, CityNames: Names{"zh-CN": "深圳市"}, District: "南山区",
+				ProvinceCode: 440000, CityCode: 440300, DistrictCode: 440305, ISP: "电信", TimeZone: "Asia/Shanghai",
+			},
+		},
+		{
+			config.Database{Name: "Test-IPinfo", Role: config.RoleASN, Provider: config.ProviderMMDB, Schema: "ipinfo-lite",
+				Mapping: map[string]string{"as_domain": "domain"}},
+			"ipinfo_lite.mmdb",
+			map[string]interface{}{"continent_code": "NA", "country_code": "US", "country": "United States",
+				"asn": "AS64496", "as_name": "Example", "as_domain": "example.com"},
+			Record{
+				CountryCode: "US", CountryNames: Names{"en": "United States"}, ContinentCode: "NA",
+				ASN: 64496, ASOrganization: "Example", Domain: "example.com",
+			},
+		},
+	}
+	for _, tt := range tests {
+		writeMMDB(t, tt.db.Path(), tt.databaseType, map[string]interface{}{"192.0.2.0/24": tt.record})
+		provider, err := OpenFile(tt.db, tt.db.Path())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.db.Name, err)
+		}
+		rec, network, found, err := provider.Lookup(ip)
+		provider.Close()
+		if err != nil || !found || network.String() != "192.0.2.0/24" {
+			t.Errorf("%s: found = %v, network = %v, err = %v", tt.db.Name, found, network, err)
+			continue
+		}
+		if !reflect.DeepEqual(rec, tt.want) {
+			t.Errorf("%s: record =\n%+v\nwant\n%+v", tt.db.Name, rec, tt.want)
+		}
+	}
+}
+
+func TestLookupEnterpriseAndTraits(t *testing.T) {
+	useDataDir(t)
+	city := config.Database{Name: "Test-City", Role: config.RoleCity}
+	enterprise := config.Database{Name: "Test-Enterprise", Role: config.RoleEnterprise}
+	isp := config.Database{Name: "Test-ISP", Role: config.RoleISP}
+	connection := config.Database{Name: "Test-Connection-Type", Role: config.RoleConnectionType}
+	domain := config.Database{Name: "Test-Domain", Role: config.RoleDomain}
+	anonymous := config.Database{Name: "Test-Anonymous-IP", Role: config.RoleAnonymousIP}
+	config.App.Databases = []config.Database{city, enterprise, isp, connection, domain, anonymous}
+
+	// 198.51.100.0/24 只在 City 和各个特征数据库中
+	openTestDB(t, city, "GeoLite2-City", map[string]interface{}{
+		"192.0.2.0/24":    map[string]interface{}{"country": map[string]interface{}{"iso_code": "FR"}, "city": map[string]interface{}{"names": map[string]interface{}{"en": "Paris"}}},
+		"198.51.100.0/24": map[string]interface{}{"country": map[string]interface{}{"iso_code": "US"}, "city": map[string]interface{}{"names": map[string]interface{}{"en": "Chicago"}}},
+	})
+	openTestDB(t, enterprise, "GeoIP2-Enterprise", map[string]interface{}{
+		"192.0.2.0/24": map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "DE"},
+			"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Berlin"}},
+			"traits":  map[string]interface{}{"isp": "Enterprise ISP", "connection_type": "Corporate", "user_type": "business", "domain": "example.de"},
+		},
+	})
+	both := func(rec map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"192.0.2.0/24": rec, "198.51.100.0/24": rec}
+	}
+	openTestDB(t, isp, "GeoIP2-ISP", both(map[string]interface{}{"autonomous_system_number": uint32(64496), "isp": "Example ISP", "organization": "Example Org"}))
+	openTestDB(t, connection, "GeoIP2-Connection-Type", both(map[string]interface{}{"connection_type": "Cable/DSL"}))
+	openTestDB(t, domain, "GeoIP2-Domain", both(map[string]interface{}{"domain": "example.com"}))
+	openTestDB(t, anonymous, "GeoIP2-Anonymous-IP", both(map[string]interface{}{"is_anonymous": true, "is_public_proxy": true}))
+
+	tests := []struct {
+		ip   string
+		want Record
+		attr Attribution
+	}{
+		{
+			// Enterprise 优先于 City 和各个特征数据库
+			"192.0.2.1",
+			Record{
+				CountryCode: "DE", CityNames: Names{"en": "Berlin"}, ASN: 64496, ISP: "Enterprise ISP", Organization: "Example Org",
+				ConnectionType: "Corporate", UserType: "business", Domain: "example.de",
+				Anonymizer: &Anonymizer{IsAnonymous: true, IsPublicProxy: true},
+			},
+			Attribution{config.FieldCountry: "Test-Enterprise", config.FieldCity: "Test-Enterprise", config.FieldASN: "Test-ISP",
+				config.FieldISP: "Test-Enterprise", config.FieldOrganization: "Test-ISP", config.FieldConnectionType: "Test-Enterprise",
+				config.FieldUserType: "Test-Enterprise", config.FieldDomain: "Test-Enterprise", config.FieldAnonymizer: "Test-Anonymous-IP"},
+		},
+		{
+			"198.51.100.1",
+			Record{
+				CountryCode: "US", CityNames: Names{"en": "Chicago"}, ASN: 64496, ISP: "Example ISP", Organization: "Example Org",
+				ConnectionType: "Cable/DSL", Domain: "example.com", Anonymizer: &Anonymizer{IsAnonymous: true, IsPublicProxy: true},
+			},
+			Attribution{config.FieldCountry: "Test-City", config.FieldCity: "Test-City", config.FieldASN: "Test-ISP",
+				config.FieldISP: "Test-ISP", config.FieldOrganization: "Test-ISP", config.FieldConnectionType: "Test-Connection-Type",
+				config.FieldDomain: "Test-Domain", config.FieldAnonymizer: "Test-Anonymous-IP"},
+		},
+	}
+	for _, tt := range tests {
+		res, err := Lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res.Record, tt.want) {
+			t.Errorf("Lookup(%s) record =\n%+v\nwant\n%+v", tt.ip, res.Record, tt.want)
+		}
+		if !reflect.DeepEqual(res.Attribution, tt.attr) {
+			t.Errorf("Lookup(%s) attribution =\n%v\nwant\n%v", tt.ip, res.Attribution, tt.attr)
+		}
+	}
+}
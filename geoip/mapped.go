@@ -0,0 +1,209 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ip-api/config"
+)
+
+func init() {
+	RegisterProvider(config.ProviderMMDB, newMappedProvider)
+}
+
+// mappedProvider 按字段映射读取任意结构的 MMDB，新的 MMDB 供应商只需要配置映射
+type mappedProvider struct {
+	mmdbProvider
+	fields []mappedField
+}
+
+// mappedField 是一个记录字段和它在 MMDB 中的路径
+type mappedField struct {
+	field string
+	lang  string // 名称字段只写入的语言，为空时接受语言映射
+	path  []string
+}
+
+func newMappedProvider(cfg config.Database, path string) (Provider, error) {
+	p := &mappedProvider{mmdbProvider: mmdbProvider{cfg: cfg, path: path, kind: config.ProviderMMDB}}
+	for field, mmdbPath := range cfg.FieldMapping() {
+		f := mappedField{field: field, path: strings.Split(mmdbPath, ".")}
+		f.field, f.lang, _ = strings.Cut(field, ".")
+		if _, ok := mappedSetters[f.field]; !ok && !isNamesField(f.field) {
+			return nil, fmt.Errorf("database %s: unknown mapping field %q", cfg.Name, field)
+		}
+		p.fields = append(p.fields, f)
+	}
+	// 按字段名称排序使结果确定，只写入一种语言的名称排在完整的语言映射之后
+	sort.Slice(p.fields, func(i, j int) bool {
+		if p.fields[i].field != p.fields[j].field {
+			return p.fields[i].field < p.fields[j].field
+		}
+		return p.fields[i].lang < p.fields[j].lang
+	})
+	return p, nil
+}
+
+func (p *mappedProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	var record interface{}
+	network, found, err := p.reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return Record{}, network, found, err
+	}
+
+	var rec Record
+	for _, f := range p.fields {
+		v, ok := valueAt(record, f.path)
+		if !ok {
+			continue
+		}
+		if isNamesField(f.field) {
+			setNames(&rec, f.field, f.lang, v)
+		} else {
+			mappedSetters[f.field](&rec, v)
+		}
+	}
+	if loc := rec.Location; loc != nil && loc.Latitude == 0 && loc.Longitude == 0 {
+		rec.Location = nil
+	}
+	return rec, network, true, nil
+}
+
+// valueAt 按路径取出解码后的 MMDB 记录中的值，数字路径段用于数组下标
+func valueAt(v interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+// isNamesField 检查记录字段是否是按语言区分的名称
+func isNamesField(field string) bool {
+	return field == "country_names" || field == "region_names" || field == "city_names"
+}
+
+// setNames 写入名称字段。语言映射按语言合并，单个字符串写入 lang 指定的语言，默认为英文。
+func setNames(rec *Record, field, lang string, v interface{}) {
+	target := map[string]*Names{
+		"country_names": &rec.CountryNames,
+		"region_names":  &rec.RegionNames,
+		"city_names":    &rec.CityNames,
+	}[field]
+
+	add := func(lang, name string) {
+		if name == "" {
+			return
+		}
+		if *target == nil {
+			*target = Names{}
+		}
+		(*target)[lang] = name
+	}
+	switch names := v.(type) {
+	case map[string]interface{}:
+		for l, name := range names {
+			if lang == "" || l == lang {
+				add(l, toString(name))
+			}
+		}
+	default:
+		if lang == "" {
+			lang = "en"
+		}
+		add(lang, toString(v))
+	}
+}
+
+// mappedSetters 将 MMDB 中的值转换后写入记录字段，键与 config.MappingTargets 一致
+var mappedSetters = map[string]func(rec *Record, v interface{}){
+	"country_code":        func(rec *Record, v interface{}) { rec.CountryCode = toString(v) },
+	"continent_code":      func(rec *Record, v interface{}) { rec.ContinentCode = toString(v) },
+	"region_code":         func(rec *Record, v interface{}) { rec.RegionCode = toString(v) },
+	"district":            func(rec *Record, v interface{}) { rec.District = toString(v) },
+	"postal":              func(rec *Record, v interface{}) { rec.Postal = toString(v) },
+	"latitude":            func(rec *Record, v interface{}) { location(rec).Latitude = toFloat(v) },
+	"longitude":           func(rec *Record, v interface{}) { location(rec).Longitude = toFloat(v) },
+	"accuracy_radius":     func(rec *Record, v interface{}) { location(rec).AccuracyRadius = uint16(toFloat(v)) },
+	"time_zone":           func(rec *Record, v interface{}) { rec.TimeZone = toString(v) },
+	"utc_offset":          func(rec *Record, v interface{}) { rec.UTCOffset = toString(v) },
+	"asn":                 func(rec *Record, v interface{}) { rec.ASN = toASN(v) },
+	"as_organization":     func(rec *Record, v interface{}) { rec.ASOrganization = toString(v) },
+	"isp":                 func(rec *Record, v interface{}) { rec.ISP = toString(v) },
+	"organization":        func(rec *Record, v interface{}) { rec.Organization = toString(v) },
+	"connection_type":     func(rec *Record, v interface{}) { rec.ConnectionType = toString(v) },
+	"user_type":           func(rec *Record, v interface{}) { rec.UserType = toString(v) },
+	"domain":              func(rec *Record, v interface{}) { rec.Domain = toString(v) },
+	"mobile_country_code": func(rec *Record, v interface{}) { rec.MobileCountryCode = toString(v) },
+	"mobile_network_code": func(rec *Record, v interface{}) { rec.MobileNetworkCode = toString(v) },
+	"mobile_brand":        func(rec *Record, v interface{}) { rec.MobileBrand = toString(v) },
+	"usage_type":          func(rec *Record, v interface{}) { rec.UsageType = toString(v) },
+}
+
+// location 返回记录的坐标，没有时创建
+func location(rec *Record) *Location {
+	if rec.Location == nil {
+		rec.Location = &Location{}
+	}
+	return rec.Location
+}
+
+// toString 将 MMDB 中的字符串或数字转换为字符串
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case map[string]interface{}, []interface{}:
+		return ""
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// toFloat 将 MMDB 中的数字或数字字符串转换为浮点数
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	case int:
+		return float64(x)
+	case string:
+		f, _ := strconv.ParseFloat(x, 64)
+		return f
+	}
+	return 0
+}
+
+// toASN 将 MMDB 中的自治系统号转换为数字，字符串可以带有 AS 前缀（例如 IPinfo 的 AS13335）
+func toASN(v interface{}) uint {
+	if s, ok := v.(string); ok {
+		s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return 0
+		}
+		return uint(n)
+	}
+	return uint(toFloat(v))
+}
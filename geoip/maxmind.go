@@ -0,0 +1,183 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"ip-api/config"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func init() {
+	RegisterProvider(config.ProviderMaxMind, newMaxMindProvider)
+}
+
+// mmdbSource 由基于 MMDB 文件的数据源实现，范围查询和 ASN 索引通过它按网络遍历数据库
+type mmdbSource interface {
+	Reader() *maxminddb.Reader
+}
+
+// mmdbProvider 是基于 MMDB 文件的数据源的公共部分
+type mmdbProvider struct {
+	cfg    config.Database
+	path   string
+	kind   string
+	reader *maxminddb.Reader
+}
+
+func (p *mmdbProvider) Open() error {
+	reader, err := maxminddb.Open(p.path)
+	if err != nil {
+		return err
+	}
+	p.reader = reader
+	return nil
+}
+
+func (p *mmdbProvider) Close() error {
+	return p.reader.Close()
+}
+
+func (p *mmdbProvider) Metadata() Metadata {
+	return Metadata{
+		Kind:         p.kind,
+		DatabaseType: p.reader.Metadata.DatabaseType,
+		BuildEpoch:   p.reader.Metadata.BuildEpoch,
+	}
+}
+
+// Reader 返回底层的 MMDB 读取器
+func (p *mmdbProvider) Reader() *maxminddb.Reader {
+	return p.reader
+}
+
+// newMaxMindProvider 根据角色创建 MaxMind 格式的数据源
+func newMaxMindProvider(cfg config.Database, path string) (Provider, error) {
+	base := mmdbProvider{cfg: cfg, path: path, kind: config.ProviderMaxMind}
+	switch cfg.Role {
+	case config.RoleCity, config.RoleEnterprise:
+		return &cityProvider{base}, nil
+	case config.RoleASN, config.RoleISP:
+		return &asnProvider{base}, nil
+	case config.RoleConnectionType, config.RoleDomain, config.RoleAnonymousIP:
+		return &traitsProvider{base}, nil
+	case config.RoleCustom:
+		return &customProvider{base}, nil
+	default:
+		return nil, fmt.Errorf("database %s: role %q is not supported by the maxmind provider", cfg.Name, cfg.Role)
+	}
+}
+
+// cityProvider 读取 GeoIP2/GeoLite2 City 和 Enterprise 数据库。
+// Enterprise 是 City 的超集，两者都按 Enterprise 的结构解码。
+type cityProvider struct{ mmdbProvider }
+
+func (p *cityProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	var record geoip2.Enterprise
+	network, found, err := p.reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return Record{}, network, found, err
+	}
+
+	rec := Record{
+		CountryCode:   record.Country.IsoCode,
+		CountryNames:  record.Country.Names,
+		ContinentCode: record.Continent.Code,
+		CityNames:     record.City.Names,
+		Postal:        record.Postal.Code,
+		TimeZone:      record.Location.TimeZone,
+	}
+	if len(record.Subdivisions) > 0 {
+		rec.RegionCode = record.Subdivisions[0].IsoCode
+		rec.RegionNames = record.Subdivisions[0].Names
+	}
+	if record.Location.Latitude != 0 || record.Location.Longitude != 0 {
+		rec.Location = &Location{
+			Latitude:       record.Location.Latitude,
+			Longitude:      record.Location.Longitude,
+			AccuracyRadius: record.Location.AccuracyRadius,
+		}
+	}
+
+	t := record.Traits
+	rec.ASN, rec.ASOrganization = t.AutonomousSystemNumber, t.AutonomousSystemOrganization
+	rec.ISP = t.ISP
+	rec.Organization = t.Organization
+	rec.ConnectionType = t.ConnectionType
+	rec.UserType = t.UserType
+	rec.Domain = t.Domain
+	rec.MobileCountryCode = t.MobileCountryCode
+	rec.MobileNetworkCode = t.MobileNetworkCode
+	return rec, network, true, nil
+}
+
+// asnProvider 读取 GeoLite2 ASN 和 GeoIP2 ISP 数据库，ISP 是 ASN 的超集
+type asnProvider struct{ mmdbProvider }
+
+func (p *asnProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	var record geoip2.ISP
+	network, found, err := p.reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return Record{}, network, found, err
+	}
+	return Record{
+		ASN:               record.AutonomousSystemNumber,
+		ASOrganization:    record.AutonomousSystemOrganization,
+		ISP:               record.ISP,
+		Organization:      record.Organization,
+		MobileCountryCode: record.MobileCountryCode,
+		MobileNetworkCode: record.MobileNetworkCode,
+	}, network, true, nil
+}
+
+// traitsProvider 读取 GeoIP2 Connection-Type、Domain 和 Anonymous-IP 数据库
+type traitsProvider struct{ mmdbProvider }
+
+func (p *traitsProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	var rec Record
+	var network *net.IPNet
+	var found bool
+	var err error
+
+	switch p.cfg.Role {
+	case config.RoleConnectionType:
+		var record geoip2.ConnectionType
+		network, found, err = p.reader.LookupNetwork(ip, &record)
+		rec.ConnectionType = record.ConnectionType
+	case config.RoleDomain:
+		var record geoip2.Domain
+		network, found, err = p.reader.LookupNetwork(ip, &record)
+		rec.Domain = record.Domain
+	case config.RoleAnonymousIP:
+		var record geoip2.AnonymousIP
+		network, found, err = p.reader.LookupNetwork(ip, &record)
+		rec.Anonymizer = &Anonymizer{
+			IsAnonymous:        record.IsAnonymous,
+			IsAnonymousVPN:     record.IsAnonymousVPN,
+			IsHostingProvider:  record.IsHostingProvider,
+			IsPublicProxy:      record.IsPublicProxy,
+			IsResidentialProxy: record.IsResidentialProxy,
+			IsTorExitNode:      record.IsTorExitNode,
+		}
+	}
+	if err != nil || !found {
+		return Record{}, network, found, err
+	}
+	return rec, network, true, nil
+}
+
+// customProvider 读取任意结构的 MMDB，记录原样出现在查询结果中，不参与合并
+type customProvider struct{ mmdbProvider }
+
+func (p *customProvider) Lookup(ip net.IP) (Record, *net.IPNet, bool, error) {
+	_, network, found, err := p.Annotate(ip)
+	return Record{}, network, found, err
+}
+
+func (p *customProvider) Annotate(ip net.IP) (interface{}, *net.IPNet, bool, error) {
+	var record interface{}
+	network, found, err := p.reader.LookupNetwork(ip, &record)
+	return record, network, found, err
+}
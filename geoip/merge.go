@@ -0,0 +1,259 @@
+package geoip
+
+import (
+	"net"
+	"slices"
+
+	"ip-api/config"
+)
+
+// Answer 是一个数据源对查询给出的记录
+type Answer struct {
+	Source  string
+	Role    string
+	Network *net.IPNet
+	Record  Record
+}
+
+// Attribution 记录最终记录的每个字段由哪个数据库提供
+type Attribution map[string]string
+
+// Policy 把各数据源的记录合并为最终记录，answers 按注册表顺序排列且只包含找到的记录
+type Policy interface {
+	Merge(answers []Answer) (Record, Attribution)
+}
+
+// policy 是当前使用的合并策略
+var policy Policy = rulePolicy{}
+
+// SetPolicy 替换合并策略
+func SetPolicy(p Policy) {
+	dbMux.Lock()
+	defer dbMux.Unlock()
+	policy = p
+}
+
+// builtinRules 排在配置的规则之后：中国地址的城市、省份、区县和 ISP 优先使用 GeoCN
+var builtinRules = []config.MergeRule{
+	{
+		Fields:    []string{config.FieldRegion, config.FieldCity, config.FieldDistrict, config.FieldISP},
+		Countries: []string{"CN"},
+		Sources:   []string{config.RoleCN},
+	},
+}
+
+// defaultOrder 是没有规则匹配时各字段的角色优先级，位置字段使用 locationOrder
+var (
+	locationOrder = []string{config.RoleEnterprise, config.RoleCity, config.RoleCN}
+	defaultOrder  = map[string][]string{
+		config.FieldASN:            {config.RoleASN, config.RoleEnterprise, config.RoleISP},
+		config.FieldISP:            {config.RoleEnterprise, config.RoleISP, config.RoleCN},
+		config.FieldOrganization:   {config.RoleEnterprise, config.RoleISP},
+		config.FieldConnectionType: {config.RoleEnterprise, config.RoleConnectionType},
+		config.FieldUserType:       {config.RoleEnterprise},
+		config.FieldDomain:         {config.RoleEnterprise, config.RoleDomain},
+		config.FieldMobile:         {config.RoleEnterprise, config.RoleISP},
+		config.FieldAnonymizer:     {config.RoleAnonymousIP},
+		// 只有 IP2Location 等其他数据源提供的字段，按注册表顺序
+		config.FieldNetSpeed:  {},
+		config.FieldUsageType: {},
+	}
+)
+
+// mergeField 描述如何判断记录是否包含一个字段以及如何复制它
+type mergeField struct {
+	// location 字段只取自国家与合并后的国家一致（或没有国家）的数据源
+	location bool
+	has      func(r *Record) bool
+	copy     func(dst, src *Record)
+}
+
+var mergeFields = map[string]mergeField{
+	config.FieldCountry: {true, func(r *Record) bool { return r.CountryCode != "" }, func(dst, src *Record) {
+		dst.CountryCode, dst.CountryNames = src.CountryCode, src.CountryNames
+	}},
+	config.FieldContinent: {true, func(r *Record) bool { return r.ContinentCode != "" }, func(dst, src *Record) {
+		dst.ContinentCode = src.ContinentCode
+	}},
+	config.FieldRegion: {true, func(r *Record) bool { return len(r.RegionNames) > 0 }, func(dst, src *Record) {
+		dst.RegionCode, dst.RegionNames, dst.ProvinceCode = src.RegionCode, src.RegionNames, src.ProvinceCode
+	}},
+	config.FieldCity: {true, func(r *Record) bool { return len(r.CityNames) > 0 }, func(dst, src *Record) {
+		dst.CityNames, dst.CityCode = src.CityNames, src.CityCode
+	}},
+	config.FieldDistrict: {true, func(r *Record) bool { return r.District != "" }, func(dst, src *Record) {
+		dst.District, dst.DistrictCode = src.District, src.DistrictCode
+	}},
+	config.FieldPostal: {true, func(r *Record) bool { return r.Postal != "" }, func(dst, src *Record) {
+		dst.Postal = src.Postal
+	}},
+	config.FieldLocation: {true, func(r *Record) bool { return r.Location != nil }, func(dst, src *Record) {
+		dst.Location = src.Location
+	}},
+	config.FieldTimeZone: {true, func(r *Record) bool { return r.TimeZone != "" || r.UTCOffset != "" }, func(dst, src *Record) {
+		dst.TimeZone, dst.UTCOffset = src.TimeZone, src.UTCOffset
+	}},
+	config.FieldElevation: {true, func(r *Record) bool { return r.Elevation != 0 }, func(dst, src *Record) {
+		dst.Elevation = src.Elevation
+	}},
+	config.FieldAreaCode: {true, func(r *Record) bool { return r.IDDCode != "" || r.AreaCode != "" }, func(dst, src *Record) {
+		dst.IDDCode, dst.AreaCode = src.IDDCode, src.AreaCode
+	}},
+	config.FieldWeather: {true, func(r *Record) bool { return r.WeatherStationCode != "" }, func(dst, src *Record) {
+		dst.WeatherStationCode, dst.WeatherStationName = src.WeatherStationCode, src.WeatherStationName
+	}},
+	config.FieldASN: {false, func(r *Record) bool { return r.ASN != 0 }, func(dst, src *Record) {
+		dst.ASN, dst.ASOrganization = src.ASN, src.ASOrganization
+	}},
+	config.FieldISP: {false, func(r *Record) bool { return r.ISP != "" }, func(dst, src *Record) {
+		dst.ISP = src.ISP
+	}},
+	config.FieldOrganization: {false, func(r *Record) bool { return r.Organization != "" }, func(dst, src *Record) {
+		dst.Organization = src.Organization
+	}},
+	config.FieldConnectionType: {false, func(r *Record) bool { return r.ConnectionType != "" }, func(dst, src *Record) {
+		dst.ConnectionType = src.ConnectionType
+	}},
+	config.FieldUserType: {false, func(r *Record) bool { return r.UserType != "" }, func(dst, src *Record) {
+		dst.UserType = src.UserType
+	}},
+	config.FieldDomain: {false, func(r *Record) bool { return r.Domain != "" }, func(dst, src *Record) {
+		dst.Domain = src.Domain
+	}},
+	config.FieldMobile: {false, func(r *Record) bool { return r.MobileCountryCode != "" || r.MobileNetworkCode != "" }, func(dst, src *Record) {
+		dst.MobileCountryCode, dst.MobileNetworkCode, dst.MobileBrand = src.MobileCountryCode, src.MobileNetworkCode, src.MobileBrand
+	}},
+	config.FieldNetSpeed: {false, func(r *Record) bool { return r.NetSpeed != "" }, func(dst, src *Record) {
+		dst.NetSpeed = src.NetSpeed
+	}},
+	config.FieldUsageType: {false, func(r *Record) bool { return r.UsageType != "" || r.Category != "" }, func(dst, src *Record) {
+		dst.UsageType, dst.AddressType, dst.Category = src.UsageType, src.AddressType, src.Category
+	}},
+	config.FieldAnonymizer: {false, func(r *Record) bool { return r.Anonymizer != nil }, func(dst, src *Record) {
+		dst.Anonymizer = src.Anonymizer
+	}},
+}
+
+// mergeFills 补充胜出的数据源缺少的部分，只用于由名称和代码组成的字段。
+// 例如中国地址的省份取自只有中文名称的 GeoCN 时，英文名称和地区代码仍然来自城市数据库。
+var mergeFills = map[string]func(dst, src *Record){
+	config.FieldRegion: func(dst, src *Record) {
+		dst.RegionNames = mergeNames(dst.RegionNames, src.RegionNames)
+		if dst.RegionCode == "" {
+			dst.RegionCode = src.RegionCode
+		}
+		if dst.ProvinceCode == 0 {
+			dst.ProvinceCode = src.ProvinceCode
+		}
+	},
+	config.FieldCity: func(dst, src *Record) {
+		dst.CityNames = mergeNames(dst.CityNames, src.CityNames)
+		if dst.CityCode == 0 {
+			dst.CityCode = src.CityCode
+		}
+	},
+}
+
+// mergeNames 返回 dst 加上 src 中 dst 没有的语言，不修改两个参数
+func mergeNames(dst, src Names) Names {
+	merged := make(Names, len(dst)+len(src))
+	for lang, name := range src {
+		merged[lang] = name
+	}
+	for lang, name := range dst {
+		merged[lang] = name
+	}
+	return merged
+}
+
+// rulePolicy 按字段合并：每个字段取自优先级最高且有该字段的数据源。
+// 优先级来自第一条匹配的规则（配置的规则在内置规则之前），其后是字段的默认顺序和其余数据源。
+// 国家最先合并，其他字段按合并后的国家匹配规则。有 mergeFills 的字段再用其余的数据源按优先级补充。
+type rulePolicy struct{}
+
+func (rulePolicy) Merge(answers []Answer) (Record, Attribution) {
+	var rec Record
+	attr := make(Attribution)
+	for _, name := range config.MergeFields {
+		f := mergeFields[name]
+		fill := mergeFills[name]
+		found := false
+		for _, a := range precedence(name, rec.CountryCode, answers) {
+			src := &a.Record
+			if f.location && src.CountryCode != "" && rec.CountryCode != "" && src.CountryCode != rec.CountryCode {
+				// 国家不一致的数据源描述的是另一个地方，例如 City 认为是美国而 GeoCN 认为是中国
+				continue
+			}
+			if !f.has(src) {
+				continue
+			}
+			if !found {
+				f.copy(&rec, src)
+				attr[name] = a.Source
+				found = true
+				if fill == nil {
+					break
+				}
+			} else {
+				fill(&rec, src)
+			}
+		}
+	}
+	return rec, attr
+}
+
+// precedence 返回字段按优先级排列的数据源，country 为空时只使用不限国家的规则
+func precedence(field, country string, answers []Answer) []*Answer {
+	order := defaultOrder[field]
+	if order == nil {
+		order = locationOrder
+	}
+	if rule := matchRule(field, country); rule != nil {
+		order = append(slices.Clip(rule.Sources), order...)
+	}
+
+	out := make([]*Answer, 0, len(answers))
+	used := make([]bool, len(answers))
+	for _, source := range order {
+		for i := range answers {
+			if !used[i] && (answers[i].Source == source || answers[i].Role == source) {
+				used[i] = true
+				out = append(out, &answers[i])
+			}
+		}
+	}
+	for i := range answers {
+		if !used[i] {
+			out = append(out, &answers[i])
+		}
+	}
+	return out
+}
+
+// matchRule 返回第一条包含该字段且国家匹配的规则
+func matchRule(field, country string) *config.MergeRule {
+	for _, rules := range [][]config.MergeRule{config.App.Merge, builtinRules} {
+		for i, rule := range rules {
+			if !slices.Contains(rule.Fields, field) {
+				continue
+			}
+			if len(rule.Countries) > 0 && (country == "" || !slices.Contains(rule.Countries, country)) {
+				continue
+			}
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// firstAnswer 按角色的顺序返回第一个找到的记录
+func firstAnswer(answers []Answer, roles ...string) *Answer {
+	for _, role := range roles {
+		for i := range answers {
+			if answers[i].Role == role {
+				return &answers[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+)
+
+func TestRulePolicyMerge(t *testing.T) {
+	city := Answer{Source: "GeoLite2-City", Role: config.RoleCity, Record: Record{
+		CountryCode: "CN", RegionCode: "GD", RegionNames: Names{"en": "Guangdong", "zh-CN": "广东"},
+		CityNames: Names{"en": "Shenzhen"}, TimeZone: "Asia/Shanghai",
+	}}
+	geocn := Answer{Source: "GeoCN", Role: config.RoleCN, Record: Record{
+		CountryCode: "CN", RegionNames: Names{"zh-CN": "广东省"}, ProvinceCode: 440000,
+		CityNames: Names{"zh-CN": "深圳市"}, CityCode: 440300, District: "南山区", ISP: "电信",
+	}}
+	asn := Answer{Source: "GeoLite2-ASN", Role: config.RoleASN, Record: Record{ASN: 4134, ASOrganization: "CHINANET"}}
+	usCity := Answer{Source: "GeoLite2-City", Role: config.RoleCity, Record: Record{
+		CountryCode: "US", RegionCode: "CA", RegionNames: Names{"en": "California"}, CityNames: Names{"en": "San Jose"},
+	}}
+	enterprise := Answer{Source: "GeoIP2-Enterprise", Role: config.RoleEnterprise, Record: Record{
+		CountryCode: "US", RegionCode: "NY", RegionNames: Names{"en": "New York"}, CityNames: Names{"en": "New York"},
+		ISP: "Enterprise ISP", ConnectionType: "Corporate",
+	}}
+	ip2l := Answer{Source: "IP2Location", Role: config.RoleCity, Record: Record{
+		CountryCode: "CN", RegionNames: Names{"en": "Guangdong"}, CityNames: Names{"en": "Shenzhen"}, UsageType: "ISP",
+	}}
+
+	tests := []struct {
+		name    string
+		rules   []config.MergeRule
+		answers []Answer
+		want    Record
+		attr    Attribution
+	}{
+		{
+			name:    "GeoCN wins for China and the city database fills the other languages and codes",
+			answers: []Answer{city, asn, geocn},
+			want: Record{
+				CountryCode: "CN", RegionCode: "GD", RegionNames: Names{"en": "Guangdong", "zh-CN": "广东省"}, ProvinceCode: 440000,
+				CityNames: Names{"en": "Shenzhen", "zh-CN": "深圳市"}, CityCode: 440300, District: "南山区",
+				TimeZone: "Asia/Shanghai", ASN: 4134, ASOrganization: "CHINANET", ISP: "电信",
+			},
+			attr: Attribution{"country": "GeoLite2-City", "region": "GeoCN", "city": "GeoCN", "district": "GeoCN",
+				"time_zone": "GeoLite2-City", "asn": "GeoLite2-ASN", "isp": "GeoCN"},
+		},
+		{
+			name:    "sources that disagree on the country do not provide location fields",
+			answers: []Answer{usCity, geocn},
+			want: Record{
+				CountryCode: "US", RegionCode: "CA", RegionNames: Names{"en": "California"},
+				CityNames: Names{"en": "San Jose"}, ISP: "电信",
+			},
+			attr: Attribution{"country": "GeoLite2-City", "region": "GeoLite2-City", "city": "GeoLite2-City", "isp": "GeoCN"},
+		},
+		{
+			name:    "only the winning source without other answers",
+			answers: []Answer{geocn},
+			want: Record{
+				CountryCode: "CN", RegionNames: Names{"zh-CN": "广东省"}, ProvinceCode: 440000,
+				CityNames: Names{"zh-CN": "深圳市"}, CityCode: 440300, District: "南山区", ISP: "电信",
+			},
+			attr: Attribution{"country": "GeoCN", "region": "GeoCN", "city": "GeoCN", "district": "GeoCN", "isp": "GeoCN"},
+		},
+		{
+			name:    "Enterprise wins over City regardless of the answer order",
+			answers: []Answer{usCity, enterprise},
+			want: Record{
+				CountryCode: "US", RegionCode: "NY", RegionNames: Names{"en": "New York"}, CityNames: Names{"en": "New York"},
+				ISP: "Enterprise ISP", ConnectionType: "Corporate",
+			},
+			attr: Attribution{"country": "GeoIP2-Enterprise", "region": "GeoIP2-Enterprise", "city": "GeoIP2-Enterprise",
+				"isp": "GeoIP2-Enterprise", "connection_type": "GeoIP2-Enterprise"},
+		},
+		{
+			name:    "configured rules come before the built-in rules",
+			rules:   []config.MergeRule{{Fields: []string{config.FieldCity}, Sources: []string{"IP2Location"}}},
+			answers: []Answer{city, geocn, ip2l},
+			want: Record{
+				CountryCode: "CN", RegionCode: "GD", RegionNames: Names{"en": "Guangdong", "zh-CN": "广东省"}, ProvinceCode: 440000,
+				CityNames: Names{"en": "Shenzhen", "zh-CN": "深圳市"}, CityCode: 440300, District: "南山区",
+				TimeZone: "Asia/Shanghai", ISP: "电信", UsageType: "ISP",
+			},
+			attr: Attribution{"country": "GeoLite2-City", "region": "GeoCN", "city": "IP2Location", "district": "GeoCN",
+				"time_zone": "GeoLite2-City", "isp": "GeoCN", "usage_type": "IP2Location"},
+		},
+	}
+	old := config.App.Merge
+	defer func() { config.App.Merge = old }()
+	for _, tt := range tests {
+		config.App.Merge = tt.rules
+		rec, attr := rulePolicy{}.Merge(tt.answers)
+		if !reflect.DeepEqual(rec, tt.want) {
+			t.Errorf("%s:\nrecord = %+v\nwant     %+v", tt.name, rec, tt.want)
+		}
+		if !reflect.DeepEqual(attr, tt.attr) {
+			t.Errorf("%s:\nattribution = %v\nwant          %v", tt.name, attr, tt.attr)
+		}
+	}
+
+	// 合并不能修改数据源的记录
+	if len(geocn.Record.RegionNames) != 1 || len(city.Record.CityNames) != 1 {
+		t.Errorf("source names were modified: %v, %v", geocn.Record.RegionNames, city.Record.CityNames)
+	}
+}
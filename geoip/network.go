@@ -0,0 +1,345 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+
+	"ip-api/config"
+	"ip-api/iputil"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxNetworkScan 限制一次范围查询从每个数据库读取的网络数量，避免过大的范围拖垮服务
+const maxNetworkScan = 200000
+
+// ErrRangeTooLarge 表示查询的范围在数据库中包含的网络过多
+var ErrRangeTooLarge = errors.New("network contains too many sub-networks")
+
+// ErrIPv4CompatibleRange 表示查询的 IPv6 范围与 ::/96 重叠。IPv6 数据库在 ::/96 中保存 IPv4 数据，
+// 遍历会返回 IPv4 网络，无法与 IPv6 范围合并。
+var ErrIPv4CompatibleRange = errors.New("range overlaps ::/96, which holds IPv4 data; use IPv4 addresses instead")
+
+// ipv4CompatibleEnd 是 ::/96 的最后一个地址
+var ipv4CompatibleEnd = netip.MustParseAddr("::ffff:ffff")
+
+// NetworkRecord 是范围查询中一个子网络合并后的数据，字段来自城市、ASN 和 GeoCN 数据库
+type NetworkRecord struct {
+	CountryCode string `json:"country_code,omitempty"`
+	Region      string `json:"region,omitempty"`
+	RegionCode  string `json:"region_code,omitempty"`
+	ASN         string `json:"asn,omitempty"`
+	Org         string `json:"org,omitempty"`
+	ISP         string `json:"isp,omitempty"`
+}
+
+// NetworkEntry 是范围中数据相同的一段连续地址
+type NetworkEntry struct {
+	Start     string       `json:"start"`
+	End       string       `json:"end"`
+	Networks  []string     `json:"networks"`
+	Addresses iputil.Count `json:"addresses"`
+	NetworkRecord
+}
+
+// NetworkShare 是范围中某个国家或 ASN 的地址数量和占比
+type NetworkShare struct {
+	Code      string       `json:"code"`
+	Name      string       `json:"name,omitempty"`
+	Addresses iputil.Count `json:"addresses"`
+	Percent   float64      `json:"percent"`
+}
+
+// NetworkBreakdown 是范围查询的结果，Entries 是按 Offset 和 Limit 分页后的子网络
+type NetworkBreakdown struct {
+	Start        string         `json:"start"`
+	End          string         `json:"end"`
+	Addresses    iputil.Count   `json:"addresses"`
+	TotalEntries int            `json:"total_entries"`
+	Offset       int            `json:"offset"`
+	Limit        int            `json:"limit"`
+	Entries      []NetworkEntry `json:"entries"`
+	Countries    []NetworkShare `json:"countries"`
+	ASNs         []NetworkShare `json:"asns"`
+}
+
+// span 是一个数据库中与查询范围重叠的网络
+type span struct {
+	r   iputil.Range
+	rec NetworkRecord
+}
+
+// LookupRange 将范围按所有数据库的网络边界拆分，合并数据相同的相邻部分，
+// 返回分页后的子网络以及按国家和 ASN 统计的地址占比。
+// 遍历使用单独打开的读取器，大范围的查询不会阻塞热加载。
+func LookupRange(r iputil.Range, offset, limit int) (*NetworkBreakdown, error) {
+	if r.From.Is6() && !ipv4CompatibleEnd.Less(r.From) {
+		return nil, ErrIPv4CompatibleRange
+	}
+
+	dbMux.RLock()
+	loaded := len(dbs) > 0
+	dbMux.RUnlock()
+	if !loaded {
+		return nil, errors.New("no GeoIP databases are available")
+	}
+
+	sources := []struct {
+		roles  []string
+		decode func(n *maxminddb.Networks) (netip.Prefix, NetworkRecord, error)
+	}{
+		{[]string{config.RoleEnterprise, config.RoleCity}, decodeCityNetwork},
+		{[]string{config.RoleASN}, decodeASNNetwork},
+		{[]string{config.RoleCN}, decodeCNNetwork},
+	}
+
+	var spans [][]span
+	for _, s := range sources {
+		reader, err := openReaderFor(s.roles...)
+		if err != nil {
+			return nil, err
+		}
+		if reader == nil {
+			continue
+		}
+		found, err := collectSpans(reader, r, s.decode)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, found)
+	}
+
+	entries := splitRange(r, spans)
+	breakdown := &NetworkBreakdown{
+		Start:        r.From.String(),
+		End:          r.To.String(),
+		Addresses:    r.Size(),
+		TotalEntries: len(entries),
+		Offset:       offset,
+		Limit:        limit,
+		Entries:      []NetworkEntry{},
+	}
+	breakdown.Countries, breakdown.ASNs = summarize(entries, breakdown.Addresses)
+
+	if offset < len(entries) {
+		end := min(offset+limit, len(entries))
+		breakdown.Entries = entries[offset:end]
+	}
+	for i := range breakdown.Entries {
+		e := &breakdown.Entries[i]
+		from, _ := netip.ParseAddr(e.Start)
+		to, _ := netip.ParseAddr(e.End)
+		for _, p := range (iputil.Range{From: from, To: to}).Prefixes() {
+			e.Networks = append(e.Networks, p.String())
+		}
+	}
+	return breakdown, nil
+}
+
+// collectSpans 读取数据库中与范围重叠的所有网络，网络超出范围的部分被裁掉
+func collectSpans(reader *maxminddb.Reader, r iputil.Range, decode func(n *maxminddb.Networks) (netip.Prefix, NetworkRecord, error)) ([]span, error) {
+	var spans []span
+	for _, p := range r.Prefixes() {
+		if p.Addr().Is6() && reader.Metadata.IPVersion == 4 {
+			continue
+		}
+		networks := reader.NetworksWithin(iputil.IPNetFromPrefix(p), maxminddb.SkipAliasedNetworks)
+		for networks.Next() {
+			network, rec, err := decode(networks)
+			if err != nil {
+				return nil, err
+			}
+			nr := iputil.PrefixRange(network)
+			if nr.From.Less(r.From) {
+				nr.From = r.From
+			}
+			if r.To.Less(nr.To) {
+				nr.To = r.To
+			}
+			spans = append(spans, span{r: nr, rec: rec})
+			if len(spans) > maxNetworkScan {
+				return nil, ErrRangeTooLarge
+			}
+		}
+		if err := networks.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return spans, nil
+}
+
+// splitRange 在所有数据库的网络边界处拆分范围，合并记录相同的相邻部分。
+// 每个数据库的 spans 按地址有序且互不重叠。
+func splitRange(r iputil.Range, spans [][]span) []NetworkEntry {
+	bounds := []netip.Addr{r.From}
+	for _, list := range spans {
+		for _, s := range list {
+			bounds = append(bounds, s.r.From)
+			if next := s.r.To.Next(); next.IsValid() && !r.To.Less(next) {
+				bounds = append(bounds, next)
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Less(bounds[j]) })
+	bounds = slices.Compact(bounds)
+
+	type segment struct {
+		r   iputil.Range
+		rec NetworkRecord
+	}
+	var segments []segment
+	pos := make([]int, len(spans))
+	for i, from := range bounds {
+		to := r.To
+		if i+1 < len(bounds) {
+			to = bounds[i+1].Prev()
+		}
+
+		var rec NetworkRecord
+		for k, list := range spans {
+			for pos[k] < len(list) && list[pos[k]].r.To.Less(from) {
+				pos[k]++
+			}
+			if pos[k] < len(list) && !from.Less(list[pos[k]].r.From) {
+				rec = mergeRecord(rec, list[pos[k]].rec)
+			}
+		}
+
+		if n := len(segments); n > 0 && segments[n-1].rec == rec {
+			segments[n-1].r.To = to
+			continue
+		}
+		segments = append(segments, segment{r: iputil.Range{From: from, To: to}, rec: rec})
+	}
+
+	entries := make([]NetworkEntry, len(segments))
+	for i, s := range segments {
+		entries[i] = NetworkEntry{
+			Start:         s.r.From.String(),
+			End:           s.r.To.String(),
+			Addresses:     s.r.Size(),
+			NetworkRecord: s.rec,
+		}
+	}
+	return entries
+}
+
+// mergeRecord 用 src 中的非空字段补充 dst
+func mergeRecord(dst, src NetworkRecord) NetworkRecord {
+	if src.CountryCode != "" {
+		dst.CountryCode = src.CountryCode
+	}
+	if src.Region != "" {
+		dst.Region = src.Region
+		dst.RegionCode = src.RegionCode
+	}
+	if src.ASN != "" {
+		dst.ASN = src.ASN
+		dst.Org = src.Org
+	}
+	if src.ISP != "" {
+		dst.ISP = src.ISP
+	}
+	return dst
+}
+
+// summarize 按国家和 ASN 统计地址数量，结果按地址数降序排列。
+// 没有国家信息的地址计入 ZZ，没有 ASN 的地址计入空代码。
+func summarize(entries []NetworkEntry, total iputil.Count) ([]NetworkShare, []NetworkShare) {
+	countries := make(map[string]*NetworkShare)
+	asns := make(map[string]*NetworkShare)
+	add := func(m map[string]*NetworkShare, code, name string, n iputil.Count) {
+		s, ok := m[code]
+		if !ok {
+			s = &NetworkShare{Code: code, Name: name}
+			m[code] = s
+		}
+		s.Addresses = s.Addresses.Add(n)
+	}
+	for _, e := range entries {
+		country := e.CountryCode
+		if country == "" {
+			country = unknownCountry
+		}
+		add(countries, country, "", e.Addresses)
+		add(asns, e.ASN, e.Org, e.Addresses)
+	}
+
+	sorted := func(m map[string]*NetworkShare) []NetworkShare {
+		out := make([]NetworkShare, 0, len(m))
+		for _, s := range m {
+			s.Percent = s.Addresses.Float64() / total.Float64() * 100
+			out = append(out, *s)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if cmp := out[i].Addresses.Cmp(out[j].Addresses); cmp != 0 {
+				return cmp > 0
+			}
+			return out[i].Code < out[j].Code
+		})
+		return out
+	}
+	return sorted(countries), sorted(asns)
+}
+
+// localizedName 优先返回中文名称，其次是英文名称
+func localizedName(names map[string]string) string {
+	if name := names["zh-CN"]; name != "" {
+		return name
+	}
+	return names["en"]
+}
+
+func decodeCityNetwork(n *maxminddb.Networks) (netip.Prefix, NetworkRecord, error) {
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Subdivisions []struct {
+			IsoCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
+		} `maxminddb:"subdivisions"`
+	}
+	network, err := n.Network(&record)
+	if err != nil {
+		return netip.Prefix{}, NetworkRecord{}, err
+	}
+	rec := NetworkRecord{CountryCode: record.Country.IsoCode}
+	if len(record.Subdivisions) > 0 {
+		rec.Region = localizedName(record.Subdivisions[0].Names)
+		rec.RegionCode = record.Subdivisions[0].IsoCode
+	}
+	return iputil.PrefixFromIPNet(network), rec, nil
+}
+
+func decodeASNNetwork(n *maxminddb.Networks) (netip.Prefix, NetworkRecord, error) {
+	var record struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	network, err := n.Network(&record)
+	if err != nil {
+		return netip.Prefix{}, NetworkRecord{}, err
+	}
+	var rec NetworkRecord
+	if record.Number != 0 {
+		rec.ASN = "AS" + strconv.FormatUint(uint64(record.Number), 10)
+		rec.Org = record.Organization
+	}
+	return iputil.PrefixFromIPNet(network), rec, nil
+}
+
+func decodeCNNetwork(n *maxminddb.Networks) (netip.Prefix, NetworkRecord, error) {
+	var record struct {
+		ISP string `maxminddb:"isp"`
+	}
+	network, err := n.Network(&record)
+	if err != nil {
+		return netip.Prefix{}, NetworkRecord{}, err
+	}
+	return iputil.PrefixFromIPNet(network), NetworkRecord{ISP: record.ISP}, nil
+}
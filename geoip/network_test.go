@@ -0,0 +1,69 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/iputil"
+)
+
+func TestLookupRange(t *testing.T) {
+	useDataDir(t)
+	city := config.Database{Name: "Test-City", Role: config.RoleCity}
+	asn := config.Database{Name: "Test-ASN", Role: config.RoleASN}
+	writeMMDB(t, city.Path(), "GeoLite2-City", map[string]interface{}{
+		"192.0.2.0/25":   country("US"),
+		"192.0.2.128/25": country("CA"),
+		"2001:db8::/33":  country("DE"),
+	})
+	writeMMDB(t, asn.Path(), "GeoLite2-ASN", map[string]interface{}{
+		"192.0.2.0/24": map[string]interface{}{"autonomous_system_number": uint32(64496), "autonomous_system_organization": "Example"},
+	})
+	if err := OpenDBs([]config.Database{city, asn}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LookupRange(iputil.Range{From: netip.MustParseAddr("192.0.2.0"), To: netip.MustParseAddr("192.0.3.255")}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start, end, country, asn string
+	}{
+		{"192.0.2.0", "192.0.2.127", "US", "AS64496"},
+		{"192.0.2.128", "192.0.2.255", "CA", "AS64496"},
+		{"192.0.3.0", "192.0.3.255", "", ""},
+	}
+	if len(b.Entries) != len(want) {
+		t.Fatalf("entries = %+v", b.Entries)
+	}
+	for i, w := range want {
+		e := b.Entries[i]
+		if e.Start != w.start || e.End != w.end || e.CountryCode != w.country || e.ASN != w.asn {
+			t.Errorf("entry %d = %s-%s %s %s, want %+v", i, e.Start, e.End, e.CountryCode, e.ASN, w)
+		}
+	}
+	if len(b.Countries) != 3 || b.Countries[0].Code != unknownCountry || b.Countries[0].Percent != 50 {
+		t.Errorf("countries = %+v", b.Countries)
+	}
+
+	b, err = LookupRange(iputil.Range{From: netip.MustParseAddr("2001:db8::"), To: netip.MustParseAddr("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff")}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Entries) != 2 || b.Entries[0].CountryCode != "DE" || b.Entries[0].End != "2001:db8:7fff:ffff:ffff:ffff:ffff:ffff" {
+		t.Errorf("IPv6 entries = %+v", b.Entries)
+	}
+
+	for _, r := range []string{"::/96", "::c000:200/120", "::/0"} {
+		rng, err := iputil.ParseRange(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LookupRange(rng, 0, 100); !errors.Is(err, ErrIPv4CompatibleRange) {
+			t.Errorf("LookupRange(%s) error = %v, want ErrIPv4CompatibleRange", r, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package geoip
+
+import (
+	"net/netip"
+
+	"ip-api/config"
+	"ip-api/iputil"
+	"ip-api/overrides"
+)
+
+// overrideSource 是覆盖记录在 Result.Sources 和 Attribution 中的名称
+const overrideSource = "overrides"
+
+// applyOverride 用覆盖记录替换合并后的字段，span 是覆盖查询结果相同的网络。
+// 覆盖的国家与数据源不同时，数据源给出的其他位置字段描述的是另一个地方，全部清除。
+func applyOverride(res *Result, e overrides.Entry, span netip.Prefix) {
+	rec := &res.Record
+	if res.Attribution == nil {
+		res.Attribution = make(Attribution)
+	}
+	if e.Country != "" && e.Country != rec.CountryCode {
+		for name, f := range mergeFields {
+			if f.location {
+				f.copy(rec, &Record{})
+				delete(res.Attribution, name)
+			}
+		}
+		rec.CountryCode, rec.CountryNames = e.Country, countryNames(e.Country, res.Answers)
+		res.Attribution[config.FieldCountry] = overrideSource
+	}
+	if e.Region != "" {
+		rec.RegionCode, rec.RegionNames, rec.ProvinceCode = "", Names{"en": e.Region}, 0
+		res.Attribution[config.FieldRegion] = overrideSource
+	}
+	if e.City != "" {
+		rec.CityNames, rec.CityCode = Names{"en": e.City}, 0
+		res.Attribution[config.FieldCity] = overrideSource
+	}
+	if e.Latitude != nil {
+		rec.Location = &Location{Latitude: *e.Latitude, Longitude: *e.Longitude}
+		res.Attribution[config.FieldLocation] = overrideSource
+	}
+	if e.Org != "" {
+		rec.Organization = e.Org
+		res.Attribution[config.FieldOrganization] = overrideSource
+	}
+	res.Tags = e.Tags
+
+	// 网络取 span 和数据源网络中更小的一个，整个网络内的地址结果相同
+	if res.Network == nil || iputil.PrefixFromIPNet(res.Network).Bits() < span.Bits() {
+		res.Network = iputil.IPNetFromPrefix(span)
+	}
+
+	network := netip.MustParsePrefix(e.Network)
+	res.Sources = append([]SourceStatus{{Name: overrideSource, Role: "override", Found: true, Network: e.Network}}, res.Sources...)
+	res.Answers = append([]Answer{{Source: overrideSource, Role: "override", Network: iputil.IPNetFromPrefix(network), Record: overrideRecord(e)}}, res.Answers...)
+}
+
+// overrideRecord 把覆盖记录转换为 Record，用于 ?debug=sources 显示原始记录
+func overrideRecord(e overrides.Entry) Record {
+	rec := Record{CountryCode: e.Country, CountryNames: countryNames(e.Country, nil), Organization: e.Org}
+	if e.Region != "" {
+		rec.RegionNames = Names{"en": e.Region}
+	}
+	if e.City != "" {
+		rec.CityNames = Names{"en": e.City}
+	}
+	if e.Latitude != nil {
+		rec.Location = &Location{Latitude: *e.Latitude, Longitude: *e.Longitude}
+	}
+	return rec
+}
@@ -0,0 +1,46 @@
+package geoip
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/overrides"
+)
+
+func TestApplyOverrideCountryNames(t *testing.T) {
+	us := Record{CountryCode: "US", CountryNames: Names{"en": "United States", "de": "USA"}, CityNames: Names{"en": "Chicago"}}
+	de := Record{CountryCode: "DE", CountryNames: Names{"en": "Germany", "de": "Deutschland"}}
+	tests := []struct {
+		name    string
+		answers []Answer
+		country string
+		want    Names
+	}{
+		{"builtin name", []Answer{{Source: "City", Record: us}}, "DE", Names{"en": "Germany"}},
+		{"name from another source", []Answer{{Source: "City", Record: us}, {Source: "Other", Record: de}}, "DE", de.CountryNames},
+		{"same country keeps names", []Answer{{Source: "City", Record: us}}, "US", us.CountryNames},
+		{"unknown code", nil, "ZZ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, attr := rulePolicy{}.Merge(tt.answers)
+			res := &Result{Record: rec, Attribution: attr, Answers: tt.answers}
+			e := overrides.Entry{Network: "10.0.0.0/8", Country: tt.country}
+			applyOverride(res, e, netip.MustParsePrefix("10.0.0.0/8"))
+
+			if res.Record.CountryCode != tt.country || !reflect.DeepEqual(res.Record.CountryNames, tt.want) {
+				t.Errorf("country = %s %v, want %s %v", res.Record.CountryCode, res.Record.CountryNames, tt.country, tt.want)
+			}
+			if tt.country != "US" {
+				if len(res.Record.CityNames) > 0 {
+					t.Errorf("city %v kept for a different country", res.Record.CityNames)
+				}
+				if res.Attribution[config.FieldCountry] != overrideSource {
+					t.Errorf("country attributed to %q", res.Attribution[config.FieldCountry])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"ip-api/config"
+	"ip-api/iputil"
+)
+
+// Provider 是一个地理位置数据源。注册表中的每个数据库对应一个 Provider，
+// 查询结果被归一化为 Record，再由 Policy 合并为最终结果。
+type Provider interface {
+	// Open 打开数据源，之后才能查询
+	Open() error
+	// Close 释放数据源占用的资源
+	Close() error
+	// Lookup 查询地址，返回归一化的记录、包含该地址的网络以及是否找到。
+	// 没有找到时应尽量返回没有数据的网络，构建合并数据库时据此跳过整个网络。
+	Lookup(ip net.IP) (Record, *net.IPNet, bool, error)
+	// Metadata 返回数据源的元数据
+	Metadata() Metadata
+}
+
+// Metadata 描述一个已打开的数据源
+type Metadata struct {
+	Kind         string // 数据源类型，例如 maxmind、geocn
+	DatabaseType string
+	BuildEpoch   uint
+}
+
+// ProviderFactory 根据注册表条目创建读取 path 处文件的数据源
+type ProviderFactory func(cfg config.Database, path string) (Provider, error)
+
+// factories 是按类型注册的数据源构造函数
+var factories = make(map[string]ProviderFactory)
+
+// RegisterProvider 注册一种数据源类型，注册表条目通过 provider 字段选择
+func RegisterProvider(kind string, factory ProviderFactory) {
+	factories[kind] = factory
+}
+
+// newProvider 为注册表条目创建读取 path 处文件的数据源，没有指定类型时根据角色选择
+func newProvider(cfg config.Database, path string) (Provider, error) {
+	kind := cfg.ProviderKind()
+	factory, ok := factories[kind]
+	if !ok {
+		return nil, fmt.Errorf("database %s: unknown provider %q", cfg.Name, kind)
+	}
+	return factory(cfg, path)
+}
+
+// OpenFile 用注册表条目的数据源类型打开 path 处的文件，例如校验尚未安装的新版本。
+// 调用者负责关闭返回的数据源。
+func OpenFile(cfg config.Database, path string) (Provider, error) {
+	provider, err := newProvider(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Open(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// FileMetadata 读取 path 处数据库文件的元数据
+func FileMetadata(cfg config.Database, path string) (Metadata, error) {
+	provider, err := OpenFile(cfg, path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer provider.Close()
+	return provider.Metadata(), nil
+}
+
+// Names 是按语言区分的名称
+type Names map[string]string
+
+// Record 是数据源返回的归一化记录，空字段表示数据源没有该数据
+type Record struct {
+	CountryCode   string    `json:"country_code,omitempty"`
+	CountryNames  Names     `json:"country_names,omitempty"`
+	ContinentCode string    `json:"continent_code,omitempty"`
+	RegionCode    string    `json:"region_code,omitempty"`
+	RegionNames   Names     `json:"region_names,omitempty"`
+	CityNames     Names     `json:"city_names,omitempty"`
+	District      string    `json:"district,omitempty"`
+	Postal        string    `json:"postal,omitempty"`
+	Location      *Location `json:"location,omitempty"`
+	TimeZone      string    `json:"time_zone,omitempty"`
+	// UTCOffset 用于只提供 UTC 偏移而没有时区名称的数据源，例如 IP2Location 的 +08:00
+	UTCOffset string  `json:"utc_offset,omitempty"`
+	Elevation float64 `json:"elevation,omitempty"`
+
+	// IP2Location 的电话区号和气象站
+	IDDCode            string `json:"idd_code,omitempty"`
+	AreaCode           string `json:"area_code,omitempty"`
+	WeatherStationCode string `json:"weather_station_code,omitempty"`
+	WeatherStationName string `json:"weather_station_name,omitempty"`
+
+	// GeoCN 的行政区划代码
+	ProvinceCode uint `json:"province_code,omitempty"`
+	CityCode     uint `json:"city_code,omitempty"`
+	DistrictCode uint `json:"district_code,omitempty"`
+
+	ASN               uint        `json:"asn,omitempty"`
+	ASOrganization    string      `json:"as_organization,omitempty"`
+	ISP               string      `json:"isp,omitempty"`
+	Organization      string      `json:"organization,omitempty"`
+	ConnectionType    string      `json:"connection_type,omitempty"`
+	UserType          string      `json:"user_type,omitempty"`
+	Domain            string      `json:"domain,omitempty"`
+	MobileCountryCode string      `json:"mobile_country_code,omitempty"`
+	MobileNetworkCode string      `json:"mobile_network_code,omitempty"`
+	MobileBrand       string      `json:"mobile_brand,omitempty"`
+	NetSpeed          string      `json:"net_speed,omitempty"`
+	UsageType         string      `json:"usage_type,omitempty"`
+	AddressType       string      `json:"address_type,omitempty"`
+	Category          string      `json:"category,omitempty"`
+	Anonymizer        *Anonymizer `json:"anonymizer,omitempty"`
+}
+
+// Location 是地址的坐标
+type Location struct {
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyRadius uint16  `json:"accuracy_radius,omitempty"`
+}
+
+// Anonymizer 是匿名网络标记，只有 Anonymous-IP 等数据源提供
+type Anonymizer struct {
+	IsAnonymous        bool `json:"is_anonymous"`
+	IsAnonymousVPN     bool `json:"is_anonymous_vpn"`
+	IsHostingProvider  bool `json:"is_hosting_provider"`
+	IsPublicProxy      bool `json:"is_public_proxy"`
+	IsResidentialProxy bool `json:"is_residential_proxy"`
+	IsTorExitNode      bool `json:"is_tor_exit_node"`
+}
+
+// HasLocation 检查记录是否包含位置数据
+func (r *Record) HasLocation() bool {
+	return r.CountryCode != "" || len(r.RegionNames) > 0 || len(r.CityNames) > 0 || r.Location != nil
+}
+
+// rangeNetwork 返回地址范围中包含该地址的最大网络，用于按起止地址存储数据的数据源
+func rangeNetwork(rng iputil.Range, addr netip.Addr) *net.IPNet {
+	addr = addr.Unmap()
+	for _, prefix := range rng.Prefixes() {
+		if prefix.Contains(addr) {
+			return iputil.IPNetFromPrefix(prefix)
+		}
+	}
+	return nil
+}
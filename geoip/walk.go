@@ -0,0 +1,40 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"ip-api/iputil"
+)
+
+// WalkMerged 按网络遍历地址范围，对每个有数据的网络调用 fn，结果与 Lookup 对网络中任意地址的结果相同。
+// 每一步查询所有数据源得到结果相同的网络，然后跳到下一个网络，因此只查询每个网络一次。
+// 每次查询单独持有读锁，遍历期间重新加载数据库时，前后两部分可能来自不同的版本。
+func WalkMerged(r iputil.Range, fn func(p netip.Prefix, res *Result) error) error {
+	pos := r.From
+	for {
+		dbMux.RLock()
+		res, span, err := lookup(net.IP(pos.AsSlice()))
+		dbMux.RUnlock()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		end := iputil.PrefixRange(span).To
+		if r.To.Less(end) {
+			end = r.To
+		}
+		if res != nil {
+			for _, p := range (iputil.Range{From: pos, To: end}).Prefixes() {
+				if err := fn(p, res); err != nil {
+					return err
+				}
+			}
+		}
+		if end == r.To {
+			return nil
+		}
+		pos = end.Next()
+	}
+}
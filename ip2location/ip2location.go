@@ -0,0 +1,371 @@
+// Package ip2location 读取 IP2Location BIN 格式的数据库，支持 DB1 到 DB26 的所有列组合以及 IPv4 和 IPv6 索引。
+package ip2location
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"ip-api/iputil"
+)
+
+// Field 是 BIN 文件中的一列
+type Field int
+
+// BIN 文件可能包含的列，具体包含哪些由数据库类型（DB1-DB26）决定
+const (
+	Country Field = iota
+	Region
+	City
+	ISP
+	Latitude
+	Longitude
+	Domain
+	ZipCode
+	TimeZone
+	NetSpeed
+	IDDCode
+	AreaCode
+	WeatherStationCode
+	WeatherStationName
+	MCC
+	MNC
+	MobileBrand
+	Elevation
+	UsageType
+	AddressType
+	Category
+	District
+	ASN
+	AS
+	fieldCount
+)
+
+// positions 是每种数据库类型中各列的位置（从 1 开始，第 1 列是起始地址），0 表示不包含该列
+var positions = [fieldCount][27]uint8{
+	Country:            {0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+	Region:             {0, 0, 0, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+	City:               {0, 0, 0, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
+	ISP:                {0, 0, 3, 0, 5, 0, 7, 5, 7, 0, 8, 0, 9, 0, 9, 0, 9, 0, 9, 7, 9, 0, 9, 7, 9, 9, 9},
+	Latitude:           {0, 0, 0, 0, 0, 5, 5, 0, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+	Longitude:          {0, 0, 0, 0, 0, 6, 6, 0, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6},
+	Domain:             {0, 0, 0, 0, 0, 0, 0, 6, 8, 0, 9, 0, 10, 0, 10, 0, 10, 0, 10, 8, 10, 0, 10, 8, 10, 10, 10},
+	ZipCode:            {0, 0, 0, 0, 0, 0, 0, 0, 0, 7, 7, 7, 7, 0, 7, 7, 7, 0, 7, 0, 7, 7, 7, 0, 7, 7, 7},
+	TimeZone:           {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 8, 7, 8, 8, 8, 7, 8, 0, 8, 8, 8, 0, 8, 8, 8},
+	NetSpeed:           {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 11, 0, 11, 8, 11, 0, 11, 0, 11, 0, 11, 11, 11},
+	IDDCode:            {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 12, 0, 12, 0, 12, 9, 12, 0, 12, 12, 12},
+	AreaCode:           {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10, 13, 0, 13, 0, 13, 10, 13, 0, 13, 13, 13},
+	WeatherStationCode: {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 14, 0, 14, 0, 14, 0, 14, 14, 14},
+	WeatherStationName: {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10, 15, 0, 15, 0, 15, 0, 15, 15, 15},
+	MCC:                {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 16, 0, 16, 9, 16, 16, 16},
+	MNC:                {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10, 17, 0, 17, 10, 17, 17, 17},
+	MobileBrand:        {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 11, 18, 0, 18, 11, 18, 18, 18},
+	Elevation:          {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 11, 19, 0, 19, 19, 19},
+	UsageType:          {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 12, 20, 20, 20},
+	AddressType:        {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 21, 21},
+	Category:           {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 22, 22},
+	District:           {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 23},
+	ASN:                {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 24},
+	AS:                 {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 25},
+}
+
+// headerSize 是 BIN 文件头的长度
+const headerSize = 64
+
+// ErrFormat 表示文件不是有效的 IP2Location BIN 文件
+var ErrFormat = errors.New("not a valid IP2Location BIN file")
+
+// Meta 是 BIN 文件头中的元数据
+type Meta struct {
+	Type        int       // 数据库类型，1-26 对应 DB1-DB26
+	Columns     int       // 每行的列数，包括起始地址
+	Date        time.Time // 数据库的发布日期
+	IPv4Count   uint32    // IPv4 数据行数
+	IPv6Count   uint32    // IPv6 数据行数
+	ProductCode byte
+	LicenseCode byte
+}
+
+// DB 是一个打开的 BIN 文件，按需从磁盘读取，可以并发查询
+type DB struct {
+	f    *os.File
+	meta Meta
+
+	// 以下地址都从 1 开始计数，与文件头中的值一致
+	ipv4Base, ipv6Base   uint32
+	ipv4Index, ipv6Index uint32
+	ipv4Row, ipv6Row     uint32 // 每行的字节数
+}
+
+// Open 打开 BIN 文件并读取文件头
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{f: f}
+	if err := db.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func (db *DB) readHeader() error {
+	var h [headerSize]byte
+	if _, err := db.f.ReadAt(h[:], 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	le := binary.LittleEndian
+
+	m := Meta{
+		Type:        int(h[0]),
+		Columns:     int(h[1]),
+		Date:        time.Date(2000+int(h[2]), time.Month(h[3]), int(h[4]), 0, 0, 0, 0, time.UTC),
+		IPv4Count:   le.Uint32(h[5:]),
+		IPv6Count:   le.Uint32(h[13:]),
+		ProductCode: h[29],
+		LicenseCode: h[30],
+	}
+	db.ipv4Base = le.Uint32(h[9:])
+	db.ipv6Base = le.Uint32(h[17:])
+	db.ipv4Index = le.Uint32(h[21:])
+	db.ipv6Index = le.Uint32(h[25:])
+
+	// 2021 年以后的文件带有产品代码 1，更早的文件该字节为 0
+	if m.ProductCode != 1 && !(h[2] <= 20 && m.ProductCode == 0) {
+		return fmt.Errorf("%w: unexpected product code %d", ErrFormat, m.ProductCode)
+	}
+	if m.Type < 1 || m.Type > 26 || m.Columns < 2 || h[3] < 1 || h[3] > 12 {
+		return fmt.Errorf("%w: database type %d with %d columns", ErrFormat, m.Type, m.Columns)
+	}
+	// 列数少于数据库类型需要的列时，解码会越过行的末尾
+	for f := range fieldCount {
+		if pos := int(positions[f][m.Type]); pos > m.Columns {
+			return fmt.Errorf("%w: database type %d needs %d columns, header has %d", ErrFormat, m.Type, pos, m.Columns)
+		}
+	}
+	db.meta = m
+	db.ipv4Row = uint32(m.Columns) * 4
+	db.ipv6Row = 16 + uint32(m.Columns-1)*4
+	return nil
+}
+
+// Close 关闭文件
+func (db *DB) Close() error {
+	return db.f.Close()
+}
+
+// Meta 返回文件头中的元数据
+func (db *DB) Meta() Meta {
+	return db.meta
+}
+
+// Has 检查数据库类型是否包含某一列
+func (db *DB) Has(f Field) bool {
+	return positions[f][db.meta.Type] != 0
+}
+
+// Record 是一行数据，数据库类型不包含的列为空。IP2Location 用 "-" 表示未知的值。
+type Record struct {
+	CountryShort       string
+	CountryLong        string
+	Region             string
+	City               string
+	ISP                string
+	Latitude           float32
+	Longitude          float32
+	Domain             string
+	ZipCode            string
+	TimeZone           string // UTC 偏移，例如 +08:00
+	NetSpeed           string
+	IDDCode            string
+	AreaCode           string
+	WeatherStationCode string
+	WeatherStationName string
+	MCC                string
+	MNC                string
+	MobileBrand        string
+	Elevation          float32
+	UsageType          string
+	AddressType        string
+	Category           string
+	District           string
+	ASN                string
+	AS                 string
+}
+
+// Lookup 查询地址所在的行，返回记录和该行覆盖的地址范围。
+// IPv4 映射的 IPv6 地址按 IPv4 查询。
+func (db *DB) Lookup(addr netip.Addr) (Record, iputil.Range, bool, error) {
+	addr = addr.Unmap().WithZone("")
+
+	base, count, index, rowSize := db.ipv4Base, db.meta.IPv4Count, db.ipv4Index, db.ipv4Row
+	if addr.Is6() {
+		base, count, index, rowSize = db.ipv6Base, db.meta.IPv6Count, db.ipv6Index, db.ipv6Row
+	}
+	if count == 0 || base == 0 {
+		return Record{}, iputil.Range{}, false, nil
+	}
+
+	// 最后一行的结束地址是下一行的起始地址，最大的地址本身按前一个地址查询
+	query := addr
+	if !addr.Next().IsValid() {
+		query = addr.Prev()
+	}
+
+	low, high := uint32(0), count-1
+	if index > 0 {
+		b := query.AsSlice()
+		key := uint32(b[0])<<8 | uint32(b[1])
+		var entry [8]byte
+		if err := db.readAt(entry[:], index+key*8); err != nil {
+			return Record{}, iputil.Range{}, false, err
+		}
+		low = binary.LittleEndian.Uint32(entry[0:])
+		high = min(binary.LittleEndian.Uint32(entry[4:]), count-1)
+	}
+
+	ipSize := uint32(4)
+	if addr.Is6() {
+		ipSize = 16
+	}
+	row := make([]byte, rowSize+ipSize)
+	for low <= high {
+		mid := low + (high-low)/2
+		if err := db.readAt(row, base+mid*rowSize); err != nil {
+			return Record{}, iputil.Range{}, false, err
+		}
+		from := parseIP(row[:ipSize])
+		to := parseIP(row[rowSize:])
+
+		switch {
+		case query.Less(from):
+			if mid == 0 {
+				return Record{}, iputil.Range{}, false, nil
+			}
+			high = mid - 1
+		case !query.Less(to):
+			low = mid + 1
+		default:
+			rec, err := db.decodeRow(row[ipSize:rowSize])
+			if err != nil {
+				return Record{}, iputil.Range{}, false, err
+			}
+			last := to.Prev()
+			if !to.Next().IsValid() {
+				// 最后一行一直到地址空间的末尾
+				last = to
+			}
+			return rec, iputil.Range{From: from, To: last}, true, nil
+		}
+	}
+	return Record{}, iputil.Range{}, false, nil
+}
+
+// parseIP 解析行首的小端序起始地址
+func parseIP(b []byte) netip.Addr {
+	if len(b) == 4 {
+		return netip.AddrFrom4([4]byte{b[3], b[2], b[1], b[0]})
+	}
+	var a [16]byte
+	for i := range a {
+		a[i] = b[15-i]
+	}
+	return netip.AddrFrom16(a)
+}
+
+// decodeRow 解码一行中起始地址之后的列
+func (db *DB) decodeRow(cols []byte) (Record, error) {
+	var rec Record
+	var err error
+	// column 返回列的原始值，第 2 列位于 cols 的开头。readHeader 已经检查了列数，这里再防止越界
+	column := func(f Field) (uint32, bool) {
+		pos := positions[f][db.meta.Type]
+		if pos == 0 {
+			return 0, false
+		}
+		off := (int(pos) - 2) * 4
+		if off+4 > len(cols) {
+			if err == nil {
+				err = fmt.Errorf("%w: row has no column %d", ErrFormat, pos)
+			}
+			return 0, false
+		}
+		return binary.LittleEndian.Uint32(cols[off:]), true
+	}
+	str := func(f Field) string {
+		ptr, ok := column(f)
+		if !ok || err != nil {
+			return ""
+		}
+		var s string
+		s, err = db.readString(ptr)
+		return s
+	}
+
+	if ptr, ok := column(Country); ok {
+		if rec.CountryShort, err = db.readString(ptr); err != nil {
+			return rec, err
+		}
+		if rec.CountryLong, err = db.readString(ptr + 3); err != nil {
+			return rec, err
+		}
+	}
+	rec.Region = str(Region)
+	rec.City = str(City)
+	rec.ISP = str(ISP)
+	if v, ok := column(Latitude); ok {
+		rec.Latitude = math.Float32frombits(v)
+	}
+	if v, ok := column(Longitude); ok {
+		rec.Longitude = math.Float32frombits(v)
+	}
+	rec.Domain = str(Domain)
+	rec.ZipCode = str(ZipCode)
+	rec.TimeZone = str(TimeZone)
+	rec.NetSpeed = str(NetSpeed)
+	rec.IDDCode = str(IDDCode)
+	rec.AreaCode = str(AreaCode)
+	rec.WeatherStationCode = str(WeatherStationCode)
+	rec.WeatherStationName = str(WeatherStationName)
+	rec.MCC = str(MCC)
+	rec.MNC = str(MNC)
+	rec.MobileBrand = str(MobileBrand)
+	if s := str(Elevation); s != "" && s != "-" {
+		if v, perr := strconv.ParseFloat(s, 32); perr == nil {
+			rec.Elevation = float32(v)
+		}
+	}
+	rec.UsageType = str(UsageType)
+	rec.AddressType = str(AddressType)
+	rec.Category = str(Category)
+	rec.District = str(District)
+	rec.ASN = str(ASN)
+	rec.AS = str(AS)
+	return rec, err
+}
+
+// readString 读取以长度字节开头的字符串，ptr 从 0 开始计数
+func (db *DB) readString(ptr uint32) (string, error) {
+	var n [1]byte
+	if _, err := db.f.ReadAt(n[:], int64(ptr)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n[0])
+	if _, err := db.f.ReadAt(buf, int64(ptr)+1); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readAt 从从 1 开始计数的地址读取数据
+func (db *DB) readAt(buf []byte, pos uint32) error {
+	_, err := db.f.ReadAt(buf, int64(pos)-1)
+	return err
+}
@@ -0,0 +1,209 @@
+package ip2location
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// binRow 是测试文件中的一行，Country 的值写作 "US|United States"
+type binRow struct {
+	from   string
+	fields map[Field]string
+}
+
+// buildBIN 生成没有索引的 BIN 文件，每个地址族的最后一行一直到地址空间的末尾
+func buildBIN(typ, columns int, v4, v6 []binRow) []byte {
+	le := binary.LittleEndian
+	v4Row, v6Row := columns*4, 16+(columns-1)*4
+	v4Size, v6Size := (len(v4)+1)*v4Row, (len(v6)+1)*v6Row
+	strBase := uint32(headerSize + v4Size + v6Size)
+
+	var strs bytes.Buffer
+	pointer := func(values ...string) uint32 {
+		ptr := strBase + uint32(strs.Len())
+		for _, v := range values {
+			strs.WriteByte(byte(len(v)))
+			strs.WriteString(v)
+		}
+		return ptr
+	}
+	column := func(f Field, row binRow) uint32 {
+		v, ok := row.fields[f]
+		switch {
+		case f == Latitude || f == Longitude:
+			x, _ := strconv.ParseFloat(v, 32)
+			return math.Float32bits(float32(x))
+		case f == Country && ok:
+			short, long, _ := strings.Cut(v, "|")
+			return pointer(short, long)
+		case f == Country:
+			return pointer("-", "-")
+		case !ok:
+			v = "-"
+		}
+		return pointer(v)
+	}
+	table := func(rows []binRow, end string) []byte {
+		var buf bytes.Buffer
+		for _, row := range append(rows, binRow{from: end}) {
+			a := netip.MustParseAddr(row.from).AsSlice()
+			for i := len(a) - 1; i >= 0; i-- {
+				buf.WriteByte(a[i])
+			}
+			for pos := 2; pos <= columns; pos++ {
+				var v uint32
+				for f := range fieldCount {
+					if int(positions[f][typ]) == pos {
+						v = column(f, row)
+					}
+				}
+				buf.Write(le.AppendUint32(nil, v))
+			}
+		}
+		return buf.Bytes()
+	}
+	v4Table := table(v4, "255.255.255.255")
+	v6Table := table(v6, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+
+	h := make([]byte, headerSize)
+	h[0], h[1], h[2], h[3], h[4] = byte(typ), byte(columns), 24, 1, 15
+	le.PutUint32(h[5:], uint32(len(v4)))
+	le.PutUint32(h[9:], headerSize+1)
+	le.PutUint32(h[13:], uint32(len(v6)))
+	le.PutUint32(h[17:], uint32(headerSize+v4Size+1))
+	h[29] = 1
+	return bytes.Join([][]byte{h, v4Table, v6Table, strs.Bytes()}, nil)
+}
+
+func writeBIN(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookupDB26(t *testing.T) {
+	full := map[Field]string{
+		Country: "JP|Japan", Region: "Tokyo", City: "Tokyo", ISP: "Example ISP", Latitude: "35.69", Longitude: "139.69",
+		Domain: "example.jp", ZipCode: "100-0001", TimeZone: "+09:00", NetSpeed: "DSL", IDDCode: "81", AreaCode: "03",
+		WeatherStationCode: "JAXX0085", WeatherStationName: "Tokyo", MCC: "440", MNC: "10", MobileBrand: "NTT",
+		Elevation: "40", UsageType: "ISP", AddressType: "U", Category: "IAB19", District: "Chiyoda", ASN: "64496", AS: "Example",
+	}
+	path := writeBIN(t, buildBIN(26, 25,
+		[]binRow{{from: "0.0.0.0"}, {from: "192.0.2.0", fields: full}, {from: "192.0.3.0"}},
+		[]binRow{{from: "::"}, {from: "2001:db8::", fields: map[Field]string{Country: "DE|Germany", City: "Berlin"}}, {from: "2001:db9::"}},
+	))
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if m := db.Meta(); m.Type != 26 || m.IPv4Count != 3 || m.IPv6Count != 3 || m.Date.Format("2006-01-02") != "2024-01-15" {
+		t.Errorf("meta = %+v", m)
+	}
+
+	rec, rng, found, err := db.Lookup(netip.MustParseAddr("192.0.2.77"))
+	if err != nil || !found {
+		t.Fatalf("lookup: found = %v, err = %v", found, err)
+	}
+	want := Record{
+		CountryShort: "JP", CountryLong: "Japan", Region: "Tokyo", City: "Tokyo", ISP: "Example ISP",
+		Latitude: 35.69, Longitude: 139.69, Domain: "example.jp", ZipCode: "100-0001", TimeZone: "+09:00",
+		NetSpeed: "DSL", IDDCode: "81", AreaCode: "03", WeatherStationCode: "JAXX0085", WeatherStationName: "Tokyo",
+		MCC: "440", MNC: "10", MobileBrand: "NTT", Elevation: 40, UsageType: "ISP", AddressType: "U",
+		Category: "IAB19", District: "Chiyoda", ASN: "64496", AS: "Example",
+	}
+	if rec != want {
+		t.Errorf("record =\n%+v\nwant\n%+v", rec, want)
+	}
+	if rng.From.String() != "192.0.2.0" || rng.To.String() != "192.0.2.255" {
+		t.Errorf("range = %s-%s", rng.From, rng.To)
+	}
+
+	tests := []struct {
+		addr, country, from, to string
+	}{
+		{"::ffff:192.0.2.1", "JP", "192.0.2.0", "192.0.2.255"},
+		{"255.255.255.255", "-", "192.0.3.0", "255.255.255.255"},
+		{"2001:db8::1", "DE", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"2001:db9::1", "-", "2001:db9::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		rec, rng, found, err := db.Lookup(netip.MustParseAddr(tt.addr))
+		if err != nil || !found || rec.CountryShort != tt.country || rng.From.String() != tt.from || rng.To.String() != tt.to {
+			t.Errorf("Lookup(%s) = %q %s-%s %v %v, want %q %s-%s", tt.addr, rec.CountryShort, rng.From, rng.To, found, err, tt.country, tt.from, tt.to)
+		}
+	}
+}
+
+func TestLookupDB1WithoutIPv6(t *testing.T) {
+	path := writeBIN(t, buildBIN(1, 2, []binRow{{from: "0.0.0.0", fields: map[Field]string{Country: "US|United States"}}}, nil))
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rec, _, found, err := db.Lookup(netip.MustParseAddr("8.8.8.8"))
+	if err != nil || !found || rec != (Record{CountryShort: "US", CountryLong: "United States"}) {
+		t.Errorf("IPv4 lookup = %+v, %v, %v", rec, found, err)
+	}
+	if db.Has(City) || !db.Has(Country) {
+		t.Error("DB1 should only have the country column")
+	}
+	if _, _, found, err := db.Lookup(netip.MustParseAddr("2001:db8::1")); found || err != nil {
+		t.Errorf("IPv6 lookup in an IPv4-only file: found = %v, err = %v", found, err)
+	}
+}
+
+func TestOpenMalformedHeader(t *testing.T) {
+	valid := buildBIN(3, 4, []binRow{{from: "0.0.0.0"}}, nil)
+	tests := []struct {
+		name   string
+		data   []byte
+		modify func(h []byte)
+	}{
+		{"short file", valid[:headerSize-1], nil},
+		{"type 0", nil, func(h []byte) { h[0] = 0 }},
+		{"type 27", nil, func(h []byte) { h[0] = 27 }},
+		{"one column", nil, func(h []byte) { h[1] = 1 }},
+		{"month 13", nil, func(h []byte) { h[3] = 13 }},
+		{"unknown product code", nil, func(h []byte) { h[29] = 2 }},
+		{"DB3 with 3 columns", nil, func(h []byte) { h[1] = 3 }},
+		{"DB26 with 2 columns", nil, func(h []byte) { h[0], h[1] = 26, 2 }},
+		{"DB26 with 24 columns", nil, func(h []byte) { h[0], h[1] = 26, 24 }},
+	}
+	for _, tt := range tests {
+		data := tt.data
+		if tt.modify != nil {
+			data = bytes.Clone(valid)
+			tt.modify(data)
+		}
+		db, err := Open(writeBIN(t, data))
+		if err == nil {
+			db.Close()
+		}
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: Open error = %v, want ErrFormat", tt.name, err)
+		}
+	}
+
+	// 2021 年以前的文件没有产品代码
+	old := bytes.Clone(valid)
+	old[2], old[29] = 20, 0
+	db, err := Open(writeBIN(t, old))
+	if err != nil {
+		t.Fatalf("pre-2021 file: %v", err)
+	}
+	db.Close()
+}
@@ -0,0 +1,205 @@
+// Package ip2region 读取 ip2region 的 xdb 格式数据库，支持缓存向量索引和将整个文件读入内存两种模式。
+package ip2region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"ip-api/iputil"
+)
+
+// CachePolicy 决定查询时哪些数据从内存读取
+type CachePolicy int
+
+const (
+	// VectorIndex 缓存文件头之后的向量索引（512 KiB），每次查询读取两到三次磁盘
+	VectorIndex CachePolicy = iota
+	// Content 将整个文件读入内存，查询不访问磁盘
+	Content
+)
+
+const (
+	headerSize      = 256
+	vectorIndexCols = 256
+	vectorIndexSize = 8 // 每个向量索引项是起始和结束的段索引地址
+	segmentSize     = 14
+)
+
+// ErrFormat 表示文件不是支持的 xdb 文件
+var ErrFormat = errors.New("not a valid ip2region xdb file")
+
+// Header 是 xdb 文件头中的元数据
+type Header struct {
+	Version     uint16
+	IndexPolicy uint16
+	CreatedAt   time.Time
+	StartIndex  uint32 // 第一个段索引的地址
+	EndIndex    uint32 // 最后一个段索引的地址
+}
+
+// Searcher 是一个打开的 xdb 文件，可以并发查询
+type Searcher struct {
+	r      io.ReaderAt
+	f      *os.File // Content 模式下为 nil
+	header Header
+	vector []byte
+}
+
+// Open 打开 xdb 文件并按缓存模式加载数据
+func Open(path string, policy CachePolicy) (*Searcher, error) {
+	s := &Searcher{}
+	switch policy {
+	case Content:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s.r = bytes.NewReader(data)
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		s.r, s.f = f, f
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// load 读取文件头和向量索引
+func (s *Searcher) load() error {
+	buf := make([]byte, headerSize+vectorIndexCols*vectorIndexCols*vectorIndexSize)
+	if _, err := s.r.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	le := binary.LittleEndian
+
+	h := Header{
+		Version:     le.Uint16(buf[0:]),
+		IndexPolicy: le.Uint16(buf[2:]),
+		CreatedAt:   time.Unix(int64(le.Uint32(buf[4:])), 0).UTC(),
+		StartIndex:  le.Uint32(buf[8:]),
+		EndIndex:    le.Uint32(buf[12:]),
+	}
+	// 只支持 IPv4 的结构版本 2；版本 3 在偏移 16 处记录 IP 版本，其中的 IPv4 文件结构相同
+	switch {
+	case h.Version == 2:
+	case h.Version == 3 && le.Uint16(buf[16:]) == 4:
+	default:
+		return fmt.Errorf("%w: unsupported structure version %d", ErrFormat, h.Version)
+	}
+	if h.EndIndex < h.StartIndex || (h.EndIndex-h.StartIndex)%segmentSize != 0 {
+		return fmt.Errorf("%w: invalid segment index %d-%d", ErrFormat, h.StartIndex, h.EndIndex)
+	}
+
+	s.header = h
+	s.vector = buf[headerSize:]
+	return nil
+}
+
+// Close 关闭文件
+func (s *Searcher) Close() error {
+	if s.f != nil {
+		return s.f.Close()
+	}
+	return nil
+}
+
+// Header 返回文件头中的元数据
+func (s *Searcher) Header() Header {
+	return s.header
+}
+
+// Lookup 查询地址所在的段，返回原始的区域字符串和该段覆盖的地址范围。
+// xdb 只包含 IPv4 数据，IPv6 地址总是返回未找到。
+func (s *Searcher) Lookup(addr netip.Addr) (string, iputil.Range, bool, error) {
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return "", iputil.Range{}, false, nil
+	}
+	b := addr.As4()
+	ip := binary.BigEndian.Uint32(b[:])
+
+	// 向量索引按前两个字节给出段索引的查找范围
+	i := (int(b[0])*vectorIndexCols + int(b[1])) * vectorIndexSize
+	start := binary.LittleEndian.Uint32(s.vector[i:])
+	end := binary.LittleEndian.Uint32(s.vector[i+4:])
+	if start == 0 || end < start {
+		return "", iputil.Range{}, false, nil
+	}
+	if start < s.header.StartIndex || end > s.header.EndIndex || (end-start)%segmentSize != 0 {
+		return "", iputil.Range{}, false, fmt.Errorf("%w: vector index %d-%d is outside the segment index", ErrFormat, start, end)
+	}
+
+	var seg [segmentSize]byte
+	low, high := 0, int((end-start)/segmentSize)
+	for low <= high {
+		mid := (low + high) / 2
+		if _, err := s.r.ReadAt(seg[:], int64(start)+int64(mid*segmentSize)); err != nil {
+			return "", iputil.Range{}, false, err
+		}
+		from := binary.LittleEndian.Uint32(seg[0:])
+		to := binary.LittleEndian.Uint32(seg[4:])
+
+		switch {
+		case ip < from:
+			high = mid - 1
+		case ip > to:
+			low = mid + 1
+		default:
+			n := binary.LittleEndian.Uint16(seg[8:])
+			ptr := binary.LittleEndian.Uint32(seg[10:])
+			data := make([]byte, n)
+			if _, err := s.r.ReadAt(data, int64(ptr)); err != nil {
+				return "", iputil.Range{}, false, err
+			}
+			return string(data), iputil.Range{From: addrFrom(from), To: addrFrom(to)}, true, nil
+		}
+	}
+	return "", iputil.Range{}, false, nil
+}
+
+// addrFrom 将整数形式的 IPv4 地址转换为 netip.Addr
+func addrFrom(ip uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return netip.AddrFrom4(b)
+}
+
+// Region 是解析后的区域字符串，ip2region 用 "0" 表示未知的值
+type Region struct {
+	Country  string
+	Region   string
+	Province string
+	City     string
+	ISP      string
+}
+
+// ParseRegion 解析 country|region|province|city|isp 格式的区域字符串
+func ParseRegion(s string) Region {
+	parts := strings.Split(s, "|")
+	field := func(i int) string {
+		if i >= len(parts) || parts[i] == "0" {
+			return ""
+		}
+		return strings.TrimSpace(parts[i])
+	}
+	return Region{
+		Country:  field(0),
+		Region:   field(1),
+		Province: field(2),
+		City:     field(3),
+		ISP:      field(4),
+	}
+}
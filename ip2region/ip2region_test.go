@@ -0,0 +1,184 @@
+package ip2region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// segment 是测试文件中的一段地址
+type segment struct {
+	from, to, region string
+}
+
+// buildXDB 生成结构版本 2 的 xdb 文件。与 ip2region 的生成工具一样，跨越 /16 边界的段被拆开，
+// 每个向量索引项只指向同一个 /16 中的段。
+func buildXDB(segments []segment) []byte {
+	le := binary.LittleEndian
+	type split struct {
+		from, to uint32
+		ptr      uint32
+		n        uint16
+	}
+
+	vectorEnd := headerSize + vectorIndexCols*vectorIndexCols*vectorIndexSize
+	var regions bytes.Buffer
+	var splits []split
+	for _, s := range segments {
+		ptr := uint32(vectorEnd + regions.Len())
+		regions.WriteString(s.region)
+		from4, to4 := netip.MustParseAddr(s.from).As4(), netip.MustParseAddr(s.to).As4()
+		from, to := binary.BigEndian.Uint32(from4[:]), binary.BigEndian.Uint32(to4[:])
+		for from <= to {
+			end := min(to, from|0xffff)
+			splits = append(splits, split{from, end, ptr, uint16(len(s.region))})
+			if end == 0xffffffff {
+				break
+			}
+			from = end + 1
+		}
+	}
+
+	data := make([]byte, vectorEnd)
+	data = append(data, regions.Bytes()...)
+	startIndex := uint32(len(data))
+	for _, s := range splits {
+		var seg [segmentSize]byte
+		le.PutUint32(seg[0:], s.from)
+		le.PutUint32(seg[4:], s.to)
+		le.PutUint16(seg[8:], s.n)
+		le.PutUint32(seg[10:], s.ptr)
+		ptr := uint32(len(data))
+		data = append(data, seg[:]...)
+
+		i := headerSize + int(s.from>>16)*vectorIndexSize
+		if le.Uint32(data[i:]) == 0 {
+			le.PutUint32(data[i:], ptr)
+		}
+		le.PutUint32(data[i+4:], ptr)
+	}
+
+	le.PutUint16(data[0:], 2)
+	le.PutUint16(data[2:], 1)
+	le.PutUint32(data[4:], uint32(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC).Unix()))
+	le.PutUint32(data[8:], startIndex)
+	le.PutUint32(data[12:], uint32(len(data)-segmentSize))
+	return data
+}
+
+func writeXDB(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.xdb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	path := writeXDB(t, buildXDB([]segment{
+		{"1.0.0.0", "1.0.0.255", "中国|0|福建省|福州市|电信"},
+		{"1.0.1.0", "1.1.255.255", "中国|0|广东省|深圳市|联通"},
+		{"8.8.8.0", "8.8.8.255", "美国|0|0|0|Level3"},
+	}))
+
+	tests := []struct {
+		addr, region, from, to string
+		found                  bool
+	}{
+		{"1.0.0.1", "中国|0|福建省|福州市|电信", "1.0.0.0", "1.0.0.255", true},
+		{"1.0.200.1", "中国|0|广东省|深圳市|联通", "1.0.1.0", "1.0.255.255", true},
+		{"1.1.0.1", "中国|0|广东省|深圳市|联通", "1.1.0.0", "1.1.255.255", true},
+		{"::ffff:8.8.8.8", "美国|0|0|0|Level3", "8.8.8.0", "8.8.8.255", true},
+		{"8.8.4.4", "", "", "", false},
+		{"2001:db8::1", "", "", "", false},
+	}
+	for _, policy := range []CachePolicy{VectorIndex, Content} {
+		s, err := Open(path, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := s.Header(); h.Version != 2 || h.CreatedAt.Format("2006-01-02") != "2024-01-15" {
+			t.Errorf("header = %+v", h)
+		}
+		for _, tt := range tests {
+			region, rng, found, err := s.Lookup(netip.MustParseAddr(tt.addr))
+			if err != nil || found != tt.found || region != tt.region {
+				t.Errorf("policy %d: Lookup(%s) = %q, %v, %v; want %q", policy, tt.addr, region, found, err, tt.region)
+				continue
+			}
+			if found && (rng.From.String() != tt.from || rng.To.String() != tt.to) {
+				t.Errorf("policy %d: Lookup(%s) range = %s-%s, want %s-%s", policy, tt.addr, rng.From, rng.To, tt.from, tt.to)
+			}
+		}
+		s.Close()
+	}
+}
+
+func TestOpenMalformedHeader(t *testing.T) {
+	valid := buildXDB([]segment{{"1.0.0.0", "1.0.0.255", "中国|0|福建省|福州市|电信"}})
+	le := binary.LittleEndian
+	tests := []struct {
+		name   string
+		data   []byte
+		modify func(b []byte)
+	}{
+		{"short file", valid[:headerSize+100], nil},
+		{"structure version 1", nil, func(b []byte) { le.PutUint16(b[0:], 1) }},
+		{"structure version 3 with IPv6", nil, func(b []byte) { le.PutUint16(b[0:], 3); le.PutUint16(b[16:], 6) }},
+		{"end index before start index", nil, func(b []byte) { le.PutUint32(b[12:], le.Uint32(b[8:])-segmentSize) }},
+		{"misaligned segment index", nil, func(b []byte) { le.PutUint32(b[12:], le.Uint32(b[12:])+1) }},
+	}
+	for _, tt := range tests {
+		data := tt.data
+		if tt.modify != nil {
+			data = bytes.Clone(valid)
+			tt.modify(data)
+		}
+		s, err := Open(writeXDB(t, data), VectorIndex)
+		if err == nil {
+			s.Close()
+		}
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: Open error = %v, want ErrFormat", tt.name, err)
+		}
+	}
+
+	v3 := bytes.Clone(valid)
+	le.PutUint16(v3[0:], 3)
+	le.PutUint16(v3[16:], 4)
+	s, err := Open(writeXDB(t, v3), VectorIndex)
+	if err != nil {
+		t.Fatalf("structure version 3 with IPv4: %v", err)
+	}
+	s.Close()
+}
+
+func TestLookupCorruptVectorIndex(t *testing.T) {
+	data := buildXDB([]segment{{"1.0.0.0", "1.0.0.255", "中国|0|福建省|福州市|电信"}})
+	// 1.0.0.0/16 的向量索引项指向文件头
+	binary.LittleEndian.PutUint32(data[headerSize+256*vectorIndexSize:], 16)
+	s, err := Open(writeXDB(t, data), Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, _, err := s.Lookup(netip.MustParseAddr("1.0.0.1")); !errors.Is(err, ErrFormat) {
+		t.Errorf("Lookup error = %v, want ErrFormat", err)
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	want := Region{Country: "中国", Province: "广东省", City: "深圳市", ISP: "电信"}
+	if got := ParseRegion("中国|0|广东省|深圳市|电信"); got != want {
+		t.Errorf("ParseRegion = %+v, want %+v", got, want)
+	}
+	if got := ParseRegion("美国|0"); got != (Region{Country: "美国"}) {
+		t.Errorf("ParseRegion of a short string = %+v", got)
+	}
+}
@@ -0,0 +1,209 @@
+// Package ipclass 根据 IANA IPv4/IPv6 特殊用途地址注册表和 bogon 列表对地址分类。
+package ipclass
+
+import (
+	"net/netip"
+)
+
+// Classification 描述一个特殊用途地址块，字段与 IANA 注册表的列对应
+type Classification struct {
+	Network            string `json:"network"`
+	Name               string `json:"name"`
+	RFC                string `json:"rfc"`
+	Source             bool   `json:"source"`
+	Destination        bool   `json:"destination"`
+	Forwardable        bool   `json:"forwardable"`
+	GloballyReachable  bool   `json:"globally_reachable"`
+	ReservedByProtocol bool   `json:"reserved_by_protocol"`
+	// Bogon 表示地址不应出现在公网路由中
+	Bogon bool `json:"bogon"`
+	// Private 表示地址块供私有网络使用（RFC 1918、共享地址空间、ULA）
+	Private bool `json:"-"`
+}
+
+// entry 是注册表中的一行
+type entry struct {
+	prefix netip.Prefix
+	class  Classification
+}
+
+// flags 按注册表的列顺序：source、destination、forwardable、globally reachable、reserved-by-protocol
+type flags [5]bool
+
+func block(cidr, name, rfc string, f flags) entry {
+	p := netip.MustParsePrefix(cidr)
+	return entry{prefix: p, class: Classification{
+		Network:            p.String(),
+		Name:               name,
+		RFC:                rfc,
+		Source:             f[0],
+		Destination:        f[1],
+		Forwardable:        f[2],
+		GloballyReachable:  f[3],
+		ReservedByProtocol: f[4],
+		Bogon:              !f[3],
+	}}
+}
+
+func private(e entry) entry {
+	e.class.Private = true
+	return e
+}
+
+// registry 是 IANA IPv4 和 IPv6 特殊用途地址注册表，另加组播地址块。
+// 注册表中 globally reachable 为 N/A 的 6to4 和 Teredo 按可达处理，以便查询其中嵌入的地址。
+// 注册表中的 ::ffff:0:0/96（IPv4-mapped Address）没有列出，Classify 按其中的 IPv4 地址分类。
+var registry = []entry{
+	// IPv4：https://www.iana.org/assignments/iana-ipv4-special-registry
+	block("0.0.0.0/8", "This network", "RFC 791", flags{true, false, false, false, true}),
+	block("0.0.0.0/32", "This host on this network", "RFC 1122", flags{true, false, false, false, true}),
+	private(block("10.0.0.0/8", "Private-Use", "RFC 1918", flags{true, true, true, false, false})),
+	private(block("100.64.0.0/10", "Shared Address Space", "RFC 6598", flags{true, true, true, false, false})),
+	block("127.0.0.0/8", "Loopback", "RFC 1122", flags{false, false, false, false, true}),
+	block("169.254.0.0/16", "Link Local", "RFC 3927", flags{true, true, false, false, true}),
+	private(block("172.16.0.0/12", "Private-Use", "RFC 1918", flags{true, true, true, false, false})),
+	block("192.0.0.0/24", "IETF Protocol Assignments", "RFC 6890", flags{false, false, false, false, false}),
+	block("192.0.0.0/29", "IPv4 Service Continuity Prefix", "RFC 7335", flags{true, true, true, false, false}),
+	block("192.0.0.8/32", "IPv4 dummy address", "RFC 7600", flags{true, false, false, false, false}),
+	block("192.0.0.9/32", "Port Control Protocol Anycast", "RFC 7723", flags{true, true, true, true, false}),
+	block("192.0.0.10/32", "Traversal Using Relays around NAT Anycast", "RFC 8155", flags{true, true, true, true, false}),
+	block("192.0.0.170/32", "NAT64/DNS64 Discovery", "RFC 8880", flags{false, false, false, false, true}),
+	block("192.0.0.171/32", "NAT64/DNS64 Discovery", "RFC 8880", flags{false, false, false, false, true}),
+	block("192.0.2.0/24", "Documentation (TEST-NET-1)", "RFC 5737", flags{false, false, false, false, false}),
+	block("192.31.196.0/24", "AS112-v4", "RFC 7535", flags{true, true, true, true, false}),
+	block("192.52.193.0/24", "AMT", "RFC 7450", flags{true, true, true, true, false}),
+	block("192.88.99.0/24", "Deprecated (6to4 Relay Anycast)", "RFC 7526", flags{false, false, false, false, false}),
+	private(block("192.168.0.0/16", "Private-Use", "RFC 1918", flags{true, true, true, false, false})),
+	block("192.175.48.0/24", "Direct Delegation AS112 Service", "RFC 7534", flags{true, true, true, true, false}),
+	block("198.18.0.0/15", "Benchmarking", "RFC 2544", flags{true, true, true, false, false}),
+	block("198.51.100.0/24", "Documentation (TEST-NET-2)", "RFC 5737", flags{false, false, false, false, false}),
+	block("203.0.113.0/24", "Documentation (TEST-NET-3)", "RFC 5737", flags{false, false, false, false, false}),
+	block("224.0.0.0/4", "Multicast", "RFC 5771", flags{false, true, true, false, false}),
+	block("240.0.0.0/4", "Reserved", "RFC 1112", flags{false, false, false, false, true}),
+	block("255.255.255.255/32", "Limited Broadcast", "RFC 919", flags{false, true, false, false, true}),
+
+	// IPv6：https://www.iana.org/assignments/iana-ipv6-special-registry
+	block("::1/128", "Loopback Address", "RFC 4291", flags{false, false, false, false, true}),
+	block("::/128", "Unspecified Address", "RFC 4291", flags{true, false, false, false, true}),
+	block("64:ff9b::/96", "IPv4-IPv6 Translation", "RFC 6052", flags{true, true, true, true, false}),
+	block("64:ff9b:1::/48", "Local-Use IPv4/IPv6 Translation", "RFC 8215", flags{true, true, true, false, false}),
+	block("100::/64", "Discard-Only Address Block", "RFC 6666", flags{true, true, true, false, false}),
+	block("2001::/23", "IETF Protocol Assignments", "RFC 2928", flags{false, false, false, false, false}),
+	block("2001::/32", "TEREDO", "RFC 4380", flags{true, true, true, true, false}),
+	block("2001:1::1/128", "Port Control Protocol Anycast", "RFC 7723", flags{true, true, true, true, false}),
+	block("2001:1::2/128", "Traversal Using Relays around NAT Anycast", "RFC 8155", flags{true, true, true, true, false}),
+	block("2001:2::/48", "Benchmarking", "RFC 5180", flags{true, true, true, false, false}),
+	block("2001:3::/32", "AMT", "RFC 7450", flags{true, true, true, true, false}),
+	block("2001:4:112::/48", "AS112-v6", "RFC 7535", flags{true, true, true, true, false}),
+	block("2001:10::/28", "Deprecated (previously ORCHID)", "RFC 4843", flags{false, false, false, false, false}),
+	block("2001:20::/28", "ORCHIDv2", "RFC 7343", flags{true, true, true, true, false}),
+	block("2001:30::/28", "Drone Remote ID Protocol Entity Tags (DETs) Prefix", "RFC 9374", flags{true, true, true, true, false}),
+	block("2001:db8::/32", "Documentation", "RFC 3849", flags{false, false, false, false, false}),
+	block("2002::/16", "6to4", "RFC 3056", flags{true, true, true, true, false}),
+	block("2620:4f:8000::/48", "Direct Delegation AS112 Service", "RFC 7534", flags{true, true, true, true, false}),
+	block("3fff::/20", "Documentation", "RFC 9637", flags{false, false, false, false, false}),
+	block("5f00::/16", "Segment Routing (SRv6) SIDs", "RFC 9602", flags{true, true, true, false, false}),
+	private(block("fc00::/7", "Unique-Local", "RFC 4193", flags{true, true, true, false, false})),
+	block("fe80::/10", "Link-Local Unicast", "RFC 4291", flags{true, true, false, false, true}),
+	block("ff00::/8", "Multicast", "RFC 4291", flags{false, true, true, false, false}),
+}
+
+// allocated 是 IANA IPv6 全球单播地址分配表中分配给 RIR 或特殊用途的地址块，
+// 不在其中的 IPv6 地址都是 bogon。2001::/16 中 IANA 保留的 2001:3c00::/22 不在列表中。
+// 来源：https://www.iana.org/assignments/ipv6-unicast-address-assignments（2024-09 的快照）
+var allocated = []netip.Prefix{
+	netip.MustParsePrefix("2001::/23"), // IANA 特殊用途
+	netip.MustParsePrefix("2001:200::/23"),
+	netip.MustParsePrefix("2001:400::/23"),
+	netip.MustParsePrefix("2001:600::/23"),
+	netip.MustParsePrefix("2001:800::/22"),
+	netip.MustParsePrefix("2001:c00::/23"),
+	netip.MustParsePrefix("2001:e00::/23"),
+	netip.MustParsePrefix("2001:1200::/23"),
+	netip.MustParsePrefix("2001:1400::/22"),
+	netip.MustParsePrefix("2001:1800::/23"),
+	netip.MustParsePrefix("2001:1a00::/23"),
+	netip.MustParsePrefix("2001:1c00::/22"),
+	netip.MustParsePrefix("2001:2000::/20"),
+	netip.MustParsePrefix("2001:3000::/21"),
+	netip.MustParsePrefix("2001:3800::/22"),
+	netip.MustParsePrefix("2001:4000::/23"),
+	netip.MustParsePrefix("2001:4200::/23"),
+	netip.MustParsePrefix("2001:4400::/23"),
+	netip.MustParsePrefix("2001:4600::/23"),
+	netip.MustParsePrefix("2001:4800::/23"),
+	netip.MustParsePrefix("2001:4a00::/23"),
+	netip.MustParsePrefix("2001:4c00::/23"),
+	netip.MustParsePrefix("2001:5000::/20"),
+	netip.MustParsePrefix("2001:8000::/19"),
+	netip.MustParsePrefix("2001:a000::/20"),
+	netip.MustParsePrefix("2001:b000::/20"),
+	netip.MustParsePrefix("2002::/16"), // 6to4
+	netip.MustParsePrefix("2003::/18"),
+	netip.MustParsePrefix("2400::/12"),
+	netip.MustParsePrefix("2410::/12"),
+	netip.MustParsePrefix("2600::/12"),
+	netip.MustParsePrefix("2610::/23"),
+	netip.MustParsePrefix("2620::/23"),
+	netip.MustParsePrefix("2630::/12"),
+	netip.MustParsePrefix("2800::/12"),
+	netip.MustParsePrefix("2a00::/12"),
+	netip.MustParsePrefix("2a10::/12"),
+	netip.MustParsePrefix("2c00::/12"),
+}
+
+// Classify 返回地址所属的最具体的特殊用途地址块。
+// 不在注册表中、且不在 IANA 全球单播分配表中的 IPv6 地址归类为未分配的 bogon。
+// 普通的全球单播地址返回 false。IPv4 映射地址按其中的 IPv4 地址分类。
+func Classify(addr netip.Addr) (Classification, bool) {
+	addr = addr.Unmap().WithZone("")
+
+	var best *entry
+	for i := range registry {
+		e := &registry[i]
+		if e.prefix.Contains(addr) && (best == nil || e.prefix.Bits() > best.prefix.Bits()) {
+			best = e
+		}
+	}
+	if best != nil {
+		return best.class, true
+	}
+
+	if addr.Is6() && !isAllocated(addr) {
+		return Classification{
+			Network: unallocatedBlock(addr).String(),
+			Name:    "Unallocated",
+			RFC:     "RFC 4291",
+			Bogon:   true,
+		}, true
+	}
+	return Classification{}, false
+}
+
+// isAllocated 判断 IPv6 地址是否在 IANA 分配的地址块中
+func isAllocated(addr netip.Addr) bool {
+	for _, p := range allocated {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// unallocatedBlock 返回包含地址、且不与任何已分配地址块重叠的最大地址块（最大为 /3），
+// 用于描述未分配的 IPv6 空间
+func unallocatedBlock(addr netip.Addr) netip.Prefix {
+	for bits := 3; ; bits++ {
+		p, _ := addr.Prefix(bits)
+		overlaps := false
+		for _, a := range allocated {
+			if a.Overlaps(p) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps || bits == 128 {
+			return p
+		}
+	}
+}
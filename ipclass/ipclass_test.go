@@ -0,0 +1,51 @@
+package ipclass
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		addr      string
+		ok        bool
+		network   string
+		name      string
+		reachable bool
+		bogon     bool
+	}{
+		{"8.8.8.8", false, "", "", false, false},
+		{"10.1.2.3", true, "10.0.0.0/8", "Private-Use", false, true},
+		{"::ffff:10.1.2.3", true, "10.0.0.0/8", "Private-Use", false, true},
+		{"192.0.0.9", true, "192.0.0.9/32", "Port Control Protocol Anycast", true, false},
+		{"192.0.0.100", true, "192.0.0.0/24", "IETF Protocol Assignments", false, true},
+		{"::1", true, "::1/128", "Loopback Address", false, true},
+		{"fe80::1%eth0", true, "fe80::/10", "Link-Local Unicast", false, true},
+		{"2001::1", true, "2001::/32", "TEREDO", true, false},
+		{"2001:db8::1", true, "2001:db8::/32", "Documentation", false, true},
+		{"2002:c000:201::", true, "2002::/16", "6to4", true, false},
+		{"2001:4860:4860::8888", false, "", "", false, false},
+		{"2409:8000::1", false, "", "", false, false},
+		{"2a10:1::1", false, "", "", false, false},
+		{"2001:3c00::1", true, "2001:3c00::/22", "Unallocated", false, true},
+		{"2004::1", true, "2004::/14", "Unallocated", false, true},
+		{"2d00::1", true, "2d00::/8", "Unallocated", false, true},
+		{"3000::1", true, "3000::/4", "Unallocated", false, true},
+		{"4000::1", true, "4000::/3", "Unallocated", false, true},
+	}
+	for _, tt := range tests {
+		c, ok := Classify(netip.MustParseAddr(tt.addr))
+		if ok != tt.ok || c.Network != tt.network || c.Name != tt.name || c.GloballyReachable != tt.reachable || c.Bogon != tt.bogon {
+			t.Errorf("Classify(%s) = %+v, %v; want %s %q reachable=%v bogon=%v",
+				tt.addr, c, ok, tt.network, tt.name, tt.reachable, tt.bogon)
+		}
+	}
+}
+
+func TestPrivate(t *testing.T) {
+	for addr, want := range map[string]bool{"100.64.0.1": true, "fd00::1": true, "127.0.0.1": false, "2001:2::1": false} {
+		if c, _ := Classify(netip.MustParseAddr(addr)); c.Private != want {
+			t.Errorf("Classify(%s).Private = %v, want %v", addr, c.Private, want)
+		}
+	}
+}
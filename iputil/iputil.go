@@ -0,0 +1,332 @@
+// Package iputil 提供 IP 地址范围和前缀的计算工具，
+// 用于遍历、比较和聚合 MMDB 中的网络。
+package iputil
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Range 是一个闭区间 [From, To] 的地址范围，From 和 To 属于同一地址族
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// PrefixFromIPNet 将 net.IPNet 转换为 netip.Prefix，4 字节的地址保持为 IPv4
+func PrefixFromIPNet(n *net.IPNet) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(n.IP)
+	ones, _ := n.Mask.Size()
+	return netip.PrefixFrom(addr, ones)
+}
+
+// IPNetFromPrefix 将 netip.Prefix 转换为 net.IPNet
+func IPNetFromPrefix(p netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   net.IP(p.Addr().AsSlice()),
+		Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}
+}
+
+// PrefixRange 返回前缀覆盖的地址范围
+func PrefixRange(p netip.Prefix) Range {
+	p = p.Masked()
+	return Range{From: p.Addr(), To: lastAddr(p)}
+}
+
+// ParseRange 解析 CIDR（1.0.0.0/16）或起止地址（1.0.0.0-1.0.255.255）形式的地址范围，
+// CIDR 中的主机位被忽略，IPv4 映射的 IPv6 地址按 IPv4 处理
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid network %q", s)
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return PrefixRange(p), nil
+	}
+
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+	from, err1 := netip.ParseAddr(strings.TrimSpace(start))
+	to, err2 := netip.ParseAddr(strings.TrimSpace(end))
+	if err1 != nil || err2 != nil {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+	from, to = from.Unmap().WithZone(""), to.Unmap().WithZone("")
+	if from.BitLen() != to.BitLen() || to.Less(from) {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+	return Range{From: from, To: to}, nil
+}
+
+// lastAddr 返回前缀中的最后一个地址
+func lastAddr(p netip.Prefix) netip.Addr {
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		for i := p.Bits(); i < 32; i++ {
+			b[i/8] |= 1 << (7 - uint(i%8))
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	for i := p.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// Contains 检查地址是否在范围内
+func (r Range) Contains(a netip.Addr) bool {
+	return a.Compare(r.From) >= 0 && a.Compare(r.To) <= 0
+}
+
+// Size 返回范围中的地址数量
+func (r Range) Size() Count {
+	return toCount(r.To).Sub(toCount(r.From)).Add(Count{Lo: 1})
+}
+
+// Prefixes 将范围拆分为最少的 CIDR 前缀列表
+func (r Range) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	from := r.From
+	for from.IsValid() && from.Compare(r.To) <= 0 {
+		width := from.BitLen()
+		// 从起始地址的对齐方式允许的最大块开始，逐步缩小直到不超出范围
+		length := width - toCount(from).trailingZeros(width)
+		var p netip.Prefix
+		for ; length <= width; length++ {
+			p = netip.PrefixFrom(from, length)
+			if lastAddr(p).Compare(r.To) <= 0 {
+				break
+			}
+		}
+		prefixes = append(prefixes, p)
+		from = lastAddr(p).Next()
+	}
+	return prefixes
+}
+
+// Merge 排序并合并重叠或相邻的范围，不同地址族的范围不会被合并
+func Merge(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := make([]Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From.Less(sorted[j].From)
+	})
+
+	merged := []Range{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		next := last.To.Next()
+		if r.From.BitLen() == last.To.BitLen() && (r.From.Compare(last.To) <= 0 || r.From == next) {
+			if r.To.Compare(last.To) > 0 {
+				last.To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Intersect 返回两个已合并（有序且不重叠）的范围列表的交集
+func Intersect(a, b []Range) []Range {
+	var out []Range
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		from := a[i].From
+		if b[j].From.Compare(from) > 0 {
+			from = b[j].From
+		}
+		to := a[i].To
+		if b[j].To.Compare(to) < 0 {
+			to = b[j].To
+		}
+		if from.Compare(to) <= 0 {
+			out = append(out, Range{From: from, To: to})
+		}
+		if a[i].To.Compare(b[j].To) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// Aggregate 将前缀列表合并为覆盖相同地址的最少前缀
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	ranges := make([]Range, 0, len(prefixes))
+	for _, p := range prefixes {
+		ranges = append(ranges, PrefixRange(p))
+	}
+	var out []netip.Prefix
+	for _, r := range Merge(ranges) {
+		out = append(out, r.Prefixes()...)
+	}
+	return out
+}
+
+// Count 是一个 128 位的地址数量
+type Count struct {
+	Hi, Lo uint64
+}
+
+// PrefixSize 返回前缀中的地址数量
+func PrefixSize(p netip.Prefix) Count {
+	host := uint(p.Addr().BitLen() - p.Bits())
+	if host >= 64 {
+		return Count{Hi: 1 << (host - 64)}
+	}
+	return Count{Lo: 1 << host}
+}
+
+// toCount 将地址转换为 128 位整数，IPv4 地址使用 32 位值
+func toCount(a netip.Addr) Count {
+	if a.Is4() {
+		b := a.As4()
+		return Count{Lo: uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])}
+	}
+	b := a.As16()
+	var c Count
+	for i := 0; i < 8; i++ {
+		c.Hi = c.Hi<<8 | uint64(b[i])
+		c.Lo = c.Lo<<8 | uint64(b[i+8])
+	}
+	return c
+}
+
+// Add 返回 c + o
+func (c Count) Add(o Count) Count {
+	lo, carry := bits.Add64(c.Lo, o.Lo, 0)
+	hi, _ := bits.Add64(c.Hi, o.Hi, carry)
+	return Count{Hi: hi, Lo: lo}
+}
+
+// Sub 返回 c - o
+func (c Count) Sub(o Count) Count {
+	lo, borrow := bits.Sub64(c.Lo, o.Lo, 0)
+	hi, _ := bits.Sub64(c.Hi, o.Hi, borrow)
+	return Count{Hi: hi, Lo: lo}
+}
+
+// Cmp 比较 c 和 o，返回 -1、0 或 1
+func (c Count) Cmp(o Count) int {
+	switch {
+	case c.Hi < o.Hi || (c.Hi == o.Hi && c.Lo < o.Lo):
+		return -1
+	case c == o:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// IsZero 检查数量是否为零
+func (c Count) IsZero() bool {
+	return c.Hi == 0 && c.Lo == 0
+}
+
+// trailingZeros 返回低 width 位中末尾零的个数
+func (c Count) trailingZeros(width int) int {
+	n := 128
+	if c.Lo != 0 {
+		n = bits.TrailingZeros64(c.Lo)
+	} else if c.Hi != 0 {
+		n = 64 + bits.TrailingZeros64(c.Hi)
+	}
+	if n > width {
+		n = width
+	}
+	return n
+}
+
+// Big 返回数量的 big.Int 表示
+func (c Count) Big() *big.Int {
+	v := new(big.Int).SetUint64(c.Hi)
+	v.Lsh(v, 64)
+	return v.Or(v, new(big.Int).SetUint64(c.Lo))
+}
+
+// Float64 返回数量的近似浮点值
+func (c Count) Float64() float64 {
+	return float64(c.Hi)*(1<<64) + float64(c.Lo)
+}
+
+// String 返回数量的十进制表示
+func (c Count) String() string {
+	return c.Big().String()
+}
+
+// MarshalJSON 将数量编码为 JSON 数字
+func (c Count) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON 从 JSON 数字解码数量
+func (c *Count) UnmarshalJSON(data []byte) error {
+	v, ok := new(big.Int).SetString(string(data), 10)
+	if !ok || v.Sign() < 0 || v.BitLen() > 128 {
+		return fmt.Errorf("invalid address count %s", data)
+	}
+	c.Lo = new(big.Int).And(v, new(big.Int).SetUint64(^uint64(0))).Uint64()
+	c.Hi = new(big.Int).Rsh(v, 64).Uint64()
+	return nil
+}
+
+// 嵌入 IPv4 地址的 IPv6 地址类型
+const (
+	Embedded6to4   = "6to4"        // 2002::/16，RFC 3056
+	EmbeddedTeredo = "teredo"      // 2001::/32，RFC 4380
+	EmbeddedNAT64  = "nat64"       // 64:ff9b::/96，RFC 6052
+	EmbeddedMapped = "ipv4-mapped" // ::ffff:0:0/96，RFC 4291
+)
+
+// Embedded 是从 IPv6 地址中解出的 IPv4 地址
+type Embedded struct {
+	Kind string
+	IPv4 netip.Addr // 客户端的 IPv4 地址
+	// Server 和 Port 仅用于 Teredo：Teredo 服务器地址和客户端 NAT 映射的外部端口
+	Server netip.Addr
+	Port   uint16
+}
+
+// EmbeddedIPv4 检查 IPv6 地址是否为 6to4、Teredo、NAT64 知名前缀或 IPv4 映射地址，
+// 并解出其中的 IPv4 地址
+func EmbeddedIPv4(a netip.Addr) (Embedded, bool) {
+	if !a.Is6() {
+		return Embedded{}, false
+	}
+	b := a.As16()
+	switch {
+	case a.Is4In6():
+		return Embedded{Kind: EmbeddedMapped, IPv4: a.Unmap()}, true
+	case b[0] == 0x20 && b[1] == 0x02:
+		return Embedded{Kind: Embedded6to4, IPv4: netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]})}, true
+	case b[0] == 0x20 && b[1] == 0x01 && b[2] == 0 && b[3] == 0:
+		// Teredo 的客户端地址和端口按位取反存储
+		return Embedded{
+			Kind:   EmbeddedTeredo,
+			IPv4:   netip.AddrFrom4([4]byte{^b[12], ^b[13], ^b[14], ^b[15]}),
+			Server: netip.AddrFrom4([4]byte{b[4], b[5], b[6], b[7]}),
+			Port:   ^(uint16(b[10])<<8 | uint16(b[11])),
+		}, true
+	case b[0] == 0 && b[1] == 0x64 && b[2] == 0xff && b[3] == 0x9b && b[4]|b[5]|b[6]|b[7]|b[8]|b[9]|b[10]|b[11] == 0:
+		return Embedded{Kind: EmbeddedNAT64, IPv4: netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]})}, true
+	}
+	return Embedded{}, false
+}
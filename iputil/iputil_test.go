@@ -0,0 +1,180 @@
+package iputil
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func rng(from, to string) Range {
+	return Range{From: netip.MustParseAddr(from), To: netip.MustParseAddr(to)}
+}
+
+func prefixes(ss ...string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range ss {
+		out = append(out, netip.MustParsePrefix(s))
+	}
+	return out
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Range
+		ok   bool
+	}{
+		{"1.0.0.0/16", rng("1.0.0.0", "1.0.255.255"), true},
+		{"1.0.3.4/16", rng("1.0.0.0", "1.0.255.255"), true},
+		{" 1.0.0.0 - 1.0.0.9 ", rng("1.0.0.0", "1.0.0.9"), true},
+		{"::ffff:10.0.0.0/104", rng("10.0.0.0", "10.255.255.255"), true},
+		{"::ffff:10.0.0.1-::ffff:10.0.0.2", rng("10.0.0.1", "10.0.0.2"), true},
+		{"2001:db8::/32", rng("2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"), true},
+		{"1.0.0.9-1.0.0.0", Range{}, false},
+		{"1.0.0.0-::1", Range{}, false},
+		{"1.0.0.0/33", Range{}, false},
+		{"1.0.0.0", Range{}, false},
+		{"bogus-1.0.0.0", Range{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseRange(%q) = %v, %v; want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	tests := []struct {
+		r    Range
+		want []netip.Prefix
+	}{
+		{rng("10.0.0.0", "10.0.0.255"), prefixes("10.0.0.0/24")},
+		{rng("10.0.0.1", "10.0.0.6"), prefixes("10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32")},
+		{rng("0.0.0.0", "255.255.255.255"), prefixes("0.0.0.0/0")},
+		{rng("255.255.255.255", "255.255.255.255"), prefixes("255.255.255.255/32")},
+		{rng("::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), prefixes("::/0")},
+		{rng("2001:db8::1", "2001:db8::2"), prefixes("2001:db8::1/128", "2001:db8::2/128")},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Prefixes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v.Prefixes() = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestMergeAndIntersect(t *testing.T) {
+	merged := Merge([]Range{
+		rng("10.0.1.0", "10.0.1.255"),
+		rng("::1", "::5"),
+		rng("10.0.0.0", "10.0.0.255"),
+		rng("10.0.0.128", "10.0.0.200"),
+		rng("10.0.3.0", "10.0.3.255"),
+		rng("::6", "::9"),
+	})
+	want := []Range{rng("10.0.0.0", "10.0.1.255"), rng("10.0.3.0", "10.0.3.255"), rng("::1", "::9")}
+	if !reflect.DeepEqual(merged, want) {
+		t.Fatalf("Merge = %v, want %v", merged, want)
+	}
+
+	got := Intersect(merged, []Range{rng("10.0.1.0", "10.0.3.0"), rng("::8", "::20")})
+	want = []Range{rng("10.0.1.0", "10.0.1.255"), rng("10.0.3.0", "10.0.3.0"), rng("::8", "::9")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Intersect = %v, want %v", got, want)
+	}
+	if Merge(nil) != nil {
+		t.Error("Merge(nil) should be nil")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	got := Aggregate(prefixes("10.0.0.0/25", "10.0.0.128/25", "10.0.1.0/24", "10.0.0.5/32", "2001:db8::/33", "2001:db8:8000::/33"))
+	want := prefixes("10.0.0.0/23", "2001:db8::/32")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate = %v, want %v", got, want)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		r    Range
+		want string
+	}{
+		{rng("10.0.0.0", "10.0.0.0"), "1"},
+		{rng("0.0.0.0", "255.255.255.255"), "4294967296"},
+		{rng("::", "::ffff:ffff:ffff:ffff"), "18446744073709551616"},
+		{rng("::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), "0"}, // 2^128 溢出为 0
+	}
+	for _, tt := range tests {
+		if got := tt.r.Size().String(); got != tt.want {
+			t.Errorf("%v.Size() = %s, want %s", tt.r, got, tt.want)
+		}
+	}
+
+	if got := PrefixSize(netip.MustParsePrefix("2001:db8::/32")).String(); got != "79228162514264337593543950336" {
+		t.Errorf("PrefixSize(/32) = %s", got)
+	}
+	a, b := Count{Lo: ^uint64(0)}, Count{Lo: 1}
+	if sum := a.Add(b); sum != (Count{Hi: 1}) || sum.Sub(b) != a || sum.Cmp(a) != 1 || a.Cmp(sum) != -1 || a.Cmp(a) != 0 {
+		t.Errorf("carry arithmetic failed: %v", sum)
+	}
+
+	data, _ := json.Marshal(Count{Hi: 1, Lo: 2})
+	var c Count
+	if err := json.Unmarshal(data, &c); err != nil || c != (Count{Hi: 1, Lo: 2}) {
+		t.Errorf("JSON round trip = %v, %v", c, err)
+	}
+	if err := json.Unmarshal([]byte("-1"), &c); err == nil {
+		t.Error("negative count should not decode")
+	}
+}
+
+func TestEmbeddedIPv4(t *testing.T) {
+	tests := []struct {
+		in     string
+		kind   string
+		ipv4   string
+		server string
+		port   uint16
+	}{
+		{"::ffff:1.2.3.4", EmbeddedMapped, "1.2.3.4", "", 0},
+		{"2002:102:304::1", Embedded6to4, "1.2.3.4", "", 0},
+		{"64:ff9b::102:304", EmbeddedNAT64, "1.2.3.4", "", 0},
+		// RFC 4380 中的示例：服务器 65.54.227.120，客户端 192.0.2.45:40000
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", EmbeddedTeredo, "192.0.2.45", "65.54.227.120", 40000},
+		{"2001:db8::1", "", "", "", 0},
+		{"64:ff9b:1::102:304", "", "", "", 0},
+	}
+	for _, tt := range tests {
+		e, ok := EmbeddedIPv4(netip.MustParseAddr(tt.in))
+		if ok != (tt.kind != "") {
+			t.Errorf("EmbeddedIPv4(%s) ok = %v", tt.in, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if e.Kind != tt.kind || e.IPv4.String() != tt.ipv4 || e.Port != tt.port {
+			t.Errorf("EmbeddedIPv4(%s) = %+v", tt.in, e)
+		}
+		if tt.server != "" && e.Server.String() != tt.server {
+			t.Errorf("EmbeddedIPv4(%s) server = %s, want %s", tt.in, e.Server, tt.server)
+		}
+	}
+	if _, ok := EmbeddedIPv4(netip.MustParseAddr("1.2.3.4")); ok {
+		t.Error("IPv4 address should not be embedded")
+	}
+}
+
+func TestIPNetConversion(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.1.0.0/16")
+	p := PrefixFromIPNet(n)
+	if p != netip.MustParsePrefix("10.1.0.0/16") {
+		t.Errorf("PrefixFromIPNet = %v", p)
+	}
+	if back := IPNetFromPrefix(p); back.String() != n.String() {
+		t.Errorf("IPNetFromPrefix = %v", back)
+	}
+}
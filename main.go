@@ -6,63 +6,111 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
 	"ip-api/api"
 	"ip-api/config"
 	"ip-api/geoip"
+	"ip-api/overrides"
 	"ip-api/updater"
 )
 
 func main() {
 	log.Println("Starting IP API server...")
 
+	configPath := os.Getenv("IP_API_CONFIG")
+	if configPath == "" {
+		configPath = "config.json"
+	}
+	if err := config.Load(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// 命令行子命令（例如 rollback）执行后直接退出
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	if _, err := os.Stat(config.App.DataDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(config.App.DataDir, 0755); err != nil {
 			log.Fatalf("Failed to create data directory: %v", err)
 		}
 	}
 
-	// 检查数据库文件是否存在，仅在缺失时下载
-	cityDBPath := filepath.Join(config.App.DataDir, "GeoLite2-City.mmdb")
-	asnDBPath := filepath.Join(config.App.DataDir, "GeoLite2-ASN.mmdb")
-	cnDBPath := filepath.Join(config.App.DataDir, "GeoCN.mmdb")
-
-	// 检查所有必需的数据库文件是否存在
+	// 检查注册表中的数据库文件是否存在，仅在缺失时下载
 	needDownload := false
-	if _, err := os.Stat(cityDBPath); os.IsNotExist(err) {
-		log.Println("GeoLite2-City.mmdb not found")
-		needDownload = true
-	}
-	if _, err := os.Stat(asnDBPath); os.IsNotExist(err) {
-		log.Println("GeoLite2-ASN.mmdb not found")
-		needDownload = true
-	}
-	if _, err := os.Stat(cnDBPath); os.IsNotExist(err) {
-		log.Println("GeoCN.mmdb not found")
-		needDownload = true
+	for _, db := range config.App.Databases {
+		if _, err := os.Stat(db.Path()); os.IsNotExist(err) {
+			log.Printf("%s not found", db.FileName())
+			needDownload = true
+		}
 	}
 
-	if needDownload {
-		log.Println("Performing initial database download...")
+	watchMode := config.App.UpdateMode == config.UpdateModeWatch
+	offlineMode := config.App.UpdateMode == config.UpdateModeOffline
+	switch {
+	case needDownload && watchMode:
+		log.Println("Built-in downloads are disabled in watch mode, waiting for missing databases to appear")
+	case needDownload && offlineMode:
+		log.Println("Offline mode: import a database bundle to provide the missing databases")
+	case needDownload:
 		updater.DownloadAll()
-	} else {
+	default:
 		log.Println("All database files exist, skipping initial download")
 	}
 
-	if err := geoip.OpenDBs(cityDBPath, asnDBPath, cnDBPath); err != nil {
-		log.Fatalf("Could not open GeoIP databases: %v", err)
+	if err := geoip.OpenDBs(config.App.Databases); err != nil {
+		// watch 和 offline 模式下缺失的数据库稍后由外部工具或数据库包提供，在此之前 /ready 返回 503
+		if !watchMode && !offlineMode {
+			log.Fatalf("Could not open GeoIP databases: %v", err)
+		}
+		log.Printf("Could not open GeoIP databases: %v", err)
 	}
 	defer geoip.CloseDBs()
 
+	// 内部地址段的覆盖记录，文件修改后自动重新加载
+	if config.App.Overrides != "" {
+		if err := overrides.Load(config.App.Overrides); err != nil {
+			log.Fatalf("Could not load overrides: %v", err)
+		}
+		overrides.OnReload(api.FlushCache)
+		go overrides.Watch()
+	}
+
 	ipAPIHandler := http.HandlerFunc(api.IPHandler)
 	// 链式中间件：限流 -> CORS -> 实际处理器
 	chainedHandler := api.RateLimitMiddleware(api.CorsMiddleware(ipAPIHandler))
 	http.Handle("/json/", chainedHandler)
 	http.Handle("/json", chainedHandler)
 
+	// ASN 查询：前缀列表、国家分布和组织名称搜索
+	asnHandler := api.RateLimitMiddleware(api.CorsMiddleware(http.HandlerFunc(api.ASNHandler)))
+	http.Handle("/asn/", asnHandler)
+	http.Handle("/asn", asnHandler)
+
+	// 防火墙和路由软件使用的 IP 范围导出
+	http.Handle("/export", api.RateLimitMiddleware(api.CorsMiddleware(http.HandlerFunc(api.ExportHandler))))
+
+	// 就绪检查：所有必需的数据库都已加载
+	http.HandleFunc("/ready", api.ReadyHandler)
+
+	// 管理接口
+	http.Handle("/admin/versions", api.AdminAuthMiddleware(http.HandlerFunc(api.VersionsHandler)))
+	http.Handle("/admin/rollback", api.AdminAuthMiddleware(http.HandlerFunc(api.RollbackHandler)))
+	http.Handle("/admin/unpin", api.AdminAuthMiddleware(http.HandlerFunc(api.UnpinHandler)))
+	http.Handle("/admin/diff", api.AdminAuthMiddleware(http.HandlerFunc(api.DiffHandler)))
+	http.Handle("/admin/bundle", api.AdminAuthMiddleware(http.HandlerFunc(api.BundleImportHandler)))
+	http.Handle("/admin/updater/status", api.AdminAuthMiddleware(http.HandlerFunc(api.UpdaterStatusHandler)))
+	http.Handle("/admin/overrides", api.AdminAuthMiddleware(http.HandlerFunc(api.OverridesHandler)))
+	http.HandleFunc("/metrics", api.MetricsHandler)
+
+	// leader 向 follower 发布当前的数据库文件
+	if config.App.Mirror.Mode == config.MirrorLeader {
+		http.Handle("/mirror/manifest", api.MirrorAuthMiddleware(http.HandlerFunc(api.MirrorManifestHandler)))
+		http.Handle("/mirror/files/", api.MirrorAuthMiddleware(http.HandlerFunc(api.MirrorFileHandler)))
+	}
+
 	// 静态地图API路由
 	staticMapHandler := http.HandlerFunc(api.StaticMapHandler)
 	chainedMapHandler := api.RateLimitMiddleware(api.CorsMiddleware(staticMapHandler))
@@ -87,9 +135,9 @@ func main() {
 
 	srv := &http.Server{
 		Addr:         config.App.ListenAddr,
-		ReadTimeout:  config.App.ReadTimeout,
-		WriteTimeout: config.App.WriteTimeout,
-		IdleTimeout:  config.App.IdleTimeout,
+		ReadTimeout:  time.Duration(config.App.ReadTimeout),
+		WriteTimeout: time.Duration(config.App.WriteTimeout),
+		IdleTimeout:  time.Duration(config.App.IdleTimeout),
 	}
 
 	go func() {
@@ -99,8 +147,24 @@ func main() {
 		}
 	}()
 
-	// 启动后台更新器
-	go updater.Start()
+	// 启动后台更新器，watch 模式下只监视数据目录，offline 模式下不更新
+	switch {
+	case watchMode:
+		go updater.Watch()
+	case !offlineMode:
+		go updater.Start()
+	}
+
+	// SIGHUP 重新加载所有数据库（例如命令行回滚之后）
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := geoip.ReloadDBs(config.App.Databases); err != nil {
+				log.Printf("Failed to reload databases: %v", err)
+			}
+		}
+	}()
 
 	// 等待中断信号以优雅地关闭服务器
 	quit := make(chan os.Signal, 1)
@@ -0,0 +1,337 @@
+package mmdbwriter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MMDB 数据段的类型编号
+const (
+	typePointer = 1
+	typeString  = 2
+	typeDouble  = 3
+	typeBytes   = 4
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeInt32   = 8
+	typeUint64  = 9
+	typeUint128 = 10
+	typeArray   = 11
+	typeBool    = 14
+	typeFloat   = 15
+)
+
+// encoder 生成数据段，相同的值只写入一次，重复出现的字符串和容器使用指针引用
+type encoder struct {
+	buf      []byte
+	pointers bool
+	// offsets 记录已写入的值的规范键到偏移量的映射
+	offsets map[string]uint32
+}
+
+func newEncoder() *encoder {
+	return &encoder{pointers: true, offsets: make(map[string]uint32)}
+}
+
+// store 写入一条顶层记录并返回它在数据段中的偏移量，相同的记录复用已有偏移量
+func (e *encoder) store(v interface{}) (uint32, error) {
+	key, err := canonicalKey(v)
+	if err != nil {
+		return 0, err
+	}
+	if off, ok := e.offsets[key]; ok {
+		return off, nil
+	}
+	off := uint32(len(e.buf))
+	if err := e.writeInline(v); err != nil {
+		// 丢弃写入了一部分的记录，以及其中已登记可复用的值
+		e.buf = e.buf[:off]
+		for k, o := range e.offsets {
+			if o >= off {
+				delete(e.offsets, k)
+			}
+		}
+		return 0, err
+	}
+	e.offsets[key] = off
+	return off, nil
+}
+
+// writeChild 写入容器中的一个值，已写入过的较长值改为写入指针
+func (e *encoder) writeChild(v interface{}) error {
+	if !e.pointers || !dedupable(v) {
+		return e.writeInline(v)
+	}
+	key, err := canonicalKey(v)
+	if err != nil {
+		return err
+	}
+	if off, ok := e.offsets[key]; ok && len(key) > 6 {
+		e.writePointer(off)
+		return nil
+	}
+	off := uint32(len(e.buf))
+	if err := e.writeInline(v); err != nil {
+		return err
+	}
+	e.offsets[key] = off
+	return nil
+}
+
+// dedupable 检查值是否值得通过指针复用
+func dedupable(v interface{}) bool {
+	switch v.(type) {
+	case string, map[string]interface{}, map[string]string, []interface{}, []string:
+		return true
+	}
+	return false
+}
+
+// writeInline 直接写入值的完整编码
+func (e *encoder) writeInline(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		e.writeControl(typeString, len(v))
+		e.buf = append(e.buf, v...)
+	case []byte:
+		e.writeControl(typeBytes, len(v))
+		e.buf = append(e.buf, v...)
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		e.writeControl(typeBool, n)
+	case float64:
+		e.writeControl(typeDouble, 8)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v))
+	case float32:
+		e.writeControl(typeFloat, 4)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(v))
+	case uint16:
+		e.writeUint(typeUint16, uint64(v))
+	case uint32:
+		e.writeUint(typeUint32, uint64(v))
+	case uint:
+		if uint64(v) > math.MaxUint32 {
+			e.writeUint(typeUint64, uint64(v))
+		} else {
+			e.writeUint(typeUint32, uint64(v))
+		}
+	case uint64:
+		e.writeUint(typeUint64, v)
+	case *big.Int:
+		if v.Sign() < 0 || v.BitLen() > 128 {
+			return fmt.Errorf("integer %s out of uint128 range", v)
+		}
+		b := v.Bytes()
+		e.writeControl(typeUint128, len(b))
+		e.buf = append(e.buf, b...)
+	case int32:
+		e.writeInt32(v)
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("integer %d out of int32 range", v)
+		}
+		e.writeInt32(int32(v))
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return e.writeInline(m)
+	case map[string]interface{}:
+		e.writeControl(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := e.writeChild(k); err != nil {
+				return err
+			}
+			if err := e.writeChild(v[k]); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return e.writeInline(list)
+	case []interface{}:
+		e.writeControl(typeArray, len(v))
+		for _, item := range v {
+			if err := e.writeChild(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
+
+// writeUint 以最少的字节写入无符号整数
+func (e *encoder) writeUint(typeNum int, v uint64) {
+	n := 0
+	for x := v; x > 0; x >>= 8 {
+		n++
+	}
+	e.writeControl(typeNum, n)
+	for i := n - 1; i >= 0; i-- {
+		e.buf = append(e.buf, byte(v>>(8*uint(i))))
+	}
+}
+
+// writeInt32 写入有符号 32 位整数，负数总是使用 4 个字节
+func (e *encoder) writeInt32(v int32) {
+	u := uint32(v)
+	n := 4
+	if v >= 0 {
+		n = 0
+		for x := u; x > 0; x >>= 8 {
+			n++
+		}
+	}
+	e.writeControl(typeInt32, n)
+	for i := n - 1; i >= 0; i-- {
+		e.buf = append(e.buf, byte(u>>(8*uint(i))))
+	}
+}
+
+// writeControl 写入控制字节，包括扩展类型和扩展长度
+func (e *encoder) writeControl(typeNum, size int) {
+	var ctrl byte
+	extended := typeNum > 7
+	if !extended {
+		ctrl = byte(typeNum) << 5
+	}
+
+	var ext []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		ext = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		ext = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		ext = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+
+	e.buf = append(e.buf, ctrl)
+	if extended {
+		e.buf = append(e.buf, byte(typeNum-7))
+	}
+	e.buf = append(e.buf, ext...)
+}
+
+// writePointer 写入指向数据段偏移量的指针
+func (e *encoder) writePointer(off uint32) {
+	switch {
+	case off < 1<<11:
+		e.buf = append(e.buf, typePointer<<5|byte(off>>8), byte(off))
+	case off < 2048+1<<19:
+		v := off - 2048
+		e.buf = append(e.buf, typePointer<<5|1<<3|byte(v>>16), byte(v>>8), byte(v))
+	case off < 526336+1<<27:
+		v := off - 526336
+		e.buf = append(e.buf, typePointer<<5|2<<3|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		e.buf = append(e.buf, typePointer<<5|3<<3, byte(off>>24), byte(off>>16), byte(off>>8), byte(off))
+	}
+}
+
+// canonicalKey 返回值的规范字符串表示，类型不同的相同数值得到不同的键
+func canonicalKey(v interface{}) (string, error) {
+	var sb strings.Builder
+	if err := writeKey(&sb, v); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func writeKey(sb *strings.Builder, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		sb.WriteString("s")
+		sb.WriteString(strconv.Quote(v))
+	case []byte:
+		sb.WriteString("b")
+		sb.WriteString(strconv.Quote(string(v)))
+	case bool:
+		sb.WriteString("t" + strconv.FormatBool(v))
+	case float64:
+		sb.WriteString("d" + strconv.FormatUint(math.Float64bits(v), 16))
+	case float32:
+		sb.WriteString("f" + strconv.FormatUint(uint64(math.Float32bits(v)), 16))
+	case uint16:
+		sb.WriteString("u16:" + strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		sb.WriteString("u32:" + strconv.FormatUint(uint64(v), 10))
+	case uint:
+		sb.WriteString("u:" + strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		sb.WriteString("u64:" + strconv.FormatUint(v, 10))
+	case *big.Int:
+		sb.WriteString("u128:" + v.String())
+	case int32:
+		sb.WriteString("i:" + strconv.FormatInt(int64(v), 10))
+	case int:
+		sb.WriteString("i:" + strconv.FormatInt(int64(v), 10))
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return writeKey(sb, m)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sb.WriteString("{")
+		for _, k := range keys {
+			sb.WriteString(strconv.Quote(k))
+			sb.WriteString(":")
+			if err := writeKey(sb, v[k]); err != nil {
+				return err
+			}
+			sb.WriteString(",")
+		}
+		sb.WriteString("}")
+	case []string:
+		sb.WriteString("[")
+		for _, s := range v {
+			sb.WriteString(strconv.Quote(s) + ",")
+		}
+		sb.WriteString("]")
+	case []interface{}:
+		sb.WriteString("[")
+		for _, item := range v {
+			if err := writeKey(sb, item); err != nil {
+				return err
+			}
+			sb.WriteString(",")
+		}
+		sb.WriteString("]")
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+// Package mmdbwriter 生成 MaxMind DB（MMDB）格式的数据库文件，
+// 生成的文件可以被 maxminddb-golang 和 geoip2-golang 直接读取。
+package mmdbwriter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// metadataStartMarker 标记元数据段的开始
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Options 是数据库的元数据选项
+type Options struct {
+	// DatabaseType 是写入元数据的 database_type。
+	DatabaseType string
+
+	// Description 是按语言区分的数据库描述，为空时使用 DatabaseType 作为英文描述
+	// （MMDB 规范要求描述非空，maxminddb-golang 的 Verify 会拒绝空的描述）。
+	Description map[string]string
+
+	// Languages 是记录中名称可能使用的语言。
+	Languages []string
+
+	// IPVersion 是 4 或 6，默认为 6。IPv6 数据库中的 IPv4 网络位于 ::/96，
+	// 并通过 ::ffff:0:0/96 和 2002::/16 别名访问。
+	IPVersion int
+
+	// BuildEpoch 是构建时间，默认为当前时间。
+	BuildEpoch time.Time
+}
+
+// slot 是节点的一条记录：指向子节点、数据或为空
+type slot struct {
+	child *node
+	data  uint32 // 数据段偏移量 + 1，0 表示没有数据
+}
+
+// node 是搜索树中的一个节点
+type node struct {
+	slots [2]slot
+}
+
+// Writer 在内存中构建搜索树和数据段
+type Writer struct {
+	opts Options
+	bits int
+	root *node
+	enc  *encoder
+}
+
+// New 创建一个新的 Writer
+func New(opts Options) *Writer {
+	if opts.IPVersion == 0 {
+		opts.IPVersion = 6
+	}
+	bits := 128
+	if opts.IPVersion == 4 {
+		bits = 32
+	}
+	return &Writer{
+		opts: opts,
+		bits: bits,
+		root: &node{},
+		enc:  newEncoder(),
+	}
+}
+
+// Insert 将 value 关联到网络 p。更具体的前缀应在包含它的前缀之后插入，
+// 后插入的前缀覆盖重叠部分。value 可以是 map[string]interface{}、
+// []interface{}、string、bool、float32、float64、int32、uint16、uint32、uint64、*big.Int（uint128）等类型。
+func (w *Writer) Insert(p netip.Prefix, value interface{}) error {
+	// 先检查网络，失败的插入不在数据段中留下无法访问的记录
+	path, length, err := w.path(p)
+	if err != nil {
+		return err
+	}
+	offset, err := w.enc.store(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode record for %s: %w", p, err)
+	}
+	w.insertData(path, length, offset+1)
+	return nil
+}
+
+// insertData 将数据偏移量写入路径对应的记录
+func (w *Writer) insertData(path [16]byte, length int, data uint32) {
+	if length == 0 {
+		w.root.slots = [2]slot{{data: data}, {data: data}}
+		return
+	}
+
+	cur := w.root
+	for i := 0; i < length-1; i++ {
+		s := &cur.slots[bit(path, i)]
+		if s.child == nil {
+			// 拆分已有的数据记录，使两个子记录继承原来的值
+			s.child = &node{slots: [2]slot{{data: s.data}, {data: s.data}}}
+			s.data = 0
+		}
+		cur = s.child
+	}
+	cur.slots[bit(path, length-1)] = slot{data: data}
+}
+
+// path 返回前缀在搜索树中的位路径和长度
+func (w *Writer) path(p netip.Prefix) ([16]byte, int, error) {
+	var path [16]byte
+	p = p.Masked()
+	addr := p.Addr()
+
+	if w.bits == 32 {
+		if !addr.Is4() {
+			return path, 0, fmt.Errorf("cannot insert IPv6 network %s into an IPv4 database", p)
+		}
+		a := addr.As4()
+		copy(path[:], a[:])
+		return path, p.Bits(), nil
+	}
+
+	if addr.Is4() {
+		a := addr.As4()
+		copy(path[12:], a[:])
+		return path, 96 + p.Bits(), nil
+	}
+	return addr.As16(), p.Bits(), nil
+}
+
+// bit 返回路径的第 i 位
+func bit(path [16]byte, i int) int {
+	return int(path[i/8]>>(7-uint(i%8))) & 1
+}
+
+// alias 让 from 前缀指向 IPv4 子树（::/96），与 MaxMind 官方写入器的行为一致
+func (w *Writer) alias(from netip.Prefix) {
+	ipv4Root := w.subtree(netip.MustParsePrefix("::/96"))
+
+	path := from.Addr().As16()
+	cur := w.root
+	for i := 0; i < from.Bits()-1; i++ {
+		s := &cur.slots[bit(path, i)]
+		if s.child == nil || s.child == ipv4Root {
+			s.child = &node{slots: [2]slot{{data: s.data}, {data: s.data}}}
+			s.data = 0
+		}
+		cur = s.child
+	}
+	cur.slots[bit(path, from.Bits()-1)] = slot{child: ipv4Root}
+}
+
+// subtree 返回前缀对应的节点，必要时创建它
+func (w *Writer) subtree(p netip.Prefix) *node {
+	path := p.Addr().As16()
+	cur := w.root
+	for i := 0; i < p.Bits(); i++ {
+		s := &cur.slots[bit(path, i)]
+		if s.child == nil {
+			s.child = &node{slots: [2]slot{{data: s.data}, {data: s.data}}}
+			s.data = 0
+		}
+		cur = s.child
+	}
+	return cur
+}
+
+// WriteTo 将数据库写入 out
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	if w.bits == 128 {
+		w.alias(netip.MustParsePrefix("::ffff:0:0/96"))
+		w.alias(netip.MustParsePrefix("2002::/16"))
+	}
+
+	// 按广度优先顺序为节点编号，别名使同一节点可能被多次引用
+	index := map[*node]uint32{w.root: 0}
+	nodes := []*node{w.root}
+	for i := 0; i < len(nodes); i++ {
+		for _, s := range nodes[i].slots {
+			if s.child == nil {
+				continue
+			}
+			if _, ok := index[s.child]; !ok {
+				index[s.child] = uint32(len(nodes))
+				nodes = append(nodes, s.child)
+			}
+		}
+	}
+
+	nodeCount := uint64(len(nodes))
+	maxRecord := nodeCount + 16 + uint64(len(w.enc.buf))
+	recordSize := 24
+	switch {
+	case maxRecord >= 1<<32:
+		return 0, fmt.Errorf("database too large: %d nodes, %d bytes of data", nodeCount, len(w.enc.buf))
+	case maxRecord >= 1<<28:
+		recordSize = 32
+	case maxRecord >= 1<<24:
+		recordSize = 28
+	}
+
+	bw := bufio.NewWriter(out)
+	counter := &countingWriter{w: bw}
+
+	record := func(s slot) uint64 {
+		switch {
+		case s.child != nil:
+			return uint64(index[s.child])
+		case s.data != 0:
+			return nodeCount + 16 + uint64(s.data-1)
+		default:
+			return nodeCount
+		}
+	}
+
+	buf := make([]byte, recordSize/4)
+	for _, n := range nodes {
+		left, right := record(n.slots[0]), record(n.slots[1])
+		switch recordSize {
+		case 24:
+			buf[0], buf[1], buf[2] = byte(left>>16), byte(left>>8), byte(left)
+			buf[3], buf[4], buf[5] = byte(right>>16), byte(right>>8), byte(right)
+		case 28:
+			buf[0], buf[1], buf[2] = byte(left>>16), byte(left>>8), byte(left)
+			buf[3] = byte((left>>24)&0x0F)<<4 | byte((right>>24)&0x0F)
+			buf[4], buf[5], buf[6] = byte(right>>16), byte(right>>8), byte(right)
+		case 32:
+			buf[0], buf[1], buf[2], buf[3] = byte(left>>24), byte(left>>16), byte(left>>8), byte(left)
+			buf[4], buf[5], buf[6], buf[7] = byte(right>>24), byte(right>>16), byte(right>>8), byte(right)
+		}
+		if _, err := counter.Write(buf); err != nil {
+			return counter.n, err
+		}
+	}
+
+	// 数据段分隔符
+	if _, err := counter.Write(make([]byte, 16)); err != nil {
+		return counter.n, err
+	}
+	if _, err := counter.Write(w.enc.buf); err != nil {
+		return counter.n, err
+	}
+
+	buildEpoch := w.opts.BuildEpoch
+	if buildEpoch.IsZero() {
+		buildEpoch = time.Now()
+	}
+	description := make(map[string]interface{}, len(w.opts.Description))
+	for k, v := range w.opts.Description {
+		description[k] = v
+	}
+	if len(description) == 0 {
+		description["en"] = w.opts.DatabaseType
+	}
+	languages := make([]interface{}, len(w.opts.Languages))
+	for i, l := range w.opts.Languages {
+		languages[i] = l
+	}
+	meta := newEncoder()
+	meta.pointers = false
+	if err := meta.writeInline(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(buildEpoch.Unix()),
+		"database_type":               w.opts.DatabaseType,
+		"description":                 description,
+		"ip_version":                  uint16(w.opts.IPVersion),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	}); err != nil {
+		return counter.n, err
+	}
+	if _, err := counter.Write(metadataStartMarker); err != nil {
+		return counter.n, err
+	}
+	if _, err := counter.Write(meta.buf); err != nil {
+		return counter.n, err
+	}
+
+	return counter.n, bw.Flush()
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,264 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// roundTrip 写出数据库并用 maxminddb-golang 读回，同时校验文件结构
+func roundTrip(t *testing.T, w *Writer) *maxminddb.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+	db, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return db
+}
+
+// lookup 查询地址，返回记录和网络，没有记录时返回 nil
+func lookup(t *testing.T, db *maxminddb.Reader, ip string) (interface{}, string) {
+	t.Helper()
+	var rec interface{}
+	network, found, err := db.LookupNetwork(net.ParseIP(ip), &rec)
+	if err != nil {
+		t.Fatalf("lookup %s: %v", ip, err)
+	}
+	if !found {
+		return nil, network.String()
+	}
+	return rec, network.String()
+}
+
+func TestRoundTripValues(t *testing.T) {
+	u128, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+	long := strings.Repeat("x", 300)
+	value := map[string]interface{}{
+		"string":  "hello",
+		"empty":   "",
+		"long":    long,
+		"bool":    true,
+		"double":  1.5,
+		"float":   float32(2.25),
+		"uint16":  uint16(65535),
+		"uint32":  uint32(4294967295),
+		"uint64":  uint64(1 << 40),
+		"uint128": u128,
+		"int32":   int32(-123456),
+		"int":     -7,
+		"bytes":   []byte{0, 1, 2},
+		"names":   map[string]string{"en": "Berlin", "de": "Berlin"},
+		"list":    []interface{}{"a", uint32(1), map[string]interface{}{"nested": []string{"b", "c"}}},
+		"nested":  map[string]interface{}{"repeat": long, "deeper": map[string]interface{}{"zero": uint32(0)}},
+	}
+	want := map[string]interface{}{
+		"string":  "hello",
+		"empty":   "",
+		"long":    long,
+		"bool":    true,
+		"double":  1.5,
+		"float":   float32(2.25),
+		"uint16":  uint64(65535),
+		"uint32":  uint64(4294967295),
+		"uint64":  uint64(1 << 40),
+		"uint128": u128,
+		"int32":   -123456,
+		"int":     -7,
+		"bytes":   []byte{0, 1, 2},
+		"names":   map[string]interface{}{"en": "Berlin", "de": "Berlin"},
+		"list":    []interface{}{"a", uint64(1), map[string]interface{}{"nested": []interface{}{"b", "c"}}},
+		"nested":  map[string]interface{}{"repeat": long, "deeper": map[string]interface{}{"zero": uint64(0)}},
+	}
+
+	w := New(Options{DatabaseType: "Test", Languages: []string{"en", "de"}})
+	if err := w.Insert(netip.MustParsePrefix("2001:db8::/32"), value); err != nil {
+		t.Fatal(err)
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+
+	got, network := lookup(t, db, "2001:db8::1")
+	if network != "2001:db8::/32" {
+		t.Errorf("network = %s, want 2001:db8::/32", network)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record = %#v\nwant %#v", got, want)
+	}
+}
+
+func TestRoundTripNetworks(t *testing.T) {
+	w := New(Options{DatabaseType: "Test"})
+	inserts := []struct {
+		network string
+		value   string
+	}{
+		{"10.0.0.0/8", "wide"},
+		{"10.1.0.0/16", "narrow"},
+		{"192.0.2.1/32", "host"},
+		{"2001:db8::/32", "v6"},
+		{"2001:db8:1::/48", "v6-narrow"},
+	}
+	for _, in := range inserts {
+		if err := w.Insert(netip.MustParsePrefix(in.network), map[string]interface{}{"v": in.value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+
+	tests := []struct {
+		ip, value, network string
+	}{
+		{"10.0.0.1", "wide", "10.0.0.0/16"},
+		{"10.1.2.3", "narrow", "10.1.0.0/16"},
+		{"10.255.0.1", "wide", "10.128.0.0/9"},
+		{"192.0.2.1", "host", "192.0.2.1/32"},
+		{"192.0.2.2", "", "192.0.2.2/31"},
+		{"2001:db8::1", "v6", "2001:db8::/48"},
+		{"2001:db8:1::1", "v6-narrow", "2001:db8:1::/48"},
+		// IPv4 子树通过 ::ffff:0:0/96 和 2002::/16 别名访问
+		{"::ffff:10.1.2.3", "narrow", "10.1.0.0/16"},
+		{"2002:a01:203::", "narrow", "2002:a01::/32"},
+		{"2002:c000:201::", "host", "2002:c000:201::/48"},
+		{"2001:db9::1", "", ""},
+	}
+	for _, tt := range tests {
+		got, network := lookup(t, db, tt.ip)
+		var value string
+		if got != nil {
+			value, _ = got.(map[string]interface{})["v"].(string)
+		}
+		if value != tt.value {
+			t.Errorf("%s: value = %q, want %q", tt.ip, value, tt.value)
+		}
+		if tt.network != "" && network != tt.network {
+			t.Errorf("%s: network = %s, want %s", tt.ip, network, tt.network)
+		}
+	}
+
+	// 每个网络只遍历一次，别名不重复出现
+	count := 0
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var rec interface{}
+		if _, err := networks.Network(&rec); err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if err := networks.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Error("no networks found")
+	}
+}
+
+func TestRoundTripIPv4(t *testing.T) {
+	w := New(Options{DatabaseType: "Test-IPv4", IPVersion: 4})
+	if err := w.Insert(netip.MustParsePrefix("198.51.100.0/24"), "v4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(netip.MustParsePrefix("2001:db8::/32"), "v6"); err == nil {
+		t.Error("inserting an IPv6 network into an IPv4 database succeeded")
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+
+	if db.Metadata.IPVersion != 4 || db.Metadata.NodeCount != 24 {
+		t.Errorf("metadata ip_version %d, node_count %d", db.Metadata.IPVersion, db.Metadata.NodeCount)
+	}
+	if got, network := lookup(t, db, "198.51.100.7"); got != "v4" || network != "198.51.100.0/24" {
+		t.Errorf("lookup = %v %s", got, network)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	w := New(Options{
+		DatabaseType: "Test-Meta",
+		Description:  map[string]string{"en": "Test database"},
+		Languages:    []string{"en", "zh-CN"},
+		BuildEpoch:   epoch,
+	})
+	if err := w.Insert(netip.MustParsePrefix("192.0.2.0/24"), "x"); err != nil {
+		t.Fatal(err)
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+
+	meta := db.Metadata
+	if meta.DatabaseType != "Test-Meta" || meta.BuildEpoch != uint(epoch.Unix()) || meta.IPVersion != 6 ||
+		meta.RecordSize != 24 || meta.BinaryFormatMajorVersion != 2 {
+		t.Errorf("metadata = %+v", meta)
+	}
+	if !reflect.DeepEqual(meta.Description, map[string]string{"en": "Test database"}) ||
+		!reflect.DeepEqual(meta.Languages, []string{"en", "zh-CN"}) {
+		t.Errorf("description %v, languages %v", meta.Description, meta.Languages)
+	}
+}
+
+func TestRecordSize28(t *testing.T) {
+	// 数据段超过 16 MiB 时记录需要 28 位
+	w := New(Options{DatabaseType: "Test"})
+	if err := w.Insert(netip.MustParsePrefix("192.0.2.0/24"), strings.Repeat("a", 1<<24)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(netip.MustParsePrefix("198.51.100.0/24"), "small"); err != nil {
+		t.Fatal(err)
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+
+	if db.Metadata.RecordSize != 28 {
+		t.Errorf("record_size = %d, want 28", db.Metadata.RecordSize)
+	}
+	if got, _ := lookup(t, db, "198.51.100.1"); got != "small" {
+		t.Errorf("lookup = %v, want small", got)
+	}
+}
+
+func TestInsertUnsupportedValue(t *testing.T) {
+	w := New(Options{DatabaseType: "Test"})
+	partial := map[string]interface{}{"a": "a string long enough to be reused", "z": 1 << 40}
+	for _, v := range []interface{}{struct{}{}, int64(1), 1 << 40, big.NewInt(-1), partial} {
+		if err := w.Insert(netip.MustParsePrefix("192.0.2.0/24"), v); err == nil {
+			t.Errorf("Insert(%T %v) succeeded", v, v)
+		}
+	}
+	if len(w.enc.buf) != 0 || len(w.enc.offsets) != 0 {
+		t.Fatalf("failed inserts left %d bytes and %d reusable values", len(w.enc.buf), len(w.enc.offsets))
+	}
+
+	// 失败的插入不留下数据，之后的记录也不引用被丢弃的值
+	value := map[string]interface{}{"a": "a string long enough to be reused"}
+	if err := w.Insert(netip.MustParsePrefix("198.51.100.0/24"), value); err != nil {
+		t.Fatal(err)
+	}
+	db := roundTrip(t, w)
+	defer db.Close()
+	if got, _ := lookup(t, db, "198.51.100.1"); !reflect.DeepEqual(got, value) {
+		t.Errorf("lookup = %v, want %v", got, value)
+	}
+	if got, _ := lookup(t, db, "192.0.2.1"); got != nil {
+		t.Errorf("lookup of a failed insert = %v", got)
+	}
+}
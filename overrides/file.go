@@ -0,0 +1,286 @@
+package overrides
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvColumns 是 CSV 文件的列，第一行必须是列名，可以省略不需要的列。
+// tags 列的格式为 key=value;key=value。
+var csvColumns = []string{"network", "country", "region", "city", "latitude", "longitude", "org", "tags"}
+
+// checkFormat 检查覆盖文件的扩展名
+func checkFormat(p string) error {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".csv", ".yaml", ".yml":
+		return nil
+	default:
+		return fmt.Errorf("overrides file %s must be .csv, .yaml or .yml", p)
+	}
+}
+
+func isCSV(p string) bool {
+	return strings.EqualFold(filepath.Ext(p), ".csv")
+}
+
+// readFile 按扩展名解析覆盖文件
+func readFile(p string) ([]Entry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if isCSV(p) {
+		return readCSV(f)
+	}
+	return readYAML(f)
+}
+
+// encodeFile 按扩展名编码覆盖文件的内容
+func encodeFile(p string, entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	if isCSV(p) {
+		if err := writeCSV(&buf, entries); err != nil {
+			return nil, err
+		}
+	} else {
+		writeYAML(&buf, entries)
+	}
+	return buf.Bytes(), nil
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, c := range csvColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["network"]; !ok {
+		return nil, fmt.Errorf("missing network column")
+	}
+
+	var entries []Entry
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		e := Entry{}
+		for name, i := range columns {
+			if i >= len(row) {
+				continue
+			}
+			if err := e.set(name, strings.TrimSpace(row[i])); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+func writeCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+	for _, e := range entries {
+		var lat, lon string
+		if e.Latitude != nil {
+			lat = strconv.FormatFloat(*e.Latitude, 'f', -1, 64)
+			lon = strconv.FormatFloat(*e.Longitude, 'f', -1, 64)
+		}
+		var tags []string
+		for _, k := range sortedKeys(e.Tags) {
+			tags = append(tags, k+"="+e.Tags[k])
+		}
+		cw.Write([]string{e.Network, e.Country, e.Region, e.City, lat, lon, e.Org, strings.Join(tags, ";")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readYAML 解析覆盖文件使用的 YAML 子集：由映射组成的列表，每项以 "- network:" 开始，
+// 值是标量，tags 是下一级缩进的映射。支持引号和 # 注释，不支持其他 YAML 语法。
+func readYAML(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var e *Entry
+	inTags := false
+	tagsIndent := 0
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			entries = append(entries, Entry{})
+			e = &entries[len(entries)-1]
+			inTags = false
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			indent += 2
+			if trimmed == "" {
+				continue
+			}
+		}
+		if e == nil {
+			return nil, fmt.Errorf("line %d: expected a list of overrides", line)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		key, value = strings.TrimSpace(key), yamlScalar(value)
+
+		if inTags && indent > tagsIndent {
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			e.Tags[key] = value
+			continue
+		}
+		inTags = false
+		if key == "tags" && value == "" {
+			inTags, tagsIndent = true, indent
+			continue
+		}
+		if err := e.set(key, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// yamlScalar 去掉标量值的引号和行尾注释
+func yamlScalar(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' {
+		// 双引号字符串可以包含转义字符，写入时使用 strconv.Quote
+		for end := 1; end < len(s); end++ {
+			if s[end] != '"' {
+				continue
+			}
+			if v, err := strconv.Unquote(s[:end+1]); err == nil {
+				return v
+			}
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' {
+		if end := strings.IndexByte(s[1:], '\''); end >= 0 {
+			return s[1 : end+1]
+		}
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+func writeYAML(w io.Writer, entries []Entry) {
+	fmt.Fprintln(w, "# 由管理接口写入，也可以直接编辑，保存后自动重新加载")
+	for _, e := range entries {
+		fmt.Fprintf(w, "- network: %s\n", e.Network)
+		for _, f := range []struct{ key, value string }{
+			{"country", e.Country}, {"region", e.Region}, {"city", e.City}, {"org", e.Org},
+		} {
+			if f.value != "" {
+				fmt.Fprintf(w, "  %s: %s\n", f.key, strconv.Quote(f.value))
+			}
+		}
+		if e.Latitude != nil {
+			fmt.Fprintf(w, "  latitude: %s\n", strconv.FormatFloat(*e.Latitude, 'f', -1, 64))
+			fmt.Fprintf(w, "  longitude: %s\n", strconv.FormatFloat(*e.Longitude, 'f', -1, 64))
+		}
+		if len(e.Tags) > 0 {
+			fmt.Fprintln(w, "  tags:")
+			for _, k := range sortedKeys(e.Tags) {
+				fmt.Fprintf(w, "    %s: %s\n", k, strconv.Quote(e.Tags[k]))
+			}
+		}
+	}
+}
+
+// set 设置一个字段，用于解析 CSV 和 YAML 文件
+func (e *Entry) set(key, value string) error {
+	switch key {
+	case "network":
+		e.Network = value
+	case "country":
+		e.Country = value
+	case "region":
+		e.Region = value
+	case "city":
+		e.City = value
+	case "org":
+		e.Org = value
+	case "latitude", "longitude":
+		if value == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", key, value)
+		}
+		if key == "latitude" {
+			e.Latitude = &f
+		} else {
+			e.Longitude = &f
+		}
+	case "tags":
+		for _, pair := range strings.Split(value, ";") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(pair, "=")
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			e.Tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	default:
+		return fmt.Errorf("unknown field %q", key)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,284 @@
+// Package overrides 保存办公网络、VPN 和数据中心等内部地址段的位置覆盖。
+// 覆盖记录从 CSV 或 YAML 文件加载到内存中的基数树，查询时先于所有数据源按最长前缀匹配。
+package overrides
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollInterval 是检查覆盖文件是否被修改的间隔
+const pollInterval = 5 * time.Second
+
+var (
+	// ErrNotFound 表示没有该网络的覆盖记录
+	ErrNotFound = errors.New("override not found")
+	// ErrInvalid 表示覆盖记录没有通过校验
+	ErrInvalid = errors.New("invalid override")
+	// ErrDisabled 表示没有配置覆盖文件
+	ErrDisabled = errors.New("overrides are not enabled")
+)
+
+// Entry 是一个网络的覆盖记录，空字段不覆盖数据源的结果
+type Entry struct {
+	Network   string            `json:"network"`
+	Country   string            `json:"country,omitempty"` // ISO 3166-1 alpha-2 国家代码
+	Region    string            `json:"region,omitempty"`
+	City      string            `json:"city,omitempty"`
+	Latitude  *float64          `json:"latitude,omitempty"`
+	Longitude *float64          `json:"longitude,omitempty"`
+	Org       string            `json:"org,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// normalize 校验记录，返回规范化的网络并将 Network 改写为规范形式
+func (e *Entry) normalize() (netip.Prefix, error) {
+	prefix, err := parseNetwork(e.Network)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	e.Network = prefix.String()
+
+	e.Country = strings.ToUpper(strings.TrimSpace(e.Country))
+	if e.Country != "" && !isCountryCode(e.Country) {
+		return prefix, fmt.Errorf("%s: invalid country code %q", e.Network, e.Country)
+	}
+	e.Region = strings.TrimSpace(e.Region)
+	e.City = strings.TrimSpace(e.City)
+	e.Org = strings.TrimSpace(e.Org)
+
+	if (e.Latitude == nil) != (e.Longitude == nil) {
+		return prefix, fmt.Errorf("%s: latitude and longitude must be set together", e.Network)
+	}
+	if e.Latitude != nil && (*e.Latitude < -90 || *e.Latitude > 90 || *e.Longitude < -180 || *e.Longitude > 180) {
+		return prefix, fmt.Errorf("%s: coordinates out of range", e.Network)
+	}
+	for k, v := range e.Tags {
+		if k == "" || strings.ContainsAny(k, "=;:#\n") || strings.ContainsAny(v, ";\n") {
+			return prefix, fmt.Errorf("%s: invalid tag %q=%q", e.Network, k, v)
+		}
+	}
+
+	if e.Country == "" && e.Region == "" && e.City == "" && e.Latitude == nil && e.Org == "" && len(e.Tags) == 0 {
+		return prefix, fmt.Errorf("%s: override has no fields", e.Network)
+	}
+	return prefix, nil
+}
+
+// parseNetwork 解析并规范化网络。查询时 IPv4 映射地址会转换为 IPv4 地址，
+// 所以 ::ffff:0:0/96 内的网络改写为对应的 IPv4 网络，否则它们永远不会匹配。
+func parseNetwork(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+	}
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix, nil
+}
+
+// isCountryCode 检查是否是两个大写字母的国家代码
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+var (
+	mu      sync.RWMutex
+	path    string
+	current = &tree{}
+	stamp   fileStamp
+)
+
+// onReload 在 Watch 重新加载覆盖文件之后调用，由 OnReload 设置
+var onReload func()
+
+// OnReload 设置 Watch 重新加载覆盖文件之后调用的函数，例如清空查询结果的缓存。
+// 必须在启动 Watch 之前调用。
+func OnReload(fn func()) {
+	onReload = fn
+}
+
+// fileStamp 标识覆盖文件的一个版本
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func statStamp(p string) fileStamp {
+	info, err := os.Stat(p)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}
+}
+
+// Load 从文件加载覆盖记录，替换当前的记录。文件不存在时从空的集合开始，
+// 通过管理接口添加的记录会写入该文件。
+func Load(p string) error {
+	if err := checkFormat(p); err != nil {
+		return err
+	}
+	s := statStamp(p)
+	entries, err := readFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	t := &tree{}
+	for i := range entries {
+		prefix, err := entries[i].normalize()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		t.insert(prefix, &entries[i])
+	}
+
+	mu.Lock()
+	path, current, stamp = p, t, s
+	mu.Unlock()
+	log.Printf("Loaded %d overrides from %s", t.count, p)
+	return nil
+}
+
+// Watch 定期检查覆盖文件，被修改后重新加载。加载失败时继续使用旧的记录。
+func Watch() {
+	for range time.Tick(pollInterval) {
+		reloadIfChanged()
+	}
+}
+
+// reloadIfChanged 在覆盖文件被修改后重新加载，成功时调用 onReload
+func reloadIfChanged() {
+	mu.RLock()
+	p, last := path, stamp
+	mu.RUnlock()
+
+	s := statStamp(p)
+	if s == last {
+		return
+	}
+	log.Printf("Detected change to %s", p)
+	if err := Load(p); err != nil {
+		log.Printf("Failed to reload overrides: %v", err)
+		// 记录这个版本，同一个文件不再重复加载
+		mu.Lock()
+		stamp = s
+		mu.Unlock()
+		return
+	}
+	if onReload != nil {
+		onReload()
+	}
+}
+
+// Lookup 返回包含该地址的最长前缀的覆盖记录（记录的网络在 Entry.Network 中），
+// 以及包含该地址、查询结果都相同的网络。没有覆盖记录时仍然返回该网络。
+func Lookup(ip net.IP) (Entry, netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Entry{}, netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+
+	mu.RLock()
+	defer mu.RUnlock()
+	span := current.span(addr)
+	n := current.lookup(addr)
+	if n == nil {
+		return Entry{}, span, false
+	}
+	return *n.entry, span, true
+}
+
+// List 按地址顺序返回所有覆盖记录
+func List() []Entry {
+	mu.RLock()
+	defer mu.RUnlock()
+	return listLocked()
+}
+
+func listLocked() []Entry {
+	entries := make([]Entry, 0, current.count)
+	current.walk(func(n *node) {
+		entries = append(entries, *n.entry)
+	})
+	return entries
+}
+
+// Add 校验并添加或替换一个网络的覆盖记录，然后写回覆盖文件
+func Add(e Entry) (Entry, error) {
+	prefix, err := e.normalize()
+	if err != nil {
+		return e, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path == "" {
+		return e, ErrDisabled
+	}
+	previous := current.get(prefix)
+	current.insert(prefix, &e)
+	if err := saveLocked(); err != nil {
+		// 写入失败时恢复内存中的记录，与文件保持一致
+		if previous != nil {
+			current.insert(prefix, previous)
+		} else {
+			current.remove(prefix)
+		}
+		return e, err
+	}
+	return e, nil
+}
+
+// Delete 删除一个网络的覆盖记录，然后写回覆盖文件
+func Delete(network string) error {
+	prefix, err := parseNetwork(network)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path == "" {
+		return ErrDisabled
+	}
+	previous := current.get(prefix)
+	if previous == nil {
+		return fmt.Errorf("%s: %w", prefix, ErrNotFound)
+	}
+	current.remove(prefix)
+	if err := saveLocked(); err != nil {
+		current.insert(prefix, previous)
+		return err
+	}
+	return nil
+}
+
+// saveLocked 原子性地将当前记录写回覆盖文件，调用者必须持有写锁
+func saveLocked() error {
+	data, err := encodeFile(path, listLocked())
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	stamp = statStamp(path)
+	return nil
+}
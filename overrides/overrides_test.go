@@ -0,0 +1,110 @@
+package overrides
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useFile 加载 dir 中的覆盖文件，测试结束后恢复包的状态
+func useFile(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "overrides.csv")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		path, current, stamp = "", &tree{}, fileStamp{}
+		mu.Unlock()
+		onReload = nil
+	})
+	if err := Load(p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"10.1.2.3/8", "10.0.0.0/8", true},
+		{" fd00::/8 ", "fd00::/8", true},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8", true},
+		{"::ffff:192.0.2.1/128", "192.0.2.1/32", true},
+		{"::ffff:0:0/96", "0.0.0.0/0", true},
+		{"::ffff:0:0/95", "::fffe:0:0/95", true},
+		{"10.0.0.0", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseNetwork(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseNetwork(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && got.String() != tt.want {
+			t.Errorf("parseNetwork(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLookupMappedNetwork(t *testing.T) {
+	useFile(t, "network,country,org\n::ffff:10.0.0.0/104,DE,Example Corp\n")
+
+	for _, ip := range []string{"10.1.2.3", "::ffff:10.1.2.3"} {
+		e, span, ok := Lookup(net.ParseIP(ip))
+		if !ok {
+			t.Fatalf("Lookup(%s) found no override", ip)
+		}
+		if e.Network != "10.0.0.0/8" || e.Country != "DE" || span.String() != "10.0.0.0/8" {
+			t.Errorf("Lookup(%s) = %+v, span %s", ip, e, span)
+		}
+	}
+	if _, _, ok := Lookup(net.ParseIP("11.0.0.1")); ok {
+		t.Error("Lookup(11.0.0.1) found an override")
+	}
+}
+
+func TestReloadCallsOnReload(t *testing.T) {
+	p := useFile(t, "network,country\n10.0.0.0/8,DE\n")
+	reloads := 0
+	OnReload(func() { reloads++ })
+
+	reloadIfChanged()
+	if reloads != 0 {
+		t.Fatalf("onReload called %d times without a change", reloads)
+	}
+
+	if err := os.WriteFile(p, []byte("network,country\n10.0.0.0/8,FR\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 大小相同时靠修改时间区分版本
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(p, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloadIfChanged()
+	if reloads != 1 {
+		t.Fatalf("onReload called %d times after a change, want 1", reloads)
+	}
+	if e, _, _ := Lookup(net.ParseIP("10.0.0.1")); e.Country != "FR" {
+		t.Errorf("country after reload = %q, want FR", e.Country)
+	}
+
+	// 加载失败时保留旧的记录且不调用 onReload
+	if err := os.WriteFile(p, []byte("network,country\n10.0.0.0/8,XYZ\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadIfChanged()
+	if reloads != 1 {
+		t.Errorf("onReload called after a failed reload")
+	}
+	if e, _, _ := Lookup(net.ParseIP("10.0.0.1")); e.Country != "FR" {
+		t.Errorf("country after failed reload = %q, want FR", e.Country)
+	}
+}
@@ -0,0 +1,178 @@
+package overrides
+
+import "net/netip"
+
+// node 是路径压缩的二进制基数树中的一个节点。
+// 没有 entry 的节点只用于分叉，删除覆盖后没有用处的节点会被合并。
+type node struct {
+	prefix   netip.Prefix
+	entry    *Entry
+	children [2]*node
+}
+
+// tree 按最长前缀匹配保存覆盖记录，IPv4 和 IPv6 分别保存在两棵树中
+type tree struct {
+	v4, v6 *node
+	count  int
+}
+
+func (t *tree) root(addr netip.Addr) **node {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// insert 插入或替换前缀的覆盖记录，prefix 必须已经规范化（Masked）
+func (t *tree) insert(prefix netip.Prefix, e *Entry) {
+	if insertNode(t.root(prefix.Addr()), prefix, e) {
+		t.count++
+	}
+}
+
+// insertNode 返回是否新增了记录（而不是替换已有的记录）
+func insertNode(n **node, prefix netip.Prefix, e *Entry) bool {
+	cur := *n
+	if cur == nil {
+		*n = &node{prefix: prefix, entry: e}
+		return true
+	}
+
+	common := commonBits(cur.prefix.Addr(), prefix.Addr(), min(cur.prefix.Bits(), prefix.Bits()))
+	switch {
+	case common == cur.prefix.Bits() && common == prefix.Bits():
+		added := cur.entry == nil
+		cur.entry = e
+		return added
+	case common == cur.prefix.Bits():
+		// 新前缀在当前节点之下
+		return insertNode(&cur.children[bitAt(prefix.Addr(), common)], prefix, e)
+	case common == prefix.Bits():
+		// 当前节点在新前缀之下
+		parent := &node{prefix: prefix, entry: e}
+		parent.children[bitAt(cur.prefix.Addr(), common)] = cur
+		*n = parent
+		return true
+	default:
+		// 在第一个不同的位分叉
+		fork := &node{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+		fork.children[bitAt(prefix.Addr(), common)] = &node{prefix: prefix, entry: e}
+		fork.children[bitAt(cur.prefix.Addr(), common)] = cur
+		*n = fork
+		return true
+	}
+}
+
+// remove 删除前缀的覆盖记录，返回是否存在
+func (t *tree) remove(prefix netip.Prefix) bool {
+	if removeNode(t.root(prefix.Addr()), prefix) {
+		t.count--
+		return true
+	}
+	return false
+}
+
+func removeNode(n **node, prefix netip.Prefix) bool {
+	cur := *n
+	if cur == nil || cur.prefix.Bits() > prefix.Bits() || !cur.prefix.Contains(prefix.Addr()) {
+		return false
+	}
+	if cur.prefix == prefix {
+		if cur.entry == nil {
+			return false
+		}
+		cur.entry = nil
+	} else if !removeNode(&cur.children[bitAt(prefix.Addr(), cur.prefix.Bits())], prefix) {
+		return false
+	}
+
+	// 没有记录的节点最多保留两个子节点的分叉
+	if cur.entry == nil {
+		switch {
+		case cur.children[0] == nil:
+			*n = cur.children[1]
+		case cur.children[1] == nil:
+			*n = cur.children[0]
+		}
+	}
+	return true
+}
+
+// lookup 返回包含地址的最长前缀的节点
+func (t *tree) lookup(addr netip.Addr) *node {
+	var best *node
+	n := *t.root(addr)
+	for n != nil && n.prefix.Contains(addr) {
+		if n.entry != nil {
+			best = n
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		n = n.children[bitAt(addr, n.prefix.Bits())]
+	}
+	return best
+}
+
+// span 返回一个包含地址的网络，网络中所有地址的最长前缀匹配结果都相同
+func (t *tree) span(addr netip.Addr) netip.Prefix {
+	bits := 0
+	n := *t.root(addr)
+	for n != nil {
+		if !n.prefix.Contains(addr) {
+			// 地址与 n 的子树在第一个不同的位分开，地址一侧的一半网络中没有记录
+			return netip.PrefixFrom(addr, commonBits(n.prefix.Addr(), addr, n.prefix.Bits())+1).Masked()
+		}
+		if n.children[0] == nil && n.children[1] == nil {
+			return n.prefix
+		}
+		bits = n.prefix.Bits() + 1
+		n = n.children[bitAt(addr, n.prefix.Bits())]
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// get 返回与前缀完全相同的记录
+func (t *tree) get(prefix netip.Prefix) *Entry {
+	n := *t.root(prefix.Addr())
+	for n != nil && n.prefix.Bits() <= prefix.Bits() && n.prefix.Contains(prefix.Addr()) {
+		if n.prefix == prefix {
+			return n.entry
+		}
+		n = n.children[bitAt(prefix.Addr(), n.prefix.Bits())]
+	}
+	return nil
+}
+
+// walk 按地址顺序访问所有记录，IPv4 在前
+func (t *tree) walk(fn func(n *node)) {
+	var visit func(n *node)
+	visit = func(n *node) {
+		if n == nil {
+			return
+		}
+		if n.entry != nil {
+			fn(n)
+		}
+		visit(n.children[0])
+		visit(n.children[1])
+	}
+	visit(t.v4)
+	visit(t.v6)
+}
+
+// bitAt 返回地址的第 i 位（从最高位开始计数）
+func bitAt(addr netip.Addr, i int) int {
+	b := addr.AsSlice()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits 返回两个地址在前 bits 位中相同的位数
+func commonBits(a, b netip.Addr, bits int) int {
+	for i := 0; i < bits; i++ {
+		if bitAt(a, i) != bitAt(b, i) {
+			return i
+		}
+	}
+	return bits
+}
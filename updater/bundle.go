@@ -0,0 +1,333 @@
+package updater
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ip-api/config"
+)
+
+// 数据库包中的固定文件名
+const (
+	bundleManifestName  = "manifest.json"
+	bundleSignatureName = "manifest.sig"
+	bundleDatabaseDir   = "databases/"
+)
+
+// BundleManifest 是数据库包的清单，签名覆盖清单的原始字节
+type BundleManifest struct {
+	CreatedAt time.Time     `json:"created_at"`
+	Databases []BundleEntry `json:"databases"`
+}
+
+// BundleEntry 描述数据库包中的一个数据库文件
+type BundleEntry struct {
+	Name         string `json:"name"`
+	File         string `json:"file"`
+	BuildEpoch   uint   `json:"build_epoch"`
+	SHA256       string `json:"sha256"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	DatabaseType string `json:"database_type"`
+}
+
+// GenerateBundleKey 生成用于签名数据库包的 ed25519 密钥对，返回 base64 编码的私钥和公钥
+func GenerateBundleKey() (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(private), base64.StdEncoding.EncodeToString(public), nil
+}
+
+// LoadSigningKey 从文件读取 base64 编码的 ed25519 私钥
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid signing key in %s", path)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// ExportBundle 将当前的数据库集合写入签名的数据库包
+func ExportBundle(w io.Writer, key ed25519.PrivateKey) (*BundleManifest, error) {
+	type source struct {
+		f    *os.File
+		file string
+		size int64
+	}
+	var sources []source
+	defer func() {
+		for _, s := range sources {
+			s.f.Close()
+		}
+	}()
+
+	manifest := &BundleManifest{CreatedAt: time.Now().UTC()}
+	for _, db := range config.App.Databases {
+		f, info, err := OpenMirrorFile(db.Name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				log.Printf("Skipping %s: database file not found", db.Name)
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", db.Name, err)
+		}
+		etag, _ := readETag(etagPath(db))
+		entry := BundleEntry{
+			Name:         db.Name,
+			File:         bundleDatabaseDir + db.FileName(),
+			BuildEpoch:   info.BuildEpoch,
+			SHA256:       info.SHA256,
+			Size:         info.Size,
+			ETag:         etag,
+			DatabaseType: info.DatabaseType,
+		}
+		manifest.Databases = append(manifest.Databases, entry)
+		sources = append(sources, source{f: f, file: entry.File, size: entry.Size})
+	}
+	if len(manifest.Databases) == 0 {
+		return nil, fmt.Errorf("no database files to export")
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestData))
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	writeEntry := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    size,
+			ModTime: manifest.CreatedAt,
+		}); err != nil {
+			return err
+		}
+		_, err := io.CopyN(tw, r, size)
+		return err
+	}
+
+	if err := writeEntry(bundleManifestName, int64(len(manifestData)), strings.NewReader(string(manifestData))); err != nil {
+		return nil, err
+	}
+	if err := writeEntry(bundleSignatureName, int64(len(signature)), strings.NewReader(signature)); err != nil {
+		return nil, err
+	}
+	for _, s := range sources {
+		if err := writeEntry(s.file, s.size, s.f); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", s.file, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// extractedFile 是从数据库包中解压的文件
+type extractedFile struct {
+	path   string
+	size   int64
+	sha256 string
+}
+
+// ImportBundle 校验数据库包的签名和校验和，然后逐个安装其中的数据库。
+// 返回清单和成功安装的数据库，调用者负责热加载它们。
+// 内容与当前文件相同的数据库和被固定版本的数据库会被跳过，注册表中不存在的数据库会被忽略。
+func ImportBundle(r io.Reader) (*BundleManifest, []config.Database, error) {
+	if len(config.App.Bundle.TrustedKeys) == 0 {
+		return nil, nil, fmt.Errorf("no trusted bundle keys configured")
+	}
+
+	if err := os.MkdirAll(config.App.DataDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	// 解压到 DataDir 内的临时目录，保证安装时的重命名是原子的
+	tmpDir, err := os.MkdirTemp(config.App.DataDir, ".bundle-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifestData, signature, files, err := extractBundle(r, tmpDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifestData == nil || signature == nil {
+		return nil, nil, fmt.Errorf("bundle is missing %s or %s", bundleManifestName, bundleSignatureName)
+	}
+	if err := verifyBundleSignature(manifestData, signature); err != nil {
+		return nil, nil, err
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+
+	// 安装任何数据库之前先核对所有文件，避免导入被篡改的数据库包的一部分
+	for _, entry := range manifest.Databases {
+		file, ok := files[entry.File]
+		if !ok {
+			return nil, nil, fmt.Errorf("bundle is missing %s", entry.File)
+		}
+		if file.size != entry.Size || !strings.EqualFold(file.sha256, entry.SHA256) {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", entry.File)
+		}
+	}
+
+	var installed []config.Database
+	var errs []error
+	for _, entry := range manifest.Databases {
+		db, ok := config.DatabaseByName(entry.Name)
+		if !ok {
+			log.Printf("Skipping %s from bundle: not in the database registry", entry.Name)
+			continue
+		}
+		if pinned := pinnedVersion(db); pinned != 0 {
+			log.Printf("Skipping %s from bundle: pinned to version %d", db.Name, pinned)
+			continue
+		}
+
+		if f, current, err := OpenMirrorFile(db.Name); err == nil {
+			f.Close()
+			if strings.EqualFold(current.SHA256, entry.SHA256) {
+				log.Printf("%s from bundle is already installed", db.Name)
+				continue
+			}
+		}
+
+		err := installDatabase(db, files[entry.File].path, entry.ETag)
+		recordResult(db, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", db.Name, err))
+			continue
+		}
+		if entry.ETag != "" {
+			if err := writeETag(etagPath(db), entry.ETag); err != nil {
+				log.Printf("Warning: failed to write ETag for %s: %v", db.Name, err)
+			}
+		}
+		log.Printf("Imported %s (build epoch %d) from bundle", db.Name, entry.BuildEpoch)
+		installed = append(installed, db)
+	}
+
+	return &manifest, installed, errors.Join(errs...)
+}
+
+// extractBundle 解压数据库包，清单和签名读入内存，数据库文件写入 dir
+func extractBundle(r io.Reader, dir string) ([]byte, []byte, map[string]extractedFile, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	defer gr.Close()
+
+	var manifestData, signature []byte
+	files := make(map[string]extractedFile)
+	remaining := config.App.Bundle.MaxBundleSize()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Size > remaining {
+			return nil, nil, nil, fmt.Errorf("bundle exceeds maximum size of %d bytes", config.App.Bundle.MaxBundleSize())
+		}
+		remaining -= header.Size
+
+		name := path.Clean(header.Name)
+		switch {
+		case name == bundleManifestName:
+			if manifestData, err = io.ReadAll(io.LimitReader(tr, 1<<20)); err != nil {
+				return nil, nil, nil, err
+			}
+		case name == bundleSignatureName:
+			if signature, err = io.ReadAll(io.LimitReader(tr, 1<<10)); err != nil {
+				return nil, nil, nil, err
+			}
+		case strings.HasPrefix(name, bundleDatabaseDir) && path.Dir(name)+"/" == bundleDatabaseDir:
+			file, err := extractFile(tr, filepath.Join(dir, path.Base(name)))
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+			files[name] = file
+		default:
+			log.Printf("Ignoring unexpected bundle entry %s", header.Name)
+		}
+	}
+	return manifestData, signature, files, nil
+}
+
+// extractFile 将 r 写入 dest 并计算哈希
+func extractFile(r io.Reader, dest string) (extractedFile, error) {
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return extractedFile{}, err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, hasher), r)
+	if err != nil {
+		return extractedFile{}, err
+	}
+	if err := out.Sync(); err != nil {
+		return extractedFile{}, err
+	}
+	return extractedFile{path: dest, size: n, sha256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// verifyBundleSignature 检查清单是否由任意一个受信任的公钥签名
+func verifyBundleSignature(manifest, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid bundle signature")
+	}
+	for _, encoded := range config.App.Bundle.TrustedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Printf("Warning: ignoring invalid trusted bundle key %q", encoded)
+			continue
+		}
+		if ed25519.Verify(ed25519.PublicKey(key), manifest, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bundle signature is not from a trusted key")
+}
@@ -0,0 +1,73 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ip-api/config"
+)
+
+func TestImportBundleSkipsPinnedDatabase(t *testing.T) {
+	old := config.App
+	defer func() { config.App = old }()
+
+	db := config.Database{Name: "Test-City", Role: config.RoleCity}
+	config.App.Databases = []config.Database{db}
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	config.App.Bundle.TrustedKeys = []string{base64.StdEncoding.EncodeToString(public)}
+
+	config.App.DataDir = t.TempDir()
+	writeTestMMDB(t, db.Path(), 1700000000)
+	var bundle bytes.Buffer
+	if _, err := ExportBundle(&bundle, private); err != nil {
+		t.Fatal(err)
+	}
+
+	config.App.DataDir = t.TempDir()
+	os.MkdirAll(versionsDir(db), 0755)
+	os.WriteFile(pinPath(db), []byte("5"), 0644)
+
+	_, installed, err := ImportBundle(&bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 0 {
+		t.Errorf("installed %v, want the pinned database skipped", installed)
+	}
+	if _, err := os.Stat(db.Path()); !os.IsNotExist(err) {
+		t.Errorf("%s was written despite the pin", filepath.Base(db.Path()))
+	}
+}
+
+func TestUploadSnapshotOffline(t *testing.T) {
+	old := config.App
+	defer func() { config.App = old }()
+
+	store := &fakeS3{t: t, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+	config.App.Snapshot.S3 = testS3
+	config.App.Snapshot.S3.Endpoint = srv.URL
+
+	db := config.Database{Name: "Test-City", Role: config.RoleCity}
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	writeTestMMDB(t, path, 1700000000)
+
+	config.App.UpdateMode = config.UpdateModeOffline
+	uploadSnapshot(db, path)
+	if len(store.objects) != 0 {
+		t.Fatalf("offline mode uploaded %d objects", len(store.objects))
+	}
+
+	config.App.UpdateMode = config.UpdateModeDownload
+	uploadSnapshot(db, path)
+	if _, ok := store.objects["/examplebucket/Test-City/1700000000.mmdb"]; !ok {
+		t.Errorf("snapshot not uploaded, have %d objects", len(store.objects))
+	}
+}
@@ -0,0 +1,178 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+// errCanaryRejected 表示新版本未通过金丝雀校验
+var errCanaryRejected = errors.New("rejected by canary validation")
+
+// CanaryReport 是一次金丝雀校验的结果
+type CanaryReport struct {
+	Checked  int      `json:"checked"`
+	Failed   int      `json:"failed"`
+	Ratio    float64  `json:"ratio"`
+	Rejected bool     `json:"rejected"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// runCanary 在暂存的数据库文件中查询黄金 IP 集合，校验新版本的数据库。
+// 没有适用于该数据库角色的断言时返回 nil 报告。
+func runCanary(db config.Database, stagedPath string) (*CanaryReport, error) {
+	golden := config.App.Canary.Golden
+	if len(golden) == 0 {
+		return nil, nil
+	}
+
+	provider, err := geoip.OpenFile(db, stagedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+
+	report := &CanaryReport{}
+	for _, g := range golden {
+		ip := net.ParseIP(g.IP)
+		if ip == nil {
+			// 加载配置时已经校验，这里只防御直接修改的配置
+			return nil, fmt.Errorf("invalid canary golden ip %q", g.IP)
+		}
+
+		applicable, failure, err := checkGolden(provider, db.Role, ip, g)
+		if err != nil {
+			return nil, fmt.Errorf("canary lookup for %s failed: %w", g.IP, err)
+		}
+		if !applicable {
+			continue
+		}
+		report.Checked++
+		if failure != "" {
+			report.Failed++
+			report.Failures = append(report.Failures, g.IP+": "+failure)
+		}
+	}
+
+	if report.Checked == 0 {
+		return nil, nil
+	}
+	report.Ratio = float64(report.Failed) / float64(report.Checked)
+	report.Rejected = report.Ratio > config.App.Canary.MaxFailureRatio
+	return report, nil
+}
+
+// checkGolden 根据数据库角色检查一个黄金 IP。
+// 返回该断言是否适用于此数据库，以及失败原因（通过时为空）。
+func checkGolden(provider geoip.Provider, role string, ip net.IP, g config.GoldenIP) (bool, string, error) {
+	switch role {
+	case config.RoleCity, config.RoleEnterprise:
+		if len(g.Country) == 0 && len(g.Province) == 0 && (g.Latitude == nil || g.Longitude == nil) {
+			return false, "", nil
+		}
+		rec, _, _, err := provider.Lookup(ip)
+		if err != nil {
+			return true, "", err
+		}
+		if len(g.Country) > 0 && !containsFold(g.Country, rec.CountryCode) {
+			return true, fmt.Sprintf("country %q not in %v", rec.CountryCode, g.Country), nil
+		}
+		if len(g.Province) > 0 {
+			var names []string
+			for _, name := range rec.RegionNames {
+				names = append(names, name)
+			}
+			if !matchProvince(g.Province, names...) {
+				return true, fmt.Sprintf("province %v not in %v", names, g.Province), nil
+			}
+		}
+		if g.Latitude != nil && g.Longitude != nil {
+			radius := g.RadiusKm
+			if radius <= 0 {
+				radius = 100
+			}
+			var lat, lon float64
+			if rec.Location != nil {
+				lat, lon = rec.Location.Latitude, rec.Location.Longitude
+			}
+			distance := haversineKm(*g.Latitude, *g.Longitude, lat, lon)
+			if distance > radius {
+				return true, fmt.Sprintf("location is %.0f km away (tolerance %.0f km)", distance, radius), nil
+			}
+		}
+		return true, "", nil
+
+	case config.RoleASN, config.RoleISP:
+		if len(g.ASN) == 0 {
+			return false, "", nil
+		}
+		rec, _, _, err := provider.Lookup(ip)
+		if err != nil {
+			return true, "", err
+		}
+		for _, want := range g.ASN {
+			if rec.ASN == want {
+				return true, "", nil
+			}
+		}
+		return true, fmt.Sprintf("ASN %d not in %v", rec.ASN, g.ASN), nil
+
+	case config.RoleCN:
+		if len(g.Province) == 0 {
+			return false, "", nil
+		}
+		rec, _, _, err := provider.Lookup(ip)
+		if err != nil {
+			return true, "", err
+		}
+		province := rec.RegionNames["zh-CN"]
+		if !matchProvince(g.Province, province) {
+			return true, fmt.Sprintf("province %q not in %v", province, g.Province), nil
+		}
+		return true, "", nil
+	}
+
+	return false, "", nil
+}
+
+// containsFold 检查 values 中是否有与 s 不区分大小写相等的值
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchProvince 检查任意名称是否与期望的省份匹配，允许省略“省”“市”等后缀
+func matchProvince(want []string, names ...string) bool {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, w := range want {
+			if strings.EqualFold(w, name) || strings.HasPrefix(name, w) || strings.HasPrefix(w, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// haversineKm 返回两个坐标之间的大圆距离（公里）
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
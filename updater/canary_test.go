@@ -0,0 +1,177 @@
+package updater
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+// writeFakeDB 把固定记录写入 fake 数据源读取的 JSON 文件
+func writeFakeDB(t *testing.T, entries []geoip.FakeEntry) string {
+	t.Helper()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "staged.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+// cityEntries 是金丝雀测试使用的城市数据
+var cityEntries = []geoip.FakeEntry{
+	{Network: "8.8.8.0/24", Record: geoip.Record{
+		CountryCode: "US", RegionNames: geoip.Names{"en": "California"},
+		Location: &geoip.Location{Latitude: 37.4, Longitude: -122.1},
+	}},
+	{Network: "1.2.4.0/24", Record: geoip.Record{
+		CountryCode: "CN", RegionNames: geoip.Names{"en": "Beijing", "zh-CN": "北京市"},
+	}},
+}
+
+func TestRunCanary(t *testing.T) {
+	old := config.App
+	defer func() { config.App = old }()
+	db := config.Database{Name: "Test-City", Role: config.RoleCity, Provider: config.ProviderFake}
+	staged := writeFakeDB(t, cityEntries)
+
+	tests := []struct {
+		name     string
+		golden   []config.GoldenIP
+		ratio    float64
+		checked  int
+		failed   int
+		rejected bool
+		failures []string
+	}{
+		{
+			name: "pass",
+			golden: []config.GoldenIP{
+				{IP: "8.8.8.8", Country: []string{"us"}},
+				{IP: "1.2.4.8", Province: []string{"北京"}},
+				{IP: "8.8.8.8", Latitude: float(37.5), Longitude: float(-122), RadiusKm: 50},
+				// 只有 ASN 断言，不适用于城市数据库
+				{IP: "8.8.8.8", ASN: []uint{15169}},
+			},
+			ratio:   0.1,
+			checked: 3,
+		},
+		{
+			name: "failure within ratio",
+			golden: []config.GoldenIP{
+				{IP: "8.8.8.8", Country: []string{"US"}},
+				{IP: "1.2.4.8", Country: []string{"JP", "KR"}},
+			},
+			ratio:    0.5,
+			checked:  2,
+			failed:   1,
+			failures: []string{`1.2.4.8: country "CN" not in [JP KR]`},
+		},
+		{
+			name: "rejected",
+			golden: []config.GoldenIP{
+				{IP: "8.8.8.8", Country: []string{"US"}},
+				{IP: "8.8.8.8", Latitude: float(51.5), Longitude: float(-0.1)},
+				{IP: "192.0.2.1", Country: []string{"US"}},
+			},
+			ratio:    0.5,
+			checked:  3,
+			failed:   2,
+			rejected: true,
+			failures: []string{"8.8.8.8: location is", `192.0.2.1: country "" not in [US]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.App.Canary = config.Canary{Golden: tt.golden, MaxFailureRatio: tt.ratio}
+			report, err := runCanary(db, staged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report == nil {
+				t.Fatal("runCanary returned no report")
+			}
+			if report.Checked != tt.checked || report.Failed != tt.failed || report.Rejected != tt.rejected {
+				t.Errorf("report = %+v, want checked %d failed %d rejected %v", report, tt.checked, tt.failed, tt.rejected)
+			}
+			if len(report.Failures) != len(tt.failures) {
+				t.Fatalf("failures = %q, want %q", report.Failures, tt.failures)
+			}
+			for i, want := range tt.failures {
+				if !strings.HasPrefix(report.Failures[i], want) {
+					t.Errorf("failure %d = %q, want prefix %q", i, report.Failures[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCanaryWithoutAssertions(t *testing.T) {
+	old := config.App
+	defer func() { config.App = old }()
+	db := config.Database{Name: "Test-City", Role: config.RoleCity, Provider: config.ProviderFake}
+	staged := writeFakeDB(t, cityEntries)
+
+	config.App.Canary = config.Canary{}
+	if report, err := runCanary(db, staged); report != nil || err != nil {
+		t.Errorf("runCanary without golden IPs = %+v, %v", report, err)
+	}
+
+	// 没有适用于该角色的断言时不产生报告
+	config.App.Canary = config.Canary{Golden: []config.GoldenIP{{IP: "8.8.8.8", ASN: []uint{15169}}}}
+	if report, err := runCanary(db, staged); report != nil || err != nil {
+		t.Errorf("runCanary without applicable assertions = %+v, %v", report, err)
+	}
+
+	config.App.Canary = config.Canary{Golden: []config.GoldenIP{{IP: "8.8.8.300", Country: []string{"US"}}}}
+	if _, err := runCanary(db, staged); err == nil {
+		t.Error("runCanary accepted an invalid golden IP")
+	}
+}
+
+func TestCheckGolden(t *testing.T) {
+	provider := &geoip.FakeProvider{Entries: []geoip.FakeEntry{
+		{Network: "8.8.8.0/24", Record: geoip.Record{ASN: 15169}},
+		{Network: "1.2.4.0/24", Record: geoip.Record{RegionNames: geoip.Names{"zh-CN": "北京市"}}},
+	}}
+	if err := provider.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role       string
+		ip         string
+		golden     config.GoldenIP
+		applicable bool
+		failure    string
+	}{
+		{config.RoleASN, "8.8.8.8", config.GoldenIP{ASN: []uint{13335, 15169}}, true, ""},
+		{config.RoleISP, "8.8.8.8", config.GoldenIP{ASN: []uint{13335}}, true, "ASN 15169 not in [13335]"},
+		{config.RoleASN, "8.8.8.8", config.GoldenIP{Country: []string{"US"}}, false, ""},
+		{config.RoleCN, "1.2.4.8", config.GoldenIP{Province: []string{"北京"}}, true, ""},
+		{config.RoleCN, "1.2.4.8", config.GoldenIP{Province: []string{"上海"}}, true, `province "北京市" not in [上海]`},
+		{config.RoleCN, "1.2.4.8", config.GoldenIP{ASN: []uint{4808}}, false, ""},
+		{config.RoleCustom, "8.8.8.8", config.GoldenIP{Country: []string{"US"}}, false, ""},
+	}
+	for _, tt := range tests {
+		applicable, failure, err := checkGolden(provider, tt.role, net.ParseIP(tt.ip), tt.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applicable != tt.applicable || failure != tt.failure {
+			t.Errorf("checkGolden(%s, %s, %+v) = %v %q, want %v %q", tt.role, tt.ip, tt.golden, applicable, failure, tt.applicable, tt.failure)
+		}
+	}
+}
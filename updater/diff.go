@@ -0,0 +1,152 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ip-api/config"
+	"ip-api/dbdiff"
+)
+
+// diffsDir 返回保存数据库差异报告的目录
+func diffsDir(db config.Database) string {
+	return filepath.Join(config.App.DataDir, "diffs", db.Name)
+}
+
+// writeDiffReport 比较当前文件和暂存的新版本，并将报告写入差异目录。
+// 比较失败只记录日志，不影响更新。
+func writeDiffReport(db config.Database, oldPath, newPath string) {
+	if !db.IsMMDB() {
+		return
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		return
+	}
+
+	report, err := dbdiff.Compare(oldPath, newPath, db.Role)
+	if err != nil {
+		log.Printf("Warning: failed to diff %s: %v", db.Name, err)
+		return
+	}
+
+	if err := os.MkdirAll(diffsDir(db), 0755); err != nil {
+		log.Printf("Warning: failed to create diff directory: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Warning: failed to encode diff of %s: %v", db.Name, err)
+		return
+	}
+	name := fmt.Sprintf("%d-%d.json", report.OldBuildEpoch, report.NewBuildEpoch)
+	if err := os.WriteFile(filepath.Join(diffsDir(db), name), data, 0644); err != nil {
+		log.Printf("Warning: failed to write diff of %s: %v", db.Name, err)
+		return
+	}
+
+	s := report.Summary
+	log.Printf("Diff of %s: %s addresses added, %s removed, %s changed",
+		db.Name, s.AddedAddresses, s.RemovedAddresses, s.ChangedAddresses)
+	pruneDiffs(db)
+}
+
+// diffFiles 返回保存的差异报告文件名，按修改时间从新到旧排列
+func diffFiles(db config.Database) ([]string, error) {
+	entries, err := os.ReadDir(diffsDir(db))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type file struct {
+		name  string
+		mtime int64
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{e.Name(), info.ModTime().UnixNano()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].mtime != files[j].mtime {
+			return files[i].mtime > files[j].mtime
+		}
+		return files[i].name > files[j].name
+	})
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+// pruneDiffs 只保留与历史版本数量相同的最新报告
+func pruneDiffs(db config.Database) {
+	keep := db.VersionsToKeep()
+	if keep <= 0 {
+		return
+	}
+	names, err := diffFiles(db)
+	if err != nil {
+		return
+	}
+	for i := keep; i < len(names); i++ {
+		os.Remove(filepath.Join(diffsDir(db), names[i]))
+	}
+}
+
+// LatestDiff 返回数据库最近一次更新的差异报告
+func LatestDiff(name string) (*dbdiff.Report, error) {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+	names, err := diffFiles(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no diff report for %s", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(diffsDir(db), names[0]))
+	if err != nil {
+		return nil, err
+	}
+	var report dbdiff.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid diff report %s: %w", names[0], err)
+	}
+	return &report, nil
+}
+
+// DiffVersions 比较数据库保存的两个历史版本
+func DiffVersions(name string, from, to uint) (*dbdiff.Report, error) {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+	if !db.IsMMDB() {
+		return nil, fmt.Errorf("diff is not supported for %s databases", db.ProviderKind())
+	}
+	for _, epoch := range []uint{from, to} {
+		if _, err := os.Stat(versionPath(db, epoch)); err != nil {
+			return nil, fmt.Errorf("version %d of %s not found", epoch, name)
+		}
+	}
+	return dbdiff.Compare(versionPath(db, from), versionPath(db, to), db.Role)
+}
@@ -0,0 +1,174 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+// MirrorFile 是 leader 发布的一个数据库文件的元数据
+type MirrorFile struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	BuildEpoch   uint      `json:"build_epoch"`
+	DatabaseType string    `json:"database_type"`
+	ModTime      time.Time `json:"mod_time"`
+}
+
+// ETag 返回文件的强 ETag，由内容的 SHA-256 生成
+func (f MirrorFile) ETag() string {
+	return `"` + f.SHA256 + `"`
+}
+
+var (
+	mirrorMu sync.Mutex
+	// mirrorCache 按数据库名称缓存文件元数据，文件大小和修改时间不变时复用
+	mirrorCache = make(map[string]mirrorEntry)
+)
+
+type mirrorEntry struct {
+	stamp fileStamp
+	file  MirrorFile
+}
+
+// MirrorManifest 返回当前所有已安装数据库文件的元数据
+func MirrorManifest() ([]MirrorFile, error) {
+	var files []MirrorFile
+	for _, db := range config.App.Databases {
+		f, info, err := OpenMirrorFile(db.Name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		f.Close()
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// OpenMirrorFile 打开数据库的当前文件并返回其元数据。
+// 元数据根据打开的文件句柄计算，即使文件随后被替换也保持一致。
+func OpenMirrorFile(name string) (*os.File, MirrorFile, error) {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return nil, MirrorFile{}, fmt.Errorf("unknown database %q", name)
+	}
+
+	f, err := os.Open(db.Path())
+	if err != nil {
+		return nil, MirrorFile{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, MirrorFile{}, err
+	}
+	stamp := fileStamp{size: stat.Size(), modTime: stat.ModTime()}
+
+	mirrorMu.Lock()
+	entry, ok := mirrorCache[db.Name]
+	mirrorMu.Unlock()
+	if ok && entry.stamp == stamp {
+		return f, entry.file, nil
+	}
+
+	info, err := describeFile(db, f, stat)
+	if err != nil {
+		f.Close()
+		return nil, MirrorFile{}, err
+	}
+	mirrorMu.Lock()
+	mirrorCache[db.Name] = mirrorEntry{stamp: stamp, file: info}
+	mirrorMu.Unlock()
+	return f, info, nil
+}
+
+// describeFile 流式计算文件的哈希并读取数据库元数据，完成后将读取位置恢复到开头。
+// 哈希从已打开的文件计算，与随后发送的内容一致，即使路径上的文件已被替换。
+func describeFile(db config.Database, f *os.File, stat os.FileInfo) (MirrorFile, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return MirrorFile{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return MirrorFile{}, err
+	}
+
+	meta, err := geoip.FileMetadata(db, f.Name())
+	if err != nil {
+		return MirrorFile{}, err
+	}
+	return MirrorFile{
+		Name:         db.Name,
+		Size:         stat.Size(),
+		SHA256:       hex.EncodeToString(hasher.Sum(nil)),
+		BuildEpoch:   meta.BuildEpoch,
+		DatabaseType: meta.DatabaseType,
+		ModTime:      stat.ModTime().UTC(),
+	}, nil
+}
+
+// signMirror 添加访问 leader 的认证头
+func signMirror(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+config.App.Mirror.Token)
+	return nil
+}
+
+// downloadFromLeader 从 leader 拉取数据库。leader 上的版本与本地相同时返回 errNotModified。
+func downloadFromLeader(db config.Database) error {
+	leader := strings.TrimRight(config.App.Mirror.LeaderURL, "/")
+
+	req, err := http.NewRequest("GET", leader+"/mirror/manifest", nil)
+	if err != nil {
+		return err
+	}
+	signMirror(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader manifest request failed with status: %s", resp.Status)
+	}
+
+	var manifest []MirrorFile
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&manifest); err != nil {
+		return fmt.Errorf("invalid leader manifest: %w", err)
+	}
+
+	var remote *MirrorFile
+	for i := range manifest {
+		if manifest[i].Name == db.Name {
+			remote = &manifest[i]
+		}
+	}
+	if remote == nil {
+		return fmt.Errorf("leader does not publish %s", db.Name)
+	}
+
+	// 本地文件与 leader 的内容相同时无需下载
+	if f, local, err := OpenMirrorFile(db.Name); err == nil {
+		f.Close()
+		if local.SHA256 == remote.SHA256 {
+			return errNotModified
+		}
+	}
+
+	fileURL := leader + "/mirror/files/" + url.PathEscape(db.Name)
+	return downloadAndExtract(db, fileURL, fetchOptions{sign: signMirror, sha256: remote.SHA256})
+}
@@ -0,0 +1,60 @@
+package updater
+
+import (
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ip-api/config"
+	"ip-api/mmdbwriter"
+)
+
+// writeTestMMDB 写入一个只有一条记录的 City 数据库
+func writeTestMMDB(t *testing.T, path string, epoch int64) {
+	t.Helper()
+	w := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-City", BuildEpoch: time.Unix(epoch, 0)})
+	if err := w.Insert(netip.MustParsePrefix("1.0.0.0/8"), map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "US"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDescribeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	writeTestMMDB(t, path, 1700000000)
+	db := config.Database{Name: "GeoLite2-City", Role: config.RoleCity}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+	info, err := describeFile(db, f, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, size, _ := fileSHA256(path)
+	if info.SHA256 != sum || info.Size != size {
+		t.Errorf("describeFile = %s/%d, want %s/%d", info.SHA256, info.Size, sum, size)
+	}
+	if info.BuildEpoch != 1700000000 || info.DatabaseType != "GeoLite2-City" {
+		t.Errorf("metadata = %d %q", info.BuildEpoch, info.DatabaseType)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("file position = %d, want 0", pos)
+	}
+}
@@ -0,0 +1,236 @@
+package updater
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+)
+
+// emptyPayloadHash 是空请求体的 SHA-256，用于 GET 请求的签名
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// downloadFromS3 从 S3 兼容对象存储下载数据库，使用对象的 ETag 检测变化
+func downloadFromS3(db config.Database) error {
+	s3 := db.Source.S3
+	sign := func(req *http.Request) error {
+		return signS3(req, s3, emptyPayloadHash, time.Now())
+	}
+	return downloadAndExtract(db, s3ObjectURL(s3, s3.Key), fetchOptions{sign: sign})
+}
+
+// s3ObjectURL 返回路径风格的对象 URL，对象键按照 SigV4 的规则编码
+func s3ObjectURL(s3 config.S3, key string) string {
+	return strings.TrimRight(s3.Endpoint, "/") + "/" + s3Escape(s3.Bucket, false) + "/" + s3Escape(strings.TrimLeft(key, "/"), true)
+}
+
+// s3Escape 按 SigV4 的规则编码字符串，只保留非保留字符，keepSlash 时保留路径分隔符
+func s3Escape(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3Credentials 返回配置的访问凭据，为空时使用环境变量
+func s3Credentials(s3 config.S3) (string, string) {
+	id, secret := s3.AccessKeyID, s3.SecretAccessKey
+	if id == "" {
+		id = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secret == "" {
+		secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return id, secret
+}
+
+// signS3 使用 AWS Signature Version 4 为请求签名。
+// 签名覆盖 host、range 和所有 x-amz-* 请求头，payloadHash 是请求体的十六进制 SHA-256。
+func signS3(req *http.Request, s3 config.S3, payloadHash string, now time.Time) error {
+	id, secret := s3Credentials(s3)
+	if id == "" || secret == "" {
+		return fmt.Errorf("missing S3 credentials")
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	region := s3.SigningRegion()
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		id, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalQuery 返回按名称排序并编码的查询字符串
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uploadSnapshot 将新安装的数据库上传到快照存储桶，失败只记录日志。offline 模式下不访问网络，不上传。
+func uploadSnapshot(db config.Database, path string) {
+	target := config.App.Snapshot.S3
+	if target.Bucket == "" || config.App.UpdateMode == config.UpdateModeOffline {
+		return
+	}
+
+	epoch, err := readBuildEpoch(db, path)
+	if err != nil {
+		log.Printf("Warning: failed to read build epoch for snapshot of %s: %v", db.Name, err)
+		return
+	}
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		log.Printf("Warning: failed to hash snapshot of %s: %v", db.Name, err)
+		return
+	}
+
+	key := snapshotKey(target, db.Name+"/"+strconv.FormatUint(uint64(epoch), 10)+db.Extension())
+
+	if err := putS3Object(target, key, path, size, sum); err != nil {
+		log.Printf("Warning: failed to upload snapshot of %s: %v", db.Name, err)
+		return
+	}
+	log.Printf("Uploaded snapshot of %s to s3://%s/%s", db.Name, target.Bucket, key)
+}
+
+// PublishFile 把本地文件上传到快照存储桶中 name 对应的对象，返回对象的地址
+func PublishFile(name, path string) (string, error) {
+	target := config.App.Snapshot.S3
+	if target.Bucket == "" {
+		return "", fmt.Errorf("snapshot.s3 is not configured")
+	}
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	key := snapshotKey(target, name)
+	if err := putS3Object(target, key, path, size, sum); err != nil {
+		return "", err
+	}
+	return "s3://" + target.Bucket + "/" + key, nil
+}
+
+// snapshotKey 在快照配置的对象键前缀下返回 name 的对象键
+func snapshotKey(target config.S3, name string) string {
+	key := strings.Trim(target.Key, "/")
+	if key != "" {
+		key += "/"
+	}
+	return key + name
+}
+
+// putS3Object 上传本地文件，请求体的 SHA-256 参与签名，服务器会据此校验内容
+func putS3Object(s3 config.S3, key, path string, size int64, sha256Hex string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPut, s3ObjectURL(s3, key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Amz-Meta-Sha256", sha256Hex)
+	if err := signS3(req, s3, sha256Hex, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload failed with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
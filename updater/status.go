@@ -0,0 +1,150 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"ip-api/config"
+)
+
+// DBUpdateStatus 是单个数据库的更新状态
+type DBUpdateStatus struct {
+	Name          string        `json:"name"`
+	LastCheck     time.Time     `json:"last_check,omitempty"`
+	LastSuccess   time.Time     `json:"last_success,omitempty"`
+	LastResult    string        `json:"last_result,omitempty"`
+	LastError     string        `json:"last_error,omitempty"`
+	PinnedVersion uint          `json:"pinned_version,omitempty"`
+	LastCanary    *CanaryReport `json:"last_canary,omitempty"`
+	Rejections    int           `json:"canary_rejections"`
+}
+
+// 更新结果，用于状态和指标
+const (
+	resultUpdated     = "updated"
+	resultNotModified = "not_modified"
+	resultPinned      = "pinned"
+	resultRejected    = "rejected"
+	resultError       = "error"
+)
+
+var (
+	statusMu sync.Mutex
+	statuses = make(map[string]*DBUpdateStatus)
+	// updateCounts 按数据库和结果统计更新次数
+	updateCounts = make(map[[2]string]int)
+)
+
+// statusFor 返回数据库的状态记录，调用者必须持有 statusMu
+func statusFor(name string) *DBUpdateStatus {
+	s, ok := statuses[name]
+	if !ok {
+		s = &DBUpdateStatus{Name: name}
+		statuses[name] = s
+	}
+	return s
+}
+
+// recordResult 记录一次更新检查的结果
+func recordResult(db config.Database, err error) {
+	result := resultUpdated
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotModified):
+		result = resultNotModified
+	case errors.Is(err, errPinned):
+		result = resultPinned
+	case errors.Is(err, errCanaryRejected):
+		result = resultRejected
+	default:
+		result = resultError
+	}
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	s := statusFor(db.Name)
+	s.LastCheck = time.Now().UTC()
+	s.LastResult = result
+	s.LastError = ""
+	if err != nil && result != resultNotModified && result != resultPinned {
+		s.LastError = err.Error()
+	}
+	if result == resultUpdated {
+		s.LastSuccess = s.LastCheck
+	}
+	if result == resultRejected {
+		s.Rejections++
+	}
+	updateCounts[[2]string{db.Name, result}]++
+}
+
+// recordCanary 记录最近一次金丝雀校验的报告
+func recordCanary(db config.Database, report *CanaryReport) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	statusFor(db.Name).LastCanary = report
+}
+
+// Status 返回注册表中每个数据库的更新状态
+func Status() []DBUpdateStatus {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	var list []DBUpdateStatus
+	for _, db := range config.App.Databases {
+		s := *statusFor(db.Name)
+		s.PinnedVersion = pinnedVersion(db)
+		list = append(list, s)
+	}
+	return list
+}
+
+// WriteMetrics 以 Prometheus 文本格式写入更新器指标
+func WriteMetrics(w io.Writer) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	keys := make([][2]string, 0, len(updateCounts))
+	for k := range updateCounts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	fmt.Fprintln(w, "# HELP ipapi_db_updates_total Database update checks by result.")
+	fmt.Fprintln(w, "# TYPE ipapi_db_updates_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "ipapi_db_updates_total{db=%q,result=%q} %d\n", k[0], k[1], updateCounts[k])
+	}
+
+	fmt.Fprintln(w, "# HELP ipapi_db_canary_rejections_total Database releases rejected by canary validation.")
+	fmt.Fprintln(w, "# TYPE ipapi_db_canary_rejections_total counter")
+	for _, db := range config.App.Databases {
+		fmt.Fprintf(w, "ipapi_db_canary_rejections_total{db=%q} %d\n", db.Name, statusFor(db.Name).Rejections)
+	}
+
+	fmt.Fprintln(w, "# HELP ipapi_db_canary_failure_ratio Failure ratio of the latest canary validation.")
+	fmt.Fprintln(w, "# TYPE ipapi_db_canary_failure_ratio gauge")
+	for _, db := range config.App.Databases {
+		if report := statusFor(db.Name).LastCanary; report != nil {
+			fmt.Fprintf(w, "ipapi_db_canary_failure_ratio{db=%q} %g\n", db.Name, report.Ratio)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP ipapi_db_last_success_timestamp_seconds Time of the last successful update.")
+	fmt.Fprintln(w, "# TYPE ipapi_db_last_success_timestamp_seconds gauge")
+	for _, db := range config.App.Databases {
+		if last := statusFor(db.Name).LastSuccess; !last.IsZero() {
+			fmt.Fprintf(w, "ipapi_db_last_success_timestamp_seconds{db=%q} %d\n", db.Name, last.Unix())
+		}
+	}
+}
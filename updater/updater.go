@@ -2,10 +2,17 @@ package updater
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,153 +24,391 @@ import (
 	"ip-api/geoip"
 )
 
-var httpClient = &http.Client{Timeout: 30 * time.Second}
+// httpClient 用于下载数据库文件。大文件的下载时间可能远超 30 秒，
+// 因此不设置整体超时，只限制建立连接和等待响应头的时间，读取响应体时使用 idleTimeoutReader。
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// bodyIdleTimeout 是读取响应体时允许的最长无数据时间，超过后中断下载，已保存的部分可以续传
+var bodyIdleTimeout = 60 * time.Second
+
+// idleTimeoutReader 在 timeout 内没有读到任何数据时调用 cancel 中断请求，
+// 每次读到数据后重新计时，因此慢速但持续的下载不受影响
+type idleTimeoutReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimeoutReader(r io.Reader, timeout time.Duration, cancel func()) *idleTimeoutReader {
+	return &idleTimeoutReader{r: r, timer: time.AfterFunc(timeout, cancel), timeout: timeout}
+}
 
-// dbSources 定义了数据库的来源信息
-var dbSources = map[string]struct {
-	EditionID string // MaxMind的数据库版本ID
-	URL       string // 针对非MaxMind源的直接URL
-}{
-	"GeoLite2-City.mmdb": {EditionID: "GeoLite2-City"},
-	"GeoLite2-ASN.mmdb":  {EditionID: "GeoLite2-ASN"},
-	"GeoCN.mmdb":         {URL: "https://github.com/ljxi/GeoCN/releases/download/Latest/GeoCN.mmdb"},
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// Stop 停止计时
+func (r *idleTimeoutReader) Stop() {
+	r.timer.Stop()
 }
 
-// DownloadAll 下载所有数据库文件，等待它们完成。
+// maxDownloadAttempts 是单次更新中续传中断下载的最大尝试次数
+const maxDownloadAttempts = 3
+
+// DownloadAll 下载注册表中的所有数据库文件，等待它们完成。
 // 这用于初始设置。
 func DownloadAll() {
 	log.Println("Performing initial database download...")
 	var wg sync.WaitGroup
-	for fileName, source := range dbSources {
+	for _, db := range config.App.Databases {
 		wg.Add(1)
-		go func(fileName string, source struct {
-			EditionID string
-			URL       string
-		}) {
+		go func(db config.Database) {
 			defer wg.Done()
-			var err error
-			if source.EditionID != "" {
-				// 从 MaxMind 下载
-				err = downloadFromMaxMind(source.EditionID, fileName)
-			} else {
-				// 从指定 URL 下载
-				err = downloadFromURL(source.URL, fileName)
-			}
-			if err != nil {
-				log.Printf("Failed to download %s: %v", fileName, err)
-			} else {
-				log.Printf("Successfully downloaded %s", fileName)
+			err := fetchDatabase(db)
+			recordResult(db, err)
+			switch err {
+			case nil:
+				log.Printf("Successfully downloaded %s", db.Name)
+			case errNotModified:
+				log.Printf("%s is up to date.", db.Name)
+			case errPinned:
+				log.Printf("%s is pinned to version %d, skipping download.", db.Name, pinnedVersion(db))
+			default:
+				log.Printf("Failed to download %s: %v", db.Name, err)
 			}
-		}(fileName, source)
+		}(db)
 	}
 	wg.Wait()
 	log.Println("Initial database download finished.")
 }
 
-// Start 运行定时器定期更新数据库。
-// 它遵循上下文以实现优雅关闭。
+// Start 按照每个数据库各自的更新间隔定期更新数据库。
 func Start() {
-	ticker := time.NewTicker(time.Duration(config.App.UpdateInterval) * time.Hour)
-	defer ticker.Stop()
+	var wg sync.WaitGroup
+	for _, db := range config.App.Databases {
+		wg.Add(1)
+		go func(db config.Database) {
+			defer wg.Done()
+			ticker := time.NewTicker(db.Interval())
+			defer ticker.Stop()
 
-	checkForUpdates := func() {
-		update()
+			for range ticker.C {
+				log.Printf("Starting scheduled update of %s.", db.Name)
+				update(db)
+			}
+		}(db)
 	}
+	wg.Wait()
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			log.Println("Starting scheduled database update.")
-			checkForUpdates()
+// update 尝试更新单个数据库，成功后热加载它。
+func update(db config.Database) {
+	log.Printf("Checking %s...", db.Name)
+	err := fetchDatabase(db)
+	recordResult(db, err)
+	if err != nil {
+		if err == errNotModified {
+			log.Printf("%s is up to date.", db.Name)
+		} else if err == errPinned {
+			log.Printf("%s is pinned to version %d, skipping update.", db.Name, pinnedVersion(db))
+		} else {
+			log.Printf("Failed to update %s: %v", db.Name, err)
 		}
+		return
+	}
+
+	log.Printf("Successfully updated %s", db.Name)
+	if err := geoip.ReloadDB(db); err != nil {
+		log.Printf("Failed to reload %s: %v", db.Name, err)
 	}
 }
 
-// update 尝试下载所有数据库文件。
-func update() {
-	log.Println("Checking for database updates...")
-	for fileName, source := range dbSources {
-		log.Printf("Checking %s...", fileName)
-		var err error
-		if source.EditionID != "" {
-			err = downloadFromMaxMind(source.EditionID, fileName)
-		} else {
-			err = downloadFromURL(source.URL, fileName)
+// fetchDatabase 根据数据库的来源类型获取新版本并安装到 DataDir
+func fetchDatabase(db config.Database) error {
+	if pinnedVersion(db) != 0 {
+		if _, err := os.Stat(db.Path()); err == nil {
+			return errPinned
 		}
+	}
 
-		if err != nil {
-			if err == errNotModified {
-				log.Printf("%s is up to date.", fileName)
-			} else {
-				log.Printf("Failed to update %s: %v", fileName, err)
-			}
-		} else {
-			log.Printf("Successfully updated %s", fileName)
-			// Reload databases after update
-			cityDBPath := filepath.Join(config.App.DataDir, "GeoLite2-City.mmdb")
-			asnDBPath := filepath.Join(config.App.DataDir, "GeoLite2-ASN.mmdb")
-			cnDBPath := filepath.Join(config.App.DataDir, "GeoCN.mmdb")
-			if err = geoip.ReloadDBs(cityDBPath, asnDBPath, cnDBPath); err != nil {
-				log.Printf("Failed to reload databases: %v", err)
-			}
+	// follower 优先从 leader 拉取，leader 不可用时退回到上游来源
+	if config.App.Mirror.Mode == config.MirrorFollower {
+		err := downloadFromLeader(db)
+		if err == nil || err == errNotModified {
+			return err
 		}
+		log.Printf("Failed to fetch %s from leader, falling back to upstream: %v", db.Name, err)
+	}
+
+	switch db.Source.Type {
+	case config.SourceMaxMind:
+		return downloadFromMaxMind(db)
+	case config.SourceURL:
+		return downloadFromURL(db)
+	case config.SourceFile:
+		return copyFromFile(db)
+	case config.SourceS3:
+		return downloadFromS3(db)
+	default:
+		return fmt.Errorf("unknown source type %q", db.Source.Type)
 	}
 }
 
 var errNotModified = fmt.Errorf("not modified")
 
-// downloadFromURL 从给定的URL下载文件
-func downloadFromURL(url, fileName string) error {
-	filePath := filepath.Join(config.App.DataDir, fileName)
-	return downloadAndExtract(url, filePath, fileName)
+// errResumable 表示下载被中断，但已保存的部分数据可以续传
+var errResumable = errors.New("download interrupted")
+
+// downloadFromURL 从数据库配置的URL下载文件
+func downloadFromURL(db config.Database) error {
+	return downloadAndExtract(db, db.Source.URL, fetchOptions{})
 }
 
 // downloadFromMaxMind 使用许可证密钥认证从 MaxMind 下载文件
-func downloadFromMaxMind(editionID, fileName string) error {
+func downloadFromMaxMind(db config.Database) error {
 	if config.App.MaxMindLicenseKey == "" {
 		return fmt.Errorf("MaxMind license key is not set")
 	}
-	url := fmt.Sprintf("https://download.maxmind.com/app/geoip_download?edition_id=%s&license_key=%s&suffix=tar.gz", editionID, config.App.MaxMindLicenseKey)
+	url := fmt.Sprintf("https://download.maxmind.com/app/geoip_download?edition_id=%s&license_key=%s&suffix=tar.gz", db.Source.EditionID, config.App.MaxMindLicenseKey)
+
+	return downloadAndExtract(db, url, fetchOptions{})
+}
+
+// copyFromFile 从本地文件复制数据库，文件的修改时间和大小未变化时跳过
+func copyFromFile(db config.Database) error {
+	info, err := os.Stat(db.Source.Path)
+	if err != nil {
+		return err
+	}
+
+	etagFilePath := etagPath(db)
+	stamp := fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	if etag, err := readETag(etagFilePath); err == nil && etag == stamp {
+		if _, err := os.Stat(db.Path()); err == nil {
+			return errNotModified
+		}
+	}
+
+	if info.Size() < db.Validation.MinSize {
+		return fmt.Errorf("source file too small (%d bytes)", info.Size())
+	}
+
+	stagedPath := db.Path() + ".tmp_final"
+	defer os.Remove(stagedPath)
+	if err := copyFile(db.Source.Path, stagedPath); err != nil {
+		return fmt.Errorf("failed to copy source file: %w", err)
+	}
 
-	filePath := filepath.Join(config.App.DataDir, fileName)
-	return downloadAndExtract(url, filePath, fileName)
+	if err := installDatabase(db, stagedPath, stamp); err != nil {
+		return err
+	}
+
+	if err := writeETag(etagFilePath, stamp); err != nil {
+		log.Printf("Warning: failed to record source stamp for %s: %v", db.Name, err)
+	}
+	return nil
+}
+
+// fetchOptions 自定义一次下载
+type fetchOptions struct {
+	// sign 在发送前修改请求，例如添加认证头或签名
+	sign func(req *http.Request) error
+	// sha256 是期望的十六进制 SHA-256，不为空时校验下载的内容
+	sha256 string
 }
 
-// downloadAndExtract 下载并提取 tar.gz 文件
-func downloadAndExtract(url, filePath, etagFileName string) error {
-	req, err := http.NewRequest("GET", url, nil)
+// downloadAndExtract 以流式方式下载文件到磁盘（支持断点续传），校验后提取并原子性地替换目标文件
+func downloadAndExtract(db config.Database, url string, opts fetchOptions) error {
+	filePath := db.Path()
+	etagFilePath := etagPath(db)
+	partPath := filePath + ".part"
+
+	var result *fetchResult
+	var err error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		result, err = fetchToFile(url, partPath, etagFilePath, db.Validation.MinSize, opts.sign)
+		if !errors.Is(err, errResumable) {
+			break
+		}
+		log.Printf("Download of %s interrupted (attempt %d/%d): %v", db.Name, attempt, maxDownloadAttempts, err)
+	}
 	if err != nil {
 		return err
 	}
+	log.Printf("Downloaded %s: %d bytes, sha256 %s", db.Name, result.Size, result.SHA256)
+	if opts.sha256 != "" && !strings.EqualFold(opts.sha256, result.SHA256) {
+		removePartial(partPath)
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", opts.sha256, result.SHA256)
+	}
+
+	// 创建最终文件的临时路径
+	finalTempPath := filePath + ".tmp_final"
+	defer os.Remove(finalTempPath) // 确保最终临时文件被清理
+
+	// 从 tar.gz 或 zip 中提取数据库文件（例如 IP2Location 的 BIN 下载是 zip），
+	// 否则直接将下载的文件移动到最终临时路径
+	if isTarGzURL(url) {
+		err = extractFromTarGz(partPath, finalTempPath, db.Extension())
+		removePartial(partPath)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s from tar.gz: %w", db.Extension(), err)
+		}
+	} else if isZipFile(partPath) {
+		err = extractFromZip(partPath, finalTempPath, db.Extension())
+		removePartial(partPath)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s from zip: %w", db.Extension(), err)
+		}
+	} else {
+		if err := os.Rename(partPath, finalTempPath); err != nil {
+			removePartial(partPath)
+			return fmt.Errorf("failed to move download to final temp path: %w", err)
+		}
+		os.Remove(partPath + ".etag")
+	}
+
+	if err := installDatabase(db, finalTempPath, result.ETag); err != nil {
+		return err
+	}
+
+	// 保存新的ETag
+	if result.ETag != "" {
+		if err := writeETag(etagFilePath, result.ETag); err != nil {
+			log.Printf("Warning: failed to write ETag for %s: %v", db.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// installDatabase 校验暂存的数据库文件，生成差异报告，原子性地替换当前版本并保存历史版本
+func installDatabase(db config.Database, stagedPath, etag string) error {
+	if err := validateDatabaseFile(db, stagedPath); err != nil {
+		return fmt.Errorf("database file validation failed: %w", err)
+	}
+
+	report, err := runCanary(db, stagedPath)
+	if err != nil {
+		return fmt.Errorf("canary validation failed: %w", err)
+	}
+	if report != nil {
+		recordCanary(db, report)
+		log.Printf("Canary validation of %s: %d/%d assertions failed", db.Name, report.Failed, report.Checked)
+		if report.Rejected {
+			return fmt.Errorf("%w: %d/%d golden assertions failed (max ratio %.2f)",
+				errCanaryRejected, report.Failed, report.Checked, config.App.Canary.MaxFailureRatio)
+		}
+	}
+
+	writeDiffReport(db, db.Path(), stagedPath)
+	archiveCurrent(db)
+
+	if err := os.Rename(stagedPath, db.Path()); err != nil {
+		return fmt.Errorf("failed to replace final file: %w", err)
+	}
+
+	if err := saveVersion(db, db.Path(), etag); err != nil {
+		log.Printf("Warning: failed to save version of %s: %v", db.Name, err)
+	}
+	uploadSnapshot(db, db.Path())
+	return nil
+}
+
+// etagPath 返回保存数据库 ETag 的文件路径
+func etagPath(db config.Database) string {
+	return db.Path() + ".etag"
+}
+
+// fetchResult 描述一次完成的下载
+type fetchResult struct {
+	Size   int64  // 下载文件的总字节数（包括续传前已有的部分）
+	SHA256 string // 下载文件的十六进制 SHA-256
+	ETag   string // 服务器返回的 ETag
+}
+
+// fetchToFile 将 url 的内容流式写入 partPath，同时计算哈希和字节数。
+// 如果 partPath 已有未完成的下载并记录了 ETag，则使用 HTTP Range 续传。
+// 返回 errResumable 包装的错误表示部分数据已保留，可以再次调用以继续下载。
+// sign 不为空时在设置完所有请求头之后调用。
+func fetchToFile(url, partPath, etagFilePath string, minSize int64, sign func(req *http.Request) error) (*fetchResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	etagFilePath := filepath.Join(config.App.DataDir, etagFileName+".etag")
 	if etag, err := readETag(etagFilePath); err == nil {
 		req.Header.Set("If-None-Match", etag)
 	}
 
+	// 检查是否存在可以续传的部分下载
+	partETagPath := partPath + ".etag"
+	var offset int64
+	partETag, _ := readETag(partETagPath)
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 && partETag != "" {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// If-Range 保证只有在远端文件未变化时才返回 206，否则返回完整的 200
+		req.Header.Set("If-Range", partETag)
+		log.Printf("Resuming download of %s from byte %d", filepath.Base(partPath), offset)
+	}
+
+	if sign != nil {
+		if err := sign(req); err != nil {
+			return nil, fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
+
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotModified {
-		return errNotModified
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		removePartial(partPath)
+		return nil, errNotModified
+	case http.StatusPartialContent:
+		if offset == 0 || !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			removePartial(partPath)
+			return nil, fmt.Errorf("unexpected Content-Range %q for resumed download", resp.Header.Get("Content-Range"))
+		}
+		// 不支持 If-Range 的服务器（例如部分对象存储）可能返回新版本的片段
+		if etag := resp.Header.Get("ETag"); etag != "" && etag != partETag {
+			removePartial(partPath)
+			return nil, fmt.Errorf("%w: remote file changed during resumed download", errResumable)
+		}
+	case http.StatusOK:
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		removePartial(partPath)
+		return nil, fmt.Errorf("%w: server rejected range request, restarting download", errResumable)
+	default:
+		return nil, fmt.Errorf("download failed with status: %s", resp.Status)
 	}
 
-	// 下载文件
+	// 检查是否为HTML错误页面
 	contentType := resp.Header.Get("Content-Type")
 	if contentType != "" {
-		// 检查是否为HTML错误页面
 		if strings.Contains(strings.ToLower(contentType), "text/html") {
-			return fmt.Errorf("received HTML content instead of binary file, Content-Type: %s", contentType)
+			removePartial(partPath)
+			return nil, fmt.Errorf("received HTML content instead of binary file, Content-Type: %s", contentType)
 		}
 		// 对于tar.gz文件，检查是否为合适的类型
-		if strings.Contains(url, "suffix=tar.gz") || strings.HasSuffix(url, ".tar.gz") {
+		if isTarGzURL(url) {
 			if !strings.Contains(strings.ToLower(contentType), "gzip") &&
 				!strings.Contains(strings.ToLower(contentType), "tar") &&
 				!strings.Contains(strings.ToLower(contentType), "octet-stream") {
@@ -172,81 +417,118 @@ func downloadAndExtract(url, filePath, etagFileName string) error {
 		}
 	}
 
-	// 将响应体写入临时文件
-	tempFilePath := filePath + ".tmp_download"
-	tempFile, err := os.Create(tempFilePath)
-	if err != nil {
-		return err
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
 	}
-	defer os.Remove(tempFilePath) // 确保临时文件被清理
-
-	// 将整个响应体读入内存以确保完整性
-	body, err := io.ReadAll(resp.Body)
+	partFile, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
-		tempFile.Close()
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
+	defer partFile.Close()
 
-	// 检查最小文件大小（避免下载到错误页面）
-	const minFileSize = 2 * 1024 * 1024 // 2MB 最小大小
-	if len(body) < minFileSize {
-		tempFile.Close()
-		return fmt.Errorf("downloaded file too small (%d bytes), likely an error page", len(body))
-	}
-
-	// 轻度验证文件格式（检查前几个字节）
-	if err := validateFileFormat(body, url); err != nil {
-		tempFile.Close()
-		return fmt.Errorf("file format validation failed: %w", err)
+	// 续传时先对已有的部分计算哈希
+	hasher := sha256.New()
+	if offset > 0 {
+		existing, err := os.Open(partPath)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.CopyN(hasher, existing, offset)
+		existing.Close()
+		if err != nil {
+			removePartial(partPath)
+			return nil, fmt.Errorf("failed to hash partial download: %w", err)
+		}
 	}
 
-	// 将完整的响应体写入临时文件
-	if _, err := tempFile.Write(body); err != nil {
-		tempFile.Close()
-		return fmt.Errorf("failed to write body to temp file: %w", err)
+	// 记录 ETag 以便中断后续传；没有 ETag 时无法安全续传
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode == http.StatusPartialContent {
+		etag = partETag
 	}
-	tempFile.Close() // 关闭文件以便提取器可以使用
-
-	// 创建最终文件的临时路径
-	finalTempPath := filePath + ".tmp_final"
-	defer os.Remove(finalTempPath) // 确保最终临时文件被清理
-
-	// 从 tar.gz 中提取 .mmdb 文件
-	// 检查URL中是否包含suffix=tar.gz参数或以.tar.gz结尾
-	if strings.Contains(url, "suffix=tar.gz") || strings.HasSuffix(url, ".tar.gz") {
-		if err := extractMmdbFromTarGz(tempFilePath, finalTempPath); err != nil {
-			return fmt.Errorf("failed to extract .mmdb from tar.gz: %w", err)
+	if etag != "" {
+		if err := writeETag(partETagPath, etag); err != nil {
+			log.Printf("Warning: failed to record ETag for partial download: %v", err)
 		}
 	} else {
-		// 如果不是tar.gz，复制到最终临时路径
-		if err := copyFile(tempFilePath, finalTempPath); err != nil {
-			return fmt.Errorf("failed to copy file to final temp path: %w", err)
+		os.Remove(partETagPath)
+	}
+
+	body := newIdleTimeoutReader(resp.Body, bodyIdleTimeout, cancel)
+	written, copyErr := io.Copy(io.MultiWriter(partFile, hasher), body)
+	body.Stop()
+	if copyErr != nil && ctx.Err() != nil {
+		copyErr = fmt.Errorf("no data received for %s", bodyIdleTimeout)
+	}
+	if copyErr == nil && resp.ContentLength >= 0 && written != resp.ContentLength {
+		copyErr = io.ErrUnexpectedEOF
+	}
+	if copyErr == nil {
+		copyErr = partFile.Sync()
+	}
+	if copyErr != nil {
+		if etag == "" {
+			removePartial(partPath)
+			return nil, fmt.Errorf("failed to read response body: %w", copyErr)
 		}
+		return nil, fmt.Errorf("%w: %d bytes saved: %v", errResumable, offset+written, copyErr)
 	}
 
-	// 验证提取的文件
-	if err := validateMmdbFile(finalTempPath); err != nil {
-		return fmt.Errorf("MMDB file validation failed: %w", err)
+	result := &fetchResult{
+		Size:   offset + written,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+		ETag:   etag,
 	}
 
-	// 原子性地替换旧文件
-	if err := os.Rename(finalTempPath, filePath); err != nil {
-		return fmt.Errorf("failed to replace final file: %w", err)
+	// 检查最小文件大小（避免下载到错误页面）
+	if result.Size < minSize {
+		removePartial(partPath)
+		return nil, fmt.Errorf("downloaded file too small (%d bytes), likely an error page", result.Size)
 	}
 
-	// 保存新的ETag
-	newETag := resp.Header.Get("ETag")
-	if newETag != "" {
-		if err := writeETag(etagFilePath, newETag); err != nil {
-			log.Printf("Warning: failed to write ETag for %s: %v", etagFileName, err)
-		}
+	// 轻度验证文件格式（检查前几个字节）
+	head := make([]byte, 512)
+	n, err := readHead(partPath, head)
+	if err != nil {
+		removePartial(partPath)
+		return nil, err
+	}
+	if err := validateFileFormat(head[:n], url); err != nil {
+		removePartial(partPath)
+		return nil, fmt.Errorf("file format validation failed: %w", err)
 	}
 
-	return nil
+	return result, nil
+}
+
+// readHead 读取文件开头的字节
+func readHead(path string, buf []byte) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := io.ReadFull(f, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// removePartial 删除未完成的下载及其 ETag 记录
+func removePartial(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + ".etag")
+}
+
+// isTarGzURL 检查URL中是否包含suffix=tar.gz参数或以.tar.gz结尾
+func isTarGzURL(url string) bool {
+	return strings.Contains(url, "suffix=tar.gz") || strings.HasSuffix(url, ".tar.gz")
 }
 
-// extractMmdbFromTarGz 从 .tar.gz 压缩包中提取 .mmdb 文件
-func extractMmdbFromTarGz(tarGzPath, destPath string) error {
+// extractFromTarGz 从 .tar.gz 压缩包中提取第一个扩展名为 ext 的文件（不区分大小写）
+func extractFromTarGz(tarGzPath, destPath, ext string) error {
 	file, err := os.Open(tarGzPath)
 	if err != nil {
 		return err
@@ -270,29 +552,70 @@ func extractMmdbFromTarGz(tarGzPath, destPath string) error {
 			return err
 		}
 
-		if header.Typeflag == tar.TypeReg && strings.HasSuffix(header.Name, ".mmdb") {
-			tempDestPath := destPath + ".tmp"
-			outFile, err := os.Create(tempDestPath)
-			if err != nil {
-				return err
-			}
+		if header.Typeflag == tar.TypeReg && hasExtFold(header.Name, ext) {
+			return extractTo(tarReader, destPath)
+		}
+	}
 
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				outFile.Close()
-				os.Remove(tempDestPath) // 出错时清理
-				return err
-			}
-			outFile.Close()
+	return fmt.Errorf("%s file not found in archive", ext)
+}
 
-			// 找到并提取了文件，现在重命名它，我们就完成了。
-			if err := os.Rename(tempDestPath, destPath); err != nil {
-				return err
-			}
-			return nil // 成功
+// zipMagic 是 zip 文件的开头
+var zipMagic = []byte("PK\x03\x04")
+
+// isZipFile 检查下载的文件是否是 zip 压缩包
+func isZipFile(path string) bool {
+	head := make([]byte, len(zipMagic))
+	n, err := readHead(path, head)
+	return err == nil && bytes.Equal(head[:n], zipMagic)
+}
+
+// extractFromZip 从 zip 压缩包中提取第一个扩展名为 ext 的文件（不区分大小写）
+func extractFromZip(zipPath, destPath, ext string) error {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || !hasExtFold(f.Name, ext) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
 		}
+		defer rc.Close()
+		return extractTo(rc, destPath)
+	}
+
+	return fmt.Errorf("%s file not found in archive", ext)
+}
+
+// hasExtFold 检查文件名是否以 ext 结尾，不区分大小写
+func hasExtFold(name, ext string) bool {
+	return strings.HasSuffix(strings.ToLower(name), strings.ToLower(ext))
+}
+
+// extractTo 将压缩包中的文件写入临时文件，完成后重命名为 destPath
+func extractTo(r io.Reader, destPath string) error {
+	tempDestPath := destPath + ".tmp"
+	outFile, err := os.Create(tempDestPath)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf(".mmdb file not found in archive")
+	if _, err := io.Copy(outFile, r); err != nil {
+		outFile.Close()
+		os.Remove(tempDestPath) // 出错时清理
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		os.Remove(tempDestPath)
+		return err
+	}
+	return os.Rename(tempDestPath, destPath)
 }
 
 func readETag(etagFile string) (string, error) {
@@ -321,10 +644,12 @@ func validateFileFormat(data []byte, url string) error {
 	}
 
 	// 如果是tar.gz文件，检查gzip魔数
-	if strings.Contains(url, "suffix=tar.gz") || strings.HasSuffix(url, ".tar.gz") {
+	if isTarGzURL(url) {
 		if len(data) >= 2 && (data[0] != 0x1f || data[1] != 0x8b) {
 			return fmt.Errorf("invalid gzip magic number")
 		}
+	} else if bytes.HasPrefix(data, zipMagic) {
+		// zip 压缩包在提取后校验其中的数据库文件
 	} else {
 		// 对于直接的MMDB文件，检查MMDB魔数
 		// MMDB文件通常以特定的字节序列开始
@@ -348,14 +673,17 @@ func validateFileFormat(data []byte, url string) error {
 	return nil
 }
 
-// validateMmdbFile 验证 MMDB 文件可以被打开
-func validateMmdbFile(filePath string) error {
-	// 尝试使用geoip2库打开文件进行验证
-	db, err := geoip.OpenTestDB(filePath)
+// validateDatabaseFile 验证数据库文件可以被对应的数据源打开并满足校验规则
+func validateDatabaseFile(db config.Database, filePath string) error {
+	meta, err := geoip.FileMetadata(db, filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open MMDB file for validation: %w", err)
+		return fmt.Errorf("failed to open database file for validation: %w", err)
+	}
+
+	rules := db.Validation
+	if rules.DatabaseType != "" && !strings.Contains(meta.DatabaseType, rules.DatabaseType) {
+		return fmt.Errorf("unexpected database type %q, want %q", meta.DatabaseType, rules.DatabaseType)
 	}
-	db.Close()
 	return nil
 }
 
@@ -371,10 +699,12 @@ func copyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
 }
 
 // min 返回两个整数中的最小值
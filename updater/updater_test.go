@@ -0,0 +1,42 @@
+package updater
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchToFileStalledBody(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "1024")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	old := bodyIdleTimeout
+	bodyIdleTimeout = 100 * time.Millisecond
+	defer func() { bodyIdleTimeout = old }()
+
+	dir := t.TempDir()
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchToFile(srv.URL, filepath.Join(dir, "db.part"), filepath.Join(dir, "db.etag"), 0, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errResumable) {
+			t.Fatalf("fetchToFile error = %v, want errResumable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetchToFile did not time out on a stalled body")
+	}
+}
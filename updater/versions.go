@@ -0,0 +1,286 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+// errPinned 表示数据库已被固定到某个版本，跳过计划更新
+var errPinned = errors.New("pinned to a previous version")
+
+// Version 描述一个保存的数据库版本
+type Version struct {
+	BuildEpoch  uint      `json:"build_epoch"`
+	SHA256      string    `json:"sha256"`
+	ETag        string    `json:"etag,omitempty"`
+	Size        int64     `json:"size"`
+	InstalledAt time.Time `json:"installed_at"`
+	Current     bool      `json:"current"`
+	Pinned      bool      `json:"pinned"`
+}
+
+// versionsDir 返回保存数据库历史版本的目录
+func versionsDir(db config.Database) string {
+	return filepath.Join(config.App.DataDir, "versions", db.Name)
+}
+
+// versionPath 返回指定构建时间的版本文件路径
+func versionPath(db config.Database, epoch uint) string {
+	return filepath.Join(versionsDir(db), strconv.FormatUint(uint64(epoch), 10)+db.Extension())
+}
+
+// pinPath 返回记录固定版本的文件路径
+func pinPath(db config.Database) string {
+	return filepath.Join(versionsDir(db), "pinned")
+}
+
+// pinnedVersion 返回数据库被固定的版本，未固定时返回 0
+func pinnedVersion(db config.Database) uint {
+	data, err := os.ReadFile(pinPath(db))
+	if err != nil {
+		return 0
+	}
+	epoch, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(epoch)
+}
+
+// readBuildEpoch 读取数据库文件元数据中的构建时间
+func readBuildEpoch(db config.Database, path string) (uint, error) {
+	meta, err := geoip.FileMetadata(db, path)
+	if err != nil {
+		return 0, err
+	}
+	return meta.BuildEpoch, nil
+}
+
+// fileSHA256 计算文件的 SHA-256
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), n, nil
+}
+
+// saveVersion 将 path 处的数据库文件保存为一个历史版本，并清理多余的旧版本。
+// 数据库文件只会被整体替换而不会被原地修改，因此优先使用硬链接节省磁盘空间。
+func saveVersion(db config.Database, path, etag string) error {
+	epoch, err := readBuildEpoch(db, path)
+	if err != nil {
+		return err
+	}
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(versionsDir(db), 0755); err != nil {
+		return err
+	}
+
+	dest := versionPath(db, epoch)
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(path, tmp); err != nil {
+		if err := copyFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	meta, err := json.MarshalIndent(Version{
+		BuildEpoch:  epoch,
+		SHA256:      sum,
+		ETag:        etag,
+		Size:        size,
+		InstalledAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(strings.TrimSuffix(dest, db.Extension())+".json", meta, 0644); err != nil {
+		return err
+	}
+
+	pruneVersions(db)
+	return nil
+}
+
+// archiveCurrent 在替换前保存当前的数据库文件（如果尚未保存），
+// 使在启用版本管理之前下载的文件也可以回滚
+func archiveCurrent(db config.Database) {
+	epoch, err := readBuildEpoch(db, db.Path())
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(versionPath(db, epoch)); err == nil {
+		return
+	}
+	etag, _ := readETag(etagPath(db))
+	if err := saveVersion(db, db.Path(), etag); err != nil {
+		log.Printf("Warning: failed to archive current %s: %v", db.Name, err)
+	}
+}
+
+// pruneVersions 删除超出保留数量的最旧版本，固定的版本永远不会被删除
+func pruneVersions(db config.Database) {
+	keep := db.VersionsToKeep()
+	if keep <= 0 {
+		return
+	}
+
+	versions, err := ListVersions(db.Name)
+	if err != nil {
+		return
+	}
+	for i := keep; i < len(versions); i++ {
+		if versions[i].Pinned || versions[i].Current {
+			continue
+		}
+		path := versionPath(db, versions[i].BuildEpoch)
+		os.Remove(path)
+		os.Remove(strings.TrimSuffix(path, db.Extension()) + ".json")
+		log.Printf("Pruned %s version %d", db.Name, versions[i].BuildEpoch)
+	}
+}
+
+// ListVersions 返回数据库保存的所有版本，按构建时间从新到旧排列
+func ListVersions(name string) ([]Version, error) {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+
+	entries, err := os.ReadDir(versionsDir(db))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	current, _ := readBuildEpoch(db, db.Path())
+	pinned := pinnedVersion(db)
+
+	var versions []Version
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(versionsDir(db), entry.Name()))
+		if err != nil {
+			continue
+		}
+		var v Version
+		if err := json.Unmarshal(data, &v); err != nil {
+			log.Printf("Warning: invalid version metadata %s: %v", entry.Name(), err)
+			continue
+		}
+		if _, err := os.Stat(versionPath(db, v.BuildEpoch)); err != nil {
+			continue
+		}
+		v.Current = v.BuildEpoch == current
+		v.Pinned = v.BuildEpoch == pinned
+		versions = append(versions, v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].BuildEpoch > versions[j].BuildEpoch
+	})
+	return versions, nil
+}
+
+// Rollback 原子性地将数据库替换为指定的历史版本，并将其固定，
+// 使计划更新不会立即覆盖它。调用者负责重新加载数据库。
+func Rollback(name string, epoch uint) (config.Database, error) {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return db, fmt.Errorf("unknown database %q", name)
+	}
+
+	src := versionPath(db, epoch)
+	if _, err := os.Stat(src); err != nil {
+		return db, fmt.Errorf("version %d of %s not found", epoch, name)
+	}
+	if err := validateDatabaseFile(db, src); err != nil {
+		return db, fmt.Errorf("version %d of %s failed validation: %w", epoch, name, err)
+	}
+
+	archiveCurrent(db)
+
+	if err := installVersion(db, src); err != nil {
+		return db, err
+	}
+
+	// 恢复该版本的 ETag，取消固定后的条件请求与磁盘上的文件保持一致。
+	// 无法读取该版本的 ETag 时删除 ETag 文件，否则较新版本的 ETag 会让下次更新得到 304。
+	var v Version
+	data, err := os.ReadFile(strings.TrimSuffix(src, db.Extension()) + ".json")
+	if err == nil && json.Unmarshal(data, &v) == nil && v.ETag != "" {
+		writeETag(etagPath(db), v.ETag)
+	} else {
+		os.Remove(etagPath(db))
+	}
+
+	if err := os.WriteFile(pinPath(db), []byte(strconv.FormatUint(uint64(epoch), 10)), 0644); err != nil {
+		return db, fmt.Errorf("rolled back but failed to pin version: %w", err)
+	}
+
+	log.Printf("Rolled back %s to version %d (pinned)", name, epoch)
+	return db, nil
+}
+
+// installVersion 用保存的版本原子替换当前的数据库文件，优先使用硬链接
+func installVersion(db config.Database, src string) error {
+	staged := db.Path() + ".tmp_rollback"
+	os.Remove(staged)
+	if err := os.Link(src, staged); err != nil {
+		if err := copyFile(src, staged); err != nil {
+			os.Remove(staged)
+			return err
+		}
+	}
+	if err := os.Rename(staged, db.Path()); err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("failed to replace %s: %w", db.FileName(), err)
+	}
+	return nil
+}
+
+// Unpin 取消数据库的版本固定，恢复计划更新
+func Unpin(name string) error {
+	db, ok := config.DatabaseByName(name)
+	if !ok {
+		return fmt.Errorf("unknown database %q", name)
+	}
+	if err := os.Remove(pinPath(db)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	log.Printf("Unpinned %s", name)
+	return nil
+}
@@ -0,0 +1,174 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ip-api/config"
+)
+
+// useVersionedDB 在临时数据目录中注册一个数据库，测试结束后恢复配置
+func useVersionedDB(t *testing.T, keep int) config.Database {
+	t.Helper()
+	old := config.App
+	t.Cleanup(func() { config.App = old })
+
+	db := config.Database{Name: "Test-City", Role: config.RoleCity, KeepVersions: keep}
+	config.App.DataDir = t.TempDir()
+	config.App.Databases = []config.Database{db}
+	return db
+}
+
+// saveTestVersion 保存一个指定构建时间的版本
+func saveTestVersion(t *testing.T, db config.Database, epoch int64, etag string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "new.mmdb")
+	writeTestMMDB(t, path, epoch)
+	if err := saveVersion(db, path, etag); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// epochs 返回 ListVersions 中的构建时间
+func epochs(t *testing.T, db config.Database) []uint {
+	t.Helper()
+	versions, err := ListVersions(db.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []uint
+	for _, v := range versions {
+		out = append(out, v.BuildEpoch)
+	}
+	return out
+}
+
+func TestSaveVersionAndList(t *testing.T) {
+	db := useVersionedDB(t, 5)
+	writeTestMMDB(t, db.Path(), 200)
+	saveTestVersion(t, db, 100, `"v1"`)
+	saveTestVersion(t, db, 200, `"v2"`)
+
+	versions, err := ListVersions(db.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want 2", len(versions))
+	}
+	if v := versions[0]; v.BuildEpoch != 200 || !v.Current || v.Pinned || v.ETag != `"v2"` || v.Size == 0 || len(v.SHA256) != 64 {
+		t.Errorf("newest version = %+v", v)
+	}
+	if v := versions[1]; v.BuildEpoch != 100 || v.Current || v.ETag != `"v1"` {
+		t.Errorf("oldest version = %+v", v)
+	}
+
+	// 版本文件缺失的元数据被忽略
+	os.Remove(versionPath(db, 100))
+	if got := epochs(t, db); len(got) != 1 || got[0] != 200 {
+		t.Errorf("versions = %v, want [200]", got)
+	}
+
+	if _, err := ListVersions("Unknown"); err == nil {
+		t.Error("ListVersions of an unknown database succeeded")
+	}
+}
+
+func TestPruneKeepsPinnedAndCurrentVersions(t *testing.T) {
+	db := useVersionedDB(t, 2)
+	// 当前文件是最旧的版本，另一个较旧的版本被固定
+	writeTestMMDB(t, db.Path(), 100)
+	saveTestVersion(t, db, 100, "")
+	saveTestVersion(t, db, 200, "")
+	os.WriteFile(pinPath(db), []byte("200"), 0644)
+	for _, epoch := range []int64{300, 400, 500} {
+		saveTestVersion(t, db, epoch, "")
+	}
+
+	got := epochs(t, db)
+	want := []uint{500, 400, 200, 100}
+	if len(got) != len(want) {
+		t.Fatalf("versions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("versions = %v, want %v", got, want)
+		}
+	}
+	if _, err := os.Stat(strings.TrimSuffix(versionPath(db, 300), db.Extension()) + ".json"); !os.IsNotExist(err) {
+		t.Error("metadata of the pruned version was kept")
+	}
+}
+
+func TestRollbackRestoresETag(t *testing.T) {
+	db := useVersionedDB(t, 5)
+	saveTestVersion(t, db, 100, `"v1"`)
+	writeTestMMDB(t, db.Path(), 200)
+	writeETag(etagPath(db), `"v2"`)
+
+	if _, err := Rollback(db.Name, 100); err != nil {
+		t.Fatal(err)
+	}
+	if epoch, _ := readBuildEpoch(db, db.Path()); epoch != 100 {
+		t.Errorf("current build epoch = %d, want 100", epoch)
+	}
+	if etag, _ := readETag(etagPath(db)); etag != `"v1"` {
+		t.Errorf("ETag = %q, want the saved \"v1\"", etag)
+	}
+	if pinned := pinnedVersion(db); pinned != 100 {
+		t.Errorf("pinned version = %d, want 100", pinned)
+	}
+	// 被替换的版本已保存，可以再回滚回去
+	if got := epochs(t, db); len(got) != 2 || got[0] != 200 {
+		t.Errorf("versions = %v, want the replaced version archived", got)
+	}
+
+	// 固定期间计划更新被跳过
+	if err := fetchDatabase(db); err != errPinned {
+		t.Errorf("fetchDatabase while pinned = %v, want errPinned", err)
+	}
+	if err := Unpin(db.Name); err != nil {
+		t.Fatal(err)
+	}
+	if pinned := pinnedVersion(db); pinned != 0 {
+		t.Errorf("pinned version after Unpin = %d", pinned)
+	}
+
+	if _, err := Rollback(db.Name, 300); err == nil {
+		t.Error("Rollback to a missing version succeeded")
+	}
+}
+
+func TestRollbackRemovesETag(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(db config.Database)
+	}{
+		{"empty saved ETag", func(db config.Database) {}},
+		{"missing metadata", func(db config.Database) {
+			os.Remove(strings.TrimSuffix(versionPath(db, 100), db.Extension()) + ".json")
+		}},
+		{"invalid metadata", func(db config.Database) {
+			os.WriteFile(strings.TrimSuffix(versionPath(db, 100), db.Extension())+".json", []byte("{"), 0644)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useVersionedDB(t, 5)
+			saveTestVersion(t, db, 100, "")
+			tt.setup(db)
+			writeTestMMDB(t, db.Path(), 200)
+			writeETag(etagPath(db), `"v2"`)
+
+			if _, err := Rollback(db.Name, 100); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(etagPath(db)); !os.IsNotExist(err) {
+				etag, _ := readETag(etagPath(db))
+				t.Errorf("ETag file kept with %q", etag)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+const (
+	// watchDebounce 是最后一次文件事件后等待写入完成的时间
+	watchDebounce = 2 * time.Second
+	// watchPollInterval 是 inotify 不可用时轮询数据目录的间隔
+	watchPollInterval = 10 * time.Second
+)
+
+// fileStamp 标识文件的一个版本，用于忽略内容没有变化的事件
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func statStamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// Watch 监视 DataDir，外部工具（例如 geoipupdate）替换数据库文件后
+// 校验并热加载受影响的数据库。优先使用 inotify，不可用时退回到轮询。
+func Watch() {
+	dir := config.App.DataDir
+	events := make(chan string, 64)
+
+	if err := watchDir(dir, events); err != nil {
+		log.Printf("File notifications unavailable (%v), polling %s every %s", err, dir, watchPollInterval)
+		go pollDir(dir, watchPollInterval, events)
+	} else {
+		log.Printf("Watching %s for database changes", dir)
+	}
+
+	w := &watcher{
+		timers: make(map[string]*time.Timer),
+		stamps: make(map[string]fileStamp),
+	}
+	w.start()
+
+	for name := range events {
+		db, ok := databaseForFile(name)
+		if !ok {
+			// 临时文件、.part 文件以及注册表以外的文件都会被忽略
+			continue
+		}
+		w.schedule(db)
+	}
+}
+
+// databaseForFile 返回文件名对应的已注册数据库
+func databaseForFile(name string) (config.Database, bool) {
+	for _, db := range config.App.Databases {
+		if db.FileName() == name {
+			return db, true
+		}
+	}
+	return config.Database{}, false
+}
+
+// watcher 为每个数据库合并短时间内的多次文件事件
+type watcher struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	stamps map[string]fileStamp
+}
+
+// start 记录启动时已加载的文件，避免重复加载，并保存为版本以便回滚和比较。
+// 文件存在但没有加载（例如启动时打开失败）的数据库像外部更新一样校验并加载，
+// 否则记录下来的文件版本会使它在文件再次变化之前一直不被加载。
+func (w *watcher) start() {
+	loaded := make(map[string]bool)
+	for _, status := range geoip.Status() {
+		loaded[status.Name] = status.Loaded
+	}
+	for _, db := range config.App.Databases {
+		stamp, err := statStamp(db.Path())
+		if err != nil {
+			continue
+		}
+		if !loaded[db.Name] {
+			w.schedule(db)
+			continue
+		}
+		w.mu.Lock()
+		w.stamps[db.Name] = stamp
+		w.mu.Unlock()
+		archiveCurrent(db)
+	}
+}
+
+// schedule 在最后一次事件之后 watchDebounce 时间重新加载数据库，
+// 使仍在写入中的文件不会被加载
+func (w *watcher) schedule(db config.Database) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t, ok := w.timers[db.Name]; ok {
+		t.Reset(watchDebounce)
+		return
+	}
+	w.timers[db.Name] = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		delete(w.timers, db.Name)
+		w.mu.Unlock()
+		w.reload(db)
+	})
+}
+
+// reload 校验被替换的文件并热加载它，校验失败时继续使用旧的读取器
+func (w *watcher) reload(db config.Database) {
+	stamp, err := statStamp(db.Path())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to stat %s: %v", db.Path(), err)
+		}
+		return
+	}
+
+	w.mu.Lock()
+	unchanged := w.stamps[db.Name] == stamp
+	w.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	log.Printf("Detected change to %s", db.FileName())
+	err = installWatched(db)
+	recordResult(db, err)
+	if err != nil {
+		if err == errPinned {
+			log.Printf("%s is pinned to version %d, ignoring external change.", db.Name, pinnedVersion(db))
+		} else {
+			log.Printf("Rejected new %s: %v", db.FileName(), err)
+		}
+		setAside(db)
+		if restored, err := statStamp(db.Path()); err == nil {
+			stamp = restored
+		}
+	}
+
+	// 无论成功与否都记录这个版本，同一个文件不再重复校验
+	w.mu.Lock()
+	w.stamps[db.Name] = stamp
+	w.mu.Unlock()
+}
+
+// installWatched 校验外部放置的数据库文件，保存版本和差异报告后热加载
+func installWatched(db config.Database) error {
+	path := db.Path()
+
+	epoch, err := readBuildEpoch(db, path)
+	if err != nil {
+		return fmt.Errorf("database file validation failed: %w", err)
+	}
+	if pinned := pinnedVersion(db); pinned != 0 && pinned != epoch {
+		return errPinned
+	}
+
+	if err := validateDatabaseFile(db, path); err != nil {
+		return fmt.Errorf("database file validation failed: %w", err)
+	}
+	report, err := runCanary(db, path)
+	if err != nil {
+		return fmt.Errorf("canary validation failed: %w", err)
+	}
+	if report != nil {
+		recordCanary(db, report)
+		if report.Rejected {
+			return fmt.Errorf("%w: %d/%d golden assertions failed (max ratio %.2f)",
+				errCanaryRejected, report.Failed, report.Checked, config.App.Canary.MaxFailureRatio)
+		}
+	}
+
+	// 旧文件已被替换，与最近保存的版本比较
+	if versions, err := ListVersions(db.Name); err == nil && len(versions) > 0 && versions[0].BuildEpoch != epoch {
+		writeDiffReport(db, versionPath(db, versions[0].BuildEpoch), path)
+	}
+	if _, err := os.Stat(versionPath(db, epoch)); os.IsNotExist(err) {
+		if err := saveVersion(db, path, ""); err != nil {
+			log.Printf("Warning: failed to save version of %s: %v", db.Name, err)
+		}
+		uploadSnapshot(db, path)
+	}
+
+	if err := geoip.ReloadDB(db); err != nil {
+		return fmt.Errorf("failed to reload: %w", err)
+	}
+	log.Printf("Reloaded %s from external update (build epoch %d)", db.Name, epoch)
+	return nil
+}
+
+// setAside 把未通过校验或固定版本检查的文件移到 <file>.rejected，并恢复固定的版本或当前加载的版本，
+// 使之后的 SIGHUP 或重启不会加载未经校验的文件
+func setAside(db config.Database) {
+	path := db.Path()
+	if err := os.Rename(path, path+".rejected"); err != nil {
+		log.Printf("Warning: failed to move rejected %s aside: %v", db.FileName(), err)
+		return
+	}
+	log.Printf("Moved rejected %s to %s", db.FileName(), filepath.Base(path)+".rejected")
+
+	epoch := pinnedVersion(db)
+	if epoch == 0 {
+		for _, status := range geoip.Status() {
+			if status.Name == db.Name && status.Loaded {
+				epoch = status.BuildEpoch
+			}
+		}
+	}
+	if epoch == 0 {
+		return
+	}
+	if err := installVersion(db, versionPath(db, epoch)); err != nil {
+		log.Printf("Warning: failed to restore version %d of %s: %v", epoch, db.Name, err)
+		return
+	}
+	log.Printf("Restored version %d of %s", epoch, db.Name)
+}
+
+// pollDir 定期检查已注册的数据库文件，文件大小或修改时间变化时发送事件
+func pollDir(dir string, interval time.Duration, events chan<- string) {
+	stamps := make(map[string]fileStamp)
+	for _, db := range config.App.Databases {
+		stamps[db.FileName()], _ = statStamp(filepath.Join(dir, db.FileName()))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, db := range config.App.Databases {
+			stamp, err := statStamp(filepath.Join(dir, db.FileName()))
+			if err != nil {
+				continue
+			}
+			if stamp != stamps[db.FileName()] {
+				stamps[db.FileName()] = stamp
+				events <- db.FileName()
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package updater
+
+import (
+	"bytes"
+	"log"
+	"syscall"
+	"unsafe"
+)
+
+// watchDir 使用 inotify 监视目录，将被写入完成、创建或移入的文件名发送到 events
+func watchDir(dir string, events chan<- string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Printf("inotify read error: %v", err)
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				if nameEnd > n {
+					break
+				}
+				if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					log.Printf("inotify queue overflow, some database changes may be missed")
+				}
+				if name := bytes.TrimRight(buf[nameStart:nameEnd], "\x00"); len(name) > 0 {
+					events <- string(name)
+				}
+				offset = nameEnd
+			}
+		}
+	}()
+	return nil
+}
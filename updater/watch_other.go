@@ -0,0 +1,10 @@
+//go:build !linux
+
+package updater
+
+import "errors"
+
+// watchDir 在不支持 inotify 的平台上总是失败，调用者退回到轮询
+func watchDir(dir string, events chan<- string) error {
+	return errors.New("inotify is not supported on this platform")
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ip-api/config"
+	"ip-api/geoip"
+)
+
+func TestSetAsideRestoresPinnedVersion(t *testing.T) {
+	dir := t.TempDir()
+	old := config.App.DataDir
+	config.App.DataDir = dir
+	defer func() { config.App.DataDir = old }()
+
+	db := config.Database{Name: "Test-City", Role: config.RoleCity}
+	if err := os.MkdirAll(versionsDir(db), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(versionPath(db, 5), []byte("good"), 0644)
+	os.WriteFile(pinPath(db), []byte("5"), 0644)
+	os.WriteFile(db.Path(), []byte("bad"), 0644)
+
+	setAside(db)
+
+	if data, _ := os.ReadFile(db.Path()); string(data) != "good" {
+		t.Errorf("%s = %q, want the pinned version", filepath.Base(db.Path()), data)
+	}
+	if data, _ := os.ReadFile(db.Path() + ".rejected"); string(data) != "bad" {
+		t.Errorf("rejected file = %q, want the rejected content", data)
+	}
+}
+
+func TestWatcherStartSchedulesUnloadedDatabases(t *testing.T) {
+	old := config.App
+	defer func() { config.App = old }()
+	config.App.DataDir = t.TempDir()
+	loaded := config.Database{Name: "Test-City", Role: config.RoleCity}
+	unloaded := config.Database{Name: "Test-Country", Role: config.RoleCity}
+	missing := config.Database{Name: "Test-Missing", Role: config.RoleCity}
+	config.App.Databases = []config.Database{loaded, unloaded, missing}
+
+	writeTestMMDB(t, loaded.Path(), 100)
+	if err := geoip.OpenDBs([]config.Database{loaded}); err != nil {
+		t.Fatal(err)
+	}
+	defer geoip.CloseDBs()
+	// 启动后才出现的文件，此时还没有加载
+	writeTestMMDB(t, unloaded.Path(), 200)
+
+	w := &watcher{timers: make(map[string]*time.Timer), stamps: make(map[string]fileStamp)}
+	w.start()
+	defer func() {
+		for _, timer := range w.timers {
+			timer.Stop()
+		}
+	}()
+
+	if _, ok := w.stamps[loaded.Name]; !ok {
+		t.Error("loaded database was not recorded")
+	}
+	if got := epochs(t, loaded); len(got) != 1 || got[0] != 100 {
+		t.Errorf("versions of the loaded database = %v, want [100]", got)
+	}
+	// 未加载的数据库不记录文件版本，而是安排校验和加载
+	if _, ok := w.stamps[unloaded.Name]; ok {
+		t.Error("unloaded database was recorded as loaded")
+	}
+	if _, ok := w.timers[unloaded.Name]; !ok {
+		t.Error("unloaded database was not scheduled for loading")
+	}
+	if _, ok := w.timers[missing.Name]; ok {
+		t.Error("database without a file was scheduled")
+	}
+}